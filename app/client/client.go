@@ -0,0 +1,249 @@
+// Package client is the command-line client for a running site's JSON API, started as `mini-blog client`.
+// It authenticates with an API token created on the settings page.
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const usage = `Usage: mini-blog client [-url URL] [-token TOKEN] <command> [arguments]
+
+The URL and token default to $MINI_BLOG_URL and $MINI_BLOG_TOKEN.
+
+Commands:
+  library [-status STATUS] [-type tv|movie]      list the library
+  watch [-unwatch] TMDB_ID SEASON EPISODE         mark an episode watched (admin)
+  post [-title T] [-slug S] [-visibility V] FILE  create a draft post from a markdown file (admin);
+                                                  the title defaults to the file's first "# " heading
+`
+
+// Client calls one site's API as the token's user
+type Client struct {
+	BaseURL string
+	Token   string
+	HTTP    *http.Client
+	Out     io.Writer
+}
+
+// Run executes the client command line in args and returns the process exit code
+func Run(args []string) int {
+	flags := flag.NewFlagSet("client", flag.ContinueOnError)
+	flags.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	baseURL := flags.String("url", envOr("MINI_BLOG_URL", "http://localhost:8080"), "site URL")
+	token := flags.String("token", os.Getenv("MINI_BLOG_TOKEN"), "API token")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	c := &Client{
+		BaseURL: strings.TrimSuffix(*baseURL, "/"),
+		Token:   *token,
+		HTTP:    &http.Client{Timeout: 30 * time.Second},
+		Out:     os.Stdout,
+	}
+
+	var err error
+	switch command, rest := flags.Arg(0), flags.Args()[1:]; command {
+	case "library":
+		err = c.library(rest)
+	case "watch":
+		err = c.watch(rest)
+	case "post":
+		err = c.post(rest)
+	default:
+		flags.Usage()
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 1
+	}
+	return 0
+}
+
+// library prints every library card, following the API's cursor pages
+func (c *Client) library(args []string) error {
+	flags := flag.NewFlagSet("library", flag.ContinueOnError)
+	status := flags.String("status", "", "only titles with this status")
+	mediaType := flags.String("type", "", "tv or movie")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	out := tabwriter.NewWriter(c.Out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(out, "ID\tTYPE\tSTATUS\tPROGRESS\tTITLE")
+	query := url.Values{"fields": {"id,type,status,progress,total,title"}, "limit": {"200"}}
+	if *status != "" {
+		query.Set("status", *status)
+	}
+	if *mediaType != "" {
+		query.Set("type", *mediaType)
+	}
+
+	for {
+		var page struct {
+			Items []struct {
+				ID       int    `json:"id"`
+				Type     string `json:"type"`
+				Status   string `json:"status"`
+				Progress int    `json:"progress"`
+				Total    int    `json:"total"`
+				Title    string `json:"title"`
+			} `json:"items"`
+			NextCursor string `json:"next_cursor"`
+		}
+		if err := c.do(http.MethodGet, "/api/tv/library?"+query.Encode(), nil, &page); err != nil {
+			return err
+		}
+		for _, item := range page.Items {
+			progress := "-"
+			if item.Type == "tv" {
+				progress = fmt.Sprintf("%d/%d", item.Progress, item.Total)
+			}
+			fmt.Fprintf(out, "%d\t%s\t%s\t%s\t%s\n", item.ID, item.Type, item.Status, progress, item.Title)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		query.Set("cursor", page.NextCursor)
+	}
+	return out.Flush()
+}
+
+// watch marks one episode watched, or unwatched with -unwatch
+func (c *Client) watch(args []string) error {
+	flags := flag.NewFlagSet("watch", flag.ContinueOnError)
+	unwatch := flags.Bool("unwatch", false, "mark the episode unwatched instead")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 3 {
+		return fmt.Errorf("watch needs TMDB_ID SEASON EPISODE")
+	}
+	for _, arg := range flags.Args() {
+		if _, err := strconv.Atoi(arg); err != nil {
+			return fmt.Errorf("%q is not a number", arg)
+		}
+	}
+
+	var result struct {
+		Progress int    `json:"progress"`
+		Total    int    `json:"total"`
+		Status   string `json:"status"`
+	}
+	path := fmt.Sprintf("/api/tv/episodes/%s/%s/%s", flags.Arg(0), flags.Arg(1), flags.Arg(2))
+	if err := c.do(http.MethodPut, path, map[string]bool{"watched": !*unwatch}, &result); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.Out, "%d/%d watched, %s\n", result.Progress, result.Total, result.Status)
+	return nil
+}
+
+// post creates a draft from a markdown file
+func (c *Client) post(args []string) error {
+	flags := flag.NewFlagSet("post", flag.ContinueOnError)
+	title := flags.String("title", "", "post title")
+	slug := flags.String("slug", "", "post slug (default: from the title)")
+	visibility := flags.String("visibility", "", "public, premium or admin")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("post needs one markdown FILE")
+	}
+
+	raw, err := os.ReadFile(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	content := string(raw)
+	if *title == "" {
+		*title, content = splitTitle(content)
+	}
+
+	var result struct {
+		ID  uint   `json:"id"`
+		URL string `json:"url"`
+	}
+	body := map[string]string{"title": *title, "content": content, "slug": *slug, "visibility": *visibility}
+	if err := c.do(http.MethodPost, "/api/posts", body, &result); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.Out, "Created draft %d: %s\n", result.ID, result.URL)
+	return nil
+}
+
+// splitTitle takes a leading "# " heading off markdown as the title
+func splitTitle(markdown string) (string, string) {
+	trimmed := strings.TrimLeft(markdown, "\r\n")
+	first, rest, _ := strings.Cut(trimmed, "\n")
+	if heading, ok := strings.CutPrefix(strings.TrimSpace(first), "# "); ok {
+		return strings.TrimSpace(heading), strings.TrimLeft(rest, "\r\n")
+	}
+	return "", markdown
+}
+
+// do sends body as JSON and decodes a JSON response into result; error responses become errors
+func (c *Client) do(method, path string, body, result interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequest(method, c.BaseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var problem struct {
+			Message string `json:"message"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&problem) == nil && problem.Message != "" {
+			return fmt.Errorf("%s (%d)", problem.Message, resp.StatusCode)
+		}
+		return fmt.Errorf("server answered %d", resp.StatusCode)
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+func envOr(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
@@ -1,7 +1,12 @@
 package config
 
 import (
+	"fmt"
 	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 	"github.com/kelseyhightower/envconfig"
@@ -17,33 +22,283 @@ type Config struct {
 		SSLMode  string `envconfig:"DB_SSL_MODE" default:"disable"`
 	}
 	JWT struct {
-		Secret string `envconfig:"JWT_SECRET" default:"your-secret-key-change-this-in-production"`
+		Secret     string        `envconfig:"JWT_SECRET"` // empty turns JWT sign-in for API clients off
+		AccessTTL  time.Duration `envconfig:"JWT_ACCESS_TTL" default:"15m"`
+		RefreshTTL time.Duration `envconfig:"JWT_REFRESH_TTL" default:"720h"`
 	}
 	Session struct {
-		Key string `envconfig:"SESSION_KEY" default:"your-session-secret-32-characters-long"`
+		Key          string   `envconfig:"SESSION_KEY" default:"your-session-secret-32-characters-long"` // signs new session cookies
+		PreviousKeys []string `envconfig:"SESSION_PREVIOUS_KEYS"`                                        // still accepted, so rotating SESSION_KEY keeps people signed in
+		SameSite     string   `envconfig:"SESSION_SAMESITE" default:"lax"`                               // lax or strict
+		Domain       string   `envconfig:"SESSION_COOKIE_DOMAIN"`                                        // empty limits the cookie to the exact host
+		Secure       string   `envconfig:"SESSION_SECURE" default:"auto"`                                // auto (secure in production), true or false
 	}
 	Server struct {
-		Port string `envconfig:"PORT" default:"8080"`
+		Port    string `envconfig:"PORT" default:"8080"`
+		BaseURL string `envconfig:"BASE_URL" default:"http://localhost:8080"` // public origin used in emails and feeds
 	}
 	Auth struct {
 		AdminEmail   string `envconfig:"ADMIN_EMAIL"`
 		ResendAPIKey string `envconfig:"RESEND_API_KEY"`
+		// ResendWebhookSecret verifies bounce and complaint webhooks (the whsec_ signing secret)
+		ResendWebhookSecret string `envconfig:"RESEND_WEBHOOK_SECRET"`
 	}
 	TMDB struct {
-		BearerToken string `envconfig:"TMDB_BEARER_TOKEN" required:"true"`
+		BearerToken  string        `envconfig:"TMDB_BEARER_TOKEN"`               // required unless SITE_MODE=blog
+		RouteTimeout time.Duration `envconfig:"TMDB_ROUTE_TIMEOUT" default:"4s"` // deadline for pages that call TMDB inline
+		// Call budgets the admin dashboard tracks usage against; 0 turns a budget off
+		HourlyBudget int `envconfig:"TMDB_HOURLY_BUDGET" default:"2000"`
+		DailyBudget  int `envconfig:"TMDB_DAILY_BUDGET" default:"20000"`
+	}
+	// TVDB is an optional second source of episode data, picked per show; an empty key turns it off
+	TVDB struct {
+		APIKey   string `envconfig:"TVDB_API_KEY"`
+		PIN      string `envconfig:"TVDB_PIN"` // only for user-supported keys
+		Language string `envconfig:"TVDB_LANGUAGE" default:"eng"`
+	}
+	Content struct {
+		HideAdult    bool     `envconfig:"HIDE_ADULT_CONTENT" default:"false"` // hide adult/NC-17 titles from visitors
+		WatchRegions []string `envconfig:"WATCH_REGIONS" default:"US"`         // countries whose streaming services show, first is preferred
+	}
+	Crawlers struct {
+		BlockPosts        bool   `envconfig:"CRAWL_BLOCK_POSTS" default:"false"`
+		BlockTV           bool   `envconfig:"CRAWL_BLOCK_TV" default:"false"`
+		Disallow          string `envconfig:"ROBOTS_DISALLOW"`                   // extra comma-separated paths for robots.txt
+		NoindexRestricted bool   `envconfig:"NOINDEX_RESTRICTED" default:"true"` // noindex premium/admin posts and share links
+	}
+	Tracker struct {
+		AutoWatching  bool   `envconfig:"TRACKER_AUTO_WATCHING" default:"true"`
+		CompleteRule  string `envconfig:"TRACKER_COMPLETE_RULE" default:"aired"` // aired, ended or off
+		PlannedResets bool   `envconfig:"TRACKER_PLANNED_RESETS" default:"true"`
+	}
+	Storage struct {
+		Dir     string `envconfig:"STORAGE_DIR" default:"uploads"`
+		BaseURL string `envconfig:"STORAGE_BASE_URL" default:"/uploads"`
+		// Driver "s3" keeps uploads in an S3-compatible bucket instead of under Dir
+		Driver            string `envconfig:"STORAGE_DRIVER" default:"disk"`
+		S3Bucket          string `envconfig:"S3_BUCKET"`
+		S3Region          string `envconfig:"S3_REGION" default:"us-east-1"`
+		S3Endpoint        string `envconfig:"S3_ENDPOINT"` // e.g. R2 or MinIO; AWS when empty
+		S3AccessKeyID     string `envconfig:"S3_ACCESS_KEY_ID"`
+		S3SecretAccessKey string `envconfig:"S3_SECRET_ACCESS_KEY"`
+		S3PublicURL       string `envconfig:"S3_PUBLIC_URL"` // where objects are served, e.g. a CDN; the bucket URL when empty
+	}
+	// Analytics counts public page views first-party and without cookies; Mode is "on", "consent" (only visitors who
+	// accept the banner) or "off". Do Not Track and Global Privacy Control are honored in every mode.
+	Analytics struct {
+		Mode          string `envconfig:"ANALYTICS_MODE" default:"on"`
+		CountryHeader string `envconfig:"ANALYTICS_COUNTRY_HEADER" default:"CF-IPCountry"` // set by the proxy in front, e.g. Cloudflare
+	}
+	// GeoIP places sign-ins and page views by country and city through MaxMind's web service; off without an account.
+	// URL is https://geolite.info for the free GeoLite2 service or https://geoip.maxmind.com for GeoIP2.
+	GeoIP struct {
+		AccountID  string `envconfig:"GEOIP_ACCOUNT_ID"`
+		LicenseKey string `envconfig:"GEOIP_LICENSE_KEY"`
+		URL        string `envconfig:"GEOIP_URL" default:"https://geolite.info"`
+	}
+	// Request body caps in echo's size format ("2M", "512K"); routes taking files get UploadSize instead of BodySize.
+	// Uploaded images must be one of ImageTypes and at most ImageDimension pixels wide and high (0 for no limit).
+	Limits struct {
+		BodySize       string   `envconfig:"MAX_BODY_SIZE" default:"2M"`
+		UploadSize     string   `envconfig:"MAX_UPLOAD_SIZE" default:"50M"`
+		ImageDimension int      `envconfig:"MAX_IMAGE_DIMENSION" default:"8000"`
+		ImageTypes     []string `envconfig:"UPLOAD_IMAGE_TYPES" default:"image/jpeg,image/png,image/gif,image/webp"`
+	}
+	TTS struct {
+		Provider string `envconfig:"TTS_PROVIDER"` // "openai" enables narration; empty disables it
+		APIKey   string `envconfig:"TTS_API_KEY"`
+		Model    string `envconfig:"TTS_MODEL" default:"tts-1"`
+		Voice    string `envconfig:"TTS_VOICE" default:"alloy"`
+	}
+	Payments struct {
+		StripeSecretKey     string `envconfig:"STRIPE_SECRET_KEY"` // empty hides the tip jar
+		StripeWebhookSecret string `envconfig:"STRIPE_WEBHOOK_SECRET"`
+		Currency            string `envconfig:"TIP_CURRENCY" default:"usd"`
+		Amounts             []int  `envconfig:"TIP_AMOUNTS" default:"3,5,10"` // whole units of Currency offered on the tip page
+	}
+	// Slash commands for tracking from chat; an empty secret turns that platform's endpoint off
+	Integrations struct {
+		SlackSigningSecret string `envconfig:"SLACK_SIGNING_SECRET"`
+		DiscordPublicKey   string `envconfig:"DISCORD_PUBLIC_KEY"` // hex, from the Discord application page
+	}
+	// Telegram bot in webhook mode; only ChatID may send it commands, and alerts go there
+	Telegram struct {
+		BotToken      string `envconfig:"TELEGRAM_BOT_TOKEN"` // empty turns the bot off
+		WebhookSecret string `envconfig:"TELEGRAM_WEBHOOK_SECRET"`
+		ChatID        int64  `envconfig:"TELEGRAM_CHAT_ID"`
+	}
+	// Single sign-on through any OpenID Connect provider (Keycloak, Authentik, ...). RoleClaim is a claim, or a
+	// dotted path such as realm_access.roles, whose values map to roles: AdminValues make an admin,
+	// PremiumValues premium and anything else a plain user. Without either list, roles are left to the site.
+	OIDC struct {
+		Issuer        string   `envconfig:"OIDC_ISSUER"` // empty turns SSO off
+		ClientID      string   `envconfig:"OIDC_CLIENT_ID"`
+		ClientSecret  string   `envconfig:"OIDC_CLIENT_SECRET"`
+		Name          string   `envconfig:"OIDC_NAME" default:"SSO"` // shown on the login button
+		Scopes        []string `envconfig:"OIDC_SCOPES" default:"openid,email,profile"`
+		RoleClaim     string   `envconfig:"OIDC_ROLE_CLAIM" default:"groups"`
+		AdminValues   []string `envconfig:"OIDC_ADMIN_VALUES"`
+		PremiumValues []string `envconfig:"OIDC_PREMIUM_VALUES"`
+	}
+	Moderation struct {
+		ReportThreshold int `envconfig:"REPORT_HIDE_THRESHOLD" default:"3"` // reporters before a post or comment is hidden; 0 never hides
+	}
+	// Admin hardening: AllowedIPs (addresses or CIDR ranges) limits admin routes to those clients, and destructive
+	// actions ask for the password or an emailed code again unless one was given within ReauthWindow
+	Admin struct {
+		AllowedIPs     []string      `envconfig:"ADMIN_ALLOWED_IPS"`     // empty allows every address
+		TrustedProxies []string      `envconfig:"ADMIN_TRUSTED_PROXIES"` // proxies whose X-Forwarded-For is believed; otherwise the peer address counts
+		ReauthWindow   time.Duration `envconfig:"ADMIN_REAUTH_WINDOW" default:"10m"`
+	}
+	Markdown struct {
+		CodeTheme string `envconfig:"CODE_THEME" default:"github"` // chroma style for fenced code blocks; shared by every site
+
+		// Kroki server that renders mermaid and plantuml blocks to SVG; empty (the default) leaves them
+		// as code, since every block, drafts included, is sent to it
+		DiagramURL string `envconfig:"DIAGRAM_URL"`
 	}
 	Env string `envconfig:"ENV" default:"development"`
+	// Mode serves half a site: "blog" drops the /tv tracker, "tracker" drops the blog; "full" is both
+	Mode string `envconfig:"SITE_MODE" default:"full"`
+}
+
+// Site modes
+const (
+	ModeFull    = "full"
+	ModeBlog    = "blog"
+	ModeTracker = "tracker"
+)
+
+// BlogEnabled reports whether the site serves posts
+func (c *Config) BlogEnabled() bool {
+	return c.Mode != ModeTracker
+}
+
+// TrackerEnabled reports whether the site serves the /tv tracker, which needs TMDB
+func (c *Config) TrackerEnabled() bool {
+	return c.Mode != ModeBlog
+}
+
+// insecureJWTSecret was the JWT_SECRET default, published in this repo; a site still using it has no secret at all
+const insecureJWTSecret = "your-secret-key-change-this-in-production"
+
+// JWTEnabled reports whether JWT_SECRET is set to something only this site knows, so JWTs can be issued and trusted
+func (c *Config) JWTEnabled() bool {
+	return c.JWT.Secret != "" && c.JWT.Secret != insecureJWTSecret
+}
+
+// SigningKeys are the keys signed links are checked against: SESSION_KEY, then SESSION_PREVIOUS_KEYS
+func (c *Config) SigningKeys() []string {
+	return append([]string{c.Session.Key}, c.Session.PreviousKeys...)
+}
+
+// Site is one blog/tracker served by this process, picked by the request's hostname
+type Site struct {
+	Name string
+	Host string // empty when a single site answers every hostname
+	*Config
 }
 
 func Load() *Config {
 	if err := godotenv.Load(); err != nil {
 		log.Println("No .env file found, using environment variables")
 	}
+	return process()
+}
+
+// LoadSites reads TENANTS ("name:host,name:host") and builds each site's config from
+// NAME_-prefixed variables, falling back to the unprefixed ones; without TENANTS there is one site
+func LoadSites() []Site {
+	cfg := Load()
+	tenants := os.Getenv("TENANTS")
+	if tenants == "" {
+		return []Site{{Config: cfg}}
+	}
+
+	var sites []Site
+	for _, entry := range strings.Split(tenants, ",") {
+		name, host, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		if !ok || name == "" || host == "" {
+			log.Fatalf("Invalid TENANTS entry %q, expected name:host", entry)
+		}
+		sites = append(sites, Site{Name: name, Host: strings.ToLower(host), Config: loadTenant(name)})
+	}
+	if err := checkTenants(sites); err != nil {
+		log.Fatalf("Invalid TENANTS: %v", err)
+	}
+	return sites
+}
+
+// checkTenants makes sure no two sites share what keeps them apart: a cookie or token signed for user N on
+// one site would sign in as user N on the other, and nested storage would serve one site's files from the other
+func checkTenants(sites []Site) error {
+	for i, a := range sites {
+		for _, b := range sites[i+1:] {
+			switch {
+			case a.DB.Host == b.DB.Host && a.DB.Port == b.DB.Port && a.DB.Name == b.DB.Name:
+				return fmt.Errorf("%s and %s share the database %s; set %s_DB_NAME", a.Name, b.Name, a.DB.Name, strings.ToUpper(b.Name))
+			case a.Session.Key == b.Session.Key:
+				return fmt.Errorf("%s and %s share SESSION_KEY; set %s_SESSION_KEY", a.Name, b.Name, strings.ToUpper(b.Name))
+			case a.JWTEnabled() && a.JWT.Secret == b.JWT.Secret:
+				return fmt.Errorf("%s and %s share JWT_SECRET; set %s_JWT_SECRET", a.Name, b.Name, strings.ToUpper(b.Name))
+			case a.Storage.Driver != "s3" && b.Storage.Driver != "s3" && nestedDirs(a.Storage.Dir, b.Storage.Dir):
+				return fmt.Errorf("%s and %s have overlapping storage directories %s and %s", a.Name, b.Name, a.Storage.Dir, b.Storage.Dir)
+			}
+		}
+	}
+	return nil
+}
+
+// nestedDirs reports whether a and b are the same directory or one is inside the other
+func nestedDirs(a, b string) bool {
+	a, b = filepath.Clean(a), filepath.Clean(b)
+	inside := func(dir, parent string) bool {
+		rel, err := filepath.Rel(parent, dir)
+		return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+	}
+	return inside(a, b) || inside(b, a)
+}
+
+// loadTenant processes the config with NAME_X temporarily standing in for X
+func loadTenant(name string) *Config {
+	prefix := strings.ToUpper(name) + "_"
+	restore := map[string]*string{}
+	for _, kv := range os.Environ() {
+		key, value, _ := strings.Cut(kv, "=")
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		base := strings.TrimPrefix(key, prefix)
+		if old, ok := os.LookupEnv(base); ok {
+			restore[base] = &old
+		} else {
+			restore[base] = nil
+		}
+		os.Setenv(base, value)
+	}
+	defer func() {
+		for key, old := range restore {
+			if old == nil {
+				os.Unsetenv(key)
+			} else {
+				os.Setenv(key, *old)
+			}
+		}
+	}()
+	return process()
+}
 
+func process() *Config {
 	var cfg Config
 	if err := envconfig.Process("", &cfg); err != nil {
 		log.Fatal("Error processing environment variables:", err)
 	}
-
+	cfg.Mode = strings.ToLower(strings.TrimSpace(cfg.Mode))
+	if cfg.Mode != ModeFull && cfg.Mode != ModeBlog && cfg.Mode != ModeTracker {
+		log.Fatalf("Invalid SITE_MODE %q, expected full, blog or tracker", cfg.Mode)
+	}
+	if cfg.TrackerEnabled() && cfg.TMDB.BearerToken == "" {
+		log.Fatal("TMDB_BEARER_TOKEN is required unless SITE_MODE=blog")
+	}
 	return &cfg
 }
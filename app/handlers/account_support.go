@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"mini-blog/app/models"
+	"mini-blog/app/services"
+	"mini-blog/app/templates"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// passwordResetScope keeps reset tokens from verifying as any other signed value
+	passwordResetScope = "password-reset"
+	passwordResetTTL   = 24 * time.Hour
+)
+
+// AdminUserResetLink emails the user a link to choose a new password. The link is also shown to the
+// admin, for when the email doesn't arrive and support passes it on another way.
+func (h *BaseHandler) AdminUserResetLink(c echo.Context) error {
+	admin := c.Get("user").(*models.User)
+	target, err := h.adminUserTarget(c)
+	if err != nil {
+		return err
+	}
+
+	link := absoluteURL(strings.TrimSuffix(h.cfg.Server.BaseURL, "/"), "/reset-password/"+h.passwordResetToken(target, time.Now().Add(passwordResetTTL)))
+	sent := true
+	if err := h.emailService.Send(target.Email, "Reset your password", services.PasswordResetHTML(target.Name, link)); err != nil {
+		log.Printf("Failed to send password reset to %s: %v", target.Email, err)
+		sent = false
+	}
+	h.recordActivity(target.ID, models.ActivitySupport, fmt.Sprintf("Password reset link sent by %s", admin.Name), "")
+	return h.render(c, templates.PasswordResetLinkNotice(link, sent))
+}
+
+// AdminUserVerify marks an account verified for a user whose code never arrived
+func (h *BaseHandler) AdminUserVerify(c echo.Context) error {
+	admin := c.Get("user").(*models.User)
+	target, err := h.adminUserTarget(c)
+	if err != nil {
+		return err
+	}
+	if target.IsVerified {
+		return h.render(c, templates.AdminUserRow(*target))
+	}
+
+	target.IsVerified, target.OTP, target.OTPExpiry, target.OTPAttempts = true, "", nil, 0
+	target.Role = h.verifiedRole(target)
+	if err := h.db.Model(target).Select("is_verified", "otp", "otp_expiry", "otp_attempts", "role").Updates(target).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to verify user")
+	}
+	h.recordActivity(target.ID, models.ActivitySupport, fmt.Sprintf("Account verified by %s", admin.Name), "")
+	return h.render(c, templates.AdminUserRow(*target))
+}
+
+// AdminUserSignOut ends every session the user has, JWTs included; site API tokens are theirs to revoke
+func (h *BaseHandler) AdminUserSignOut(c echo.Context) error {
+	admin := c.Get("user").(*models.User)
+	target, err := h.adminUserTarget(c)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	target.SessionsRevokedAt = &now
+	if err := h.db.Model(target).Update("sessions_revoked_at", now).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to sign user out")
+	}
+	h.recordActivity(target.ID, models.ActivitySupport, fmt.Sprintf("Signed out everywhere by %s", admin.Name), "")
+	return h.render(c, templates.AdminUserRow(*target))
+}
+
+// ResetPasswordPage asks for a new password, when the reset link is still good
+func (h *BaseHandler) ResetPasswordPage(c echo.Context) error {
+	token := c.Param("token")
+	if _, ok := h.passwordResetUser(token); !ok {
+		return h.render(c, templates.Layout("Reset password", templates.ResetPasswordForm(token, "This reset link has expired or was already used. Ask support for a new one."), c.Request().URL.Path))
+	}
+	return h.render(c, templates.Layout("Reset password", templates.ResetPasswordForm(token), c.Request().URL.Path))
+}
+
+// ResetPassword sets the new password, which also voids the link. Following the link proves the
+// address, so the account is verified too, and every other session is signed out.
+func (h *BaseHandler) ResetPassword(c echo.Context) error {
+	token := c.Param("token")
+	user, ok := h.passwordResetUser(token)
+	if !ok {
+		return h.render(c, templates.ResetPasswordFormContent(token, "This reset link has expired or was already used. Ask support for a new one."))
+	}
+	password := c.FormValue("password")
+	if password != c.FormValue("confirm_password") {
+		return h.render(c, templates.ResetPasswordFormContent(token, "Passwords do not match"))
+	}
+	if len(password) < 6 {
+		return h.render(c, templates.ResetPasswordFormContent(token, "Password must be at least 6 characters"))
+	}
+	if user.IsBanned() {
+		return h.render(c, templates.ResetPasswordFormContent(token, "This account has been suspended"))
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to process password")
+	}
+	now := time.Now()
+	user.Password, user.SessionsRevokedAt = string(hashedPassword), &now
+	user.IsVerified, user.OTP, user.OTPExpiry, user.OTPAttempts = true, "", nil, 0
+	if err := h.db.Model(user).Select("password", "sessions_revoked_at", "is_verified", "otp", "otp_expiry", "otp_attempts").Updates(user).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to reset password")
+	}
+
+	h.recordActivity(user.ID, models.ActivitySupport, "Password changed with a reset link", "")
+	h.setUserSession(c, user.ID)
+	c.Response().Header().Set("HX-Redirect", "/")
+	return c.NoContent(http.StatusOK)
+}
+
+// passwordResetToken is "<user id>.<expiry>.<signature>". The signature also covers the current password
+// hash, so a link stops working once it has been used.
+func (h *BaseHandler) passwordResetToken(user *models.User, expires time.Time) string {
+	value := fmt.Sprintf("%d.%d", user.ID, expires.Unix())
+	return value + "." + services.SignValue(h.cfg.Session.Key, passwordResetScope, value+"|"+user.Password)
+}
+
+// passwordResetUser returns the account a reset token is for, when the token is genuine, unused and unexpired
+func (h *BaseHandler) passwordResetUser(token string) (*models.User, bool) {
+	id, rest, _ := strings.Cut(token, ".")
+	expiry, signature, _ := strings.Cut(rest, ".")
+	userID, err := strconv.ParseUint(id, 10, 64)
+	unix, expiryErr := strconv.ParseInt(expiry, 10, 64)
+	if err != nil || expiryErr != nil || time.Now().After(time.Unix(unix, 0)) {
+		return nil, false
+	}
+	var user models.User
+	if err := h.db.First(&user, userID).Error; err != nil {
+		return nil, false
+	}
+	if !services.VerifyValue(h.cfg.SigningKeys(), passwordResetScope, id+"."+expiry+"|"+user.Password, signature) {
+		return nil, false
+	}
+	return &user, true
+}
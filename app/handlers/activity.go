@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"mini-blog/app/models"
+	"mini-blog/app/services"
+	"mini-blog/app/templates"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	activityPageSize = 20
+	// activityRetention is how long the activity log goes back
+	activityRetention = 90 * 24 * time.Hour
+)
+
+// SettingsActivity pages through the signed-in user's activity log, newest first (?page=)
+func (h *BaseHandler) SettingsActivity(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+	page := 1
+	if n, err := strconv.Atoi(c.QueryParam("page")); err == nil && n > 1 {
+		page = n
+	}
+
+	// One extra row tells whether there is an older page
+	var activities []models.Activity
+	h.db.Where("user_id = ?", user.ID).Order("created_at desc, id desc").
+		Offset((page - 1) * activityPageSize).Limit(activityPageSize + 1).Find(&activities)
+	more := len(activities) > activityPageSize
+	if more {
+		activities = activities[:activityPageSize]
+	}
+	return h.render(c, templates.ActivityPanel(activities, page, more))
+}
+
+// recordActivity adds an entry to the user's activity log; a failure is logged and otherwise ignored
+func (h *BaseHandler) recordActivity(userID uint, event, title, link string) {
+	if userID == 0 {
+		return
+	}
+	if err := h.db.Create(&models.Activity{UserID: userID, Event: event, Title: title, Link: link}).Error; err != nil {
+		log.Printf("Failed to record %s activity: %v", event, err)
+	}
+}
+
+// recordSignIn logs a sign-in, placed by GeoIP when it is set up; the address itself isn't kept
+func (h *BaseHandler) recordSignIn(c echo.Context, userID uint) {
+	title := "Signed in"
+	if location := h.clientLocation(c).String(); location != "" {
+		title += " from " + location
+	}
+	h.recordActivity(userID, models.ActivitySignIn, title, "")
+}
+
+// clientLocation places the visitor's address, read as the admin network check reads it, so only
+// ADMIN_TRUSTED_PROXIES can vouch for a forwarded one
+func (h *BaseHandler) clientLocation(c echo.Context) services.GeoLocation {
+	return h.geoIP.Lookup(h.adminIPExtractor(c.Request()))
+}
+
+// recordEpisodesWatched logs episodes of a show marked watched; what names them, such as "S1E2" or "season 2"
+func (h *BaseHandler) recordEpisodesWatched(user *models.User, tmdbID int, what string) {
+	if user == nil {
+		return
+	}
+	var media models.Media
+	h.db.Select("title").Where("tmdb_id = ?", tmdbID).First(&media)
+	h.recordActivity(user.ID, models.ActivityEpisodeWatched, fmt.Sprintf("Watched %s %s", media.Title, what), "/tv")
+}
+
+// PruneActivity drops activity older than activityRetention
+func (h *BaseHandler) PruneActivity() {
+	if err := h.db.Unscoped().Where("created_at < ?", time.Now().Add(-activityRetention)).Delete(&models.Activity{}).Error; err != nil {
+		log.Printf("Failed to prune activity: %v", err)
+	}
+}
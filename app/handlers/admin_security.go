@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"fmt"
+	"log"
+	"math/big"
+	"mini-blog/app/models"
+	"mini-blog/app/services"
+	"mini-blog/app/templates"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	reauthScope          = "reauth"
+	reauthCodeTTL        = 10 * time.Minute
+	reauthAttempts       = 5 // passwords, codes and code emails per admin per reauthAttemptWindow
+	reauthAttemptWindow  = 15 * time.Minute
+	reauthAtSessionKey   = "reauth_at"
+	reauthCodeSessionKey = "reauth_code" // signature of the emailed code, never the code itself
+	reauthExpSessionKey  = "reauth_code_expires"
+)
+
+// RestrictAdminIPs answers 403 on admin routes to clients outside ADMIN_ALLOWED_IPS; without a list everyone passes
+func (h *BaseHandler) RestrictAdminIPs(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if err := h.checkAdminNetwork(c); err != nil {
+			return err
+		}
+		return next(c)
+	}
+}
+
+// checkAdminNetwork is the ADMIN_ALLOWED_IPS check itself, shared by the admin route groups and requireAdmin,
+// which guards the admin-only API endpoints that live outside those groups
+func (h *BaseHandler) checkAdminNetwork(c echo.Context) error {
+	if len(h.adminNetworks) > 0 && !services.InNetworks(h.adminNetworks, h.adminIPExtractor(c.Request())) {
+		return echo.NewHTTPError(http.StatusForbidden, "Admin access is not allowed from this network")
+	}
+	return nil
+}
+
+// RequireReauth guards destructive admin actions. Admins who confirmed their password or an emailed code within
+// ADMIN_REAUTH_WINDOW go straight through; everyone else is asked to confirm first.
+func (h *BaseHandler) RequireReauth(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if h.recentlyReauthenticated(c) {
+			return next(c)
+		}
+		if h.isHTMXRequest(c) {
+			// Open the dialog over the page; the admin repeats the action once confirmed
+			c.Response().Header().Set("HX-Retarget", "body")
+			c.Response().Header().Set("HX-Reswap", "beforeend")
+			return h.render(c, templates.ReauthDialog())
+		}
+		return c.Redirect(http.StatusSeeOther, "/admin/reauth?next="+url.QueryEscape(c.Request().URL.RequestURI()))
+	}
+}
+
+// AdminReauthPage is the full-page confirmation for destructive links followed outside HTMX, such as exports
+func (h *BaseHandler) AdminReauthPage(c echo.Context) error {
+	next := reauthNext(c.QueryParam("next"))
+	if next == "" {
+		next = "/admin/dashboard"
+	}
+	page := templates.ReauthPanel(next, "", "", false)
+	return h.render(c, templates.Layout("Confirm it's you", page, c.Request().URL.Path, c.Get("user").(*models.User)))
+}
+
+// AdminReauth checks the admin's password or emailed code and opens the step-up window.
+// From the full page it continues to ?next=; in the dialog it says to repeat the action.
+func (h *BaseHandler) AdminReauth(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+	next := reauthNext(c.FormValue("next"))
+	if _, allowed := h.reauthLimiter.Allow(user.ID); !allowed {
+		return h.render(c, templates.ReauthPanel(next, "", "Too many attempts. Wait a few minutes and try again.", false))
+	}
+
+	session, _ := h.store.Get(c.Request(), "auth-session")
+	password, code := c.FormValue("password"), h.trimFormValue(c, "code")
+	confirmed := false
+	switch {
+	case code != "":
+		expires, _ := session.Values[reauthExpSessionKey].(int64)
+		signature, _ := session.Values[reauthCodeSessionKey].(string)
+		confirmed = time.Now().Unix() < expires &&
+			services.VerifyValue(h.cfg.SigningKeys(), reauthScope, fmt.Sprintf("%d|%d|%s", user.ID, expires, code), signature)
+	case password != "":
+		confirmed = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)) == nil
+	}
+	if !confirmed {
+		return h.render(c, templates.ReauthPanel(next, "", "That password or code isn't right", false))
+	}
+
+	delete(session.Values, reauthCodeSessionKey)
+	delete(session.Values, reauthExpSessionKey)
+	session.Values[reauthAtSessionKey] = time.Now().Unix()
+	if err := session.Save(c.Request(), c.Response()); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to save session")
+	}
+
+	if next != "" {
+		return h.htmxRedirect(c, next)
+	}
+	return h.render(c, templates.ReauthPanel("", "Confirmed. Run the action again.", "", true))
+}
+
+// AdminReauthCode emails the admin a one-time code, for when they'd rather not type their password
+func (h *BaseHandler) AdminReauthCode(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+	next := reauthNext(c.FormValue("next"))
+	if _, allowed := h.reauthLimiter.Allow(user.ID); !allowed {
+		return h.render(c, templates.ReauthPanel(next, "", "Too many attempts. Wait a few minutes and try again.", false))
+	}
+
+	n, err := rand.Int(rand.Reader, big.NewInt(1_000_000))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create a code")
+	}
+	code := fmt.Sprintf("%06d", n.Int64())
+	expires := time.Now().Add(reauthCodeTTL).Unix()
+
+	// The session cookie is signed but readable, so it holds a keyed signature rather than the code
+	session, _ := h.store.Get(c.Request(), "auth-session")
+	session.Values[reauthCodeSessionKey] = services.SignValue(h.cfg.Session.Key, reauthScope, fmt.Sprintf("%d|%d|%s", user.ID, expires, code))
+	session.Values[reauthExpSessionKey] = expires
+	if err := session.Save(c.Request(), c.Response()); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to save session")
+	}
+
+	if err := h.emailService.Send(user.Email, "Your confirmation code", services.ReauthCodeHTML(user.Name, code)); err != nil {
+		log.Printf("Failed to send confirmation code to %s: %v", user.Email, err)
+		return h.render(c, templates.ReauthPanel(next, "", "Couldn't send the code. Use your password instead.", false))
+	}
+	return h.render(c, templates.ReauthPanel(next, "We emailed you a code. It works for 10 minutes.", "", false))
+}
+
+// recentlyReauthenticated reports whether this session confirmed the admin's identity within the step-up window
+func (h *BaseHandler) recentlyReauthenticated(c echo.Context) bool {
+	session, _ := h.store.Get(c.Request(), "auth-session")
+	at, ok := session.Values[reauthAtSessionKey].(int64)
+	return ok && time.Since(time.Unix(at, 0)) < h.cfg.Admin.ReauthWindow
+}
+
+// reauthNext keeps ?next= to paths on this site, so the confirmation page can't bounce admins elsewhere
+func reauthNext(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return ""
+	}
+	return next
+}
@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"log"
+	"mini-blog/app/models"
+	"mini-blog/app/services"
+	"mini-blog/app/templates"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	analyticsConsentCookie = "analytics_consent"
+	analyticsConsentGiven  = "granted"
+	analyticsTopN          = 10
+)
+
+// analyticsPrivatePaths are never counted: admin and account pages, and pages reached from personal email
+// or shared links, whose paths carry live tokens
+var analyticsPrivatePaths = []string{"/admin", "/settings", "/email", "/subscribe", "/logout", "/reset-password", "/preview"}
+
+// analyticsRanges are the periods the analytics page offers, in days
+var analyticsRanges = []int{7, 30, 90}
+
+// CollectPageView counts successful full-page GETs of public pages as anonymous page views.
+// Visitors sending Do Not Track or Global Privacy Control are skipped, as are bots and admins.
+func (h *BaseHandler) CollectPageView(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		err := next(c)
+		if err != nil || !h.countsPageView(c) {
+			return err
+		}
+
+		req := c.Request()
+		view := models.PageView{
+			Path:     req.URL.Path,
+			Referrer: services.ReferrerHost(req.Referer(), req.Host),
+			Country:  services.CountryCode(req.Header.Get(h.cfg.Analytics.CountryHeader)),
+		}
+		if !h.geoIP.Enabled() {
+			h.savePageView(view)
+			return nil
+		}
+		// A GeoIP lookup can take a moment for an address it hasn't seen, so the view is saved after the response
+		ip := h.adminIPExtractor(req)
+		go func() {
+			location := h.geoIP.Lookup(ip)
+			if view.Country == "" {
+				view.Country = location.Country
+			}
+			if location.City != "" && location.Country != "" {
+				view.City = location.City + ", " + location.Country
+			}
+			h.savePageView(view)
+		}()
+		return nil
+	}
+}
+
+func (h *BaseHandler) savePageView(view models.PageView) {
+	if err := h.db.Create(&view).Error; err != nil {
+		log.Printf("Failed to record page view of %s: %v", view.Path, err)
+	}
+}
+
+func (h *BaseHandler) countsPageView(c echo.Context) bool {
+	req, res := c.Request(), c.Response()
+	// Paths past the column size are junk requests that happened to render
+	if req.Method != http.MethodGet || h.isHTMXRequest(c) || len(req.URL.Path) > 255 {
+		return false
+	}
+	// Pages are templ components written straight to the writer, so echo never records a status or type for them;
+	// redirects, feeds, JSON and files all go through echo and set theirs
+	if res.Committed && res.Status != http.StatusOK {
+		return false
+	}
+	if contentType := res.Header().Get(echo.HeaderContentType); contentType != "" && !strings.HasPrefix(contentType, echo.MIMETextHTML) {
+		return false
+	}
+	for _, prefix := range analyticsPrivatePaths {
+		if strings.HasPrefix(req.URL.Path, prefix) {
+			return false
+		}
+	}
+	if !h.analyticsAllowed(c) || services.IsBot(req.UserAgent()) {
+		return false
+	}
+	user := h.GetCurrentUser(c)
+	return user == nil || !user.IsAdmin()
+}
+
+// analyticsAllowed reports whether this visitor may be counted under the configured mode
+func (h *BaseHandler) analyticsAllowed(c echo.Context) bool {
+	if services.DoNotTrack(c.Request()) {
+		return false
+	}
+	switch h.cfg.Analytics.Mode {
+	case models.AnalyticsOn:
+		return true
+	case models.AnalyticsConsent:
+		cookie, err := c.Cookie(analyticsConsentCookie)
+		return err == nil && cookie.Value == analyticsConsentGiven
+	default:
+		return false
+	}
+}
+
+// analyticsConsentPending reports whether the consent banner should ask this visitor
+func (h *BaseHandler) analyticsConsentPending(c echo.Context) bool {
+	if h.cfg.Analytics.Mode != models.AnalyticsConsent || services.DoNotTrack(c.Request()) {
+		return false
+	}
+	_, err := c.Cookie(analyticsConsentCookie)
+	return err != nil
+}
+
+// AnalyticsConsent stores the visitor's answer to the consent banner (choice=granted|denied) and removes the banner
+func (h *BaseHandler) AnalyticsConsent(c echo.Context) error {
+	choice := "denied"
+	if c.FormValue("choice") == analyticsConsentGiven {
+		choice = analyticsConsentGiven
+	}
+	c.SetCookie(&http.Cookie{
+		Name:     analyticsConsentCookie,
+		Value:    choice,
+		Path:     "/",
+		MaxAge:   86400 * 365,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return c.NoContent(http.StatusOK)
+}
+
+// AdminAnalytics shows page views, top pages, referrers, countries and cities for the last ?days= days
+func (h *BaseHandler) AdminAnalytics(c echo.Context) error {
+	days, _ := strconv.Atoi(c.QueryParam("days"))
+	if !slices.Contains(analyticsRanges, days) {
+		days = 30
+	}
+
+	page := templates.AnalyticsPage(h.analyticsSummary(days, h.userLocation(c)), analyticsRanges, h.cfg.Analytics.Mode)
+	if h.isHTMXRequest(c) {
+		return h.render(c, page)
+	}
+	return h.render(c, templates.Layout("Analytics", page, c.Request().URL.Path, c.Get("user").(*models.User)))
+}
+
+// analyticsSummary totals page views over the last days days, with days starting at midnight in loc
+func (h *BaseHandler) analyticsSummary(days int, loc *time.Location) models.AnalyticsSummary {
+	now := time.Now().In(loc)
+	since := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc).AddDate(0, 0, 1-days)
+	summary := models.AnalyticsSummary{Days: days}
+	for day := since; !day.After(now); day = day.AddDate(0, 0, 1) {
+		summary.Daily = append(summary.Daily, models.AnalyticsCount{Label: day.Format("2006-01-02")})
+	}
+
+	var viewed []time.Time
+	h.db.Model(&models.PageView{}).Where("created_at >= ?", since).Pluck("created_at", &viewed)
+	summary.Views = int64(len(viewed))
+	for _, at := range viewed {
+		at = at.In(loc)
+		// Rounded to whole days, so the 23 and 25 hour days around DST changes land right
+		i := int(time.Date(at.Year(), at.Month(), at.Day(), 0, 0, 0, 0, loc).Sub(since).Hours()+12) / 24
+		if i >= 0 && i < len(summary.Daily) {
+			summary.Daily[i].Views++
+		}
+	}
+
+	summary.Pages = h.topPageViews(since, "path")
+	summary.Referrers = h.topPageViews(since, "referrer")
+	summary.Countries = h.topPageViews(since, "country")
+	summary.Cities = h.topPageViews(since, "city")
+	return summary
+}
+
+// topPageViews ranks the values of column by views since since, leaving out empty values
+func (h *BaseHandler) topPageViews(since time.Time, column string) []models.AnalyticsCount {
+	var counts []models.AnalyticsCount
+	h.db.Model(&models.PageView{}).
+		Select(column+" AS label, COUNT(*) AS views").
+		Where("created_at >= ? AND "+column+" <> ''", since).
+		Group(column).
+		Order("views desc").
+		Limit(analyticsTopN).
+		Scan(&counts)
+	return counts
+}
@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"fmt"
+	"mini-blog/app/models"
+	"mini-blog/app/services"
+	"mini-blog/app/templates"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultAnnouncementRate = 60             // emails per minute
+	maxAnnouncementRate     = emailQueueRate // faster would only pile up in the queue
+
+	segmentAudiencePrefix = "segment:"
+)
+
+// announcementForm is the compose form shared by preview, test-send and send
+type announcementForm struct {
+	Subject  string `validate:"required,max=200"`
+	Body     string `validate:"required"`
+	Audience string
+	Rate     int
+	Tracking bool
+}
+
+// AdminAnnouncements is the compose page for one-off broadcast emails
+func (h *BaseHandler) AdminAnnouncements(c echo.Context) error {
+	form := announcementForm{Audience: models.AudienceAll, Rate: defaultAnnouncementRate, Tracking: true}
+	return h.renderAnnouncements(c, form, "", "")
+}
+
+// AdminAnnouncementPreview renders the email as recipients will see it
+func (h *BaseHandler) AdminAnnouncementPreview(c echo.Context) error {
+	form := h.bindAnnouncementForm(c)
+	return h.render(c, templates.AnnouncementPreview(services.AnnouncementHTML(form.Subject, services.MarkdownToHTML(form.Body)), h.audienceSize(form.Audience)))
+}
+
+// AdminAnnouncementTest sends the announcement to the current admin only, bypassing the queue
+func (h *BaseHandler) AdminAnnouncementTest(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+	form := h.bindAnnouncementForm(c)
+	if err := h.validator.Struct(form); err != nil {
+		return h.renderAnnouncements(c, form, "", "Subject and message are required")
+	}
+
+	html := services.AnnouncementHTML(form.Subject, services.MarkdownToHTML(form.Body))
+	if err := h.emailService.Send(user.Email, "[Test] "+form.Subject, html); err != nil {
+		return h.renderAnnouncements(c, form, "", "Test send failed: "+err.Error())
+	}
+	return h.renderAnnouncements(c, form, "Test email sent to "+user.Email, "")
+}
+
+// AdminAnnouncementSend queues the announcement for every verified user in the chosen audience
+func (h *BaseHandler) AdminAnnouncementSend(c echo.Context) error {
+	form := h.bindAnnouncementForm(c)
+	if err := h.validator.Struct(form); err != nil {
+		return h.renderAnnouncements(c, form, "", "Subject and message are required")
+	}
+
+	recipients := h.audienceRecipients(form.Audience)
+	if len(recipients) == 0 {
+		return h.renderAnnouncements(c, form, "", "No verified users in this audience")
+	}
+
+	now := time.Now()
+	campaign := models.EmailCampaign{
+		Name:            form.Subject,
+		Subject:         form.Subject,
+		Kind:            models.CampaignAnnouncement,
+		Audience:        form.Audience,
+		TrackingEnabled: form.Tracking,
+		SentAt:          &now,
+	}
+	if err := h.db.Create(&campaign).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create campaign")
+	}
+
+	html := services.AnnouncementHTML(form.Subject, services.MarkdownToHTML(form.Body))
+	go h.deliverCampaign(campaign, models.EmailKindAnnouncement, recipients, html, time.Minute/time.Duration(form.Rate))
+
+	minutes := (len(recipients) + form.Rate - 1) / form.Rate
+	message := fmt.Sprintf("Queued for %d recipient(s), sending over about %d minute(s)", len(recipients), minutes)
+	return h.renderAnnouncements(c, announcementForm{Audience: form.Audience, Rate: form.Rate, Tracking: form.Tracking}, message, "")
+}
+
+func (h *BaseHandler) bindAnnouncementForm(c echo.Context) announcementForm {
+	form := announcementForm{
+		Subject:  h.trimFormValue(c, "subject"),
+		Body:     h.trimFormValue(c, "body"),
+		Audience: c.FormValue("audience"),
+		Tracking: c.FormValue("tracking") == "on",
+	}
+	if !models.IsValidAudience(form.Audience) && h.audienceSegment(form.Audience) == nil {
+		form.Audience = models.AudienceAll
+	}
+
+	form.Rate, _ = strconv.Atoi(c.FormValue("rate"))
+	if form.Rate <= 0 {
+		form.Rate = defaultAnnouncementRate
+	}
+	if form.Rate > maxAnnouncementRate {
+		form.Rate = maxAnnouncementRate
+	}
+	return form
+}
+
+// audienceRecipients lists verified email addresses in an audience
+func (h *BaseHandler) audienceRecipients(audience string) []string {
+	var emails []string
+	h.audienceQuery(audience).Pluck("email", &emails)
+	return emails
+}
+
+func (h *BaseHandler) audienceSize(audience string) int64 {
+	var count int64
+	h.audienceQuery(audience).Count(&count)
+	return count
+}
+
+func (h *BaseHandler) audienceQuery(audience string) *gorm.DB {
+	db := h.db.Model(&models.User{}).Where("is_verified = ?", true)
+	switch audience {
+	case models.AudiencePremium:
+		db = db.Where("role = ?", models.RolePremium)
+	case models.AudienceAdmins:
+		db = db.Where("role = ?", models.RoleAdmin)
+	default:
+		if segment := h.audienceSegment(audience); segment != nil {
+			db = segment.Apply(db)
+		}
+	}
+	return db
+}
+
+// audienceSegment resolves a "segment:<id>" audience to its saved segment
+func (h *BaseHandler) audienceSegment(audience string) *models.Segment {
+	id, err := strconv.ParseUint(strings.TrimPrefix(audience, segmentAudiencePrefix), 10, 64)
+	if err != nil || !strings.HasPrefix(audience, segmentAudiencePrefix) {
+		return nil
+	}
+	var segment models.Segment
+	if h.db.First(&segment, id).Error != nil {
+		return nil
+	}
+	return &segment
+}
+
+// audienceOptions lists the built-in audiences followed by saved segments, with recipient counts
+func (h *BaseHandler) audienceOptions() []templates.SelectOption {
+	var options []templates.SelectOption
+	for _, audience := range models.Audiences {
+		options = append(options, templates.SelectOption{
+			Value: audience,
+			Label: fmt.Sprintf("%s (%d)", models.AudienceNames[audience], h.audienceSize(audience)),
+		})
+	}
+
+	var segments []models.Segment
+	h.db.Order("name asc").Find(&segments)
+	for _, segment := range segments {
+		audience := segmentAudiencePrefix + strconv.FormatUint(uint64(segment.ID), 10)
+		options = append(options, templates.SelectOption{
+			Value: audience,
+			Label: fmt.Sprintf("Segment: %s (%d)", segment.Name, h.audienceSize(audience)),
+		})
+	}
+	return options
+}
+
+func (h *BaseHandler) renderAnnouncements(c echo.Context, form announcementForm, successMessage, errorMessage string) error {
+	page := templates.AnnouncementsPage(form.Subject, form.Body, form.Audience, form.Rate, maxAnnouncementRate, form.Tracking, h.audienceOptions(), successMessage, errorMessage)
+	if h.isHTMXRequest(c) {
+		return h.render(c, page)
+	}
+	return h.render(c, templates.Layout("Announcements", page, c.Request().URL.Path, c.Get("user").(*models.User)))
+}
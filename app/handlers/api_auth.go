@@ -0,0 +1,234 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"mini-blog/app/models"
+	"mini-blog/app/services"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	jwtLoginAttempts = 10 // per account per jwtLoginWindow
+	jwtLoginWindow   = 15 * time.Minute
+)
+
+// tokenPair is what API clients get from signing in or refreshing
+type tokenPair struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"` // seconds until the access token expires
+}
+
+// JWTAuth signs in /api requests that carry a JWT access token as "Authorization: Bearer".
+// Bad, expired, revoked and refresh tokens are turned away; site API tokens and requests without
+// a token pass through to the usual checks. /api/auth/ is left alone, so a client holding an
+// expired access token can still refresh.
+func (h *BaseHandler) JWTAuth(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		path := c.Request().URL.Path
+		token, ok := bearerToken(c)
+		if !ok || strings.HasPrefix(token, apiTokenPrefix) || !strings.HasPrefix(path, "/api/") || strings.HasPrefix(path, "/api/auth/") {
+			return next(c)
+		}
+
+		claims, err := h.parseLiveJWT(token, services.JWTAccess)
+		if err != nil {
+			c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Bearer error="invalid_token"`)
+			return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+		}
+		var user models.User
+		if err := h.db.First(&user, claims.Subject).Error; err != nil {
+			return echo.NewHTTPError(http.StatusUnauthorized, "Account no longer exists")
+		}
+		if user.IsBanned() {
+			return echo.NewHTTPError(http.StatusForbidden, "This account has been suspended")
+		}
+		if user.SessionRevoked(time.Unix(claims.IssuedAt, 0)) {
+			c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Bearer error="invalid_token"`)
+			return echo.NewHTTPError(http.StatusUnauthorized, "Token has been revoked")
+		}
+		c.Set("current_user", &user)
+		return next(c)
+	}
+}
+
+// APIAuthToken signs an API client in with email and password (JSON or form) and issues an access and refresh token
+func (h *BaseHandler) APIAuthToken(c echo.Context) error {
+	if !h.cfg.JWTEnabled() {
+		return echo.NewHTTPError(http.StatusNotFound, "JWT sign-in is not configured")
+	}
+	var body struct {
+		Email    string `json:"email" form:"email"`
+		Password string `json:"password" form:"password"`
+	}
+	if err := c.Bind(&body); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request body")
+	}
+	body.Email = strings.TrimSpace(body.Email)
+	if body.Email == "" || body.Password == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "Email and password are required")
+	}
+
+	var user models.User
+	if err := h.db.Where("email = ?", body.Email).First(&user).Error; err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Invalid email or password")
+	}
+	if _, allowed := h.jwtLoginLimiter.Allow(user.ID); !allowed {
+		return echo.NewHTTPError(http.StatusTooManyRequests, "Too many attempts. Wait a few minutes and try again.")
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(body.Password)) != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Invalid email or password")
+	}
+	if !user.IsVerified {
+		return echo.NewHTTPError(http.StatusForbidden, "Please verify your email before logging in")
+	}
+	if user.IsBanned() {
+		return echo.NewHTTPError(http.StatusForbidden, "This account has been suspended")
+	}
+	return h.issueTokenPair(c, user.ID)
+}
+
+// APIAuthRefresh trades a refresh token for a new pair. The old refresh token is revoked, so each works once.
+func (h *BaseHandler) APIAuthRefresh(c echo.Context) error {
+	var body struct {
+		RefreshToken string `json:"refresh_token" form:"refresh_token"`
+	}
+	if err := c.Bind(&body); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request body")
+	}
+
+	claims, err := h.parseLiveJWT(body.RefreshToken, services.JWTRefresh)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+	}
+	revoked, err := h.revokeJWT(claims)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to refresh token")
+	}
+	if !revoked {
+		// Another request spent this refresh token first
+		return echo.NewHTTPError(http.StatusUnauthorized, "Token has been revoked")
+	}
+	var user models.User
+	if err := h.db.First(&user, claims.Subject).Error; err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Account no longer exists")
+	}
+	if user.IsBanned() {
+		return echo.NewHTTPError(http.StatusForbidden, "This account has been suspended")
+	}
+	// Signing out everywhere, or a password reset, ends refresh tokens issued before it
+	if user.SessionRevoked(time.Unix(claims.IssuedAt, 0)) {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Token has been revoked")
+	}
+	return h.issueTokenPair(c, user.ID)
+}
+
+// APIAuthRevoke signs an API client out: the bearer access token and any refresh_token in the body stop working
+func (h *BaseHandler) APIAuthRevoke(c echo.Context) error {
+	var body struct {
+		RefreshToken string `json:"refresh_token" form:"refresh_token"`
+	}
+	if err := c.Bind(&body); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request body")
+	}
+
+	var tokens []services.JWTClaims
+	if access, ok := bearerToken(c); ok && !strings.HasPrefix(access, apiTokenPrefix) {
+		claims, err := h.parseLiveJWT(access, services.JWTAccess)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+		}
+		tokens = append(tokens, claims)
+	}
+	if body.RefreshToken != "" {
+		claims, err := h.parseLiveJWT(body.RefreshToken, services.JWTRefresh)
+		// Revoking a token that's already dead is a no-op, not an error
+		if err == nil {
+			tokens = append(tokens, claims)
+		}
+	}
+	if len(tokens) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "No token to revoke")
+	}
+	// A client may only revoke its own tokens
+	for _, claims := range tokens[1:] {
+		if claims.Subject != tokens[0].Subject {
+			return echo.NewHTTPError(http.StatusForbidden, "Tokens belong to different accounts")
+		}
+	}
+
+	for _, claims := range tokens {
+		if _, err := h.revokeJWT(claims); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to revoke token")
+		}
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// issueTokenPair answers with a new access and refresh token for userID
+func (h *BaseHandler) issueTokenPair(c echo.Context, userID uint) error {
+	if !h.cfg.JWTEnabled() {
+		return echo.NewHTTPError(http.StatusNotFound, "JWT sign-in is not configured")
+	}
+	access, _, err := services.NewJWT(h.cfg.JWT.Secret, userID, services.JWTAccess, h.cfg.JWT.AccessTTL)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to issue token")
+	}
+	refresh, _, err := services.NewJWT(h.cfg.JWT.Secret, userID, services.JWTRefresh, h.cfg.JWT.RefreshTTL)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to issue token")
+	}
+	c.Response().Header().Set(echo.HeaderCacheControl, "no-store")
+	return c.JSON(http.StatusOK, tokenPair{
+		AccessToken:  access,
+		RefreshToken: refresh,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(h.cfg.JWT.AccessTTL.Seconds()),
+	})
+}
+
+// parseLiveJWT checks a token of kind is well formed, signed by this site, unexpired and not revoked
+func (h *BaseHandler) parseLiveJWT(token, kind string) (services.JWTClaims, error) {
+	if !h.cfg.JWTEnabled() {
+		return services.JWTClaims{}, errors.New("JWT sign-in is not configured")
+	}
+	claims, err := services.ParseJWT(h.cfg.JWT.Secret, token)
+	switch {
+	case errors.Is(err, services.ErrJWTExpired):
+		return claims, errors.New("Token expired")
+	case err != nil:
+		return claims, errors.New("Invalid token")
+	case claims.Kind != kind:
+		return claims, errors.New("Wrong kind of token")
+	}
+	var revoked int64
+	h.db.Model(&models.RevokedToken{}).Where("id = ?", claims.ID).Count(&revoked)
+	if revoked > 0 {
+		return claims, errors.New("Token has been revoked")
+	}
+	return claims, nil
+}
+
+// revokeJWT adds a token to the denylist, reporting false if it was already there.
+// Entries for tokens that have since expired are cleared out on the way.
+func (h *BaseHandler) revokeJWT(claims services.JWTClaims) (bool, error) {
+	if err := h.db.Where("expires_at < ?", time.Now()).Delete(&models.RevokedToken{}).Error; err != nil {
+		log.Printf("Failed to prune revoked tokens: %v", err)
+	}
+	result := h.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.RevokedToken{ID: claims.ID, ExpiresAt: claims.Expires()})
+	return result.RowsAffected > 0, result.Error
+}
+
+// bearerToken returns the "Authorization: Bearer" credential, if the request sent one
+func bearerToken(c echo.Context) (string, bool) {
+	token, ok := strings.CutPrefix(c.Request().Header.Get(echo.HeaderAuthorization), "Bearer ")
+	return strings.TrimSpace(token), ok && strings.TrimSpace(token) != ""
+}
@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"mini-blog/app/models"
+	"mini-blog/app/templates"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+const (
+	// apiTokenPrefix marks the site's tokens so they are easy to spot in scripts and secret scanners
+	apiTokenPrefix = "mb_"
+	// apiRateLimit is how many calls one token may make a minute
+	apiRateLimit = 120
+	// apiTokenErrorsKept is how many recent failed calls each token keeps for its settings panel
+	apiTokenErrorsKept = 10
+)
+
+// APITokenUsage counts calls made with an API token, holds each token to apiRateLimit calls a minute
+// and keeps its latest errors. Requests without a token pass straight through.
+func (h *BaseHandler) APITokenUsage(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		apiToken := h.requestAPIToken(c)
+		if apiToken == nil {
+			return next(c)
+		}
+
+		limit, allowed := h.apiLimiter.Allow(apiToken.ID)
+		header := c.Response().Header()
+		header.Set("X-RateLimit-Limit", strconv.Itoa(limit.Limit))
+		header.Set("X-RateLimit-Remaining", strconv.Itoa(limit.Remaining))
+		header.Set("X-RateLimit-Reset", strconv.FormatInt(limit.Reset.Unix(), 10))
+		if !allowed {
+			header.Set("Retry-After", strconv.Itoa(int(time.Until(limit.Reset).Seconds())+1))
+			err := echo.NewHTTPError(http.StatusTooManyRequests, "Rate limit exceeded")
+			h.recordAPICall(c, apiToken, err)
+			return err
+		}
+
+		c.Set("api_token", apiToken)
+		err := next(c)
+		h.recordAPICall(c, apiToken, err)
+		return err
+	}
+}
+
+// requestAPIToken finds the token sent as "Authorization: Bearer", if it is one of the site's
+func (h *BaseHandler) requestAPIToken(c echo.Context) *models.APIToken {
+	token, ok := strings.CutPrefix(c.Request().Header.Get(echo.HeaderAuthorization), "Bearer ")
+	if !ok || !strings.HasPrefix(token, apiTokenPrefix) {
+		return nil
+	}
+
+	var apiToken models.APIToken
+	if err := h.db.Where("token_hash = ?", hashAPIToken(token)).First(&apiToken).Error; err != nil {
+		return nil
+	}
+	return &apiToken
+}
+
+// recordAPICall adds a call to the token's counts, keeping it among the token's recent errors if it failed
+func (h *BaseHandler) recordAPICall(c echo.Context, apiToken *models.APIToken, err error) {
+	status := c.Response().Status
+	message := ""
+	if err != nil {
+		status, message = http.StatusInternalServerError, err.Error()
+		if httpErr, ok := err.(*echo.HTTPError); ok {
+			status, message = httpErr.Code, fmt.Sprint(httpErr.Message)
+		}
+	}
+
+	counts := map[string]interface{}{"call_count": gorm.Expr("call_count + 1")}
+	if status >= http.StatusBadRequest {
+		counts["error_count"] = gorm.Expr("error_count + 1")
+	}
+	if err := h.db.Model(apiToken).UpdateColumns(counts).Error; err != nil {
+		log.Printf("Failed to count API call for token %d: %v", apiToken.ID, err)
+	}
+	if status < http.StatusBadRequest {
+		return
+	}
+
+	if message == "" {
+		message = http.StatusText(status)
+	}
+	h.db.Create(&models.APITokenError{
+		TokenID: apiToken.ID, Method: c.Request().Method, Path: c.Request().URL.Path, Status: status, Message: message,
+	})
+	kept := h.db.Model(&models.APITokenError{}).Select("id").Where("token_id = ?", apiToken.ID).Order("id desc").Limit(apiTokenErrorsKept)
+	h.db.Where("token_id = ? AND id NOT IN (?)", apiToken.ID, kept).Delete(&models.APITokenError{})
+}
+
+// tokenUser authenticates a request by its "Authorization: Bearer" API token, for clients without a session.
+// Tokens made before the user signed out everywhere (or reset their password) no longer work.
+func (h *BaseHandler) tokenUser(c echo.Context) *models.User {
+	apiToken, ok := c.Get("api_token").(*models.APIToken)
+	if !ok {
+		if apiToken = h.requestAPIToken(c); apiToken == nil {
+			return nil
+		}
+	}
+	var user models.User
+	if err := h.db.First(&user, apiToken.UserID).Error; err != nil || user.IsBanned() || user.SessionRevoked(apiToken.CreatedAt) {
+		return nil
+	}
+
+	// Like LastSeenAt, hour granularity is plenty
+	if now := time.Now(); apiToken.LastUsedAt == nil || now.Sub(*apiToken.LastUsedAt) > time.Hour {
+		h.db.Model(apiToken).UpdateColumn("last_used_at", now)
+	}
+	return &user
+}
+
+// APITokenCreate issues a token for the signed-in user; it is shown once and only its hash is kept
+func (h *BaseHandler) APITokenCreate(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create token")
+	}
+	token := apiTokenPrefix + hex.EncodeToString(secret)
+
+	apiToken := models.APIToken{UserID: user.ID, Name: h.trimFormValue(c, "name"), TokenHash: hashAPIToken(token)}
+	if err := h.validator.Struct(apiToken); err != nil {
+		return h.renderAPITokens(c, user, "", "Give the token a name")
+	}
+	if err := h.db.Create(&apiToken).Error; err != nil {
+		return h.renderAPITokens(c, user, "", "Failed to create token")
+	}
+	return h.renderAPITokens(c, user, token, "")
+}
+
+// APITokenDelete revokes one of the signed-in user's tokens
+func (h *BaseHandler) APITokenDelete(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+	id, err := h.parseUintParam(c, "id")
+	if err != nil {
+		return err
+	}
+
+	if err := h.db.Where("user_id = ?", user.ID).Delete(&models.APIToken{}, id).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to revoke token")
+	}
+	return h.renderAPITokens(c, user, "", "")
+}
+
+// apiTokens lists the user's tokens with their recent errors and current rate-limit window
+func (h *BaseHandler) apiTokens(user *models.User) []models.APIToken {
+	var tokens []models.APIToken
+	h.db.Preload("Errors", func(db *gorm.DB) *gorm.DB { return db.Order("id desc") }).
+		Where("user_id = ?", user.ID).Order("created_at desc").Find(&tokens)
+	for i := range tokens {
+		limit := h.apiLimiter.Status(tokens[i].ID)
+		tokens[i].RateLimit, tokens[i].RateLimitRemaining, tokens[i].RateLimitReset = limit.Limit, limit.Remaining, limit.Reset
+	}
+	return tokens
+}
+
+func (h *BaseHandler) renderAPITokens(c echo.Context, user *models.User, newToken, errorMessage string) error {
+	return h.render(c, templates.APITokensPanel(h.apiTokens(user), newToken, errorMessage))
+}
+
+func hashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -1,8 +1,11 @@
 package handlers
 
 import (
+	"crypto/rand"
+	"crypto/subtle"
 	"fmt"
-	"math/rand"
+	"hash/fnv"
+	"math/big"
 	"mini-blog/app/models"
 	"mini-blog/app/templates"
 	"net/http"
@@ -11,15 +14,16 @@ import (
 
 	"github.com/labstack/echo/v4"
 	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
 )
 
 // Auth page handlers
 func (h *BaseHandler) SignupPage(c echo.Context) error {
-	return h.render(c, templates.Layout("Sign Up", templates.SignupForm(), c.Request().URL.Path))
+	return h.render(c, templates.Layout(h.t(c, "auth.signup"), templates.SignupForm(), c.Request().URL.Path))
 }
 
 func (h *BaseHandler) LoginPage(c echo.Context) error {
-	return h.render(c, templates.Layout("Login", templates.LoginForm(), c.Request().URL.Path))
+	return h.render(c, templates.Layout(h.t(c, "auth.login"), templates.LoginForm(), c.Request().URL.Path))
 }
 
 // Auth action handlers
@@ -42,7 +46,7 @@ func (h *BaseHandler) Signup(c echo.Context) error {
 
 	// Check if user exists
 	var existingUser models.User
-	if err := models.DB.Where("email = ?", email).First(&existingUser).Error; err == nil {
+	if err := h.db.Where("email = ?", email).First(&existingUser).Error; err == nil {
 		if existingUser.IsVerified {
 			return h.render(c, templates.SignupFormContent("Account already exists. Please login."))
 		}
@@ -66,10 +70,10 @@ func (h *BaseHandler) Signup(c echo.Context) error {
 		OTP:        otp,
 		OTPExpiry:  &otpExpiry,
 		IsVerified: false,
-		Role:       models.RoleUser,
+		Role:       h.signupRoles().DefaultRole,
 	}
 
-	if err := models.DB.Create(&user).Error; err != nil {
+	if err := h.db.Create(&user).Error; err != nil {
 		return h.render(c, templates.SignupFormContent("Email already registered"))
 	}
 
@@ -91,7 +95,7 @@ func (h *BaseHandler) Login(c echo.Context) error {
 	}
 
 	var user models.User
-	if err := models.DB.Where("email = ?", email).First(&user).Error; err != nil {
+	if err := h.db.Where("email = ?", email).First(&user).Error; err != nil {
 		return h.render(c, templates.LoginFormContent("Invalid email or password"))
 	}
 
@@ -102,31 +106,89 @@ func (h *BaseHandler) Login(c echo.Context) error {
 	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
 		return h.render(c, templates.LoginFormContent("Invalid email or password"))
 	}
+	if user.IsBanned() {
+		return h.render(c, templates.LoginFormContent("This account has been suspended"))
+	}
 
 	h.setUserSession(c, user.ID)
 	c.Response().Header().Set("HX-Redirect", "/")
 	return c.NoContent(http.StatusOK)
 }
 
+const (
+	// otpMaxAttempts is how many wrong codes an account gets before its code is thrown away and a new one must be sent
+	otpMaxAttempts = 5
+	// otpMaxTotalAttempts caps wrong codes across resends; past it only an admin can verify the account
+	otpMaxTotalAttempts = 20
+
+	otpResendsPerAccount = 3  // codes re-sent to one account per otpResendWindow
+	otpResendsPerIP      = 10 // codes re-sent from one address per otpResendWindow
+	otpResendWindow      = 15 * time.Minute
+)
+
+// VerifyOTP checks the code against the account it was sent to. Each wrong code counts against that
+// account: every otpMaxAttempts-th invalidates the code and otpMaxTotalAttempts locks the account, so
+// six digits can't be worked through by resending.
 func (h *BaseHandler) VerifyOTP(c echo.Context) error {
+	email := h.trimFormValue(c, "email")
 	otp := h.trimFormValue(c, "otp")
 	if otp == "" {
-		return h.render(c, templates.OTPFormContent("", "Please enter the verification code"))
+		return h.render(c, templates.OTPFormContent(email, "Please enter the verification code"))
 	}
 
 	var user models.User
-	if err := models.DB.Where("otp = ? AND otp_expiry > ?", otp, time.Now()).First(&user).Error; err != nil {
-		return h.render(c, templates.OTPFormContent("", "Invalid or expired verification code"))
+	err := h.db.Where("email = ? AND is_verified = ?", email, false).First(&user).Error
+	if err == nil && user.OTPAttempts >= otpMaxTotalAttempts {
+		return h.render(c, templates.OTPFormContent(email, otpExhaustedMessage(user.OTPAttempts)))
+	}
+	if err != nil || user.OTP == "" || user.OTPExpiry == nil || time.Now().After(*user.OTPExpiry) {
+		return h.render(c, templates.OTPFormContent(email, "Invalid or expired verification code"))
 	}
 
-	user.IsVerified, user.OTP, user.OTPExpiry = true, "", nil
-	if user.Email == h.cfg.Auth.AdminEmail {
-		user.Role = models.RoleAdmin
+	// Every write below is conditional on the code still being live and under this code's share of the
+	// attempts, so parallel guesses can't each see the same count and slip past it
+	code := user.OTP
+	limit := min((user.OTPAttempts/otpMaxAttempts+1)*otpMaxAttempts, otpMaxTotalAttempts)
+	live := func() *gorm.DB {
+		return h.db.Model(&models.User{}).Where("id = ? AND otp = ? AND otp_attempts < ?", user.ID, code, limit)
 	}
+	if subtle.ConstantTimeCompare([]byte(otp), []byte(code)) != 1 {
+		result := live().UpdateColumn("otp_attempts", gorm.Expr("otp_attempts + 1"))
+		if result.Error != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to verify account")
+		}
+		if result.RowsAffected == 0 {
+			return h.render(c, templates.OTPFormContent(email, otpExhaustedMessage(user.OTPAttempts)))
+		}
 
-	if err := models.DB.Save(&user).Error; err != nil {
+		var attempts int
+		h.db.Model(&models.User{}).Where("id = ?", user.ID).Select("otp_attempts").Scan(&attempts)
+		title := fmt.Sprintf("Wrong verification code (attempt %d of %d)", (attempts-1)%otpMaxAttempts+1, otpMaxAttempts)
+		message := "Invalid or expired verification code"
+		if attempts >= limit {
+			if err := h.db.Model(&models.User{}).Where("id = ? AND otp = ?", user.ID, code).
+				Updates(map[string]interface{}{"otp": "", "otp_expiry": nil}).Error; err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, "Failed to verify account")
+			}
+			title = "Verification code invalidated after too many wrong attempts"
+			message = otpExhaustedMessage(attempts)
+		}
+		if location := h.clientLocation(c).String(); location != "" {
+			title += " from " + location
+		}
+		h.recordActivity(user.ID, models.ActivityOTPFailed, title, "")
+		return h.render(c, templates.OTPFormContent(email, message))
+	}
+
+	user.IsVerified, user.OTP, user.OTPExpiry, user.OTPAttempts = true, "", nil, 0
+	user.Role = h.verifiedRole(&user)
+	result := live().Updates(map[string]interface{}{"is_verified": true, "otp": "", "otp_expiry": nil, "otp_attempts": 0, "role": user.Role})
+	if result.Error != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to verify account")
 	}
+	if result.RowsAffected == 0 {
+		return h.render(c, templates.OTPFormContent(email, otpExhaustedMessage(user.OTPAttempts)))
+	}
 
 	h.emailService.SendWelcomeEmail(user.Email, user.Name, user.IsAdmin())
 	h.setUserSession(c, user.ID)
@@ -134,8 +196,55 @@ func (h *BaseHandler) VerifyOTP(c echo.Context) error {
 	return c.NoContent(http.StatusOK)
 }
 
+// otpExhaustedMessage tells someone out of guesses whether a new code would help
+func otpExhaustedMessage(attempts int) string {
+	if attempts >= otpMaxTotalAttempts {
+		return "Too many wrong codes. Ask the site admin to verify your account."
+	}
+	return "Too many wrong codes. Request a new code to try again."
+}
+
+// ResendOTP sends a fresh code to an account still waiting on one, with otpMaxAttempts new guesses. Wrong
+// codes still count toward otpMaxTotalAttempts. The reply is the same whether or not the address has such
+// an account, or the resend was refused.
 func (h *BaseHandler) ResendOTP(c echo.Context) error {
-	return h.render(c, templates.OTPFormContent("", "OTP resent successfully"))
+	var user models.User
+	if err := h.db.Where("email = ? AND is_verified = ?", h.trimFormValue(c, "email"), false).First(&user).Error; err == nil && h.allowOTPResend(c, &user) {
+		if err := h.issueOTP(&user); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to resend code")
+		}
+		h.sendOTP(user.Email, user.Name, user.OTP)
+	}
+	return h.render(c, templates.SuccessMessage("A new code is on its way"))
+}
+
+// allowOTPResend holds each account and client address to a few codes per otpResendWindow, so
+// resending can't buy unlimited guesses or flood someone's inbox
+func (h *BaseHandler) allowOTPResend(c echo.Context, user *models.User) bool {
+	if user.OTPAttempts >= otpMaxTotalAttempts {
+		return false
+	}
+	if _, allowed := h.otpAccountLimiter.Allow(user.ID); !allowed {
+		return false
+	}
+	_, allowed := h.otpIPLimiter.Allow(h.clientKey(c))
+	return allowed
+}
+
+// clientKey is a hash of the client's address, for limiters that count per address
+func (h *BaseHandler) clientKey(c echo.Context) uint {
+	ip := fnv.New64a()
+	ip.Write([]byte(h.adminIPExtractor(c.Request())))
+	return uint(ip.Sum64())
+}
+
+// issueOTP saves a new code for user. Wrong guesses so far round up to the code's share, so each code
+// gets otpMaxAttempts while the total keeps counting.
+func (h *BaseHandler) issueOTP(user *models.User) error {
+	otpExpiry := time.Now().Add(10 * time.Minute)
+	user.OTP, user.OTPExpiry = h.generateOTP(), &otpExpiry
+	user.OTPAttempts = (user.OTPAttempts + otpMaxAttempts - 1) / otpMaxAttempts * otpMaxAttempts
+	return h.db.Model(user).Select("otp", "otp_expiry", "otp_attempts").Updates(user).Error
 }
 
 func (h *BaseHandler) Logout(c echo.Context) error {
@@ -145,9 +254,9 @@ func (h *BaseHandler) Logout(c echo.Context) error {
 
 // Helper methods for auth
 func (h *BaseHandler) generateOTP() string {
-	rand.Seed(time.Now().UnixNano())
-	otp := rand.Intn(900000) + 100000
-	return strconv.Itoa(otp)
+	// crypto/rand doesn't fail as of Go 1.24, and a guessable code would undo the attempt limit
+	n, _ := rand.Int(rand.Reader, big.NewInt(900000))
+	return strconv.FormatInt(n.Int64()+100000, 10)
 }
 
 func (h *BaseHandler) sendOTP(email, name, otp string) {
@@ -163,19 +272,21 @@ func (h *BaseHandler) updateAndResendOTP(c echo.Context, user *models.User, name
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to process password")
 	}
 
-	otp := h.generateOTP()
-	otpExpiry := time.Now().Add(10 * time.Minute)
+	// Signing up again is another way to ask for a code, so it shares the resend limits
+	if !h.allowOTPResend(c, user) {
+		return h.render(c, templates.SignupFormContent("Too many codes requested. Try again later."))
+	}
 
 	user.Name = name
 	user.Password = string(hashedPassword)
-	user.OTP = otp
-	user.OTPExpiry = &otpExpiry
-
-	if err := models.DB.Save(user).Error; err != nil {
+	if err := h.db.Model(user).Select("name", "password").Updates(user).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to update account")
+	}
+	if err := h.issueOTP(user); err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to update account")
 	}
 
-	h.sendOTP(user.Email, name, otp)
+	h.sendOTP(user.Email, name, user.OTP)
 
 	// For successful signup, change HTMX target to replace entire form wrapper
 	c.Response().Header().Set("HX-Retarget", "#auth-form-wrapper")
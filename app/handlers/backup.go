@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"mini-blog/app/models"
+	"mini-blog/app/templates"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const archiveManifestFile = "manifest.json"
+
+// AdminBackup shows the export form, and the import form while the install is still fresh
+func (h *BaseHandler) AdminBackup(c echo.Context) error {
+	return h.renderBackup(c, "")
+}
+
+// AdminBackupExport downloads every covered table as a zip of JSON files; ?passwords=on includes password hashes
+func (h *BaseHandler) AdminBackupExport(c echo.Context) error {
+	tables, err := models.ArchiveTables(h.db)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to build export")
+	}
+	includePasswords := c.QueryParam("passwords") == "on"
+
+	now := time.Now()
+	filename := fmt.Sprintf("mini-blog-%s.zip", now.Format(segmentDateLayout))
+	c.Response().Header().Set(echo.HeaderContentType, "application/zip")
+	c.Response().Header().Set(echo.HeaderContentDisposition, `attachment; filename="`+filename+`"`)
+	c.Response().WriteHeader(http.StatusOK)
+
+	archive := zip.NewWriter(c.Response())
+	manifest := models.ArchiveManifest{Version: models.ArchiveVersion, CreatedAt: now.UTC(), Passwords: includePasswords, Tables: tables}
+	if err := writeArchiveFile(archive, archiveManifestFile, manifest); err != nil {
+		return err
+	}
+	for _, table := range tables {
+		rows, err := models.ExportTable(h.db, table, includePasswords)
+		if err != nil {
+			return err
+		}
+		if err := writeArchiveFile(archive, table+".json", rows); err != nil {
+			return err
+		}
+	}
+	return archive.Close()
+}
+
+// AdminBackupImport loads an export into a fresh install, then signs the admin out since user IDs change
+func (h *BaseHandler) AdminBackupImport(c echo.Context) error {
+	if !models.IsFreshInstall(h.db) {
+		return h.renderBackup(c, "Imports only run on a fresh install with no posts, media or other users")
+	}
+
+	header, err := c.FormFile("archive")
+	if err != nil {
+		return h.renderBackup(c, "Choose an export archive to import")
+	}
+	file, err := header.Open()
+	if err != nil {
+		return h.renderBackup(c, "Failed to read the archive")
+	}
+	defer file.Close()
+
+	archive, err := zip.NewReader(file, header.Size)
+	if err != nil {
+		return h.renderBackup(c, "That file is not a zip archive")
+	}
+
+	var manifest models.ArchiveManifest
+	if err := readArchiveFile(archive, archiveManifestFile, &manifest); err != nil {
+		return h.renderBackup(c, "The archive has no readable manifest")
+	}
+	if manifest.Version != models.ArchiveVersion {
+		return h.renderBackup(c, fmt.Sprintf("Unsupported archive version %d", manifest.Version))
+	}
+
+	data := make(map[string]models.ArchiveRows, len(manifest.Tables))
+	for _, table := range manifest.Tables {
+		var rows models.ArchiveRows
+		if err := readArchiveFile(archive, table+".json", &rows); err != nil {
+			return h.renderBackup(c, "The archive is missing or has a damaged "+table+" file")
+		}
+		data[table] = rows
+	}
+
+	if err := models.ImportArchive(h.db, data, c.Get("user").(*models.User)); err != nil {
+		return h.renderBackup(c, "Import failed: "+err.Error())
+	}
+
+	h.clearUserSession(c)
+	return h.htmxRedirect(c, "/login")
+}
+
+func (h *BaseHandler) renderBackup(c echo.Context, errorMessage string) error {
+	page := templates.BackupPage(models.IsFreshInstall(h.db), errorMessage)
+	if h.isHTMXRequest(c) {
+		return h.render(c, page)
+	}
+	return h.render(c, templates.Layout("Backup", page, c.Request().URL.Path, c.Get("user").(*models.User)))
+}
+
+func writeArchiveFile(archive *zip.Writer, name string, v interface{}) error {
+	w, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(w).Encode(v)
+}
+
+// readArchiveFile decodes numbers as json.Number so IDs and counts reach the database unchanged
+func readArchiveFile(archive *zip.Reader, name string, v interface{}) error {
+	f, err := archive.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	decoder := json.NewDecoder(f)
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
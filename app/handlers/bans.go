@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"fmt"
+	"mini-blog/app/models"
+	"mini-blog/app/templates"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// AdminUserBan suspends an account: its sessions, API tokens and JWTs stop working on their next request
+// and its comments are hidden, but nothing is deleted, so unbanning restores everything
+func (h *BaseHandler) AdminUserBan(c echo.Context) error {
+	admin := c.Get("user").(*models.User)
+	target, err := h.adminUserTarget(c)
+	if err != nil {
+		return err
+	}
+	if target.ID == admin.ID {
+		return echo.NewHTTPError(http.StatusBadRequest, "You can't ban yourself")
+	}
+	if target.IsAdmin() {
+		return echo.NewHTTPError(http.StatusBadRequest, "Change the admin's role before banning them")
+	}
+	if target.IsBanned() {
+		return h.render(c, templates.AdminUserRow(*target))
+	}
+
+	now := time.Now()
+	target.BannedAt, target.BannedByID, target.BanReason = &now, &admin.ID, h.trimFormValue(c, "reason")
+	if err := h.validator.Var(target.BanReason, "max=500"); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Keep the reason under 500 characters")
+	}
+	if err := h.db.Model(target).Updates(map[string]interface{}{
+		"banned_at": target.BannedAt, "banned_by_id": target.BannedByID, "ban_reason": target.BanReason,
+	}).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to ban user")
+	}
+
+	h.emitEvent(models.EventUserBanned, fmt.Sprintf("%s banned by %s", target.Email, admin.Email), "/admin/users",
+		map[string]interface{}{"user_id": target.ID, "email": target.Email, "banned_by": admin.ID, "reason": target.BanReason})
+	return h.render(c, templates.AdminUserRow(*target))
+}
+
+// AdminUserUnban lifts a ban; the user signs in again and their comments reappear
+func (h *BaseHandler) AdminUserUnban(c echo.Context) error {
+	admin := c.Get("user").(*models.User)
+	target, err := h.adminUserTarget(c)
+	if err != nil {
+		return err
+	}
+	if !target.IsBanned() {
+		return h.render(c, templates.AdminUserRow(*target))
+	}
+
+	reason := target.BanReason
+	if err := h.db.Model(target).Updates(map[string]interface{}{"banned_at": nil, "banned_by_id": nil, "ban_reason": ""}).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to unban user")
+	}
+	target.BannedAt, target.BannedByID, target.BanReason = nil, nil, ""
+
+	h.emitEvent(models.EventUserUnbanned, fmt.Sprintf("%s unbanned by %s", target.Email, admin.Email), "/admin/users",
+		map[string]interface{}{"user_id": target.ID, "email": target.Email, "unbanned_by": admin.ID, "ban_reason": reason})
+	return h.render(c, templates.AdminUserRow(*target))
+}
+
+func (h *BaseHandler) adminUserTarget(c echo.Context) (*models.User, error) {
+	id, err := h.parseUintParam(c, "id")
+	if err != nil {
+		return nil, err
+	}
+	var user models.User
+	if err := h.db.First(&user, id).Error; err != nil {
+		return nil, echo.NewHTTPError(http.StatusNotFound, "User not found")
+	}
+	return &user, nil
+}
@@ -1,14 +1,20 @@
 package handlers
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log"
 	"mini-blog/app/config"
 	"mini-blog/app/models"
 	"mini-blog/app/services"
 	"mini-blog/app/templates"
+	"net"
 	"net/http"
+	"net/netip"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/a-h/templ"
@@ -22,61 +28,182 @@ import (
 type BaseHandler struct {
 	validator    *validator.Validate
 	emailService *services.EmailService
-	tmdbService  *services.TMDBService
+	tmdbService  *services.TMDBService // TMDB-only calls: artwork hashes and watch providers
+	metadata     *services.Providers   // search, details, episodes and images per media type
+	tvdb         *services.TVDBService
+	storage      services.Storage
+	ttsService   *services.TTSService
+	stripe       *services.StripeService
+	webhooks     *services.WebhookService
+	slash        *services.SlashCommandService
+	telegram     *services.TelegramService
+	oidc         *services.OIDCService
+	geoIP        *services.GeoIPService
+	apiLimiter   *services.RateLimiter // calls per API token
 	store        *sessions.CookieStore
 	cfg          *config.Config
+	db           *gorm.DB // the site's own database; each hosted site gets its own handler
+
+	mediaSyncQueue chan int // TMDB IDs waiting for a background sync
+	mediaSyncs     sync.Map // TMDB IDs queued or syncing, so repeat opens don't pile up
+
+	watchImportMu sync.Mutex // lets one worker at a time search and apply this site's import items
+
+	// Admin hardening: the networks admin routes accept (none means all), how the client address is read for
+	// that check, and password or code attempts per admin when confirming a destructive action
+	adminNetworks    []netip.Prefix
+	adminIPExtractor echo.IPExtractor
+	reauthLimiter    *services.RateLimiter
+
+	jwtLoginLimiter *services.RateLimiter // password attempts per account when API clients ask for a JWT
+
+	// Verification codes re-sent per unverified account and per client address (keyed by a hash of it)
+	otpAccountLimiter *services.RateLimiter
+	otpIPLimiter      *services.RateLimiter
+
+	anonLikeLimiter *services.RateLimiter // likes and unlikes by signed-out visitors per client address
 }
 
-func NewBaseHandler(cfg *config.Config) *BaseHandler {
-	store := sessions.NewCookieStore([]byte(cfg.Session.Key))
-	store.Options = &sessions.Options{
-		Path:     "/",
-		MaxAge:   86400 * 7,
-		HttpOnly: true,
-		Secure:   cfg.Env == "production",
+func NewBaseHandler(cfg *config.Config, db *gorm.DB) *BaseHandler {
+	tmdb := services.NewTMDBService(cfg.TMDB.BearerToken)
+	adminNetworks, err := services.ParseNetworks(cfg.Admin.AllowedIPs)
+	if err != nil {
+		log.Fatalf("Invalid ADMIN_ALLOWED_IPS: %v", err)
+	}
+	trustedProxies, err := services.ParseNetworks(cfg.Admin.TrustedProxies)
+	if err != nil {
+		log.Fatalf("Invalid ADMIN_TRUSTED_PROXIES: %v", err)
+	}
+	// Only the listed proxies may vouch for a client in X-Forwarded-For; without any, the peer address is the client
+	proxyTrust := []echo.TrustOption{echo.TrustLoopback(false), echo.TrustLinkLocal(false), echo.TrustPrivateNet(false)}
+	for _, proxy := range trustedProxies {
+		_, network, _ := net.ParseCIDR(proxy.String())
+		proxyTrust = append(proxyTrust, echo.TrustIPRange(network))
+	}
+
+	store, err := services.NewSessionStore(cfg)
+	if err != nil {
+		log.Fatalf("Invalid session settings: %v", err)
 	}
 
 	return &BaseHandler{
 		validator:    validator.New(),
 		emailService: services.NewEmailService(cfg),
-		tmdbService:  services.NewTMDBService(cfg.TMDB.BearerToken),
+		tmdbService:  tmdb,
+		metadata:     services.NewProviders(tmdb),
+		tvdb:         services.NewTVDBService(cfg),
+		storage:      services.NewStorage(cfg),
+		ttsService:   services.NewTTSService(cfg),
+		stripe:       services.NewStripeService(cfg),
+		webhooks:     services.NewWebhookService(),
+		slash:        services.NewSlashCommandService(cfg),
+		telegram:     services.NewTelegramService(cfg),
+		oidc:         services.NewOIDCService(cfg),
+		geoIP:        services.NewGeoIPService(cfg),
+		apiLimiter:   services.NewRateLimiter(apiRateLimit, time.Minute),
 		store:        store,
 		cfg:          cfg,
+		db:           db,
+
+		mediaSyncQueue: make(chan int, mediaSyncQueueSize),
+
+		adminNetworks:    adminNetworks,
+		adminIPExtractor: echo.ExtractIPFromXFFHeader(proxyTrust...),
+		reauthLimiter:    services.NewRateLimiter(reauthAttempts, reauthAttemptWindow),
+
+		jwtLoginLimiter: services.NewRateLimiter(jwtLoginAttempts, jwtLoginWindow),
+
+		otpAccountLimiter: services.NewRateLimiter(otpResendsPerAccount, otpResendWindow),
+		otpIPLimiter:      services.NewRateLimiter(otpResendsPerIP, otpResendWindow),
+
+		anonLikeLimiter: services.NewRateLimiter(anonLikesPerIP, time.Hour),
 	}
 }
 
 // Common utility methods
 func (h *BaseHandler) render(c echo.Context, component templ.Component) error {
-	return component.Render(c.Request().Context(), c.Response().Writer)
+	return component.Render(h.renderContext(c), c.Response().Writer)
 }
 
-func (h *BaseHandler) renderWithCardUpdate(c echo.Context, component templ.Component, media models.Media) error {
-	c.Response().Header().Set("Content-Type", "text/html")
-	c.Response().WriteHeader(http.StatusOK)
-
-	// Render main content
-	component.Render(c.Request().Context(), c.Response().Writer)
+// renderContext carries per-request presentation state (locale, timezone, theme) into templates
+func (h *BaseHandler) renderContext(c echo.Context) context.Context {
+	ctx := services.WithLocale(c.Request().Context(), h.resolveLocale(c))
+	ctx = services.WithTheme(ctx, h.resolveTheme(c))
 
-	// Update search card out-of-band
-	c.Response().Writer.Write([]byte(fmt.Sprintf(`<div hx-swap-oob="true" id="tmdb-%d">`, media.TMDBID)))
-	templates.UnifiedMediaCard(media, h.GetCurrentUser(c), false).Render(c.Request().Context(), c.Response().Writer)
-	c.Response().Writer.Write([]byte(`</div>`))
+	user := h.GetCurrentUser(c)
+	ctx = services.WithPolicy(ctx, services.PolicyFor(user))
+	if noindex, _ := c.Get("noindex").(bool); noindex {
+		ctx = services.WithNoindex(ctx)
+	}
+	if meta, ok := c.Get("page_meta").(services.PageMeta); ok {
+		ctx = services.WithPageMeta(ctx, meta)
+	}
+	ctx = services.WithSiteSections(ctx, h.cfg.BlogEnabled(), h.cfg.TrackerEnabled())
+	if h.stripe.Enabled() {
+		ctx = services.WithTipJar(ctx)
+	}
+	if h.tvdb.Enabled() {
+		ctx = services.WithTVDB(ctx)
+	}
+	if h.oidc.Enabled() {
+		ctx = services.WithSSO(ctx, h.cfg.OIDC.Name)
+	}
+	if h.analyticsConsentPending(c) {
+		ctx = services.WithAnalyticsConsentPrompt(ctx)
+	}
 
-	return nil
+	dateFormat := ""
+	if user != nil {
+		dateFormat = user.DateFormat
+	}
+	return services.WithTimezone(ctx, h.userLocation(c), dateFormat)
 }
 
 func (h *BaseHandler) GetCurrentUser(c echo.Context) *models.User {
+	// Reuse the user loaded earlier in this request (render paths ask more than once)
+	if cached, ok := c.Get("current_user").(*models.User); ok {
+		return cached
+	}
+
 	session, _ := h.store.Get(c.Request(), "auth-session")
 	userID, ok := session.Values["user_id"].(uint)
 	if !ok {
-		return nil
+		// Scripts and the CLI client sign in with an API token instead, on the API only (like JWTAuth), so a
+		// leaked token can't open admin pages or mint more tokens
+		if !strings.HasPrefix(c.Request().URL.Path, "/api/") {
+			return nil
+		}
+		user := h.tokenUser(c)
+		if user != nil {
+			c.Set("current_user", user)
+		}
+		return user
 	}
 
 	var user models.User
-	if err := models.DB.First(&user, userID).Error; err != nil {
+	if err := h.db.First(&user, userID).Error; err != nil {
 		return nil
 	}
+	if user.IsBanned() {
+		// Banning ends every signed-in session on its next request
+		h.clearUserSession(c)
+		return nil
+	}
+	if user.SessionsRevokedAt != nil {
+		// Sessions from before an admin signed the user out everywhere, or before a password reset
+		if signedIn, _ := session.Values["signed_in_at"].(int64); signedIn < user.SessionsRevokedAt.UnixMicro() {
+			h.clearUserSession(c)
+			return nil
+		}
+	}
 
+	// Activity only needs hour granularity, so avoid a write on every request
+	if now := time.Now(); user.LastSeenAt == nil || now.Sub(*user.LastSeenAt) > time.Hour {
+		user.LastSeenAt = &now
+		h.db.Model(&user).UpdateColumn("last_seen_at", now)
+	}
+
+	c.Set("current_user", &user)
 	return &user
 }
 
@@ -86,6 +213,9 @@ func (h *BaseHandler) requireAdmin(c echo.Context) (*models.User, error) {
 	if user == nil || !user.IsAdmin() {
 		return nil, echo.NewHTTPError(http.StatusForbidden, "Admin access required")
 	}
+	if err := h.checkAdminNetwork(c); err != nil {
+		return nil, err
+	}
 	return user, nil
 }
 
@@ -119,9 +249,9 @@ func (h *BaseHandler) htmxRedirect(c echo.Context, url string) error {
 	return c.NoContent(http.StatusOK)
 }
 
-// getMediaModalData: Centralized modal data fetching
-func (h *BaseHandler) getMediaModalData(tmdbID int, mediaType string, useLocal bool) (*models.Media, []models.Season, []models.Episode, []models.Episode, error) {
-	media, err := h.getMediaData(tmdbID, mediaType, useLocal)
+// getMediaModalData: Centralized modal data fetching; TMDB previews are cancelled with ctx
+func (h *BaseHandler) getMediaModalData(ctx context.Context, tmdbID int, mediaType string, useLocal bool) (*models.Media, []models.Season, []models.Episode, []models.Episode, error) {
+	media, err := h.getMediaData(ctx, tmdbID, mediaType, useLocal)
 	if err != nil {
 		return nil, nil, nil, nil, err
 	}
@@ -130,62 +260,65 @@ func (h *BaseHandler) getMediaModalData(tmdbID int, mediaType string, useLocal b
 		return media, nil, nil, nil, nil
 	}
 
-	seasons, episodes, allEpisodes := h.getTVData(tmdbID, useLocal)
+	seasons, episodes, allEpisodes := h.getTVData(ctx, tmdbID, useLocal)
 	return media, seasons, episodes, allEpisodes, nil
 }
 
-func (h *BaseHandler) getMediaData(tmdbID int, mediaType string, useLocal bool) (*models.Media, error) {
+func (h *BaseHandler) getMediaData(ctx context.Context, tmdbID int, mediaType string, useLocal bool) (*models.Media, error) {
 	if useLocal {
 		var media models.Media
-		err := models.DB.Where("tmdb_id = ?", tmdbID).First(&media).Error
+		err := h.db.Where("tmdb_id = ?", tmdbID).First(&media).Error
 		return &media, err
 	}
-	return h.tmdbService.GetDetails(tmdbID, mediaType)
+	return h.metadata.ForContext(ctx, mediaType).GetDetails(tmdbID, mediaType)
 }
 
-func (h *BaseHandler) getTVData(tmdbID int, useLocal bool) ([]models.Season, []models.Episode, []models.Episode) {
+func (h *BaseHandler) getTVData(ctx context.Context, tmdbID int, useLocal bool) ([]models.Season, []models.Episode, []models.Episode) {
 	if useLocal {
 		var seasons []models.Season
 		var allEpisodes []models.Episode
-		models.DB.Where("tmdb_id = ? AND season_number > 0", tmdbID).Order("season_number ASC").Find(&seasons)
-		models.DB.Where("tmdb_id = ?", tmdbID).Find(&allEpisodes)
+		h.db.Where("tmdb_id = ? AND season_number > 0", tmdbID).Order("season_number ASC").Find(&seasons)
+		h.db.Where("tmdb_id = ?", tmdbID).Find(&allEpisodes)
 
 		var episodes []models.Episode
 		if len(seasons) > 0 {
 			lastSeason := h.getLastWatchedSeason(allEpisodes)
-			models.DB.Where("tmdb_id = ? AND season_number = ?", tmdbID, lastSeason).Order("episode_number ASC").Find(&episodes)
+			h.db.Where("tmdb_id = ? AND season_number = ?", tmdbID, lastSeason).Order("episode_number ASC").Find(&episodes)
 		}
 		return seasons, episodes, allEpisodes
 	}
 
-	// TMDB preview data
-	tmdbSeasons, err := h.tmdbService.GetSeasons(tmdbID)
+	// Provider preview data
+	provider := h.metadata.ForContext(ctx, models.MediaTypeTV)
+	providerSeasons, err := provider.GetDetailedSeasons(tmdbID)
 	if err != nil {
 		return nil, nil, nil
 	}
 
 	var seasons []models.Season
-	for _, s := range tmdbSeasons {
+	for _, s := range providerSeasons {
 		if s.SeasonNumber > 0 {
-			seasons = append(seasons, models.Season{
-				TMDBID: tmdbID, SeasonNumber: s.SeasonNumber,
-				Name: s.Name, EpisodeCount: s.EpisodeCount, PosterPath: s.PosterPath,
-			})
+			seasons = append(seasons, s)
 		}
 	}
 
 	var episodes []models.Episode
 	if len(seasons) > 0 {
-		if eps, err := h.tmdbService.GetDetailedEpisodes(tmdbID, seasons[0].SeasonNumber); err == nil {
+		if eps, err := provider.GetDetailedEpisodes(tmdbID, seasons[0].SeasonNumber); err == nil {
 			episodes = eps
 		}
 	}
 	return seasons, episodes, episodes
 }
 
-// getMediaSorted: Unified media fetching with optional filters and search, sorted by last watched
-func (h *BaseHandler) getMediaSorted(filters []string, searchTerm string) []models.Media {
+// libraryGridPageSize is how many cards a page of the library grid shows
+const libraryGridPageSize = 48
+
+// getMediaSorted: Unified media fetching with optional filters and search, sorted by last watched.
+// Returns one page of the library and the total number of pages
+func (h *BaseHandler) getMediaSorted(state templates.LibraryState, hideAdult bool) ([]models.Media, int) {
 	var media []models.Media
+	filters, searchTerm := state.Filters, state.Query
 	var conditions []string
 	var args []interface{}
 
@@ -226,7 +359,20 @@ func (h *BaseHandler) getMediaSorted(filters []string, searchTerm string) []mode
 		}
 	}
 
-	models.DB.Raw(`
+	if hideAdult {
+		adultClause := "m.adult = false AND m.certification IS DISTINCT FROM ?"
+		args = append(args, models.CertificationNC17)
+		if whereClause == "" {
+			whereClause = "WHERE " + adultClause
+		} else {
+			whereClause = "WHERE (" + strings.TrimPrefix(whereClause, "WHERE ") + ") AND " + adultClause
+		}
+	}
+
+	var total int64
+	h.db.Raw(`SELECT COUNT(*) FROM media m `+whereClause, args...).Scan(&total)
+
+	h.db.Raw(`
 		SELECT m.* FROM media m
 		LEFT JOIN (
 			SELECT tmdb_id, MAX(watched_at) as last_episode_watched
@@ -235,14 +381,54 @@ func (h *BaseHandler) getMediaSorted(filters []string, searchTerm string) []mode
 			GROUP BY tmdb_id
 		) e ON m.tmdb_id = e.tmdb_id
 		`+whereClause+`
-		ORDER BY 
+		ORDER BY `+librarySortColumn(state.Sort)+`
 			CASE 
 				WHEN m.type = 'tv' AND e.last_episode_watched IS NOT NULL THEN e.last_episode_watched
 				ELSE m.updated_at
-			END DESC NULLS LAST
-	`, args...).Find(&media)
+			END DESC NULLS LAST, m.id DESC
+		LIMIT ? OFFSET ?
+	`, append(args, libraryGridPageSize, (state.Page-1)*libraryGridPageSize)...).Find(&media)
 
-	return media
+	attachSeasons(h.db, media)
+	return media, int((total + libraryGridPageSize - 1) / libraryGridPageSize)
+}
+
+// librarySortColumn returns the leading ORDER BY terms for a sort key; recent activity always breaks ties
+func librarySortColumn(sortKey string) string {
+	switch sortKey {
+	case models.SortRating:
+		return "NULLIF(m.rating, 0) DESC NULLS LAST,"
+	case models.SortEpisodeScore:
+		return "NULLIF(m.episode_score, 0) DESC NULLS LAST,"
+	}
+	return ""
+}
+
+// attachSeasons loads every show's seasons in one query for per-season grid progress
+func attachSeasons(db *gorm.DB, media []models.Media) {
+	var ids []int
+	for _, m := range media {
+		if m.Type == models.MediaTypeTV {
+			ids = append(ids, m.TMDBID)
+		}
+	}
+	if len(ids) == 0 {
+		return
+	}
+
+	var seasons []models.Season
+	db.Select("tmdb_id", "season_number", "episode_count", "watched_count").
+		Where("tmdb_id IN ? AND season_number > 0", ids).
+		Order("season_number ASC").
+		Find(&seasons)
+
+	byShow := make(map[int][]models.Season)
+	for _, season := range seasons {
+		byShow[season.TMDBID] = append(byShow[season.TMDBID], season)
+	}
+	for i := range media {
+		media[i].Seasons = byShow[media[i].TMDBID]
+	}
 }
 
 // getLastWatchedSeason: Helper for modal data fetching
@@ -274,20 +460,25 @@ func (h *BaseHandler) updateMediaAndRefreshModal(c echo.Context, updateFn func(*
 	}
 
 	var media models.Media
-	if err := models.DB.Where("tmdb_id = ?", tmdbID).First(&media).Error; err != nil {
+	if err := h.db.Where("tmdb_id = ?", tmdbID).First(&media).Error; err != nil {
 		return echo.NewHTTPError(http.StatusNotFound, "Media not found")
 	}
 
+	previousStatus := media.Status
 	if err := updateFn(&media); err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
 	}
+	if previousStatus != models.StatusCompleted && media.Status == models.StatusCompleted {
+		h.draftMilestonePost(media, h.GetCurrentUser(c))
+	}
 
-	refreshedMedia, seasons, episodes, allEpisodes, err := h.getMediaModalData(tmdbID, media.Type, true)
+	refreshedMedia, seasons, episodes, allEpisodes, err := h.getMediaModalData(c.Request().Context(), tmdbID, media.Type, true)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to refresh modal")
 	}
 
-	return h.render(c, templates.MediaDetailModal(refreshedMedia, seasons, episodes, allEpisodes, h.GetCurrentUser(c)))
+	user := h.GetCurrentUser(c)
+	return h.renderPartial(c, h.newPartial(templates.MediaDetailModal(refreshedMedia, seasons, episodes, allEpisodes, user)).Card(*refreshedMedia, user))
 }
 
 // Generic episode marking function (DRY for MarkEpisodeWatched, MarkSeasonWatched, MarkShowWatched)
@@ -307,7 +498,7 @@ func (h *BaseHandler) markEpisodes(c echo.Context, scope string) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "Invalid parameters")
 	}
 
-	freshDB := models.DB.Session(&gorm.Session{NewDB: true})
+	freshDB := h.db.Session(&gorm.Session{NewDB: true})
 	var episodes []models.Episode
 	freshDB.Where(whereClause, whereArgs...).Find(&episodes)
 	if len(episodes) == 0 {
@@ -332,15 +523,26 @@ func (h *BaseHandler) markEpisodes(c echo.Context, scope string) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to update episodes")
 	}
 
+	if !allWatched {
+		what := "in full"
+		switch scope {
+		case "episode":
+			what = fmt.Sprintf("S%dE%d", whereArgs[1], whereArgs[2])
+		case "season":
+			what = fmt.Sprintf("season %d", whereArgs[1])
+		}
+		h.recordEpisodesWatched(h.GetCurrentUser(c), tmdbID, what)
+	}
+
 	time.Sleep(10 * time.Millisecond)
-	h.updateMediaProgress(tmdbID)
+	h.updateMediaProgress(tmdbID, h.airedCutoff(c), h.trackerRules(c), h.GetCurrentUser(c))
 
 	return h.handleEpisodeResponse(c, scope, whereClause, whereArgs, tmdbID)
 }
 
 // Helper functions for episode operations
 func (h *BaseHandler) buildEpisodeQuery(scope string, c echo.Context, tmdbID int) (string, []interface{}) {
-	now := time.Now()
+	airedBy := h.airedCutoff(c)
 	switch scope {
 	case "episode":
 		season, _ := strconv.Atoi(c.Param("season"))
@@ -349,16 +551,16 @@ func (h *BaseHandler) buildEpisodeQuery(scope string, c echo.Context, tmdbID int
 			return "", nil
 		}
 		return "tmdb_id = ? AND season_number = ? AND episode_number = ? AND (air_date IS NULL OR air_date <= ?)",
-			[]interface{}{tmdbID, season, episode, now}
+			[]interface{}{tmdbID, season, episode, airedBy}
 	case "season":
 		season, _ := strconv.Atoi(c.Param("season"))
 		if season == 0 {
 			return "", nil
 		}
 		return "tmdb_id = ? AND season_number = ? AND air_date <= ?",
-			[]interface{}{tmdbID, season, now}
+			[]interface{}{tmdbID, season, airedBy}
 	case "show":
-		return "tmdb_id = ? AND air_date <= ?", []interface{}{tmdbID, now}
+		return "tmdb_id = ? AND air_date <= ?", []interface{}{tmdbID, airedBy}
 	}
 	return "", nil
 }
@@ -377,20 +579,21 @@ func (h *BaseHandler) handleEpisodeResponse(c echo.Context, scope, whereClause s
 	switch scope {
 	case "episode":
 		var episode models.Episode
-		models.DB.Where(whereClause, whereArgs...).First(&episode)
-		return h.render(c, templates.UnifiedEpisodeRow(episode, h.GetCurrentUser(c)))
+		h.db.Where(whereClause, whereArgs...).First(&episode)
+		return h.renderEpisodeToggle(c, episode)
 	case "season":
-		return h.renderSeasonResponse(c, tmdbID, whereArgs[1].(int), "toggle")
+		return h.renderSeasonToggle(c, tmdbID, whereArgs[1].(int))
 	case "show":
 		return h.htmxRedirect(c, "/tv")
 	}
 	return c.NoContent(http.StatusOK)
 }
 
-// Helper to update media progress after episode changes
-func (h *BaseHandler) updateMediaProgress(tmdbID int) {
+// Helper to update media progress after episode changes; airedBy is the viewer's "today".
+// A show the change completes gets a milestone post for user to review.
+func (h *BaseHandler) updateMediaProgress(tmdbID int, airedBy time.Time, rules models.TrackerRules, user *models.User) {
 	// Use fresh database session to ensure accurate counts
-	freshDB := models.DB.Session(&gorm.Session{NewDB: true})
+	freshDB := h.db.Session(&gorm.Session{NewDB: true})
 
 	var media models.Media
 	if freshDB.Where("tmdb_id = ?", tmdbID).First(&media).Error == nil {
@@ -400,30 +603,77 @@ func (h *BaseHandler) updateMediaProgress(tmdbID int) {
 		// Count watched episodes
 		freshDB.Model(&models.Episode{}).Where("tmdb_id = ? AND watched = ?", tmdbID, true).Count(&totalWatched)
 		// Count aired episodes for proper completion calculation
-		freshDB.Model(&models.Episode{}).Where("tmdb_id = ? AND air_date <= ?", tmdbID, time.Now()).Count(&totalAired)
+		freshDB.Model(&models.Episode{}).Where("tmdb_id = ? AND air_date <= ?", tmdbID, airedBy).Count(&totalAired)
 
 		media.Progress = int(totalWatched)
+		models.RefreshSeasonCounts(freshDB, tmdbID)
 
-		if totalWatched == 0 {
-			media.Status = "planned"
-		} else if totalAired > 0 && totalWatched >= totalAired {
-			media.Status = "completed"
-		} else {
-			media.Status = "watching"
+		previousStatus := media.Status
+		media.Status = rules.NextStatus(media.Status, totalWatched, totalAired, media.InProduction)
+		if freshDB.Save(&media).Error == nil && previousStatus != models.StatusCompleted && media.Status == models.StatusCompleted {
+			h.draftMilestonePost(media, user)
 		}
-		freshDB.Save(&media)
 	}
 }
 
-// Unified season data fetcher and renderer
-func (h *BaseHandler) renderSeasonResponse(c echo.Context, tmdbID, seasonNumber int, updateType string) error {
-	h.updateMediaProgress(tmdbID)
+// setEpisodeWatched marks one episode watched (or not) and refreshes its show's progress and status
+func (h *BaseHandler) setEpisodeWatched(episode *models.Episode, watched bool, airedBy time.Time, rules models.TrackerRules, user *models.User) error {
+	updates := map[string]interface{}{"watched": watched, "watched_at": nil}
+	if watched {
+		updates["watched_at"] = time.Now()
+	}
+	if err := h.db.Model(episode).Updates(updates).Error; err != nil {
+		return err
+	}
+	if watched {
+		h.recordEpisodesWatched(user, episode.TMDBID, fmt.Sprintf("S%dE%d", episode.SeasonNumber, episode.EpisodeNumber))
+	}
+	h.updateMediaProgress(episode.TMDBID, airedBy, rules, user)
+	return nil
+}
+
+// renderEpisodeToggle swaps the toggled episode row and refreshes the rest of the modal and its card
+func (h *BaseHandler) renderEpisodeToggle(c echo.Context, episode models.Episode) error {
+	user := h.GetCurrentUser(c)
+	freshDB := h.db.Session(&gorm.Session{NewDB: true})
+	_, seasons, allEpisodes, media := h.getSeasonData(freshDB, episode.TMDBID, episode.SeasonNumber)
+
+	return h.renderPartial(c, h.newPartial(templates.UnifiedEpisodeRow(episode, user)).
+		Update("episode-chart", templates.EpisodeRatingChart(allEpisodes, episode.SeasonNumber)).
+		Update("season-buttons", templates.SeasonButtonsContainer(media, seasons, allEpisodes, user, episode.SeasonNumber)).
+		Update("media-info", templates.MediaInfoSection(media, user)).
+		Update("media-poster", templates.MediaPoster(media)).
+		Card(media, user))
+}
 
-	// Get fresh data
-	freshDB := models.DB.Session(&gorm.Session{NewDB: true})
+// renderSeasonToggle re-renders the season buttons (the toggle's own target) plus the episodes, chart, info and card
+func (h *BaseHandler) renderSeasonToggle(c echo.Context, tmdbID, seasonNumber int) error {
+	user := h.GetCurrentUser(c)
+	freshDB := h.db.Session(&gorm.Session{NewDB: true})
+	episodes, seasons, allEpisodes, media := h.getSeasonData(freshDB, tmdbID, seasonNumber)
+
+	return h.renderPartial(c, h.newPartial(templates.SeasonButtons(media, seasons, allEpisodes, user, seasonNumber)).
+		Update("episode-chart", templates.EpisodeRatingChart(allEpisodes, seasonNumber)).
+		Update("episodes-container", templates.EpisodesListWithWatched(episodes, user)).
+		Update("media-info", templates.MediaInfoSection(media, user)).
+		Update("media-poster", templates.MediaPoster(media)).
+		Card(media, user))
+}
+
+// renderEpisodeRange refreshes the open season and everything derived from watched state; the
+// triggering button uses hx-swap="none", so the response is all out-of-band
+func (h *BaseHandler) renderEpisodeRange(c echo.Context, tmdbID, seasonNumber int) error {
+	user := h.GetCurrentUser(c)
+	freshDB := h.db.Session(&gorm.Session{NewDB: true})
 	episodes, seasons, allEpisodes, media := h.getSeasonData(freshDB, tmdbID, seasonNumber)
 
-	return h.render(c, templates.SeasonResponse(media, seasons, episodes, allEpisodes, seasonNumber, h.GetCurrentUser(c), updateType))
+	return h.renderPartial(c, h.newPartial(nil).
+		Update("episode-chart", templates.EpisodeRatingChart(allEpisodes, seasonNumber)).
+		Update("episodes-container", templates.EpisodesListWithWatched(episodes, user)).
+		Update("season-buttons", templates.SeasonButtonsContainer(media, seasons, allEpisodes, user, seasonNumber)).
+		Update("media-info", templates.MediaInfoSection(media, user)).
+		Update("media-poster", templates.MediaPoster(media)).
+		Card(media, user))
 }
 
 // Consolidated data fetcher
@@ -441,10 +691,16 @@ func (h *BaseHandler) getSeasonData(db *gorm.DB, tmdbID, seasonNumber int) ([]mo
 	return episodes, seasons, allEpisodes, media
 }
 
+// setUserSession signs the user in, whichever way they authenticated, and logs the sign-in to their activity
 func (h *BaseHandler) setUserSession(c echo.Context, userID uint) error {
 	session, _ := h.store.Get(c.Request(), "auth-session")
 	session.Values["user_id"] = userID
-	return session.Save(c.Request(), c.Response())
+	session.Values["signed_in_at"] = time.Now().UnixMicro()
+	if err := session.Save(c.Request(), c.Response()); err != nil {
+		return err
+	}
+	h.recordSignIn(c, userID)
+	return nil
 }
 
 func (h *BaseHandler) clearUserSession(c echo.Context) error {
@@ -505,88 +761,269 @@ func (h *BaseHandler) trimFormValue(c echo.Context, key string) string {
 }
 
 func (h *BaseHandler) isHTMXRequest(c echo.Context) bool {
-	return c.Request().Header.Get("HX-Request") == "true"
+	// History restores after a cache miss want the full page, not the fragment
+	return c.Request().Header.Get("HX-Request") == "true" && c.Request().Header.Get("HX-History-Restore-Request") != "true"
 }
 
 // SyncMedia updates a media item from TMDB (minimal implementation)
 func (h *BaseHandler) SyncMedia(tmdbID int) error {
+	return h.syncMedia(tmdbID, false)
+}
+
+// syncMedia refreshes a title and, for shows, its episodes from the show's metadata provider.
+// prune drops seasons and episodes the provider no longer lists, as after switching providers;
+// it is skipped if any season failed to load so a flaky request can't delete watch history.
+// Failures are kept on the title (see Media.RecordSyncResult) as well as returned.
+func (h *BaseHandler) syncMedia(tmdbID int, prune bool) error {
 	var media models.Media
-	if err := models.DB.Where("tmdb_id = ?", tmdbID).First(&media).Error; err != nil {
+	if err := h.db.Where("tmdb_id = ?", tmdbID).First(&media).Error; err != nil {
 		return err
 	}
 
 	// Fetch fresh details
-	freshMedia, err := h.tmdbService.GetDetails(tmdbID, media.Type)
+	freshMedia, err := h.metadata.For(media.Type).GetDetails(tmdbID, media.Type)
 	if err != nil {
+		h.recordSyncFailure(&media, fmt.Errorf("details: %w", err))
 		return err
 	}
 
 	// Update non-user fields
 	media.Title = freshMedia.Title
 	media.Overview = freshMedia.Overview
-	media.PosterPath = freshMedia.PosterPath
+	posterPath := freshMedia.PosterPath
+	if media.CustomPosterPath != "" {
+		posterPath = media.CustomPosterPath
+	}
+	if posterPath != media.PosterPath || media.PosterBlurhash == "" {
+		media.PosterBlurhash = h.posterBlurhash(posterPath)
+	}
+	media.PosterPath = posterPath
 	media.VoteCount = freshMedia.VoteCount
 	media.VoteAverage = freshMedia.VoteAverage
 	media.InProduction = freshMedia.InProduction
+	media.Adult = freshMedia.Adult
+	media.Certification = freshMedia.Certification
+	media.DetectedAnime(freshMedia.AnimeDetected)
+	// An ID entered by an admin wins over TMDB's link
+	if media.TVDBID == 0 {
+		media.TVDBID = freshMedia.TVDBID
+	}
 	now := time.Now()
-	media.LastSyncedAt = &now
+	if media.Type != models.MediaTypeTV {
+		media.LastSyncedAt = &now
+		media.RecordSyncResult(nil, now)
+	}
 
-	models.DB.Save(&media)
+	h.db.Save(&media)
 
 	// Sync episodes for TV shows
+	var syncErrs []error
 	if media.Type == "tv" {
-		detailedSeasons, _ := h.tmdbService.GetDetailedSeasons(tmdbID)
+		provider := h.episodeSource(media)
+		detailedSeasons, err := provider.GetDetailedSeasons(tmdbID)
+		if err != nil {
+			syncErrs = append(syncErrs, fmt.Errorf("seasons: %w", err))
+		}
+		complete := err == nil
 		totalEpisodes := 0
+		seasonsSeen := map[int]bool{}
+		episodesSeen := map[[2]int]bool{}
 
 		for _, season := range detailedSeasons {
 			if season.SeasonNumber > 0 {
 				totalEpisodes += season.EpisodeCount
+				seasonsSeen[season.SeasonNumber] = true
 
 				// Upsert season
 				var existingSeason models.Season
-				if models.DB.Where("tmdb_id = ? AND season_number = ?", tmdbID, season.SeasonNumber).First(&existingSeason).Error != nil {
-					models.DB.Create(&season)
+				if h.db.Where("tmdb_id = ? AND season_number = ?", tmdbID, season.SeasonNumber).First(&existingSeason).Error != nil {
+					h.db.Create(&season)
 				} else {
 					existingSeason.Name = season.Name
 					existingSeason.EpisodeCount = season.EpisodeCount
-					models.DB.Save(&existingSeason)
+					h.db.Save(&existingSeason)
 				}
 
 				// Sync episodes
-				detailedEpisodes, _ := h.tmdbService.GetDetailedEpisodes(tmdbID, season.SeasonNumber)
+				detailedEpisodes, err := provider.GetDetailedEpisodes(tmdbID, season.SeasonNumber)
+				if err != nil {
+					complete = false
+					syncErrs = append(syncErrs, fmt.Errorf("season %d episodes: %w", season.SeasonNumber, err))
+				}
 				for _, episode := range detailedEpisodes {
-					var existingEpisode models.Episode
-					if models.DB.Where("tmdb_id = ? AND season_number = ? AND episode_number = ?",
-						tmdbID, season.SeasonNumber, episode.EpisodeNumber).First(&existingEpisode).Error != nil {
-						models.DB.Create(&episode)
-					} else {
-						existingEpisode.Name = episode.Name
-						existingEpisode.Overview = episode.Overview
-						existingEpisode.AirDate = episode.AirDate
-						models.DB.Save(&existingEpisode)
-					}
+					episodesSeen[[2]int{season.SeasonNumber, episode.EpisodeNumber}] = true
 				}
+				h.upsertEpisodes(tmdbID, season.SeasonNumber, detailedEpisodes)
 			}
 		}
 
+		if prune && complete && len(seasonsSeen) > 0 {
+			h.pruneEpisodes(tmdbID, seasonsSeen, episodesSeen)
+		}
+
 		media.TotalEpisodes = totalEpisodes
 		var watchedCount int64
-		models.DB.Model(&models.Episode{}).Where("tmdb_id = ? AND watched = ?", tmdbID, true).Count(&watchedCount)
+		h.db.Model(&models.Episode{}).Where("tmdb_id = ? AND watched = ?", tmdbID, true).Count(&watchedCount)
 		media.Progress = int(watchedCount)
-		models.DB.Save(&media)
+		// A show only counts as synced once every season came through, so the schedule retries it after the backoff
+		if len(syncErrs) == 0 {
+			media.LastSyncedAt = &now
+		}
+		media.RecordSyncResult(errors.Join(syncErrs...), now)
+		h.db.Save(&media)
+		models.RefreshSeasonCounts(h.db, tmdbID)
+		models.RefreshEpisodeScores(h.db, tmdbID)
+	}
+
+	return errors.Join(syncErrs...)
+}
+
+// upsertEpisodes stores a season's episodes as the provider lists them, keeping watch state on existing rows.
+// Still placeholders are left to HashEpisodeStills, so a long show doesn't wait on an image download per episode.
+func (h *BaseHandler) upsertEpisodes(tmdbID, seasonNumber int, episodes []models.Episode) {
+	for _, episode := range episodes {
+		var existingEpisode models.Episode
+		if h.db.Where("tmdb_id = ? AND season_number = ? AND episode_number = ?",
+			tmdbID, seasonNumber, episode.EpisodeNumber).First(&existingEpisode).Error != nil {
+			h.db.Create(&episode)
+			continue
+		}
+		// TVDB has no TMDB stills or ratings, so an episode keeps the last ones TMDB gave
+		if episode.StillPath != "" {
+			if episode.StillPath != existingEpisode.StillPath {
+				existingEpisode.StillBlurhash, existingEpisode.StillHashFailedAt = "", nil
+			}
+			existingEpisode.StillPath = episode.StillPath
+		}
+		if episode.VoteCount > 0 {
+			existingEpisode.VoteAverage = episode.VoteAverage
+			existingEpisode.VoteCount = episode.VoteCount
+		}
+		existingEpisode.Name = episode.Name
+		existingEpisode.Overview = episode.Overview
+		existingEpisode.AirDate = episode.AirDate
+		h.db.Save(&existingEpisode)
 	}
+}
 
+// syncSeason refreshes one season's episodes from the show's provider without re-syncing the whole show,
+// e.g. after an air date moves. Unwatched episodes the provider dropped are pruned; watched ones keep their
+// history and still count, so the season's episode count matches what is stored. Season 0 holds the specials.
+func (h *BaseHandler) syncSeason(media models.Media, seasonNumber int) error {
+	episodes, err := h.episodeSource(media).GetDetailedEpisodes(media.TMDBID, seasonNumber)
+	if err != nil {
+		return err
+	}
+	h.upsertEpisodes(media.TMDBID, seasonNumber, episodes)
+
+	// An empty answer is more likely a provider hiccup than a season with nothing left in it
+	if len(episodes) > 0 {
+		numbers := make([]int, len(episodes))
+		for i, episode := range episodes {
+			numbers[i] = episode.EpisodeNumber
+		}
+		if err := h.db.Unscoped().Where("tmdb_id = ? AND season_number = ? AND watched = ? AND episode_number NOT IN ?",
+			media.TMDBID, seasonNumber, false, numbers).Delete(&models.Episode{}).Error; err != nil {
+			return err
+		}
+	}
+
+	var stored int64
+	h.db.Model(&models.Episode{}).Where("tmdb_id = ? AND season_number = ?", media.TMDBID, seasonNumber).Count(&stored)
+	season := models.Season{TMDBID: media.TMDBID, SeasonNumber: seasonNumber, Name: fmt.Sprintf("Season %d", seasonNumber)}
+	if seasonNumber == 0 {
+		season.Name = "Specials"
+	}
+	if err := h.db.Where("tmdb_id = ? AND season_number = ?", media.TMDBID, seasonNumber).FirstOrCreate(&season).Error; err != nil {
+		return err
+	}
+	if err := h.db.Model(&season).Update("episode_count", stored).Error; err != nil {
+		return err
+	}
+	var totalEpisodes int64
+	h.db.Model(&models.Season{}).Where("tmdb_id = ? AND season_number > 0", media.TMDBID).
+		Select("COALESCE(SUM(episode_count), 0)").Scan(&totalEpisodes)
+	if err := h.db.Model(&media).Update("total_episodes", totalEpisodes).Error; err != nil {
+		return err
+	}
+	models.RefreshSeasonCounts(h.db, media.TMDBID)
+	models.RefreshEpisodeScores(h.db, media.TMDBID)
 	return nil
 }
 
-// BackgroundSync syncs all active media (minimal background job)
+// recordSyncFailure counts a failed sync on the title without touching its other fields
+func (h *BaseHandler) recordSyncFailure(media *models.Media, err error) {
+	media.RecordSyncResult(err, time.Now())
+	if dbErr := h.db.Model(media).Select("last_sync_error", "sync_failures", "next_sync_at").Updates(media).Error; dbErr != nil {
+		log.Printf("Failed to record sync failure for %d: %v", media.TMDBID, dbErr)
+	}
+}
+
+// episodeSource is where a show's seasons and episodes come from: its type's provider unless an admin picked TVDB
+func (h *BaseHandler) episodeSource(media models.Media) services.EpisodeSource {
+	if media.MetadataProvider == models.ProviderTVDB && media.TVDBID != 0 && h.tvdb.Enabled() {
+		return h.tvdb.Series(media.TVDBID)
+	}
+	return h.metadata.For(media.Type)
+}
+
+// pruneEpisodes deletes a show's seasons and episodes missing from the provider's latest data
+func (h *BaseHandler) pruneEpisodes(tmdbID int, seasonsSeen map[int]bool, episodesSeen map[[2]int]bool) {
+	var episodes []models.Episode
+	h.db.Where("tmdb_id = ? AND season_number > 0", tmdbID).Find(&episodes)
+	for _, episode := range episodes {
+		if !episodesSeen[[2]int{episode.SeasonNumber, episode.EpisodeNumber}] {
+			h.db.Unscoped().Delete(&episode)
+		}
+	}
+
+	var seasons []models.Season
+	h.db.Where("tmdb_id = ? AND season_number > 0", tmdbID).Find(&seasons)
+	for _, season := range seasons {
+		if !seasonsSeen[season.SeasonNumber] {
+			h.db.Unscoped().Delete(&season)
+		}
+	}
+}
+
+// posterBlurhash and stillBlurhash are placeholder hashes for TMDB artwork, shaped like it;
+// "" when there is no image or it couldn't be fetched, so it can be tried again later
+func (h *BaseHandler) posterBlurhash(path string) string {
+	return h.imageBlurhash(path, 3, 4)
+}
+
+func (h *BaseHandler) stillBlurhash(path string) string {
+	return h.imageBlurhash(path, 4, 3)
+}
+
+func (h *BaseHandler) imageBlurhash(path string, xComponents, yComponents int) string {
+	if path == "" {
+		return ""
+	}
+	hash, err := h.tmdbService.ImageBlurhash(path, xComponents, yComponents)
+	if err != nil {
+		log.Printf("Failed to hash image %s: %v", path, err)
+		return ""
+	}
+	return hash
+}
+
+// BackgroundSync refreshes every title whose sync schedule says it's due (see Media.SyncScheduled)
 func (h *BaseHandler) BackgroundSync() {
 	var mediaItems []models.Media
-	models.DB.Where("status IN ?", []string{"watching", "planned"}).Find(&mediaItems)
+	h.db.Where("sync_schedule IN ? OR (sync_schedule = ? AND status IN ?)",
+		[]string{models.SyncScheduleDaily, models.SyncScheduleWeekly},
+		models.SyncScheduleAuto, []string{models.StatusWatching, models.StatusPlanned}).Find(&mediaItems)
 
 	for _, m := range mediaItems {
-		if m.LastSyncedAt == nil || m.LastSyncedAt.Before(time.Now().Add(-48*time.Hour)) {
-			h.SyncMedia(m.TMDBID)
+		if m.SyncScheduled(time.Now()) {
+			if h.tmdbThrottled() {
+				log.Printf("TMDB budget nearly spent, stopping background sync")
+				return
+			}
+			if err := h.SyncMedia(m.TMDBID); err != nil {
+				log.Printf("Background sync of %d failed: %v", m.TMDBID, err)
+			}
 			time.Sleep(500 * time.Millisecond) // Rate limit
 		}
 	}
@@ -594,7 +1031,7 @@ func (h *BaseHandler) BackgroundSync() {
 
 // syncInProduction: Helper to sync production status from TMDB
 func (h *BaseHandler) syncInProduction(media *models.Media) {
-	if freshMedia, err := h.tmdbService.GetDetails(media.TMDBID, media.Type); err == nil {
+	if freshMedia, err := h.metadata.For(media.Type).GetDetails(media.TMDBID, media.Type); err == nil {
 		media.InProduction = freshMedia.InProduction
 	}
 }
@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"mini-blog/app/models"
+	"mini-blog/app/templates"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// CategoryPosts is a category's landing page: its description and the published posts filed under it
+func (h *BaseHandler) CategoryPosts(c echo.Context) error {
+	user := h.GetCurrentUser(c)
+
+	var category models.Category
+	if err := h.db.Where("slug = ?", c.Param("slug")).First(&category).Error; err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "Category not found")
+	}
+
+	state := templates.PostsState{Category: category.Slug, Page: 1}
+	if page, err := strconv.Atoi(c.QueryParam("page")); err == nil && page > 1 {
+		state.Page = page
+	}
+
+	query := h.db.Model(&models.Post{}).Scopes(models.PostsVisibleTo(user)).Where("category_id = ?", category.ID)
+	var total int64
+	if err := query.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch posts")
+	}
+	state.TotalPages = int((total + postsPerPage - 1) / postsPerPage)
+
+	var posts []models.Post
+	if err := query.Preload("Tags").Order("created_at desc").Offset((state.Page - 1) * postsPerPage).Limit(postsPerPage).Find(&posts).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch posts")
+	}
+
+	h.localizePosts(c, posts)
+	h.markFinished(user, posts)
+	h.attachLikes(c, posts)
+
+	// Paging swaps just the results
+	if h.isHTMXRequest(c) {
+		return h.render(c, templates.PostsResults(posts, state))
+	}
+	return h.render(c, templates.Layout(category.Name, templates.CategoryPage(category, posts, state), c.Request().URL.Path, user))
+}
+
+// AdminCategoryCreate adds a category from the dashboard form, re-rendering the dashboard's category list
+func (h *BaseHandler) AdminCategoryCreate(c echo.Context) error {
+	category := models.Category{
+		Name:        h.trimFormValue(c, "name"),
+		Description: h.trimFormValue(c, "description"),
+	}
+	category.Slug = h.generateSlug(category.Name)
+	if err := h.validator.Struct(category); err != nil {
+		return h.renderAdminCategories(c, "Category names need a letter or digit and at most 50 characters")
+	}
+
+	if err := h.db.Create(&category).Error; err != nil {
+		return h.renderAdminCategories(c, "A category with that name already exists")
+	}
+	return h.renderAdminCategories(c, "")
+}
+
+// AdminCategoryDelete removes a category; its posts stay, uncategorised
+func (h *BaseHandler) AdminCategoryDelete(c echo.Context) error {
+	id, err := h.parseUintParam(c, "id")
+	if err != nil {
+		return err
+	}
+
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.Post{}).Where("category_id = ?", id).Update("category_id", nil).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Delete(&models.Category{}, id).Error
+	})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to delete category")
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+// adminCategories lists every category with how many posts it holds, published or not
+func (h *BaseHandler) adminCategories() []models.Category {
+	var categories []models.Category
+	h.db.Model(&models.Category{}).
+		Select("categories.*, COUNT(posts.id) AS post_count, COUNT(posts.id) FILTER (WHERE posts.published) AS published_count").
+		Joins("LEFT JOIN posts ON posts.category_id = categories.id AND posts.deleted_at IS NULL").
+		Group("categories.id").
+		Order("categories.name").
+		Find(&categories)
+	return categories
+}
+
+func (h *BaseHandler) renderAdminCategories(c echo.Context, errorMessage string) error {
+	return h.render(c, templates.AdminCategories(h.adminCategories(), errorMessage))
+}
+
+// postCategoryID reads the post form's category_id, nil for none or a category that no longer exists
+func (h *BaseHandler) postCategoryID(c echo.Context) *uint {
+	id, err := strconv.ParseUint(c.FormValue("category_id"), 10, 64)
+	if err != nil {
+		return nil
+	}
+	var category models.Category
+	if err := h.db.First(&category, id).Error; err != nil {
+		return nil
+	}
+	return &category.ID
+}
+
+// postCategories lists the categories offered by the post editor
+func (h *BaseHandler) postCategories() []models.Category {
+	var categories []models.Category
+	h.db.Order("name").Find(&categories)
+	return categories
+}
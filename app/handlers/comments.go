@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"fmt"
+	"mini-blog/app/models"
+	"mini-blog/app/templates"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// commentMaxDepth is how deep replies nest; a reply to the deepest level joins that level instead
+const commentMaxDepth = 4
+
+// PostComments renders the comment section under a post, oldest first
+func (h *BaseHandler) PostComments(c echo.Context) error {
+	post, err := h.loadPublishedPost(c)
+	if err != nil {
+		return err
+	}
+	return h.renderComments(c, post, "")
+}
+
+// PostCommentCreate adds the signed-in user's comment to a post, as a reply when parent_id is set
+func (h *BaseHandler) PostCommentCreate(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+	post, err := h.loadPublishedPost(c)
+	if err != nil {
+		return err
+	}
+
+	comment := models.Comment{PostID: post.ID, UserID: user.ID, Body: h.trimFormValue(c, "body")}
+	if id, err := strconv.ParseUint(c.FormValue("parent_id"), 10, 64); err == nil && id > 0 {
+		if comment.ParentID, err = h.replyParent(post.ID, uint(id)); err != nil {
+			return err
+		}
+	}
+	// Only the body; the unloaded User would fail its own rules
+	if err := h.validator.StructPartial(comment, "Body"); err != nil {
+		return h.renderComments(c, post, "Comments must be between 1 and 2000 characters")
+	}
+	if err := h.db.Create(&comment).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to add comment")
+	}
+	verb := "Commented on "
+	if comment.ParentID != nil {
+		verb = "Replied to a comment on "
+	}
+	h.recordActivity(user.ID, models.ActivityComment, verb+post.Title, fmt.Sprintf("/posts/%s#comment-%d", post.Slug, comment.ID))
+	return h.renderComments(c, post, "")
+}
+
+// PostCommentReplyForm opens the reply form under a comment
+func (h *BaseHandler) PostCommentReplyForm(c echo.Context) error {
+	post, err := h.loadPublishedPost(c)
+	if err != nil {
+		return err
+	}
+	id, err := h.parseUintParam(c, "id")
+	if err != nil {
+		return err
+	}
+	var comment models.Comment
+	if err := h.db.Where("id = ? AND post_id = ?", id, post.ID).First(&comment).Error; err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "Comment not found")
+	}
+	return h.render(c, templates.CommentReplyForm(post, comment))
+}
+
+// PostCommentDelete removes a comment; admins may delete any, everyone else only their own.
+// Its replies move up to take its place in the thread.
+func (h *BaseHandler) PostCommentDelete(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+	post, err := h.loadPublishedPost(c)
+	if err != nil {
+		return err
+	}
+	id, err := h.parseUintParam(c, "id")
+	if err != nil {
+		return err
+	}
+
+	var comment models.Comment
+	if err := h.db.Where("id = ? AND post_id = ?", id, post.ID).First(&comment).Error; err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "Comment not found")
+	}
+	if comment.UserID != user.ID && !user.IsAdmin() {
+		return echo.NewHTTPError(http.StatusForbidden, "Access denied")
+	}
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.Comment{}).Where("parent_id = ?", comment.ID).Update("parent_id", comment.ParentID).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&comment).Error
+	})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to delete comment")
+	}
+	// Deleting the comment settles any reports on it
+	now := time.Now()
+	h.db.Model(&models.Report{}).Where("comment_id = ? AND status = ?", comment.ID, models.ReportOpen).
+		Updates(map[string]interface{}{"status": models.ReportResolved, "resolved_by_id": user.ID, "resolved_at": &now})
+	return h.renderComments(c, post, "")
+}
+
+func (h *BaseHandler) renderComments(c echo.Context, post models.Post, errorMessage string) error {
+	user := h.GetCurrentUser(c)
+	query := h.db.Preload("User").Where("post_id = ?", post.ID)
+	// Comments hidden after reports or by a ban stay visible to admins, marked as such
+	if user == nil || !user.IsAdmin() {
+		query = query.Where("hidden = ?", false).
+			Where("user_id NOT IN (?)", h.db.Model(&models.User{}).Select("id").Where("banned_at IS NOT NULL"))
+	}
+	var comments []models.Comment
+	query.Order("created_at asc").Find(&comments)
+	return h.render(c, templates.CommentsSection(post, threadComments(comments), len(comments), user, errorMessage))
+}
+
+// replyParent returns the comment a reply to id hangs under: id itself, or its parent when id is already
+// at commentMaxDepth
+func (h *BaseHandler) replyParent(postID, id uint) (*uint, error) {
+	var parent models.Comment
+	if err := h.db.Where("id = ? AND post_id = ?", id, postID).First(&parent).Error; err != nil {
+		return nil, echo.NewHTTPError(http.StatusNotFound, "Comment not found")
+	}
+	depth := 0
+	for ancestor := parent.ParentID; ancestor != nil && depth < commentMaxDepth; depth++ {
+		var next models.Comment
+		if h.db.Select("id", "parent_id").First(&next, *ancestor).Error != nil {
+			break
+		}
+		ancestor = next.ParentID
+	}
+	if depth+1 >= commentMaxDepth {
+		return parent.ParentID, nil
+	}
+	return &parent.ID, nil
+}
+
+// threadComments nests replies under their parents, each level oldest first. A reply whose parent isn't
+// shown, say one hidden after reports, moves to the top level rather than disappearing with it.
+func threadComments(comments []models.Comment) []models.Comment {
+	shown := make(map[uint]bool, len(comments))
+	for _, comment := range comments {
+		shown[comment.ID] = true
+	}
+	var roots []models.Comment
+	replies := make(map[uint][]models.Comment)
+	for _, comment := range comments {
+		if comment.ParentID != nil && shown[*comment.ParentID] {
+			replies[*comment.ParentID] = append(replies[*comment.ParentID], comment)
+		} else {
+			roots = append(roots, comment)
+		}
+	}
+
+	var nest func(level []models.Comment) []models.Comment
+	nest = func(level []models.Comment) []models.Comment {
+		for i := range level {
+			level[i].Replies = nest(replies[level[i].ID])
+		}
+		return level
+	}
+	return nest(roots)
+}
@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"mini-blog/app/models"
+	"mini-blog/app/templates"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// AdminContentCalendar shows drafts, scheduled and published posts for a month (?month=2006-01)
+func (h *BaseHandler) AdminContentCalendar(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+	loc := h.userLocation(c)
+
+	month, err := time.ParseInLocation("2006-01", c.QueryParam("month"), loc)
+	if err != nil {
+		now := time.Now().In(loc)
+		month = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, loc)
+	}
+
+	// Pad to whole weeks so leading/trailing days in the grid are populated too
+	start := month.AddDate(0, 0, -7)
+	end := month.AddDate(0, 1, 7)
+
+	var posts []models.Post
+	h.db.Where("(publish_at IS NOT NULL AND publish_at >= ? AND publish_at < ?) OR (publish_at IS NULL AND created_at >= ? AND created_at < ?)",
+		start, end, start, end).
+		Order("COALESCE(publish_at, created_at) asc").
+		Find(&posts)
+
+	if h.isHTMXRequest(c) {
+		return h.render(c, templates.ContentCalendar(month, posts))
+	}
+	return h.render(c, templates.Layout("Content Calendar", templates.ContentCalendar(month, posts), c.Request().URL.Path, user))
+}
+
+// AdminPostReschedule moves a post to a new day, keeping its time of day (09:00 if it had none)
+func (h *BaseHandler) AdminPostReschedule(c echo.Context) error {
+	id, err := h.parseUintParam(c, "id")
+	if err != nil {
+		return err
+	}
+
+	loc := h.userLocation(c)
+	day, err := time.ParseInLocation("2006-01-02", c.FormValue("date"), loc)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid date")
+	}
+
+	var post models.Post
+	if err := h.db.First(&post, id).Error; err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "Post not found")
+	}
+	if post.Published {
+		return echo.NewHTTPError(http.StatusBadRequest, "Published posts can't be rescheduled")
+	}
+
+	hour, minute := 9, 0
+	if post.PublishAt != nil {
+		current := post.PublishAt.In(loc)
+		hour, minute = current.Hour(), current.Minute()
+	}
+	publishAt := time.Date(day.Year(), day.Month(), day.Day(), hour, minute, 0, 0, loc)
+
+	if err := h.db.Model(&post).Updates(map[string]interface{}{
+		"publish_at": publishAt,
+		"version":    gorm.Expr("version + 1"),
+	}).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to reschedule post")
+	}
+
+	c.QueryParams().Set("month", publishAt.Format("2006-01"))
+	if month := c.FormValue("month"); month != "" {
+		c.QueryParams().Set("month", month)
+	}
+	return h.AdminContentCalendar(c)
+}
@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"mini-blog/app/models"
+	"mini-blog/app/templates"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+var errCouponUnavailable = errors.New("coupon unavailable")
+
+// Admin coupon management
+func (h *BaseHandler) AdminCoupons(c echo.Context) error {
+	return h.renderCoupons(c, "")
+}
+
+func (h *BaseHandler) AdminCouponCreate(c echo.Context) error {
+	coupon := models.Coupon{Code: strings.ToUpper(h.trimFormValue(c, "code"))}
+	coupon.TrialDays, _ = strconv.Atoi(c.FormValue("trial_days"))
+	coupon.PercentOff, _ = strconv.Atoi(c.FormValue("percent_off"))
+	coupon.AmountOff, _ = strconv.Atoi(c.FormValue("amount_off"))
+	coupon.MaxRedemptions, _ = strconv.Atoi(c.FormValue("max_redemptions"))
+	if coupon.MaxRedemptions < 0 {
+		coupon.MaxRedemptions = 0
+	}
+	if value := c.FormValue("expires_on"); value != "" {
+		day, err := time.ParseInLocation("2006-01-02", value, h.userLocation(c))
+		if err != nil {
+			return h.renderCoupons(c, "Invalid expiry date")
+		}
+		expiresAt := day.AddDate(0, 0, 1).Add(-time.Second)
+		coupon.ExpiresAt = &expiresAt
+	}
+	offers := 0
+	for _, value := range []int{coupon.TrialDays, coupon.PercentOff, coupon.AmountOff} {
+		if value != 0 {
+			offers++
+		}
+	}
+	if err := h.validator.Struct(coupon); err != nil || offers != 1 {
+		return h.renderCoupons(c, "A code and one offer are required: a trial of 1 to 365 days, 1 to 100 percent off or an amount off")
+	}
+
+	if err := h.db.Create(&coupon).Error; err != nil {
+		return h.renderCoupons(c, "A coupon with that code already exists")
+	}
+	return h.renderCoupons(c, "")
+}
+
+func (h *BaseHandler) AdminCouponDelete(c echo.Context) error {
+	id, err := h.parseUintParam(c, "id")
+	if err != nil {
+		return err
+	}
+
+	// Redemptions stay, so users who already had a trial can't take another
+	if err := h.db.Delete(&models.Coupon{}, id).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to delete coupon")
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+func (h *BaseHandler) renderCoupons(c echo.Context, errorMessage string) error {
+	var coupons []models.Coupon
+	h.db.Order("created_at desc").Find(&coupons)
+
+	page := templates.CouponsPage(coupons, h.cfg.Payments.Currency, errorMessage)
+	if h.isHTMXRequest(c) {
+		return h.render(c, page)
+	}
+	return h.render(c, templates.Layout("Coupons", page, c.Request().URL.Path, c.Get("user").(*models.User)))
+}
+
+// RedeemCoupon starts a premium trial for the signed-in user; each account gets one trial
+func (h *BaseHandler) RedeemCoupon(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+	if user.Role != models.RoleUser {
+		return h.render(c, templates.TrialPanel(user, "", "Your account already has premium access"))
+	}
+
+	var redeemed int64
+	h.db.Model(&models.CouponRedemption{}).Where("user_id = ?", user.ID).Count(&redeemed)
+	if redeemed > 0 {
+		return h.render(c, templates.TrialPanel(user, "", "You have already used a trial"))
+	}
+
+	code := strings.ToUpper(h.trimFormValue(c, "code"))
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		var coupon models.Coupon
+		if err := tx.Where("code = ?", code).First(&coupon).Error; err != nil || coupon.Discount() || !coupon.Redeemable(time.Now()) {
+			return errCouponUnavailable
+		}
+		if err := tx.Create(&models.CouponRedemption{CouponID: coupon.ID, UserID: user.ID}).Error; err != nil {
+			return err
+		}
+		// Guarded so two users can't take the last redemption at once
+		result := tx.Model(&coupon).
+			Where("max_redemptions = 0 OR redemptions < max_redemptions").
+			Update("redemptions", gorm.Expr("redemptions + 1"))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errCouponUnavailable
+		}
+
+		endsAt := time.Now().AddDate(0, 0, coupon.TrialDays)
+		user.Role, user.TrialEndsAt = models.RolePremium, &endsAt
+		return tx.Model(user).Select("role", "trial_ends_at").Updates(user).Error
+	})
+	switch {
+	case errors.Is(err, errCouponUnavailable):
+		return h.render(c, templates.TrialPanel(user, "", "That code is invalid or no longer available"))
+	case err != nil:
+		user.Role, user.TrialEndsAt = models.RoleUser, nil
+		return h.render(c, templates.TrialPanel(user, "", "Failed to start your trial"))
+	}
+
+	h.emitEvent(models.EventSubscriptionCreated, fmt.Sprintf("%s started a %s premium trial", user.Email, code), "/admin/users", map[string]interface{}{
+		"user_id": user.ID,
+		"email":   user.Email,
+		"coupon":  code,
+		"ends_at": user.TrialEndsAt,
+	})
+	return h.render(c, templates.TrialPanel(user, "Your premium trial has started", ""))
+}
+
+// EndExpiredTrials moves users whose trial has run out back to the regular role
+func (h *BaseHandler) EndExpiredTrials() {
+	var users []models.User
+	h.db.Where("role = ? AND trial_ends_at IS NOT NULL AND trial_ends_at <= ?", models.RolePremium, time.Now()).Find(&users)
+
+	for _, user := range users {
+		if err := h.db.Model(&user).Updates(map[string]interface{}{"role": models.RoleUser, "trial_ends_at": nil}).Error; err != nil {
+			log.Printf("Failed to end trial of user %d: %v", user.ID, err)
+			continue
+		}
+		h.emitEvent(models.EventSubscriptionEnded, user.Email+"'s premium trial ended", "/admin/users", map[string]interface{}{
+			"user_id": user.ID,
+			"email":   user.Email,
+		})
+	}
+	if len(users) > 0 {
+		log.Printf("Ended %d expired trial(s)", len(users))
+	}
+}
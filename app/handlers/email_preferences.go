@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"mini-blog/app/models"
+	"mini-blog/app/services"
+	"mini-blog/app/templates"
+	"net/http"
+	"strings"
+
+	"github.com/a-h/templ"
+	"github.com/labstack/echo/v4"
+)
+
+// EmailPreferences shows the preference center for the signed address in ?e=&s=
+func (h *BaseHandler) EmailPreferences(c echo.Context) error {
+	email, signature, err := h.signedEmail(c)
+	if err != nil {
+		return err
+	}
+	return h.renderEmailPreferences(c, templates.EmailPreferencesPage(h.emailPreferenceFor(email), signature, ""))
+}
+
+// EmailPreferencesUpdate saves the chosen email types; "otp_only" turns every optional email off
+func (h *BaseHandler) EmailPreferencesUpdate(c echo.Context) error {
+	email, signature, err := h.signedEmail(c)
+	if err != nil {
+		return err
+	}
+
+	otpOnly := c.FormValue("otp_only") == "on"
+	pref, err := h.saveEmailPreference(email,
+		!otpOnly && c.FormValue("newsletters") == "on",
+		!otpOnly && c.FormValue("episode_alerts") == "on",
+		!otpOnly && c.FormValue("digests") == "on",
+	)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to save preferences")
+	}
+
+	return h.renderEmailPreferences(c, templates.EmailPreferencesPage(pref, signature, "Preferences saved"))
+}
+
+// EmailUnsubscribePage is where the footer's unsubscribe link lands. A GET only asks, since link scanners
+// would otherwise unsubscribe people; the button posts to EmailUnsubscribe.
+func (h *BaseHandler) EmailUnsubscribePage(c echo.Context) error {
+	email, signature, err := h.signedEmail(c)
+	if err != nil {
+		return err
+	}
+	return h.renderEmailPreferences(c, templates.EmailUnsubscribeConfirm(email, signature))
+}
+
+// EmailUnsubscribe turns off every optional email. It answers the confirmation page's button and the
+// RFC 8058 one-click POST mail clients send to the List-Unsubscribe address.
+func (h *BaseHandler) EmailUnsubscribe(c echo.Context) error {
+	email, signature, err := h.signedEmail(c)
+	if err != nil {
+		return err
+	}
+
+	pref, err := h.saveEmailPreference(email, false, false, false)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to unsubscribe")
+	}
+
+	return h.renderEmailPreferences(c, templates.EmailPreferencesPage(pref, signature, "You've been unsubscribed. You'll only receive login codes and account emails."))
+}
+
+// signedEmail reads the address and signature from the request, rejecting tampered links
+func (h *BaseHandler) signedEmail(c echo.Context) (string, string, error) {
+	email := strings.ToLower(strings.TrimSpace(c.FormValue("e")))
+	signature := c.FormValue("s")
+	if email == "" || !services.VerifyValue(h.cfg.SigningKeys(), emailPreferencesScope, email, signature) {
+		return "", "", echo.NewHTTPError(http.StatusForbidden, "Invalid or expired link")
+	}
+	return email, signature, nil
+}
+
+func (h *BaseHandler) saveEmailPreference(email string, newsletters, episodeAlerts, digests bool) (models.EmailPreference, error) {
+	var pref models.EmailPreference
+	h.db.Where("email = ?", email).FirstOrInit(&pref, models.EmailPreference{Email: email})
+
+	pref.Newsletters = newsletters
+	pref.EpisodeAlerts = episodeAlerts
+	pref.Digests = digests
+	return pref, h.db.Save(&pref).Error
+}
+
+func (h *BaseHandler) renderEmailPreferences(c echo.Context, page templ.Component) error {
+	if h.isHTMXRequest(c) {
+		return h.render(c, page)
+	}
+	return h.render(c, templates.Layout("Email Preferences", page, c.Request().URL.Path, h.GetCurrentUser(c)))
+}
@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"log"
+	"mini-blog/app/models"
+	"mini-blog/app/services"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// EmailQueueInterval is the worker's pause between batches
+const EmailQueueInterval = 30 * time.Second
+
+const (
+	emailQueueBatch       = 50
+	emailQueueSendGap     = 200 * time.Millisecond // stays well under the provider's rate limit
+	emailQueueMaxAttempts = 3
+	emailPreferencesScope = "email-prefs"
+
+	// emailQueueRate is what the worker delivers per minute: a full batch, sent one gap apart, then a pause
+	emailQueueRate = int(time.Minute * emailQueueBatch / (emailQueueBatch*emailQueueSendGap + EmailQueueInterval))
+)
+
+// enqueueEmail schedules an email for the queue worker at sendAt; optional kinds get a preferences footer
+func (h *BaseHandler) enqueueEmail(kind, to, subject, html string, sendAt time.Time, sendID *uint) error {
+	if kind != models.EmailKindTransactional {
+		html += services.PreferencesFooterHTML(h.emailPreferencesURL("/email/preferences", to), h.emailPreferencesURL("/email/unsubscribe", to))
+	}
+
+	return h.db.Create(&models.EmailJob{
+		Kind:    kind,
+		To:      to,
+		Subject: subject,
+		HTML:    html,
+		Status:  models.EmailJobQueued,
+		SendAt:  sendAt,
+		SendID:  sendID,
+	}).Error
+}
+
+// ProcessEmailQueue sends due jobs, skipping suppressed recipients and those who opted out of the job's kind
+func (h *BaseHandler) ProcessEmailQueue() {
+	var jobs []models.EmailJob
+	h.db.Where("status = ? AND send_at <= ?", models.EmailJobQueued, time.Now()).
+		Order("send_at asc").Limit(emailQueueBatch).Find(&jobs)
+
+	for _, job := range jobs {
+		if h.emailSuppressed(job.To) {
+			h.finishEmailJob(&job, models.EmailJobSkipped, "address suppressed after a bounce or complaint")
+			continue
+		}
+		if pref := h.emailPreferenceFor(job.To); !pref.Allows(job.Kind) {
+			h.finishEmailJob(&job, models.EmailJobSkipped, "recipient opted out")
+			continue
+		}
+
+		job.Attempts++
+		if err := h.emailService.SendWithHeaders(job.To, job.Subject, job.HTML, h.listUnsubscribeHeaders(job)); err != nil {
+			log.Printf("Email job %d to %s failed (attempt %d): %v", job.ID, job.To, job.Attempts, err)
+			if job.Attempts >= emailQueueMaxAttempts {
+				h.finishEmailJob(&job, models.EmailJobFailed, err.Error())
+			} else {
+				// Back off before retrying
+				h.db.Model(&job).Updates(map[string]interface{}{
+					"attempts": job.Attempts,
+					"error":    err.Error(),
+					"send_at":  time.Now().Add(time.Duration(job.Attempts) * 5 * time.Minute),
+				})
+			}
+		} else {
+			h.finishEmailJob(&job, models.EmailJobSent, "")
+		}
+
+		time.Sleep(emailQueueSendGap)
+	}
+}
+
+// listUnsubscribeHeaders lets mail clients offer their own unsubscribe button on optional emails, which
+// unsubscribes with a single POST (RFC 8058) instead of opening the confirmation page
+func (h *BaseHandler) listUnsubscribeHeaders(job models.EmailJob) map[string]string {
+	if job.Kind == models.EmailKindTransactional {
+		return nil
+	}
+	return map[string]string{
+		"List-Unsubscribe":      "<" + h.emailPreferencesURL("/email/unsubscribe", job.To) + ">",
+		"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+	}
+}
+
+// finishEmailJob records a job's final state, mirroring it onto the campaign send when there is one
+func (h *BaseHandler) finishEmailJob(job *models.EmailJob, status, errorMessage string) {
+	updates := map[string]interface{}{"status": status, "attempts": job.Attempts, "error": errorMessage}
+	if status == models.EmailJobSent {
+		now := time.Now()
+		updates["sent_at"] = &now
+	}
+	h.db.Model(job).Updates(updates)
+
+	if job.SendID != nil {
+		h.db.Model(&models.EmailSend{}).Where("id = ?", *job.SendID).
+			Updates(map[string]interface{}{"status": status, "error": errorMessage})
+	}
+}
+
+// emailPreferenceFor returns the stored preferences for email, or everything enabled when none are saved
+func (h *BaseHandler) emailPreferenceFor(email string) models.EmailPreference {
+	pref := models.EmailPreference{Email: email, Newsletters: true, EpisodeAlerts: true, Digests: true}
+	h.db.Where("email = ?", strings.ToLower(email)).First(&pref)
+	return pref
+}
+
+// emailPreferencesURL builds a signed absolute link to a preference center page for email
+func (h *BaseHandler) emailPreferencesURL(path, email string) string {
+	email = strings.ToLower(email)
+	query := url.Values{
+		"e": {email},
+		"s": {services.SignValue(h.cfg.Session.Key, emailPreferencesScope, email)},
+	}
+	return strings.TrimSuffix(h.cfg.Server.BaseURL, "/") + path + "?" + query.Encode()
+}
@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"mini-blog/app/models"
+	"mini-blog/app/services"
+	"mini-blog/app/templates"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm/clause"
+)
+
+// suppressionsPerPage bounds the suppression list shown at once; search narrows it
+const suppressionsPerPage = 200
+
+// ResendWebhook puts addresses that hard-bounced or complained on the suppression list, from Resend's
+// email.bounced and email.complained events signed with RESEND_WEBHOOK_SECRET
+func (h *BaseHandler) ResendWebhook(c echo.Context) error {
+	payload, err := io.ReadAll(io.LimitReader(c.Request().Body, 1<<16))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Unreadable body")
+	}
+	if err := h.emailService.VerifyWebhook(payload, c.Request().Header); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	var event services.ResendEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid event")
+	}
+	var reason, detail string
+	switch event.Type {
+	case "email.bounced":
+		// A soft bounce (full mailbox, greylisting) may well succeed next time
+		if strings.EqualFold(event.Data.Bounce.Type, "Transient") {
+			return c.NoContent(http.StatusOK)
+		}
+		reason, detail = models.SuppressionBounce, event.Data.Bounce.Message
+	case "email.complained":
+		reason = models.SuppressionComplaint
+	default:
+		return c.NoContent(http.StatusOK)
+	}
+	if runes := []rune(detail); len(runes) > 500 {
+		detail = string(runes[:500])
+	}
+
+	for _, to := range event.Data.To {
+		email := strings.ToLower(strings.TrimSpace(to))
+		if email == "" {
+			continue
+		}
+		// The first reason sticks; a later bounce for a complained address changes nothing
+		suppression := models.EmailSuppression{Email: email, Reason: reason, Detail: detail}
+		if err := h.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&suppression).Error; err != nil {
+			log.Printf("Failed to suppress %s: %v", email, err)
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to record suppression")
+		}
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+// AdminSuppressions lists suppressed addresses, newest first, filtered by ?q=
+func (h *BaseHandler) AdminSuppressions(c echo.Context) error {
+	return h.renderSuppressions(c, strings.TrimSpace(c.QueryParam("q")), "")
+}
+
+// AdminSuppressionDelete clears an address from the suppression list so the queue mails it again
+func (h *BaseHandler) AdminSuppressionDelete(c echo.Context) error {
+	id, err := h.parseUintParam(c, "id")
+	if err != nil {
+		return err
+	}
+	var suppression models.EmailSuppression
+	if err := h.db.First(&suppression, id).Error; err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "Suppression not found")
+	}
+	if err := h.db.Delete(&suppression).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to clear suppression")
+	}
+	return h.renderSuppressions(c, strings.TrimSpace(c.FormValue("q")), "Cleared "+suppression.Email)
+}
+
+func (h *BaseHandler) renderSuppressions(c echo.Context, search, successMessage string) error {
+	query := h.db.Order("created_at desc").Limit(suppressionsPerPage)
+	if search != "" {
+		query = query.Where("email LIKE ?", "%"+strings.ToLower(search)+"%")
+	}
+	var suppressions []models.EmailSuppression
+	query.Find(&suppressions)
+	var total int64
+	h.db.Model(&models.EmailSuppression{}).Count(&total)
+
+	page := templates.SuppressionsPage(suppressions, total, search, successMessage)
+	if h.isHTMXRequest(c) {
+		return h.render(c, page)
+	}
+	return h.render(c, templates.Layout("Suppressed Addresses", page, c.Request().URL.Path, c.Get("user").(*models.User)))
+}
+
+// emailSuppressed reports whether email is on the suppression list
+func (h *BaseHandler) emailSuppressed(email string) bool {
+	var count int64
+	h.db.Model(&models.EmailSuppression{}).Where("email = ?", strings.ToLower(email)).Count(&count)
+	return count > 0
+}
@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"mini-blog/app/models"
+	"mini-blog/app/templates"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	webhookQueueBatch       = 50
+	webhookQueueMaxAttempts = 5
+	notificationPageSize    = 100
+)
+
+// emitEvent records a site event in the notification center and queues it for every webhook subscribed to it.
+// link is the admin page to open from the notification; data becomes the webhook payload's "data".
+func (h *BaseHandler) emitEvent(event, title, link string, data interface{}) {
+	if err := h.db.Create(&models.Notification{Event: event, Title: title, Link: link}).Error; err != nil {
+		log.Printf("Failed to record %s notification: %v", event, err)
+	}
+
+	var webhooks []models.Webhook
+	h.db.Where("active = ?", true).Find(&webhooks)
+	if len(webhooks) == 0 {
+		return
+	}
+	payload, err := json.Marshal(map[string]interface{}{
+		"event":      event,
+		"created_at": time.Now().UTC(),
+		"data":       data,
+	})
+	if err != nil {
+		log.Printf("Failed to encode %s event: %v", event, err)
+		return
+	}
+
+	for _, webhook := range webhooks {
+		if !webhook.Wants(event) {
+			continue
+		}
+		h.db.Create(&models.WebhookDelivery{
+			WebhookID: webhook.ID,
+			Event:     event,
+			Payload:   string(payload),
+			Status:    models.WebhookQueued,
+			SendAt:    time.Now(),
+		})
+	}
+}
+
+// ProcessWebhookQueue delivers due webhook events, backing off between failed attempts
+func (h *BaseHandler) ProcessWebhookQueue() {
+	var deliveries []models.WebhookDelivery
+	h.db.Where("status = ? AND send_at <= ?", models.WebhookQueued, time.Now()).
+		Order("send_at asc").Limit(webhookQueueBatch).Find(&deliveries)
+
+	for _, delivery := range deliveries {
+		var webhook models.Webhook
+		if err := h.db.First(&webhook, delivery.WebhookID).Error; err != nil || !webhook.Active {
+			h.db.Model(&delivery).Updates(map[string]interface{}{"status": models.WebhookFailed, "error": "webhook removed or disabled"})
+			continue
+		}
+
+		delivery.Attempts++
+		err := h.webhooks.Deliver(webhook.URL, webhook.Secret, delivery.Event, []byte(delivery.Payload))
+		switch {
+		case err == nil:
+			h.db.Model(&delivery).Updates(map[string]interface{}{"status": models.WebhookDelivered, "attempts": delivery.Attempts, "error": ""})
+		case delivery.Attempts >= webhookQueueMaxAttempts:
+			log.Printf("Webhook delivery %d to %s failed for good: %v", delivery.ID, webhook.URL, err)
+			h.db.Model(&delivery).Updates(map[string]interface{}{"status": models.WebhookFailed, "attempts": delivery.Attempts, "error": err.Error()})
+		default:
+			h.db.Model(&delivery).Updates(map[string]interface{}{
+				"attempts": delivery.Attempts,
+				"error":    err.Error(),
+				"send_at":  time.Now().Add(time.Duration(delivery.Attempts*delivery.Attempts) * time.Minute),
+			})
+		}
+	}
+}
+
+// AdminNotifications lists recent site events, newest first, and marks them read
+func (h *BaseHandler) AdminNotifications(c echo.Context) error {
+	var notifications []models.Notification
+	h.db.Order("created_at desc").Limit(notificationPageSize).Find(&notifications)
+	h.db.Model(&models.Notification{}).Where("read_at IS NULL").Update("read_at", time.Now())
+
+	page := templates.NotificationsPage(notifications)
+	if h.isHTMXRequest(c) {
+		return h.render(c, page)
+	}
+	return h.render(c, templates.Layout("Notifications", page, c.Request().URL.Path, c.Get("user").(*models.User)))
+}
+
+// Admin outgoing webhook management
+func (h *BaseHandler) AdminWebhooks(c echo.Context) error {
+	return h.renderWebhooks(c, "")
+}
+
+func (h *BaseHandler) AdminWebhookCreate(c echo.Context) error {
+	form, err := c.FormParams()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid form")
+	}
+	var events []string
+	for _, event := range form["events"] {
+		if models.IsValidEvent(event) {
+			events = append(events, event)
+		}
+	}
+
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create secret")
+	}
+	webhook := models.Webhook{
+		URL:    h.trimFormValue(c, "url"),
+		Secret: hex.EncodeToString(secret),
+		Events: strings.Join(events, ","),
+		Active: true,
+	}
+	if err := h.validator.Struct(webhook); err != nil {
+		return h.renderWebhooks(c, "Enter a valid URL")
+	}
+
+	if err := h.db.Create(&webhook).Error; err != nil {
+		return h.renderWebhooks(c, "Failed to save webhook")
+	}
+	return h.renderWebhooks(c, "")
+}
+
+func (h *BaseHandler) AdminWebhookDelete(c echo.Context) error {
+	id, err := h.parseUintParam(c, "id")
+	if err != nil {
+		return err
+	}
+
+	if err := h.db.Delete(&models.Webhook{}, id).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to delete webhook")
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+func (h *BaseHandler) renderWebhooks(c echo.Context, errorMessage string) error {
+	var webhooks []models.Webhook
+	h.db.Order("created_at asc").Find(&webhooks)
+
+	var deliveries []models.WebhookDelivery
+	h.db.Order("created_at desc").Limit(20).Find(&deliveries)
+
+	page := templates.WebhooksPage(webhooks, deliveries, errorMessage)
+	if h.isHTMXRequest(c) {
+		return h.render(c, page)
+	}
+	return h.render(c, templates.Layout("Webhooks", page, c.Request().URL.Path, c.Get("user").(*models.User)))
+}
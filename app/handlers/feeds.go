@@ -0,0 +1,278 @@
+package handlers
+
+import (
+	"encoding/xml"
+	"fmt"
+	"html"
+	"mini-blog/app/models"
+	"mini-blog/app/services"
+	"mini-blog/app/templates"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// feedSize is how many recent posts a feed carries
+const feedSize = 50
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Content string     `xml:"xmlns:content,attr"`
+	Atom    string     `xml:"xmlns:atom,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	Language      string    `xml:"language"`
+	LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+	Self          rssSelf   `xml:"atom:link"`
+	Items         []rssItem `xml:"item"`
+}
+
+// rssSelf is the atom:link to the feed itself that validators expect of RSS 2.0
+type rssSelf struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+	Type string `xml:"type,attr"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Link        string  `xml:"link"`
+	GUID        rssGUID `xml:"guid"`
+	PubDate     string  `xml:"pubDate"`
+	Description string  `xml:"description"`
+	Content     string  `xml:"content:encoded"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Author  atomAuthor  `xml:"author"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomEntry struct {
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Link    atomLink    `xml:"link"`
+	Updated string      `xml:"updated"`
+	Summary string      `xml:"summary,omitempty"`
+	Content atomContent `xml:"content"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+// jsonFeed follows JSON Feed 1.1 (https://jsonfeed.org/version/1.1)
+type jsonFeed struct {
+	Version     string         `json:"version"`
+	Title       string         `json:"title"`
+	HomePageURL string         `json:"home_page_url"`
+	FeedURL     string         `json:"feed_url"`
+	Language    string         `json:"language"`
+	Authors     []jsonAuthor   `json:"authors"`
+	Items       []jsonFeedItem `json:"items"`
+}
+
+type jsonAuthor struct {
+	Name string `json:"name"`
+}
+
+type jsonFeedItem struct {
+	ID            string `json:"id"`
+	URL           string `json:"url"`
+	Title         string `json:"title"`
+	ContentHTML   string `json:"content_html"`
+	Summary       string `json:"summary,omitempty"`
+	Image         string `json:"image,omitempty"`
+	DatePublished string `json:"date_published"`
+	DateModified  string `json:"date_modified"`
+}
+
+// feedSummary is a post's excerpt, or its whole text when it has none
+func feedSummary(post models.Post) string {
+	if post.Excerpt != "" {
+		return post.Excerpt
+	}
+	return services.MarkdownToText(post.Content)
+}
+
+// feedContent is a post's rendered markdown, headed by its cover image
+func feedContent(post models.Post, base string) string {
+	content := string(services.MarkdownToHTML(post.Content))
+	if post.CoverImage == "" {
+		return content
+	}
+	return fmt.Sprintf(`<p><img src="%s" alt=""></p>`, html.EscapeString(absoluteURL(base, post.CoverImage))) + content
+}
+
+// RSSFeed lists recent public posts as an RSS 2.0 feed, each with its rendered markdown in content:encoded
+func (h *BaseHandler) RSSFeed(c echo.Context) error {
+	return h.writeRSS(c, "NODELIKE", "Recent posts from NODELIKE", "/", h.feedPosts(h.db))
+}
+
+// TagFeed is the RSS feed of one tag's recent public posts, at /tags/:slug/feed.xml
+func (h *BaseHandler) TagFeed(c echo.Context) error {
+	var tag models.Tag
+	if err := h.db.Where("slug = ?", c.Param("slug")).First(&tag).Error; err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "Tag not found")
+	}
+	tagged := h.db.Table("post_tags").Select("post_id").Where("tag_id = ?", tag.ID)
+	posts := h.feedPosts(h.db.Where("id IN (?)", tagged))
+	return h.writeRSS(c, "NODELIKE: #"+tag.Name, "Recent posts tagged "+tag.Name+" from NODELIKE",
+		templates.PostsState{Tag: tag.Slug}.URL(1), posts)
+}
+
+// CategoryFeed is the RSS feed of one category's recent public posts, at /category/:slug/feed.xml
+func (h *BaseHandler) CategoryFeed(c echo.Context) error {
+	var category models.Category
+	if err := h.db.Where("slug = ?", c.Param("slug")).First(&category).Error; err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "Category not found")
+	}
+	posts := h.feedPosts(h.db.Where("category_id = ?", category.ID))
+	return h.writeRSS(c, "NODELIKE: "+category.Name, "Recent posts in "+category.Name+" from NODELIKE",
+		templates.PostsState{Category: category.Slug}.URL(1), posts)
+}
+
+// writeRSS answers with posts as an RSS 2.0 channel whose page is the site-relative path
+func (h *BaseHandler) writeRSS(c echo.Context, title, description, path string, posts []models.Post) error {
+	base := strings.TrimSuffix(h.cfg.Server.BaseURL, "/")
+	feed := rssFeed{
+		Version: "2.0",
+		Content: "http://purl.org/rss/1.0/modules/content/",
+		Atom:    "http://www.w3.org/2005/Atom",
+		Channel: rssChannel{
+			Title:       title,
+			Link:        base + path,
+			Description: description,
+			Language:    models.LocaleEnglish,
+			Self:        rssSelf{Href: base + c.Request().URL.Path, Rel: "self", Type: "application/rss+xml"},
+		},
+	}
+	updated := time.Time{}
+	for _, post := range posts {
+		link := base + "/posts/" + post.Slug
+		feed.Channel.Items = append(feed.Channel.Items, rssItem{
+			Title:       post.Title,
+			Link:        link,
+			GUID:        rssGUID{IsPermaLink: true, Value: link},
+			PubDate:     post.CalendarDate().UTC().Format(time.RFC1123Z),
+			Description: feedSummary(post),
+			Content:     feedContent(post, base),
+		})
+		if post.UpdatedAt.After(updated) {
+			updated = post.UpdatedAt
+		}
+	}
+	if !updated.IsZero() {
+		feed.Channel.LastBuildDate = updated.UTC().Format(time.RFC1123Z)
+	}
+
+	out, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to build feed")
+	}
+	return c.Blob(http.StatusOK, "application/rss+xml; charset=utf-8", append([]byte(xml.Header), out...))
+}
+
+// AtomFeed lists recent public posts as an Atom feed
+func (h *BaseHandler) AtomFeed(c echo.Context) error {
+	base := strings.TrimSuffix(h.cfg.Server.BaseURL, "/")
+	posts := h.feedPosts(h.db)
+
+	feed := atomFeed{
+		Title:  "NODELIKE",
+		ID:     base + "/",
+		Links:  []atomLink{{Href: base + "/"}, {Href: base + c.Request().URL.Path, Rel: "self", Type: "application/atom+xml"}},
+		Author: atomAuthor{Name: "NODELIKE"},
+	}
+	updated := time.Time{}
+	for _, post := range posts {
+		link := base + "/posts/" + post.Slug
+		feed.Entries = append(feed.Entries, atomEntry{
+			Title:   post.Title,
+			ID:      link,
+			Link:    atomLink{Href: link},
+			Updated: post.UpdatedAt.UTC().Format(time.RFC3339),
+			Summary: post.Excerpt,
+			Content: atomContent{Type: "html", Body: feedContent(post, base)},
+		})
+		if post.UpdatedAt.After(updated) {
+			updated = post.UpdatedAt
+		}
+	}
+	feed.Updated = updated.UTC().Format(time.RFC3339)
+
+	out, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to build feed")
+	}
+	return c.Blob(http.StatusOK, "application/atom+xml; charset=utf-8", append([]byte(xml.Header), out...))
+}
+
+// JSONFeed lists recent public posts as a JSON Feed
+func (h *BaseHandler) JSONFeed(c echo.Context) error {
+	base := strings.TrimSuffix(h.cfg.Server.BaseURL, "/")
+	posts := h.feedPosts(h.db)
+
+	feed := jsonFeed{
+		Version:     "https://jsonfeed.org/version/1.1",
+		Title:       "NODELIKE",
+		HomePageURL: base + "/",
+		FeedURL:     base + c.Request().URL.Path,
+		Language:    models.LocaleEnglish,
+		Authors:     []jsonAuthor{{Name: "NODELIKE"}},
+		Items:       make([]jsonFeedItem, 0, len(posts)),
+	}
+	for _, post := range posts {
+		link := base + "/posts/" + post.Slug
+		feed.Items = append(feed.Items, jsonFeedItem{
+			ID:            link,
+			URL:           link,
+			Title:         post.Title,
+			ContentHTML:   feedContent(post, base),
+			Summary:       post.Excerpt,
+			Image:         absoluteURL(base, post.CoverImage),
+			DatePublished: post.CalendarDate().UTC().Format(time.RFC3339),
+			DateModified:  post.UpdatedAt.UTC().Format(time.RFC3339),
+		})
+	}
+
+	c.Response().Header().Set(echo.HeaderContentType, "application/feed+json; charset=utf-8")
+	return c.JSON(http.StatusOK, feed)
+}
+
+// feedPosts returns the newest public posts from query, which callers may narrow first
+func (h *BaseHandler) feedPosts(query *gorm.DB) []models.Post {
+	var posts []models.Post
+	query.Scopes(models.PostsVisibleTo(nil)).Order("created_at desc").Limit(feedSize).Find(&posts)
+	return posts
+}
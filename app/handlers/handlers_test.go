@@ -0,0 +1,2114 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"image"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"mini-blog/app/config"
+	"mini-blog/app/models"
+	"mini-blog/app/services"
+	"mini-blog/app/testdb"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// newTestHandler is a handler over a fresh in-memory database, with external services left unconfigured
+func newTestHandler(t *testing.T) (*BaseHandler, *gorm.DB) {
+	t.Helper()
+	db := testdb.Open(t)
+	cfg := &config.Config{}
+	cfg.Session.Key = "test-session-key-32-characters-xx"
+	cfg.Server.BaseURL = "http://example.com"
+	cfg.Storage.Dir = t.TempDir()
+	return NewBaseHandler(cfg, db), db
+}
+
+// testRequest describes one request to a handler, signed in as user when set
+type testRequest struct {
+	method string
+	target string
+	params map[string]string
+	form   url.Values
+	header map[string]string
+	user   *models.User
+	htmx   bool
+}
+
+// serve runs handler on req the way the router would, error handling included
+func serve(handler echo.HandlerFunc, req testRequest) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(req.method, req.target, strings.NewReader(req.form.Encode()))
+	if req.form != nil {
+		r.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+	}
+	if req.htmx {
+		r.Header.Set("HX-Request", "true")
+	}
+	for name, value := range req.header {
+		r.Header.Set(name, value)
+	}
+
+	e := echo.New()
+	rec := httptest.NewRecorder()
+	c := e.NewContext(r, rec)
+	var names, values []string
+	for name, value := range req.params {
+		names, values = append(names, name), append(values, value)
+	}
+	c.SetParamNames(names...)
+	c.SetParamValues(values...)
+	if req.user != nil {
+		// What the session lookup and RequireAuth would have left behind
+		c.Set("current_user", req.user)
+		c.Set("user", req.user)
+	}
+	if err := handler(c); err != nil {
+		e.HTTPErrorHandler(err, c)
+	}
+	return rec
+}
+
+func TestCategoryPostsListsVisiblePosts(t *testing.T) {
+	h, db := newTestHandler(t)
+	category := models.Category{Name: "Reviews", Slug: "reviews"}
+	db.Create(&category)
+	inCategory := func(p *models.Post) { p.CategoryID = &category.ID }
+
+	public := testdb.Post(t, db, inCategory)
+	premium := testdb.Post(t, db, inCategory, func(p *models.Post) { p.Visibility = models.VisibilityPremium })
+	draft := testdb.Post(t, db, inCategory, func(p *models.Post) { p.Published = false })
+	elsewhere := testdb.Post(t, db)
+
+	rec := serve(h.CategoryPosts, testRequest{method: http.MethodGet, target: "/category/reviews", params: map[string]string{"slug": "reviews"}, htmx: true})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d; want 200", rec.Code)
+	}
+	body := rec.Body.String()
+	if !strings.Contains(body, public.Title) {
+		t.Errorf("public post %q missing", public.Title)
+	}
+	for _, hidden := range []*models.Post{premium, draft, elsewhere} {
+		if strings.Contains(body, hidden.Title) {
+			t.Errorf("post %q should not be listed", hidden.Title)
+		}
+	}
+
+	rec = serve(h.CategoryPosts, testRequest{method: http.MethodGet, target: "/category/missing", params: map[string]string{"slug": "missing"}})
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("unknown category status = %d; want 404", rec.Code)
+	}
+}
+
+func TestAdminIntegrityRepair(t *testing.T) {
+	h, db := newTestHandler(t)
+	admin := testdb.Admin(t, db)
+	show := testdb.Show(t, db, 1, 4)
+	db.Model(show).Update("total_episodes", 12)
+
+	rec := serve(h.AdminIntegrity, testRequest{method: http.MethodGet, target: "/admin/integrity", user: admin, htmx: true})
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "Repair 1 problem(s)") {
+		t.Fatalf("check page = %d %q; want the drifted total offered for repair", rec.Code, rec.Body.String())
+	}
+
+	rec = serve(h.AdminIntegrityRepair, testRequest{method: http.MethodPost, target: "/admin/integrity/repair", user: admin, htmx: true})
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "No problems found") {
+		t.Fatalf("repair page = %d %q; want a clean report", rec.Code, rec.Body.String())
+	}
+	var repaired models.Media
+	db.First(&repaired, show.ID)
+	if repaired.TotalEpisodes != 4 {
+		t.Errorf("total episodes = %d; want 4", repaired.TotalEpisodes)
+	}
+}
+
+func TestSubscribersGetScheduledPostsOnceConfirmed(t *testing.T) {
+	h, db := newTestHandler(t)
+	form := url.Values{"email": {"Reader@Example.com"}}
+	rec := serve(h.Subscribe, testRequest{method: http.MethodPost, target: "/subscribe", form: form, htmx: true})
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "Check your inbox") {
+		t.Fatalf("subscribe = %d %q; want the confirmation prompt", rec.Code, rec.Body.String())
+	}
+	var subscriber models.Subscriber
+	if err := db.Where("email = ?", "reader@example.com").First(&subscriber).Error; err != nil || subscriber.Confirmed() {
+		t.Fatalf("subscriber = %+v, %v; want one unconfirmed row", subscriber, err)
+	}
+	var confirmation models.EmailJob
+	if err := db.First(&confirmation).Error; err != nil || confirmation.Kind != models.EmailKindTransactional || !strings.Contains(confirmation.HTML, subscriber.Token) {
+		t.Fatalf("confirmation = %+v, %v; want it queued with the token", confirmation, err)
+	}
+	db.Delete(&confirmation)
+
+	schedule := func(p *models.Post) {
+		publishAt := time.Now().Add(-time.Minute)
+		p.Published, p.Status, p.PublishAt = false, models.PostStatusApproved, &publishAt
+	}
+	testdb.Post(t, db, schedule)
+	h.PublishScheduledPosts()
+	var jobs int64
+	if db.Model(&models.EmailJob{}).Count(&jobs); jobs != 0 {
+		t.Fatalf("queued %d email(s) for an unconfirmed subscriber", jobs)
+	}
+
+	rec = serve(h.SubscribeConfirm, testRequest{method: http.MethodGet, target: "/subscribe/confirm/" + subscriber.Token, params: map[string]string{"token": subscriber.Token}})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("confirm status = %d; want 200", rec.Code)
+	}
+	post := testdb.Post(t, db, schedule)
+	h.PublishScheduledPosts()
+	h.notifySubscribers(*post)
+	var queued []models.EmailJob
+	db.Find(&queued)
+	if len(queued) != 1 || queued[0].To != "reader@example.com" || queued[0].Subject != post.Title {
+		t.Errorf("queued = %+v; want one email with the new post", queued)
+	}
+}
+
+func TestCollectPageViewHonorsDoNotTrack(t *testing.T) {
+	h, db := newTestHandler(t)
+	h.cfg.Analytics.Mode = models.AnalyticsOn
+	h.cfg.Analytics.CountryHeader = "CF-IPCountry"
+	post := testdb.Post(t, db)
+	view := h.CollectPageView(h.PostView)
+	browser := "Mozilla/5.0 (X11; Linux x86_64) Firefox/130.0"
+
+	visits := []map[string]string{
+		{"User-Agent": browser, "Referer": "https://www.news.example/story?id=4", "CF-IPCountry": "de"},
+		{"User-Agent": browser, "DNT": "1"},
+		{"User-Agent": browser, "Sec-GPC": "1"},
+		{"User-Agent": "Googlebot/2.1"},
+	}
+	for _, header := range visits {
+		rec := serve(view, testRequest{method: http.MethodGet, target: "/posts/" + post.Slug, params: map[string]string{"slug": post.Slug}, header: header})
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d; want 200", rec.Code)
+		}
+	}
+
+	var views []models.PageView
+	db.Find(&views)
+	if len(views) != 1 {
+		t.Fatalf("recorded %d view(s); want only the first visit", len(views))
+	}
+	if got := views[0]; got.Path != "/posts/"+post.Slug || got.Referrer != "news.example" || got.Country != "DE" {
+		t.Errorf("view = %+v; want the path, the referring host and DE", got)
+	}
+}
+
+func TestPostViewLinkPreviewTags(t *testing.T) {
+	h, db := newTestHandler(t)
+	h.cfg.Server.BaseURL = "https://blog.example/"
+	post := testdb.Post(t, db, func(p *models.Post) { p.OGImage = "/uploads/cover.jpg" })
+	premium := testdb.Post(t, db, func(p *models.Post) { p.Visibility = models.VisibilityPremium })
+	premiumUser := testdb.User(t, db, func(u *models.User) { u.Role = models.RolePremium })
+
+	body := serve(h.PostView, testRequest{method: http.MethodGet, target: "/posts/" + post.Slug, params: map[string]string{"slug": post.Slug}}).Body.String()
+	for _, tag := range []string{
+		`<meta property="og:title" content="` + post.Title + `">`,
+		`<meta property="og:description" content="Some markdown content.">`,
+		`<meta property="og:image" content="https://blog.example/uploads/cover.jpg">`,
+		`<meta name="twitter:card" content="summary_large_image">`,
+	} {
+		if !strings.Contains(body, tag) {
+			t.Errorf("post page is missing %s", tag)
+		}
+	}
+
+	body = serve(h.PostView, testRequest{method: http.MethodGet, target: "/posts/" + premium.Slug, params: map[string]string{"slug": premium.Slug}, user: premiumUser}).Body.String()
+	if strings.Contains(body, "og:description") || !strings.Contains(body, `<meta name="twitter:card" content="summary">`) {
+		t.Error("premium post preview should be a plain summary without the post's text")
+	}
+}
+
+func TestJWTRefreshRotatesAndRevokeSticks(t *testing.T) {
+	h, db := newTestHandler(t)
+	h.cfg.JWT.Secret = "test-jwt-secret"
+	h.cfg.JWT.AccessTTL, h.cfg.JWT.RefreshTTL = time.Minute, time.Hour
+	hash, _ := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	user := testdb.User(t, db, func(u *models.User) { u.Password = string(hash) })
+
+	issue := func(handler echo.HandlerFunc, form url.Values) tokenPair {
+		t.Helper()
+		rec := serve(handler, testRequest{method: http.MethodPost, target: "/api/auth/token", form: form})
+		var pair tokenPair
+		if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &pair) != nil {
+			t.Fatalf("status = %d (%s); want a token pair", rec.Code, rec.Body.String())
+		}
+		return pair
+	}
+	whoami := func(access string) int {
+		handler := h.JWTAuth(func(c echo.Context) error {
+			if current := h.GetCurrentUser(c); current == nil || current.ID != user.ID {
+				return echo.NewHTTPError(http.StatusUnauthorized)
+			}
+			return c.NoContent(http.StatusOK)
+		})
+		return serve(handler, testRequest{method: http.MethodGet, target: "/api/palette", header: map[string]string{"Authorization": "Bearer " + access}}).Code
+	}
+
+	if rec := serve(h.APIAuthToken, testRequest{method: http.MethodPost, target: "/api/auth/token", form: url.Values{"email": {user.Email}, "password": {"wrong"}}}); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("wrong password status = %d; want 401", rec.Code)
+	}
+	first := issue(h.APIAuthToken, url.Values{"email": {user.Email}, "password": {"correct horse"}})
+	if code := whoami(first.AccessToken); code != http.StatusOK {
+		t.Fatalf("access token status = %d; want 200", code)
+	}
+	if code := whoami(first.RefreshToken); code != http.StatusUnauthorized {
+		t.Errorf("refresh token used for a call: status = %d; want 401", code)
+	}
+
+	second := issue(h.APIAuthRefresh, url.Values{"refresh_token": {first.RefreshToken}})
+	if rec := serve(h.APIAuthRefresh, testRequest{method: http.MethodPost, target: "/api/auth/refresh", form: url.Values{"refresh_token": {first.RefreshToken}}}); rec.Code != http.StatusUnauthorized {
+		t.Errorf("reused refresh token status = %d; want 401", rec.Code)
+	}
+
+	revoke := testRequest{method: http.MethodPost, target: "/api/auth/revoke", form: url.Values{"refresh_token": {second.RefreshToken}}, header: map[string]string{"Authorization": "Bearer " + second.AccessToken}}
+	if rec := serve(h.APIAuthRevoke, revoke); rec.Code != http.StatusNoContent {
+		t.Fatalf("revoke status = %d; want 204", rec.Code)
+	}
+	if code := whoami(second.AccessToken); code != http.StatusUnauthorized {
+		t.Errorf("revoked access token status = %d; want 401", code)
+	}
+	if rec := serve(h.APIAuthRefresh, testRequest{method: http.MethodPost, target: "/api/auth/refresh", form: url.Values{"refresh_token": {second.RefreshToken}}}); rec.Code != http.StatusUnauthorized {
+		t.Errorf("revoked refresh token status = %d; want 401", rec.Code)
+	}
+}
+
+func TestAPITokensOnlySignInToTheAPI(t *testing.T) {
+	h, db := newTestHandler(t)
+	admin := testdb.Admin(t, db)
+	db.Create(&models.APIToken{UserID: admin.ID, Name: "cli", TokenHash: hashAPIToken("mb_secret")})
+
+	whoami := func(target string) int {
+		handler := func(c echo.Context) error {
+			if current := h.GetCurrentUser(c); current == nil || current.ID != admin.ID {
+				return echo.NewHTTPError(http.StatusUnauthorized)
+			}
+			return c.NoContent(http.StatusOK)
+		}
+		return serve(handler, testRequest{method: http.MethodGet, target: target, header: map[string]string{"Authorization": "Bearer mb_secret"}}).Code
+	}
+	if code := whoami("/api/tv/library"); code != http.StatusOK {
+		t.Fatalf("API call status = %d; want 200", code)
+	}
+	if code := whoami("/admin/users"); code != http.StatusUnauthorized {
+		t.Errorf("admin page with an API token status = %d; want 401", code)
+	}
+
+	revoked := time.Now().Add(time.Second)
+	db.Model(admin).Update("sessions_revoked_at", &revoked)
+	if code := whoami("/api/tv/library"); code != http.StatusUnauthorized {
+		t.Errorf("token made before signing out everywhere status = %d; want 401", code)
+	}
+}
+
+func TestReportsHideCommentUntilDismissed(t *testing.T) {
+	h, db := newTestHandler(t)
+	h.cfg.Moderation.ReportThreshold = 2
+	post := testdb.Post(t, db)
+	author := testdb.User(t, db)
+	admin := testdb.Admin(t, db)
+	comment := models.Comment{PostID: post.ID, UserID: author.ID, Body: "Buy cheap pills"}
+	if err := db.Create(&comment).Error; err != nil {
+		t.Fatal(err)
+	}
+	params := map[string]string{"slug": post.Slug, "id": fmt.Sprint(comment.ID)}
+	report := func(user *models.User) int {
+		return serve(h.CommentReport, testRequest{method: http.MethodPost, target: "/posts/" + post.Slug + "/comments/1/report", params: params, form: url.Values{"reason": {models.ReportSpam}}, user: user}).Code
+	}
+	hidden := func() bool {
+		var current models.Comment
+		db.First(&current, comment.ID)
+		return current.Hidden
+	}
+
+	if code := report(author); code != http.StatusBadRequest {
+		t.Errorf("reporting own comment status = %d; want 400", code)
+	}
+	first := testdb.User(t, db)
+	report(first)
+	report(first)
+	if hidden() {
+		t.Fatal("one reporter reporting twice should not hide the comment")
+	}
+	if code := report(testdb.User(t, db)); code != http.StatusOK || !hidden() {
+		t.Fatalf("second reporter status = %d, hidden = %v; want 200 and hidden", code, hidden())
+	}
+	comments := serve(h.PostComments, testRequest{method: http.MethodGet, target: "/posts/" + post.Slug + "/comments", params: map[string]string{"slug": post.Slug}, user: first}).Body.String()
+	if strings.Contains(comments, "Buy cheap pills") {
+		t.Error("hidden comment is still shown to readers")
+	}
+
+	var open models.Report
+	db.Where("comment_id = ?", comment.ID).First(&open)
+	if rec := serve(h.AdminReportDismiss, testRequest{method: http.MethodPost, target: "/admin/reports/1/dismiss", params: map[string]string{"id": fmt.Sprint(open.ID)}, user: admin, htmx: true}); rec.Code != http.StatusOK {
+		t.Fatalf("dismiss status = %d; want 200", rec.Code)
+	}
+	var stillOpen int64
+	db.Model(&models.Report{}).Where("status = ?", models.ReportOpen).Count(&stillOpen)
+	if hidden() || stillOpen != 0 {
+		t.Errorf("after dismiss hidden = %v, open reports = %d; want shown with none open", hidden(), stillOpen)
+	}
+}
+
+// importBundle posts a zip of files to the markdown import as admin and returns the result panel
+func importBundle(t *testing.T, h *BaseHandler, admin *models.User, files map[string]string, publish bool) string {
+	t.Helper()
+	var buf bytes.Buffer
+	bundle := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, _ := bundle.Create(name)
+		w.Write([]byte(content))
+	}
+	bundle.Close()
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, _ := form.CreateFormFile("bundle", "posts.zip")
+	part.Write(buf.Bytes())
+	if publish {
+		form.WriteField("publish", "1")
+	}
+	form.Close()
+	r := httptest.NewRequest(http.MethodPost, "/admin/backup/markdown", &body)
+	r.Header.Set(echo.HeaderContentType, form.FormDataContentType())
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(r, rec)
+	c.Set("user", admin)
+	if err := h.AdminMarkdownImport(c); err != nil {
+		t.Fatal(err)
+	}
+	return rec.Body.String()
+}
+
+func TestMarkdownImportCreatesAndUpdatesBySlug(t *testing.T) {
+	h, db := newTestHandler(t)
+	admin := testdb.Admin(t, db)
+	existing := testdb.Post(t, db, func(p *models.Post) { p.Slug = "hello-world" })
+
+	out := importBundle(t, h, admin, map[string]string{
+		"_posts/2021-03-04-first-steps.md": "---\ntitle: First steps\ntags: [go, Blogging]\ncategories: [Notes]\n---\n\nIt begins.\n",
+		"content/hello-world/index.md":     "---\ntitle: Hello again\ndraft: true\n---\nRewritten.\n",
+		"content/broken.md":                "no front matter here",
+	}, true)
+	if !strings.Contains(out, "Created 1 and updated 1 posts.") || !strings.Contains(out, "content/broken.md: no front matter") {
+		t.Fatalf("unexpected result: %s", out)
+	}
+
+	var created models.Post
+	if err := db.Preload("Tags").Preload("Category").Where("slug = ?", "first-steps").First(&created).Error; err != nil {
+		t.Fatalf("Jekyll post not created: %v", err)
+	}
+	if !created.Published || created.CreatedAt.Format("2006-01-02") != "2021-03-04" || len(created.Tags) != 2 || created.Category == nil || created.Category.Name != "Notes" {
+		t.Errorf("created post = published %v, date %s, %d tags, category %v", created.Published, created.CreatedAt, len(created.Tags), created.Category)
+	}
+
+	var updated models.Post
+	db.First(&updated, existing.ID)
+	if updated.Title != "Hello again" || updated.Content != "Rewritten." || updated.Status != models.PostStatusDraft || updated.Published {
+		t.Errorf("updated post = %q %q status %s published %v", updated.Title, updated.Content, updated.Status, updated.Published)
+	}
+}
+
+func TestMarkdownImportKeepsStatusAndVisibilityItDoesNotSet(t *testing.T) {
+	h, db := newTestHandler(t)
+	admin := testdb.Admin(t, db)
+	existing := testdb.Post(t, db, func(p *models.Post) { p.Slug = "members-only"; p.Visibility = models.VisibilityPremium })
+
+	importBundle(t, h, admin, map[string]string{
+		"members-only.md": "---\ntitle: Still for members\n---\nEdited.\n",
+		"brand-new.md":    "---\ntitle: Brand new\n---\nFresh.\n",
+	}, false)
+
+	var updated models.Post
+	db.First(&updated, existing.ID)
+	if updated.Content != "Edited." || updated.Visibility != models.VisibilityPremium || !updated.Published {
+		t.Errorf("updated post = %q, visibility %s, published %v; want the edit with its visibility and status kept", updated.Content, updated.Visibility, updated.Published)
+	}
+	var created models.Post
+	db.Where("slug = ?", "brand-new").First(&created)
+	if created.Status != models.PostStatusDraft || created.Published {
+		t.Errorf("new post = status %s, published %v; want a draft", created.Status, created.Published)
+	}
+}
+
+func TestBanSignsOutAndHidesCommentsUntilUnbanned(t *testing.T) {
+	h, db := newTestHandler(t)
+	admin := testdb.Admin(t, db)
+	reader := testdb.User(t, db)
+	post := testdb.Post(t, db)
+	if err := db.Create(&models.Comment{PostID: post.ID, UserID: reader.ID, Body: "Totally normal comment"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	params := map[string]string{"id": fmt.Sprint(reader.ID)}
+	comments := func(user *models.User) string {
+		return serve(h.PostComments, testRequest{method: http.MethodGet, target: "/posts/" + post.Slug + "/comments", params: map[string]string{"slug": post.Slug}, user: user}).Body.String()
+	}
+
+	if rec := serve(h.AdminUserBan, testRequest{method: http.MethodPost, target: "/admin/users/1/ban", params: params, form: url.Values{"reason": {"spam"}}, user: admin}); rec.Code != http.StatusOK {
+		t.Fatalf("ban status = %d; want 200", rec.Code)
+	}
+
+	// The reader's existing session cookie no longer signs them in
+	session := serve(func(c echo.Context) error { return h.setUserSession(c, reader.ID) }, testRequest{method: http.MethodGet, target: "/"})
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	for _, cookie := range session.Result().Cookies() {
+		r.AddCookie(cookie)
+	}
+	if user := h.GetCurrentUser(echo.New().NewContext(r, httptest.NewRecorder())); user != nil {
+		t.Error("banned user is still signed in")
+	}
+	if strings.Contains(comments(nil), "Totally normal comment") {
+		t.Error("banned user's comment is still shown to visitors")
+	}
+	if out := comments(admin); !strings.Contains(out, "Totally normal comment") || !strings.Contains(out, "Author banned") {
+		t.Error("admins should still see the banned user's comment, marked")
+	}
+
+	if rec := serve(h.AdminUserUnban, testRequest{method: http.MethodPost, target: "/admin/users/1/unban", params: params, user: admin}); rec.Code != http.StatusOK {
+		t.Fatalf("unban status = %d; want 200", rec.Code)
+	}
+	if !strings.Contains(comments(nil), "Totally normal comment") {
+		t.Error("comment should be back after unbanning")
+	}
+}
+
+func TestPostViewsCountOncePerSession(t *testing.T) {
+	h, db := newTestHandler(t)
+	h.cfg.Analytics.Mode = models.AnalyticsOn
+	post := testdb.Post(t, db)
+	browser := map[string]string{"User-Agent": "Mozilla/5.0 (X11; Linux x86_64) Firefox/128.0"}
+	view := testRequest{method: http.MethodGet, target: "/posts/" + post.Slug, params: map[string]string{"slug": post.Slug}, header: browser}
+
+	first := serve(h.PostView, view)
+	cookies := first.Result().Cookies()
+	if len(cookies) == 0 {
+		t.Fatal("first view set no session cookie")
+	}
+	// The same browser session again, then a new visitor, then an admin
+	again := httptest.NewRequest(http.MethodGet, view.target, nil)
+	again.Header.Set("User-Agent", browser["User-Agent"])
+	for _, cookie := range cookies {
+		again.AddCookie(cookie)
+	}
+	c := echo.New().NewContext(again, httptest.NewRecorder())
+	c.SetParamNames("slug")
+	c.SetParamValues(post.Slug)
+	if err := h.PostView(c); err != nil {
+		t.Fatal(err)
+	}
+	serve(h.PostView, view)
+	view.user = testdb.Admin(t, db)
+	serve(h.PostView, view)
+
+	admin := testdb.Admin(t, db)
+	rec := serve(h.PostViewsAPI, testRequest{method: http.MethodGet, target: "/api/posts/1/views?days=7", params: map[string]string{"id": fmt.Sprint(post.ID)}, user: admin})
+	var body struct {
+		Total int64 `json:"total"`
+		Daily []struct {
+			Views int64 `json:"views"`
+		} `json:"daily"`
+	}
+	if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &body) != nil {
+		t.Fatalf("status = %d (%s)", rec.Code, rec.Body.String())
+	}
+	if body.Total != 2 || len(body.Daily) != 7 || body.Daily[6].Views != 2 {
+		t.Errorf("total = %d over %d days, today %v; want 2 views today over 7 days", body.Total, len(body.Daily), body.Daily)
+	}
+}
+
+func TestResendBounceSuppressesQueuedEmail(t *testing.T) {
+	h, db := newTestHandler(t)
+	secret := []byte("resend-webhook-test-secret")
+	h.cfg.Auth.ResendWebhookSecret = "whsec_" + base64.StdEncoding.EncodeToString(secret)
+	deliver := func(payload string, signed bool) int {
+		id, timestamp := "msg_1", fmt.Sprint(time.Now().Unix())
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(id + "." + timestamp + "." + payload))
+		signature := "v1," + base64.StdEncoding.EncodeToString(mac.Sum(nil))
+		if !signed {
+			signature = "v1,bm90IGEgc2lnbmF0dXJl"
+		}
+		r := httptest.NewRequest(http.MethodPost, "/webhooks/resend", strings.NewReader(payload))
+		r.Header.Set("svix-id", id)
+		r.Header.Set("svix-timestamp", timestamp)
+		r.Header.Set("svix-signature", signature)
+		rec := httptest.NewRecorder()
+		if err := h.ResendWebhook(echo.New().NewContext(r, rec)); err != nil {
+			if he, ok := err.(*echo.HTTPError); ok {
+				return he.Code
+			}
+			t.Fatal(err)
+		}
+		return rec.Code
+	}
+
+	if code := deliver(`{"type":"email.bounced","data":{"to":["Gone@example.com"],"bounce":{"type":"Permanent"}}}`, false); code != http.StatusBadRequest {
+		t.Errorf("unsigned webhook status = %d; want 400", code)
+	}
+	deliver(`{"type":"email.bounced","data":{"to":["full@example.com"],"bounce":{"type":"Transient"}}}`, true)
+	if code := deliver(`{"type":"email.bounced","data":{"to":["Gone@example.com"],"bounce":{"type":"Permanent","message":"No such user"}}}`, true); code != http.StatusOK {
+		t.Fatalf("bounce webhook status = %d; want 200", code)
+	}
+
+	for _, to := range []string{"gone@example.com", "full@example.com"} {
+		if err := h.enqueueEmail(models.EmailKindTransactional, to, "Hello", "<p>Hi</p>", time.Now(), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h.ProcessEmailQueue()
+	statuses := map[string]string{}
+	var jobs []models.EmailJob
+	db.Find(&jobs)
+	for _, job := range jobs {
+		statuses[job.To] = job.Status
+	}
+	if statuses["gone@example.com"] != models.EmailJobSkipped || statuses["full@example.com"] != models.EmailJobSent {
+		t.Errorf("job statuses = %v; want the hard bounce skipped and the soft bounce sent", statuses)
+	}
+}
+
+func TestEmbedShortcodesExpandOnlyOnTheirOwnLine(t *testing.T) {
+	h, db := newTestHandler(t)
+	post := testdb.Post(t, db, func(p *models.Post) {
+		p.Content = "{{youtube https://youtu.be/dQw4w9WgXcQ}}\n\n```\n{{youtube aaaaaaaaaaa}}\n```\n\nSee {{youtube bbbbbbbbbbb}} inline."
+	})
+
+	body := serve(h.PostView, testRequest{method: http.MethodGet, target: "/posts/" + post.Slug, params: map[string]string{"slug": post.Slug}}).Body.String()
+	if !strings.Contains(body, `src="https://www.youtube-nocookie.com/embed/dQw4w9WgXcQ"`) {
+		t.Error("standalone shortcode was not expanded into a player")
+	}
+	if strings.Contains(body, "embed/aaaaaaaaaaa") || strings.Contains(body, "embed/bbbbbbbbbbb") {
+		t.Error("shortcodes in a code block or running text were expanded")
+	}
+}
+
+func TestPostMarkdownExtras(t *testing.T) {
+	h, db := newTestHandler(t)
+	post := testdb.Post(t, db, func(p *models.Post) {
+		p.Content = "Claim[^1].\n\n- [x] done\n\n| a | b |\n|---|---|\n| 1 | 2 |\n\nTerm\n: Meaning\n\n[^1]: The source."
+	})
+
+	body := serve(h.PostView, testRequest{method: http.MethodGet, target: "/posts/" + post.Slug, params: map[string]string{"slug": post.Slug}}).Body.String()
+	for _, want := range []string{
+		`<sup class="footnote-ref" id="fnref:1"><a href="#fn:1">1</a></sup>`,
+		`<li id="fn:1">The source.`,
+		`<li class="task-list-item"><input type="checkbox" disabled checked> done`,
+		`<div class="table-wrap"><table>`,
+		`<dt>Term</dt>`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("post page is missing %s", want)
+		}
+	}
+}
+
+func TestOnlyReviewFrontmatterIsHidden(t *testing.T) {
+	draft := reviewDraft(models.Media{Title: "Heat", TMDBID: 949, Type: models.MediaTypeMovie})
+	if out := string(services.MarkdownToHTML(draft)); strings.Contains(out, "tmdb_id") || !strings.Contains(out, "My take") {
+		t.Errorf("review draft = %q; want its metadata hidden", out)
+	}
+
+	post := "---\n\nOpening line\n\n---\n\nClosing line"
+	if out := string(services.MarkdownToHTML(post)); !strings.Contains(out, "Opening line") {
+		t.Errorf("post between rules = %q; want its text kept", out)
+	}
+}
+
+func TestSignedOutLikesAreLimitedPerAddress(t *testing.T) {
+	h, db := newTestHandler(t)
+	post := testdb.Post(t, db)
+	like := testRequest{method: http.MethodPost, target: "/posts/" + post.Slug + "/like", params: map[string]string{"slug": post.Slug}, htmx: true}
+
+	// Every request comes without the visitor cookie, as a script dropping it would
+	for i := range anonLikesPerIP {
+		if rec := serve(h.PostLikeToggle, like); rec.Code != http.StatusOK {
+			t.Fatalf("like %d = %d %q", i+1, rec.Code, rec.Body.String())
+		}
+	}
+	if rec := serve(h.PostLikeToggle, like); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("like over the limit = %d; want 429", rec.Code)
+	}
+
+	like.user = testdb.User(t, db)
+	if rec := serve(h.PostLikeToggle, like); rec.Code != http.StatusOK {
+		t.Errorf("signed-in like = %d; want it unaffected", rec.Code)
+	}
+}
+
+func TestFuzzyScoreMatchesLongTitles(t *testing.T) {
+	long := "Heat " + strings.Repeat("and more ", 200)
+	if score := services.FuzzyScore("heat", long); score < 0 {
+		t.Errorf("prefix of a long title scored %d; want a match", score)
+	}
+	if score := services.FuzzyScore("more", long); score < 0 {
+		t.Errorf("substring of a long title scored %d; want a match", score)
+	}
+	if score := services.FuzzyScore("xyz", long); score != -1 {
+		t.Errorf("missing query scored %d; want -1", score)
+	}
+}
+
+func TestEpisodeExportDefusesFormulas(t *testing.T) {
+	h, db := newTestHandler(t)
+	show := testdb.Show(t, db, 1, 2, func(m *models.Media) { m.Title = "=HYPERLINK(\"http://evil.example\")" })
+	db.Model(&models.Episode{}).Where("tmdb_id = ? AND episode_number = 1", show.TMDBID).Update("name", "@SUM(A1)")
+
+	rec := serve(h.MediaExportCSV, testRequest{method: http.MethodGet, target: "/tv/export.csv", params: map[string]string{"tmdbId": fmt.Sprint(show.TMDBID)}})
+	rows, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil || len(rows) != 3 {
+		t.Fatalf("export = %v, %v", rows, err)
+	}
+	if rows[1][0] != "'"+show.Title || rows[1][3] != "'@SUM(A1)" {
+		t.Errorf("first episode row = %q; want the formula-like cells prefixed", rows[1])
+	}
+	if rows[2][1] != "1" || strings.HasPrefix(rows[2][3], "'") {
+		t.Errorf("second episode row = %q; want plain cells untouched", rows[2])
+	}
+}
+
+func TestSpoilersAreParsedAsMarkdown(t *testing.T) {
+	const box = `<div class="spoiler" tabindex="0" title="Spoiler: click to reveal">`
+	const span = `<span class="spoiler" tabindex="0" title="Spoiler: click to reveal">`
+	for _, render := range []func(string) template.HTML{services.MarkdownToHTML, services.UserMarkdownToHTML} {
+		out := string(render("[spoiler]\nHe dies.\n\nThe end.\n[/spoiler]\n\nIt was [spoiler]*him*[/spoiler] all along."))
+		if !strings.Contains(out, box+"<p>He dies.</p>\n\n<p>The end.</p>\n</div>") {
+			t.Errorf("block spoiler = %q", out)
+		}
+		if !strings.Contains(out, "It was "+span+"<em>him</em></span> all along.") {
+			t.Errorf("inline spoiler = %q", out)
+		}
+
+		out = string(render("```\n[spoiler]\nliteral\n[/spoiler]\n```\n\nUse `[spoiler]x[/spoiler]` to hide text."))
+		if strings.Contains(out, "class=\"spoiler\"") || !strings.Contains(out, "<code>[spoiler]x[/spoiler]</code>") {
+			t.Errorf("spoiler inside code was rewritten: %q", out)
+		}
+
+		out = string(render("Starts [spoiler]here\n\nand ends[/spoiler] here."))
+		if strings.Contains(out, "class=\"spoiler\"") || strings.Count(out, "<p>") != strings.Count(out, "</p>") {
+			t.Errorf("spoiler across paragraphs = %q; want it left as text", out)
+		}
+
+		out = string(render("See [the docs](https://example.com) and [spoiler]this[/spoiler]."))
+		if !strings.Contains(out, `href="https://example.com"`) || !strings.Contains(out, span+"this</span>") {
+			t.Errorf("links next to a spoiler = %q", out)
+		}
+	}
+}
+
+func TestStaleNarrationIsNotServed(t *testing.T) {
+	h, db := newTestHandler(t)
+	admin := testdb.Admin(t, db)
+	post := testdb.Post(t, db)
+	_, hash := narrationSource(*post)
+	db.Create(&models.PostNarration{PostID: post.ID, Status: models.NarrationReady, URL: "/uploads/narration.mp3", SourceHash: hash})
+
+	view := testRequest{method: http.MethodGet, target: "/posts/" + post.Slug, params: map[string]string{"slug": post.Slug}}
+	if body := serve(h.PostView, view).Body.String(); !strings.Contains(body, "/uploads/narration.mp3") {
+		t.Fatal("post page is missing its narration")
+	}
+
+	db.Model(post).Update("content", "Rewritten since the narration was made.")
+	if body := serve(h.PostView, view).Body.String(); strings.Contains(body, "/uploads/narration.mp3") {
+		t.Error("post page plays narration of an earlier version")
+	}
+	if body := serve(h.PodcastFeed, testRequest{method: http.MethodGet, target: "/podcast.xml"}).Body.String(); strings.Contains(body, "narration.mp3") {
+		t.Error("podcast feed carries stale narration")
+	}
+	panel := serve(h.AdminPostNarration, testRequest{method: http.MethodGet, target: "/admin/posts/1/narration", params: map[string]string{"id": fmt.Sprint(post.ID)}, user: admin, htmx: true})
+	if !strings.Contains(panel.Body.String(), "Out of date") {
+		t.Errorf("narration panel = %q; want it marked out of date", panel.Body.String())
+	}
+}
+
+func TestPinnedPostsLeadTheHomepage(t *testing.T) {
+	h, db := newTestHandler(t)
+	admin := testdb.Admin(t, db)
+	older := testdb.Post(t, db, func(p *models.Post) { p.Title = "Older but pinned" })
+	testdb.Post(t, db, func(p *models.Post) { p.Title = "Newest" })
+
+	rec := serve(h.AdminPostTogglePin, testRequest{method: http.MethodPost, target: "/admin/posts/1/pin", params: map[string]string{"id": fmt.Sprint(older.ID)}, user: admin, htmx: true})
+	if !strings.Contains(rec.Body.String(), "Unpin") {
+		t.Fatalf("pin button = %q; want Unpin", rec.Body.String())
+	}
+
+	body := serve(h.Home, testRequest{method: http.MethodGet, target: "/"}).Body.String()
+	pinned, newest := strings.Index(body, "Older but pinned"), strings.Index(body, "Newest")
+	if pinned < 0 || newest < 0 || pinned > newest {
+		t.Errorf("pinned post at %d, newest at %d; want the pinned post first", pinned, newest)
+	}
+	if strings.Count(body, "Older but pinned") != 1 {
+		t.Error("pinned post is repeated in the latest posts")
+	}
+}
+
+func TestExcerptAndCoverReplaceTruncatedMarkdown(t *testing.T) {
+	h, db := newTestHandler(t)
+	testdb.Post(t, db, func(p *models.Post) {
+		p.Content = "The opening paragraph nobody should see in a card."
+		p.Excerpt = "A hand-written summary."
+		p.CoverImage = "/uploads/cover.jpg"
+	})
+
+	list := serve(h.Posts, testRequest{method: http.MethodGet, target: "/posts"}).Body.String()
+	if !strings.Contains(list, "A hand-written summary.") || strings.Contains(list, "opening paragraph") {
+		t.Error("post card does not use the excerpt")
+	}
+	if !strings.Contains(list, `src="/uploads/cover.jpg"`) {
+		t.Error("post card has no cover image")
+	}
+
+	feed := serve(h.RSSFeed, testRequest{method: http.MethodGet, target: "/feed.xml"}).Body.String()
+	if !strings.Contains(feed, "<description>A hand-written summary.</description>") {
+		t.Error("RSS description does not use the excerpt")
+	}
+	if !strings.Contains(feed, "&lt;img src=&#34;http://example.com/uploads/cover.jpg&#34;") {
+		t.Errorf("RSS content does not start with the absolute cover image:\n%s", feed)
+	}
+}
+
+func TestMermaidBlocksRenderOnceAsSVG(t *testing.T) {
+	h, db := newTestHandler(t)
+	renders := 0
+	kroki := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		renders++
+		if r.URL.Path != "/mermaid/svg" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`<svg xmlns="http://www.w3.org/2000/svg"></svg>`))
+	}))
+	defer kroki.Close()
+	services.SetDiagramServer(kroki.URL)
+	t.Cleanup(func() { services.SetDiagramServer("") })
+
+	post := testdb.Post(t, db, func(p *models.Post) {
+		p.Content = "```mermaid\ngraph TD; A-->B\n```\n\n```plantuml\n@startuml\n@enduml\n```"
+	})
+	view := testRequest{method: http.MethodGet, target: "/posts/" + post.Slug, params: map[string]string{"slug": post.Slug}}
+	serve(h.PostView, view)
+	body := serve(h.PostView, view).Body.String()
+
+	svg := base64.StdEncoding.EncodeToString([]byte(`<svg xmlns="http://www.w3.org/2000/svg"></svg>`))
+	if !strings.Contains(body, `<img src="data:image/svg+xml;base64,`+svg+`" alt="mermaid diagram"`) {
+		t.Error("mermaid block was not rendered as an SVG image")
+	}
+	if !strings.Contains(body, "@startuml") {
+		t.Error("plantuml block that failed to render is not left as code")
+	}
+	if renders != 2 {
+		t.Errorf("diagram server called %d times over two views; want each diagram rendered once", renders)
+	}
+}
+
+func TestSiteModeLeavesOutTheOtherHalf(t *testing.T) {
+	h, db := newTestHandler(t)
+	admin := testdb.Admin(t, db)
+	db.Create(&models.Media{TMDBID: 1396, Type: "tv", Title: "Breaking Bad", Status: models.StatusWatching})
+
+	h.cfg.Mode = config.ModeBlog
+	palette := serve(h.Palette, testRequest{method: http.MethodGet, target: "/api/palette", user: admin}).Body.String()
+	if strings.Contains(palette, `"/tv`) || strings.Contains(palette, "Breaking Bad") {
+		t.Error("blog-only palette still offers the tracker")
+	}
+	if sw := serve(h.ServiceWorker, testRequest{method: http.MethodGet, target: "/sw.js"}).Body.String(); strings.Contains(sw, `"/tv"`) {
+		t.Error("blog-only service worker caches /tv")
+	}
+	var offline struct {
+		Watchlist []OfflineMedia `json:"watchlist"`
+	}
+	json.Unmarshal(serve(h.OfflineSync, testRequest{method: http.MethodGet, target: "/api/offline"}).Body.Bytes(), &offline)
+	if len(offline.Watchlist) != 0 {
+		t.Error("blog-only offline sync returned the watchlist")
+	}
+
+	h.cfg.Mode = config.ModeTracker
+	sw := serve(h.ServiceWorker, testRequest{method: http.MethodGet, target: "/sw.js"}).Body.String()
+	if strings.Contains(sw, `"/posts"`) || !strings.Contains(sw, `const SHELL = ["/","/tv","/static/styles.css","/icon.svg"];`) {
+		t.Errorf("tracker-only service worker shell is wrong:\n%s", sw[:120])
+	}
+}
+
+func TestLandingServesTheChosenPage(t *testing.T) {
+	h, db := newTestHandler(t)
+	admin := testdb.Admin(t, db)
+
+	save := serve(h.AdminLandingUpdate, testRequest{method: http.MethodPost, target: "/admin/landing", user: admin, htmx: true,
+		form: url.Values{"kind": {models.LandingPage}, "title": {"Welcome"}, "content": {"Hello **there**"}}})
+	if !strings.Contains(save.Body.String(), "Landing page saved") {
+		t.Fatalf("custom landing page was not saved:\n%s", save.Body.String())
+	}
+	home := serve(h.Landing, testRequest{method: http.MethodGet, target: "/"}).Body.String()
+	if !strings.Contains(home, "<strong>there</strong>") {
+		t.Error("/ does not serve the custom page")
+	}
+
+	serve(h.AdminLandingUpdate, testRequest{method: http.MethodPost, target: "/admin/landing", user: admin, form: url.Values{"kind": {models.LandingTracker}}})
+	if rec := serve(h.Landing, testRequest{method: http.MethodGet, target: "/"}); rec.Header().Get("Location") != "/tv" {
+		t.Errorf("tracker landing answered %d to %q; want a redirect to /tv", rec.Code, rec.Header().Get("Location"))
+	}
+
+	// A tracker landing saved before the site went blog-only falls back to the blog home
+	h.cfg.Mode = config.ModeBlog
+	if rec := serve(h.Landing, testRequest{method: http.MethodGet, target: "/"}); rec.Code != http.StatusOK {
+		t.Errorf("blog-only landing answered %d; want the blog home", rec.Code)
+	}
+}
+
+func TestCommentRepliesNestToALimit(t *testing.T) {
+	h, db := newTestHandler(t)
+	reader := testdb.User(t, db)
+	post := testdb.Post(t, db)
+	params := map[string]string{"slug": post.Slug}
+
+	var parent string
+	var ids []uint
+	for i := 0; i <= commentMaxDepth; i++ {
+		serve(h.PostCommentCreate, testRequest{method: http.MethodPost, target: "/posts/" + post.Slug + "/comments", params: params, user: reader,
+			form: url.Values{"body": {fmt.Sprintf("level %d", i)}, "parent_id": {parent}}})
+		var comment models.Comment
+		db.Order("id desc").First(&comment)
+		ids = append(ids, comment.ID)
+		parent = fmt.Sprint(comment.ID)
+	}
+	var deepest models.Comment
+	db.First(&deepest, ids[commentMaxDepth])
+	if deepest.ParentID == nil || *deepest.ParentID != ids[commentMaxDepth-2] {
+		t.Errorf("reply past the depth limit hangs under %v; want it beside its parent under %d", deepest.ParentID, ids[commentMaxDepth-2])
+	}
+
+	body := serve(h.PostComments, testRequest{method: http.MethodGet, target: "/posts/" + post.Slug + "/comments", params: map[string]string{"slug": post.Slug}}).Body.String()
+	if !strings.Contains(body, "(5)") || !strings.Contains(body, "4 replies") {
+		t.Error("thread does not count every comment and every reply under the top one")
+	}
+
+	// Deleting a comment moves its replies up into its place
+	serve(h.PostCommentDelete, testRequest{method: http.MethodDelete, target: "/", params: map[string]string{"slug": post.Slug, "id": fmt.Sprint(ids[1])}, user: reader})
+	var orphan models.Comment
+	db.First(&orphan, ids[2])
+	if orphan.ParentID == nil || *orphan.ParentID != ids[0] {
+		t.Errorf("reply to a deleted comment hangs under %v; want %d", orphan.ParentID, ids[0])
+	}
+}
+
+func TestActivityLogListsOnlyYourOwnActivity(t *testing.T) {
+	h, db := newTestHandler(t)
+	reader, other := testdb.User(t, db), testdb.User(t, db)
+	post := testdb.Post(t, db)
+	params := map[string]string{"slug": post.Slug}
+
+	serve(func(c echo.Context) error { return h.setUserSession(c, reader.ID) }, testRequest{method: http.MethodGet, target: "/"})
+	for _, percent := range []string{"95", "100"} {
+		serve(h.ReadingProgressBeacon, testRequest{method: http.MethodPost, target: "/", params: params, user: reader, form: url.Values{"percent": {percent}}})
+	}
+	serve(h.PostCommentCreate, testRequest{method: http.MethodPost, target: "/", params: params, user: other, form: url.Values{"body": {"Not yours"}}})
+	for i := 0; i < activityPageSize; i++ {
+		serve(h.PostCommentCreate, testRequest{method: http.MethodPost, target: "/", params: params, user: reader, form: url.Values{"body": {"Mine"}}})
+	}
+
+	first := serve(h.SettingsActivity, testRequest{method: http.MethodGet, target: "/settings/activity", user: reader}).Body.String()
+	if strings.Count(first, "Commented on "+post.Title) != activityPageSize || !strings.Contains(first, "page=2") {
+		t.Error("first page is not the newest full page with a link to older activity")
+	}
+	older := serve(h.SettingsActivity, testRequest{method: http.MethodGet, target: "/settings/activity?page=2", user: reader}).Body.String()
+	if strings.Count(older, "Read "+post.Title) != 1 || !strings.Contains(older, "Signed in") || strings.Contains(older, "page=3") {
+		t.Error("older page is missing the one finished read or the sign-in")
+	}
+	if strings.Count(first+older, "Commented on") != activityPageSize {
+		t.Error("activity log shows another user's comment")
+	}
+}
+
+func TestPreviewLinksOpenDraftsUntilTheyExpire(t *testing.T) {
+	h, db := newTestHandler(t)
+	admin := testdb.Admin(t, db)
+	draft := testdb.Post(t, db, func(p *models.Post) {
+		p.Published, p.Status, p.Content = false, models.PostStatusDraft, "Secret **draft**"
+	})
+	view := func(token string) *httptest.ResponseRecorder {
+		return serve(h.PostView, testRequest{method: http.MethodGet, target: "/preview/" + token, params: map[string]string{"token": token}})
+	}
+
+	created := serve(h.AdminPostPreviewLink, testRequest{method: http.MethodPost, target: "/", params: map[string]string{"id": fmt.Sprint(draft.ID)}, user: admin, form: url.Values{"days": {"1"}}}).Body.String()
+	start := strings.Index(created, "/preview/")
+	if start < 0 {
+		t.Fatalf("no preview link in:\n%s", created)
+	}
+	token := created[start+len("/preview/"):]
+	token = token[:strings.Index(token, `"`)]
+
+	if rec := view(token); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "<strong>draft</strong>") {
+		t.Errorf("valid preview link answered %d without the draft", rec.Code)
+	}
+	if rec := serve(h.PostView, testRequest{method: http.MethodGet, target: "/posts/" + draft.Slug, params: map[string]string{"slug": draft.Slug}}); rec.Code != http.StatusNotFound {
+		t.Errorf("draft by slug answered %d; want 404", rec.Code)
+	}
+	tampered := strings.Replace(token, fmt.Sprint(draft.ID)+".", fmt.Sprint(draft.ID+1)+".", 1)
+	expired := h.previewToken(draft.ID, time.Now().Add(-time.Minute))
+	for _, bad := range []string{tampered, expired, "nonsense"} {
+		if rec := view(bad); rec.Code != http.StatusNotFound {
+			t.Errorf("preview token %q answered %d; want 404", bad, rec.Code)
+		}
+	}
+}
+
+func TestSignInsArePlacedByGeoIP(t *testing.T) {
+	lookups := 0
+	maxmind := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lookups++
+		if account, key, _ := r.BasicAuth(); account != "42" || key != "license" || r.URL.Path != "/geoip/v2.1/city/81.2.69.142" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"city": {"names": {"en": "London"}}, "country": {"iso_code": "GB", "names": {"en": "United Kingdom"}}}`))
+	}))
+	defer maxmind.Close()
+
+	h, db := newTestHandler(t)
+	h.cfg.GeoIP.AccountID, h.cfg.GeoIP.LicenseKey, h.cfg.GeoIP.URL = "42", "license", maxmind.URL
+	reader := testdb.User(t, db)
+	signIn := func(ip string) {
+		rec := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/login", nil)
+		r.RemoteAddr = ip + ":4321"
+		if err := h.setUserSession(echo.New().NewContext(r, rec), reader.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	signIn("81.2.69.142")
+	signIn("81.2.69.142")
+	signIn("192.168.1.5")
+
+	var titles []string
+	db.Model(&models.Activity{}).Where("user_id = ?", reader.ID).Order("id").Pluck("title", &titles)
+	want := []string{"Signed in from London, United Kingdom", "Signed in from London, United Kingdom", "Signed in"}
+	if strings.Join(titles, "|") != strings.Join(want, "|") {
+		t.Errorf("sign-ins logged as %q; want %q", titles, want)
+	}
+	if lookups != 1 {
+		t.Errorf("GeoIP called %d times; want the address looked up once and private ones never", lookups)
+	}
+}
+
+func TestResendingOTPsIsLimited(t *testing.T) {
+	h, db := newTestHandler(t)
+	expiry := time.Now().Add(10 * time.Minute)
+	user := testdb.User(t, db, func(u *models.User) { u.IsVerified, u.OTP, u.OTPExpiry = false, "123456", &expiry })
+	resend := func() string {
+		serve(h.ResendOTP, testRequest{method: http.MethodPost, target: "/resend-otp", form: url.Values{"email": {user.Email}}})
+		var reloaded models.User
+		db.First(&reloaded, user.ID)
+		return reloaded.OTP
+	}
+	verify := func(otp string) string {
+		return serve(h.VerifyOTP, testRequest{method: http.MethodPost, target: "/verify-otp", form: url.Values{"email": {user.Email}, "otp": {otp}}}).Body.String()
+	}
+
+	codes := map[string]bool{}
+	for i := 0; i < otpResendsPerAccount+2; i++ {
+		codes[resend()] = true
+	}
+	if len(codes) != otpResendsPerAccount {
+		t.Errorf("got %d distinct codes from %d resends; want %d", len(codes), otpResendsPerAccount+2, otpResendsPerAccount)
+	}
+
+	// Out of guesses for good: a new code doesn't help
+	db.Model(user).Update("otp_attempts", otpMaxTotalAttempts-1)
+	if body := verify("000000"); !strings.Contains(body, "Ask the site admin") {
+		t.Errorf("last wrong code says %q; want it pointed to the admin", body)
+	}
+	h.otpAccountLimiter = services.NewRateLimiter(otpResendsPerAccount, otpResendWindow)
+	if code := resend(); code != "" {
+		t.Error("a code was re-sent to an account out of guesses")
+	}
+	if body := verify("000000"); !strings.Contains(body, "Ask the site admin") {
+		t.Errorf("locked account says %q; want it pointed to the admin", body)
+	}
+}
+
+func TestWrongOTPsInvalidateTheCode(t *testing.T) {
+	h, db := newTestHandler(t)
+	expiry := time.Now().Add(10 * time.Minute)
+	user := testdb.User(t, db, func(u *models.User) { u.IsVerified, u.OTP, u.OTPExpiry = false, "123456", &expiry })
+	verify := func(otp string) string {
+		return serve(h.VerifyOTP, testRequest{method: http.MethodPost, target: "/verify-otp", form: url.Values{"email": {user.Email}, "otp": {otp}}}).Body.String()
+	}
+
+	for i := 0; i < otpMaxAttempts; i++ {
+		verify("000000")
+	}
+	if body := verify("123456"); !strings.Contains(body, "Invalid or expired") {
+		t.Error("the right code still verifies after too many wrong ones")
+	}
+	var failed int64
+	db.Model(&models.Activity{}).Where("user_id = ? AND event = ?", user.ID, models.ActivityOTPFailed).Count(&failed)
+	if failed != otpMaxAttempts {
+		t.Errorf("recorded %d failed attempts, want %d", failed, otpMaxAttempts)
+	}
+
+	serve(h.ResendOTP, testRequest{method: http.MethodPost, target: "/resend-otp", form: url.Values{"email": {user.Email}}})
+	var reloaded models.User
+	db.First(&reloaded, user.ID)
+	if reloaded.OTPAttempts != otpMaxAttempts || reloaded.OTP == "" {
+		t.Fatalf("attempts = %d after a resend; want a fresh code with the wrong ones still counted", reloaded.OTPAttempts)
+	}
+	if rec := serve(h.VerifyOTP, testRequest{method: http.MethodPost, target: "/verify-otp", form: url.Values{"email": {user.Email}, "otp": {reloaded.OTP}}}); rec.Header().Get("HX-Redirect") != "/" {
+		t.Errorf("the resent code does not verify: %s", rec.Body.String())
+	}
+}
+
+func TestAdminResetLinkSetsAPasswordOnceAndSignsOutOldSessions(t *testing.T) {
+	h, db := newTestHandler(t)
+	admin := testdb.Admin(t, db)
+	user := testdb.User(t, db, func(u *models.User) { u.IsVerified = false })
+	params := map[string]string{"id": fmt.Sprint(user.ID)}
+	signedIn := func(session *httptest.ResponseRecorder) bool {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		for _, cookie := range session.Result().Cookies() {
+			r.AddCookie(cookie)
+		}
+		return h.GetCurrentUser(echo.New().NewContext(r, httptest.NewRecorder())) != nil
+	}
+	old := serve(func(c echo.Context) error { return h.setUserSession(c, user.ID) }, testRequest{method: http.MethodGet, target: "/"})
+
+	notice := serve(h.AdminUserResetLink, testRequest{method: http.MethodPost, target: "/", params: params, user: admin}).Body.String()
+	start := strings.Index(notice, "/reset-password/")
+	if start < 0 {
+		t.Fatal("no reset link shown to the admin")
+	}
+	token := strings.SplitN(notice[start+len("/reset-password/"):], `"`, 2)[0]
+	reset := func() *httptest.ResponseRecorder {
+		return serve(h.ResetPassword, testRequest{method: http.MethodPost, target: "/", params: map[string]string{"token": token}, form: url.Values{"password": {"new-secret"}, "confirm_password": {"new-secret"}}})
+	}
+
+	rec := reset()
+	if rec.Header().Get("HX-Redirect") != "/" {
+		t.Fatalf("reset did not sign the user in: %s", rec.Body.String())
+	}
+	var reloaded models.User
+	db.First(&reloaded, user.ID)
+	if !reloaded.IsVerified || bcrypt.CompareHashAndPassword([]byte(reloaded.Password), []byte("new-secret")) != nil {
+		t.Error("reset did not set the password and verify the account")
+	}
+	if signedIn(old) || !signedIn(rec) {
+		t.Error("reset should end the old session and keep the new one")
+	}
+	if !strings.Contains(reset().Body.String(), "already used") {
+		t.Error("a reset link works twice")
+	}
+
+	h.cfg.JWT.Secret, h.cfg.JWT.AccessTTL, h.cfg.JWT.RefreshTTL = "test-jwt-secret", time.Minute, time.Hour
+	var pair tokenPair
+	json.Unmarshal(serve(h.APIAuthToken, testRequest{method: http.MethodPost, target: "/api/auth/token", form: url.Values{"email": {user.Email}, "password": {"new-secret"}}}).Body.Bytes(), &pair)
+	if pair.RefreshToken == "" {
+		t.Fatal("no JWT pair issued with the new password")
+	}
+
+	serve(h.AdminUserSignOut, testRequest{method: http.MethodPost, target: "/", params: params, user: admin})
+	if signedIn(rec) {
+		t.Error("signing out everywhere left a session signed in")
+	}
+	api := h.JWTAuth(func(c echo.Context) error { return c.NoContent(http.StatusOK) })
+	if code := serve(api, testRequest{method: http.MethodGet, target: "/api/palette", header: map[string]string{"Authorization": "Bearer " + pair.AccessToken}}).Code; code != http.StatusUnauthorized {
+		t.Errorf("access JWT after signing out everywhere: status = %d; want 401", code)
+	}
+	if code := serve(h.APIAuthRefresh, testRequest{method: http.MethodPost, target: "/api/auth/refresh", form: url.Values{"refresh_token": {pair.RefreshToken}}}).Code; code != http.StatusUnauthorized {
+		t.Errorf("refresh JWT after signing out everywhere: status = %d; want 401", code)
+	}
+}
+
+func TestDuplicatedPostKeepsItsMetadata(t *testing.T) {
+	h, db := newTestHandler(t)
+	admin := testdb.Admin(t, db)
+	category := models.Category{Name: "Notes", Slug: "notes"}
+	db.Create(&category)
+	source := testdb.Post(t, db, func(p *models.Post) {
+		p.CategoryID, p.Excerpt, p.CoverImage = &category.ID, "Short version", "/uploads/cover.png"
+		p.MetaDescription, p.OGImage, p.Pinned = "For search results", "/uploads/card.png", true
+	})
+	tag := models.Tag{Name: "Go", Slug: "go"}
+	db.Create(&tag)
+	db.Model(source).Association("Tags").Append(&tag)
+	db.Create(&models.PostMedia{PostID: source.ID, TMDBID: 603})
+
+	rec := serve(h.AdminPostDuplicate, testRequest{method: http.MethodPost, target: "/admin/posts/1/duplicate", params: map[string]string{"id": fmt.Sprint(source.ID)}, user: admin, htmx: true})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("duplicate status = %d", rec.Code)
+	}
+	var copied models.Post
+	if err := db.Preload("Tags").Where("slug = ?", source.Slug+"-copy").First(&copied).Error; err != nil {
+		t.Fatal(err)
+	}
+	if copied.CategoryID == nil || *copied.CategoryID != category.ID || copied.Excerpt != source.Excerpt || copied.CoverImage != source.CoverImage ||
+		copied.MetaDescription != source.MetaDescription || copied.OGImage != source.OGImage || copied.Pinned || copied.Status != models.PostStatusDraft {
+		t.Errorf("copy = %+v; want the source's metadata as an unpinned draft", copied)
+	}
+	if len(copied.Tags) != 1 || copied.Tags[0].ID != tag.ID {
+		t.Errorf("copy tags = %+v; want the source's tag", copied.Tags)
+	}
+	var links int64
+	if db.Model(&models.PostMedia{}).Where("post_id = ? AND tmdb_id = ?", copied.ID, 603).Count(&links); links != 1 {
+		t.Error("copy lost the linked media")
+	}
+}
+
+func TestExpiredPostsAreUnpublished(t *testing.T) {
+	h, db := newTestHandler(t)
+	expiresIn := func(d time.Duration) func(*models.Post) {
+		return func(p *models.Post) {
+			expiresAt := time.Now().Add(d)
+			p.Status, p.ExpiresAt = models.PostStatusPublished, &expiresAt
+		}
+	}
+	expired := testdb.Post(t, db, expiresIn(-time.Minute))
+	running := testdb.Post(t, db, expiresIn(time.Hour))
+
+	h.UnpublishExpiredPosts()
+	var reloaded models.Post
+	db.First(&reloaded, expired.ID)
+	if reloaded.Published || reloaded.Status != models.PostStatusDraft || reloaded.ExpiresAt == nil {
+		t.Errorf("expired post = published %v, status %q; want a draft that keeps its expiry", reloaded.Published, reloaded.Status)
+	}
+	var other models.Post
+	db.First(&other, running.ID)
+	if !other.Published {
+		t.Error("a post that hasn't expired yet was unpublished")
+	}
+}
+
+func TestSignupRolesApplyOnVerification(t *testing.T) {
+	h, db := newTestHandler(t)
+	admin := testdb.Admin(t, db)
+	form := url.Values{"default_role": {models.RolePremium}, "premium_domains": {"@Family.example\n"}, "admin_domains": {"staff.example, family.example"}}
+	if body := serve(h.AdminSignupRolesUpdate, testRequest{method: http.MethodPost, target: "/", form: form, user: admin}).Body.String(); !strings.Contains(body, "family.example is in both lists") {
+		t.Error("a domain in both lists was accepted")
+	}
+	form.Set("admin_domains", "staff.example")
+	serve(h.AdminSignupRolesUpdate, testRequest{method: http.MethodPost, target: "/", form: form, user: admin})
+
+	signUpAndVerify := func(email string) models.User {
+		serve(h.Signup, testRequest{method: http.MethodPost, target: "/signup", form: url.Values{"name": {"New"}, "email": {email}, "password": {"secret1"}, "confirm_password": {"secret1"}}})
+		var user models.User
+		db.Where("email = ?", email).First(&user)
+		if user.Role != models.RolePremium {
+			t.Errorf("%s signed up as %q; want the default premium", email, user.Role)
+		}
+		serve(h.VerifyOTP, testRequest{method: http.MethodPost, target: "/verify-otp", form: url.Values{"email": {email}, "otp": {user.OTP}}})
+		db.First(&user, user.ID)
+		return user
+	}
+
+	if user := signUpAndVerify("kid@family.example"); user.Role != models.RolePremium {
+		t.Errorf("family domain role = %q; want premium", user.Role)
+	}
+	if user := signUpAndVerify("ops@staff.example"); user.Role != models.RoleAdmin {
+		t.Errorf("staff domain role = %q; want admin", user.Role)
+	}
+	if user := signUpAndVerify("someone@elsewhere.example"); user.Role != models.RolePremium || !user.IsVerified {
+		t.Errorf("unlisted domain = %q verified %v; want the default role", user.Role, user.IsVerified)
+	}
+}
+
+func TestSoleAdminCanPublishTheirOwnPost(t *testing.T) {
+	h, db := newTestHandler(t)
+	admin := testdb.Admin(t, db)
+	post := testdb.Post(t, db, func(p *models.Post) {
+		p.Published, p.Status, p.AuthorID = false, models.PostStatusDraft, &admin.ID
+	})
+	transition := func(form url.Values) string {
+		return serve(h.AdminPostTransition, testRequest{method: http.MethodPost, target: "/", params: map[string]string{"id": fmt.Sprint(post.ID)}, form: form, user: admin}).Body.String()
+	}
+
+	transition(url.Values{"to": {models.PostStatusInReview}, "reviewer_id": {fmt.Sprint(admin.ID)}})
+	if body := transition(url.Values{"to": {models.PostStatusApproved}}); strings.Contains(body, "approve their own posts") {
+		t.Fatal("the only admin couldn't approve their own post")
+	}
+	transition(url.Values{"to": {models.PostStatusPublished}})
+	var reloaded models.Post
+	db.First(&reloaded, post.ID)
+	if !reloaded.Published {
+		t.Fatalf("status = %q; want the post published", reloaded.Status)
+	}
+
+	// Once there is a second admin, review goes back to being someone else's job
+	testdb.Admin(t, db)
+	db.Model(&models.Post{}).Where("id = ?", post.ID).Updates(map[string]interface{}{"status": models.PostStatusInReview, "published": false})
+	if body := transition(url.Values{"to": {models.PostStatusApproved}}); !strings.Contains(body, "approve their own posts") {
+		t.Error("an author approved their own post with another admin available")
+	}
+}
+
+func TestParallelOTPGuessesStillHitTheLimit(t *testing.T) {
+	h, db := newTestHandler(t)
+	expiry := time.Now().Add(10 * time.Minute)
+	user := testdb.User(t, db, func(u *models.User) { u.IsVerified, u.OTP, u.OTPExpiry = false, "123456", &expiry })
+	verify := func(otp string) *httptest.ResponseRecorder {
+		return serve(h.VerifyOTP, testRequest{method: http.MethodPost, target: "/verify-otp", form: url.Values{"email": {user.Email}, "otp": {otp}}})
+	}
+
+	// Hold each guess after it has read the account until every guess has, the worst interleaving
+	guesses := 2 * otpMaxAttempts
+	var reads atomic.Int32
+	allRead := make(chan struct{})
+	db.Callback().Query().After("gorm:query").Register("test:otp_barrier", func(tx *gorm.DB) {
+		if tx.Statement.Table != "users" {
+			return
+		}
+		if n := reads.Add(1); n == int32(guesses) {
+			close(allRead)
+		} else if n < int32(guesses) {
+			select {
+			case <-allRead:
+			case <-time.After(2 * time.Second):
+			}
+		}
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < guesses; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			verify(fmt.Sprintf("%06d", i))
+		}(i)
+	}
+	wg.Wait()
+
+	var reloaded models.User
+	db.First(&reloaded, user.ID)
+	if reloaded.OTPAttempts != otpMaxAttempts || reloaded.OTP != "" {
+		t.Errorf("attempts = %d, code kept = %v; want %d counted and the code invalidated", reloaded.OTPAttempts, reloaded.OTP != "", otpMaxAttempts)
+	}
+	if verify("123456").Header().Get("HX-Redirect") != "" {
+		t.Error("the right code verified after parallel guesses used up the attempts")
+	}
+}
+
+// fakeOIDC is an OpenID Connect provider whose token endpoint signs whatever claims the test sets
+type fakeOIDC struct {
+	*httptest.Server
+	claims map[string]interface{}
+}
+
+func newFakeOIDC(t *testing.T, clientID string) *fakeOIDC {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: jose.JSONWebKey{Key: key, KeyID: "test"}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := &fakeOIDC{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer": f.URL, "authorization_endpoint": f.URL + "/auth", "token_endpoint": f.URL + "/token",
+			"jwks_uri": f.URL + "/jwks", "id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{Key: &key.PublicKey, KeyID: "test", Algorithm: "RS256", Use: "sig"}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		claims := map[string]interface{}{"iss": f.URL, "aud": clientID, "iat": time.Now().Unix(), "exp": time.Now().Add(time.Hour).Unix()}
+		for name, value := range f.claims {
+			claims[name] = value
+		}
+		payload, _ := json.Marshal(claims)
+		signed, _ := signer.Sign(payload)
+		idToken, _ := signed.CompactSerialize()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "access", "token_type": "Bearer", "id_token": idToken})
+	})
+	f.Server = httptest.NewServer(mux)
+	t.Cleanup(f.Close)
+	return f
+}
+
+func TestSSOChecksStateAndNonceBeforeLinkingOrPromoting(t *testing.T) {
+	h, db := newTestHandler(t)
+	provider := newFakeOIDC(t, "mini-blog")
+	h.cfg.OIDC.Issuer, h.cfg.OIDC.ClientID = provider.URL, "mini-blog"
+	h.cfg.OIDC.RoleClaim, h.cfg.OIDC.AdminValues = "groups", []string{"admins"}
+	h.cfg.Auth.AdminEmail = "owner@example.com"
+
+	// signIn runs the login redirect and the callback; tamper adjusts the callback's state and the token's claims
+	signIn := func(claims map[string]interface{}, tamper func(state *string, claims map[string]interface{})) *httptest.ResponseRecorder {
+		login := serve(h.SSOLogin, testRequest{method: http.MethodGet, target: "/auth/oidc/login"})
+		location, err := url.Parse(login.Header().Get("Location"))
+		if err != nil || location.Query().Get("state") == "" {
+			t.Fatalf("login redirect = %q; want the provider with a state", login.Header().Get("Location"))
+		}
+		state := location.Query().Get("state")
+		claims["nonce"] = location.Query().Get("nonce")
+		if tamper != nil {
+			tamper(&state, claims)
+		}
+		provider.claims = claims
+
+		var cookies []string
+		for _, cookie := range login.Result().Cookies() {
+			cookies = append(cookies, cookie.Name+"="+cookie.Value)
+		}
+		return serve(h.SSOCallback, testRequest{method: http.MethodGet, target: "/auth/oidc/callback?code=c&state=" + url.QueryEscape(state), header: map[string]string{"Cookie": strings.Join(cookies, "; ")}})
+	}
+	signedIn := func(rec *httptest.ResponseRecorder) bool {
+		return rec.Code == http.StatusSeeOther && rec.Header().Get("Location") == "/"
+	}
+
+	claims := func(subject, email string, verified bool) map[string]interface{} {
+		return map[string]interface{}{"sub": subject, "email": email, "email_verified": verified, "name": "SSO User"}
+	}
+	if signedIn(signIn(claims("sub-a", "a@example.com", true), func(state *string, _ map[string]interface{}) { *state = "forged" })) {
+		t.Error("a callback with the wrong state signed in")
+	}
+	if signedIn(signIn(claims("sub-a", "a@example.com", true), func(_ *string, c map[string]interface{}) { c["nonce"] = "replayed" })) {
+		t.Error("an ID token with the wrong nonce signed in")
+	}
+
+	existing := testdb.User(t, db, func(u *models.User) { u.Email = "reader@example.com" })
+	if signedIn(signIn(claims("sub-reader", "reader@example.com", false), nil)) {
+		t.Error("an unverified provider email was linked to an existing account")
+	}
+	if !signedIn(signIn(claims("sub-reader", "reader@example.com", true), nil)) {
+		t.Fatal("a verified provider email did not sign in to the existing account")
+	}
+	var linked models.User
+	db.First(&linked, existing.ID)
+	if linked.OIDCSubject == nil || *linked.OIDCSubject != "sub-reader" {
+		t.Error("the existing account was not linked to the provider subject")
+	}
+
+	if !signedIn(signIn(claims("sub-owner", "owner@example.com", false), nil)) {
+		t.Fatal("an unverified provider email could not sign in at all")
+	}
+	var owner models.User
+	db.Where("email = ?", "owner@example.com").First(&owner)
+	if owner.IsAdmin() || owner.IsVerified {
+		t.Errorf("unverified ADMIN_EMAIL sign-in: admin %v, verified %v; want neither", owner.IsAdmin(), owner.IsVerified)
+	}
+
+	grouped := claims("sub-staff", "staff@example.com", true)
+	grouped["groups"] = []string{"admins"}
+	if !signedIn(signIn(grouped, nil)) {
+		t.Fatal("a mapped admin could not sign in")
+	}
+	var staff models.User
+	db.Where("email = ?", "staff@example.com").First(&staff)
+	if !staff.IsAdmin() || !staff.IsVerified {
+		t.Errorf("mapped group sign-in: admin %v, verified %v; want both", staff.IsAdmin(), staff.IsVerified)
+	}
+}
+
+func TestNewslettersOnlyReachReadersWhoCanOpenThePost(t *testing.T) {
+	h, db := newTestHandler(t)
+	admin := testdb.Admin(t, db)
+	testdb.User(t, db)
+	testdb.User(t, db, func(u *models.User) { u.Role = models.RolePremium })
+	send := func(post *models.Post) string {
+		return serve(h.AdminNewsletterSend, testRequest{method: http.MethodPost, target: "/", form: url.Values{"post_id": {fmt.Sprint(post.ID)}}, user: admin}).Body.String()
+	}
+
+	for visibility, want := range map[string]int{models.VisibilityPublic: 3, models.VisibilityPremium: 2, models.VisibilityAdmin: 1} {
+		post := testdb.Post(t, db, func(p *models.Post) { p.Visibility = visibility })
+		if body := send(post); !strings.Contains(body, fmt.Sprintf("Queued for %d recipient(s)", want)) {
+			t.Errorf("%s post was not queued for exactly %d reader(s)", visibility, want)
+		}
+	}
+}
+
+func TestJWTsNeedASecretOfTheSitesOwn(t *testing.T) {
+	h, db := newTestHandler(t)
+	hash, _ := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	user := testdb.User(t, db, func(u *models.User) { u.Password = string(hash) })
+	// A token anyone could forge with the secret this repo used to ship as the default
+	forged, _, _ := services.NewJWT("your-secret-key-change-this-in-production", user.ID, services.JWTAccess, time.Hour)
+	api := h.JWTAuth(func(c echo.Context) error { return c.NoContent(http.StatusOK) })
+
+	for _, secret := range []string{"", "your-secret-key-change-this-in-production"} {
+		h.cfg.JWT.Secret = secret
+		if rec := serve(h.APIAuthToken, testRequest{method: http.MethodPost, target: "/api/auth/token", form: url.Values{"email": {user.Email}, "password": {"correct horse"}}}); rec.Code == http.StatusOK {
+			t.Errorf("JWT_SECRET %q: tokens were issued", secret)
+		}
+		if code := serve(api, testRequest{method: http.MethodGet, target: "/api/palette", header: map[string]string{"Authorization": "Bearer " + forged}}).Code; code != http.StatusUnauthorized {
+			t.Errorf("JWT_SECRET %q: forged token status = %d; want 401", secret, code)
+		}
+	}
+}
+
+func TestAdminAllowlistCoversAdminAPIs(t *testing.T) {
+	h, db := newTestHandler(t)
+	admin := testdb.Admin(t, db)
+	post := testdb.Post(t, db)
+	networks, err := services.ParseNetworks([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.adminNetworks = networks
+	guarded := h.RestrictAdminIPs(func(c echo.Context) error { return c.NoContent(http.StatusOK) })
+	views := testRequest{method: http.MethodGet, target: "/api/posts/1/views", params: map[string]string{"id": fmt.Sprint(post.ID)}, user: admin}
+
+	// httptest requests come from 192.0.2.1, outside the list
+	if code := serve(guarded, testRequest{method: http.MethodGet, target: "/admin/dashboard", user: admin}).Code; code != http.StatusForbidden {
+		t.Errorf("admin route from outside the list: status = %d; want 403", code)
+	}
+	if code := serve(h.PostViewsAPI, views).Code; code != http.StatusForbidden {
+		t.Errorf("admin API from outside the list: status = %d; want 403", code)
+	}
+
+	h.adminNetworks, _ = services.ParseNetworks([]string{"192.0.2.0/24"})
+	if code := serve(guarded, testRequest{method: http.MethodGet, target: "/admin/dashboard", user: admin}).Code; code != http.StatusOK {
+		t.Errorf("admin route from inside the list: status = %d; want 200", code)
+	}
+	if code := serve(h.PostViewsAPI, views).Code; code != http.StatusOK {
+		t.Errorf("admin API from inside the list: status = %d; want 200", code)
+	}
+}
+
+func TestRequireReauthAsksUntilTheAdminConfirms(t *testing.T) {
+	h, db := newTestHandler(t)
+	h.cfg.Admin.ReauthWindow = 10 * time.Minute
+	hash, _ := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	admin := testdb.Admin(t, db, func(u *models.User) { u.Password = string(hash) })
+	ran := 0
+	guarded := h.RequireReauth(func(c echo.Context) error { ran++; return c.NoContent(http.StatusOK) })
+	export := testRequest{method: http.MethodGet, target: "/admin/backup/export", user: admin}
+
+	rec := serve(guarded, export)
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/admin/reauth?next=%2Fadmin%2Fbackup%2Fexport" {
+		t.Errorf("unconfirmed: status = %d, location = %q; want a redirect to the confirmation page", rec.Code, rec.Header().Get("Location"))
+	}
+	htmx := export
+	htmx.htmx = true
+	if rec := serve(guarded, htmx); rec.Header().Get("HX-Retarget") != "body" {
+		t.Error("unconfirmed HTMX request should open the confirmation dialog")
+	}
+
+	confirm := func(password string) string {
+		rec := serve(h.AdminReauth, testRequest{method: http.MethodPost, target: "/admin/reauth", form: url.Values{"password": {password}}, user: admin})
+		var cookies []string
+		for _, cookie := range rec.Result().Cookies() {
+			cookies = append(cookies, cookie.Name+"="+cookie.Value)
+		}
+		return strings.Join(cookies, "; ")
+	}
+	if cookie := confirm("wrong"); cookie != "" {
+		export.header = map[string]string{"Cookie": cookie}
+		serve(guarded, export)
+	}
+	if ran != 0 {
+		t.Fatal("the action ran before the admin confirmed")
+	}
+
+	export.header = map[string]string{"Cookie": confirm("correct horse")}
+	if rec := serve(guarded, export); rec.Code != http.StatusOK || ran != 1 {
+		t.Errorf("confirmed: status = %d, ran %d time(s); want the action to run", rec.Code, ran)
+	}
+	h.cfg.Admin.ReauthWindow = time.Nanosecond
+	if serve(guarded, export); ran != 1 {
+		t.Error("the action ran after the confirmation window closed")
+	}
+}
+
+func TestPaletteAndOfflineWatchlistHideAdultTitles(t *testing.T) {
+	h, db := newTestHandler(t)
+	h.cfg.Content.HideAdult = true
+	testdb.Movie(t, db, func(m *models.Media) { m.Title = "Family Night"; m.Status = models.StatusWatching })
+	testdb.Movie(t, db, func(m *models.Media) { m.Title = "After Dark"; m.Status = models.StatusWatching; m.Adult = true })
+	admin := testdb.Admin(t, db)
+
+	for _, user := range []*models.User{nil, admin} {
+		palette := serve(h.Palette, testRequest{method: http.MethodGet, target: "/api/palette", user: user}).Body.String()
+		offline := serve(h.OfflineSync, testRequest{method: http.MethodGet, target: "/api/offline", user: user}).Body.String()
+		for name, out := range map[string]string{"palette": palette, "offline watchlist": offline} {
+			if !strings.Contains(out, "Family Night") {
+				t.Errorf("%s is missing the family title", name)
+			}
+			if shown, want := strings.Contains(out, "After Dark"), user != nil; shown != want {
+				t.Errorf("%s shows the adult title = %v for admin = %v; want %v", name, shown, user != nil, want)
+			}
+		}
+	}
+}
+
+func TestAdultTitlesHiddenBySiteSetting(t *testing.T) {
+	h, db := newTestHandler(t)
+	admin := testdb.Admin(t, db)
+	show := testdb.Show(t, db, 1, 2, func(m *models.Media) { m.Adult = true })
+	id := fmt.Sprint(show.TMDBID)
+	refresh := testRequest{method: http.MethodGet, target: "/tv/modal/" + id + "/refresh?type=tv", params: map[string]string{"id": id}}
+	episodes := testRequest{method: http.MethodGet, target: "/tv/" + id + "/episodes/1", params: map[string]string{"tmdbId": id, "season": "1"}}
+
+	if code := serve(h.MediaModalRefresh, refresh).Code; code != http.StatusOK {
+		t.Fatalf("modal refresh before hiding = %d; want 200", code)
+	}
+	serve(h.AdminAdultMediaUpdate, testRequest{method: http.MethodPost, target: "/admin/adult-media", form: url.Values{"hide": {"on"}}, user: admin, htmx: true})
+	if !h.adultMedia().Hide {
+		t.Fatal("setting was not saved")
+	}
+	if code := serve(h.MediaModalRefresh, refresh).Code; code != http.StatusNotFound {
+		t.Errorf("modal refresh of a hidden title = %d; want 404", code)
+	}
+	if code := serve(h.MediaEpisodes, episodes).Code; code != http.StatusNotFound {
+		t.Errorf("episodes of a hidden title = %d; want 404", code)
+	}
+}
+
+func TestUploadKeysTakeTheSniffedExtension(t *testing.T) {
+	h, db := newTestHandler(t)
+	admin := testdb.Admin(t, db)
+	h.cfg.Limits.ImageTypes = []string{"image/png"}
+
+	var img bytes.Buffer
+	png.Encode(&img, image.NewRGBA(image.Rect(0, 0, 4, 4)))
+	for filename, want := range map[string]string{"photo.PNG": ".png", "page.html": ".png", "noext": ".png"} {
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+		part, _ := form.CreateFormFile("file", filename)
+		part.Write(img.Bytes())
+		form.Close()
+		parsed, err := multipart.NewReader(&body, form.Boundary()).ReadForm(1 << 20)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		upload, err := h.storeUpload(parsed.File["file"][0], admin)
+		if err != nil {
+			t.Fatalf("%s: %v", filename, err)
+		}
+		if !strings.HasSuffix(upload.Key, want) || strings.Contains(upload.Key, ".html") {
+			t.Errorf("%s stored as %s; want a %s key", filename, upload.Key, want)
+		}
+	}
+}
+
+func TestUploadsUsedAsCoverOrPreviewImagesAreKept(t *testing.T) {
+	h, db := newTestHandler(t)
+	admin := testdb.Admin(t, db)
+	uses := map[string]func(*models.Post, string){
+		"cover":   func(p *models.Post, url string) { p.CoverImage = url },
+		"preview": func(p *models.Post, url string) { p.OGImage = url },
+	}
+	for name, use := range uses {
+		upload := models.Upload{Filename: name + ".jpg", Key: name + ".jpg", URL: "/uploads/" + name + ".jpg"}
+		if err := db.Create(&upload).Error; err != nil {
+			t.Fatal(err)
+		}
+		testdb.Post(t, db, func(p *models.Post) {
+			p.Content = "No images in here."
+			use(p, "https://blog.example"+upload.URL)
+		})
+
+		out := serve(h.AdminUploadDelete, testRequest{method: http.MethodPost, target: "/admin/uploads/1/delete", params: map[string]string{"id": fmt.Sprint(upload.ID)}, user: admin}).Body.String()
+		if !strings.Contains(out, "still used in 1 post(s)") {
+			t.Errorf("deleting the %s image: %s; want it refused", name, out)
+		}
+		if err := db.First(&models.Upload{}, upload.ID).Error; err != nil {
+			t.Errorf("upload used as a %s image was deleted: %v", name, err)
+		}
+	}
+}
+
+func TestAdminPostCreateChecksTagsFirst(t *testing.T) {
+	h, db := newTestHandler(t)
+	admin := testdb.Admin(t, db)
+
+	form := url.Values{"title": {"Tagged"}, "content": {"Body"}, "tags": {"go, " + strings.Repeat("x", 51)}}
+	rec := serve(h.AdminPostCreate, testRequest{method: http.MethodPost, target: "/admin/posts", form: form, user: admin, htmx: true})
+	var count int64
+	db.Model(&models.Post{}).Count(&count)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "Tags must be at most 50 characters") || count != 0 {
+		t.Fatalf("long tag = %d %q with %d post(s); want 400 and nothing saved", rec.Code, rec.Body.String(), count)
+	}
+
+	form.Set("tags", "go, web")
+	if rec := serve(h.AdminPostCreate, testRequest{method: http.MethodPost, target: "/admin/posts", form: form, user: admin, htmx: true}); rec.Code != http.StatusOK {
+		t.Fatalf("create = %d %q", rec.Code, rec.Body.String())
+	}
+	var post models.Post
+	if err := db.Preload("Tags").Where("slug = ?", "tagged").First(&post).Error; err != nil || len(post.Tags) != 2 {
+		t.Fatalf("post = %+v, %v; want it saved with both tags", post, err)
+	}
+}
+
+func TestTagAndCategoryFeedsOnlyCarryTheirPosts(t *testing.T) {
+	h, db := newTestHandler(t)
+	category := models.Category{Name: "Notes", Slug: "notes"}
+	db.Create(&category)
+	tag := models.Tag{Name: "Go", Slug: "go"}
+	db.Create(&tag)
+	inBoth := testdb.Post(t, db, func(p *models.Post) { p.Title = "Filed and tagged"; p.CategoryID = &category.ID })
+	db.Model(inBoth).Association("Tags").Append(&tag)
+	private := testdb.Post(t, db, func(p *models.Post) {
+		p.Title = "Members only"
+		p.CategoryID = &category.ID
+		p.Visibility = models.VisibilityPremium
+	})
+	db.Model(private).Association("Tags").Append(&tag)
+	testdb.Post(t, db, func(p *models.Post) { p.Title = "Elsewhere" })
+
+	feeds := map[string]echo.HandlerFunc{"/tags/go/feed.xml": h.TagFeed, "/category/notes/feed.xml": h.CategoryFeed}
+	for target, handler := range feeds {
+		rec := serve(handler, testRequest{method: http.MethodGet, target: target, params: map[string]string{"slug": strings.Split(target, "/")[2]}})
+		out := rec.Body.String()
+		if rec.Code != http.StatusOK || !strings.Contains(out, "Filed and tagged") || strings.Contains(out, "Elsewhere") || strings.Contains(out, "Members only") {
+			t.Errorf("%s: status %d\n%s", target, rec.Code, out)
+		}
+	}
+	if code := serve(h.TagFeed, testRequest{method: http.MethodGet, target: "/tags/nope/feed.xml", params: map[string]string{"slug": "nope"}}).Code; code != http.StatusNotFound {
+		t.Errorf("unknown tag feed status = %d; want 404", code)
+	}
+
+	// Links come from BASE_URL, not whatever Host the request claimed
+	h.cfg.Server.BaseURL = "https://blog.example/"
+	out := serve(h.RSSFeed, testRequest{method: http.MethodGet, target: "http://attacker.example/feed.xml"}).Body.String()
+	if strings.Contains(out, "attacker.example") || !strings.Contains(out, "https://blog.example/posts/"+inBoth.Slug) {
+		t.Errorf("feed links don't follow BASE_URL:\n%s", out)
+	}
+}
+
+func TestSignedLinksSurviveASessionKeyRotation(t *testing.T) {
+	h, _ := newTestHandler(t)
+	email := "reader@example.com"
+	signature := services.SignValue(h.cfg.Session.Key, emailPreferencesScope, email)
+	preferences := testRequest{method: http.MethodGet, target: "/email/preferences?e=" + url.QueryEscape(email) + "&s=" + signature}
+
+	h.cfg.Session.PreviousKeys = []string{h.cfg.Session.Key}
+	h.cfg.Session.Key = "rotated-session-key-32-characters"
+	if code := serve(h.EmailPreferences, preferences).Code; code != http.StatusOK {
+		t.Errorf("link signed with the previous key: status = %d; want 200", code)
+	}
+	h.cfg.Session.PreviousKeys = nil
+	if code := serve(h.EmailPreferences, preferences).Code; code != http.StatusForbidden {
+		t.Errorf("link signed with a retired key: status = %d; want 403", code)
+	}
+}
+
+func TestUnsubscribeLinksAskBeforeUnsubscribing(t *testing.T) {
+	h, _ := newTestHandler(t)
+	email := "reader@example.com"
+	link := h.emailPreferencesURL("/email/unsubscribe", email)
+	target := strings.TrimPrefix(link, h.cfg.Server.BaseURL)
+	wanted := func() bool { pref := h.emailPreferenceFor(email); return pref.Allows(models.EmailKindNewsletter) }
+
+	// What a link scanner does
+	if rec := serve(h.EmailUnsubscribePage, testRequest{method: http.MethodGet, target: target}); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `action="/email/unsubscribe"`) {
+		t.Fatalf("GET status = %d; want the confirmation form", rec.Code)
+	}
+	if !wanted() {
+		t.Fatal("opening the link unsubscribed the reader")
+	}
+
+	// What a mail client's one-click button does
+	oneClick := testRequest{method: http.MethodPost, target: target, form: url.Values{"List-Unsubscribe": {"One-Click"}}}
+	if code := serve(h.EmailUnsubscribe, oneClick).Code; code != http.StatusOK || wanted() {
+		t.Errorf("one-click POST status = %d; want the reader unsubscribed", code)
+	}
+
+	job := models.EmailJob{Kind: models.EmailKindNewsletter, To: email}
+	headers := h.listUnsubscribeHeaders(job)
+	if headers["List-Unsubscribe"] != "<"+link+">" || headers["List-Unsubscribe-Post"] != "List-Unsubscribe=One-Click" {
+		t.Errorf("newsletter headers = %v", headers)
+	}
+	job.Kind = models.EmailKindTransactional
+	if headers := h.listUnsubscribeHeaders(job); headers != nil {
+		t.Errorf("transactional email headers = %v; want none", headers)
+	}
+}
+
+func TestBackupExportImportsIntoAFreshInstall(t *testing.T) {
+	h, db := newTestHandler(t)
+	hash, _ := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	admin := testdb.Admin(t, db, func(u *models.User) { u.Password = string(hash) })
+	reader := testdb.User(t, db)
+	category := models.Category{Name: "Notes", Slug: "notes"}
+	db.Create(&category)
+	post := testdb.Post(t, db, func(p *models.Post) { p.Title = "Moved house"; p.CategoryID = &category.ID })
+	tag := models.Tag{Name: "Go", Slug: "go"}
+	db.Create(&tag)
+	db.Model(post).Association("Tags").Append(&tag)
+	db.Create(&models.Comment{PostID: post.ID, UserID: reader.ID, Body: "Welcome back"})
+	show := testdb.Show(t, db, 2, 3)
+	models.SaveSetting(db, models.SettingLanding, models.Landing{Title: "Hi", Content: "# Hi"})
+
+	rec := serve(h.AdminBackupExport, testRequest{method: http.MethodGet, target: "/admin/backup/export?passwords=on", user: admin})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("export status = %d", rec.Code)
+	}
+
+	// A new install, with only the bootstrap admin that gets replaced
+	fresh, freshDB := newTestHandler(t)
+	bootstrap := testdb.Admin(t, freshDB)
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, _ := form.CreateFormFile("archive", "backup.zip")
+	part.Write(rec.Body.Bytes())
+	form.Close()
+	r := httptest.NewRequest(http.MethodPost, "/admin/backup/import", &body)
+	r.Header.Set(echo.HeaderContentType, form.FormDataContentType())
+	imported := httptest.NewRecorder()
+	c := echo.New().NewContext(r, imported)
+	c.Set("user", bootstrap)
+	if err := fresh.AdminBackupImport(c); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(imported.Body.String(), "Import failed") {
+		t.Fatalf("import: %s", imported.Body.String())
+	}
+
+	var users, comments, episodes int64
+	freshDB.Model(&models.User{}).Count(&users)
+	freshDB.Model(&models.Comment{}).Count(&comments)
+	freshDB.Model(&models.Episode{}).Where("tmdb_id = ?", show.TMDBID).Count(&episodes)
+	if users != 2 || comments != 1 || episodes != 6 {
+		t.Errorf("imported %d users, %d comments, %d episodes; want 2, 1, 6", users, comments, episodes)
+	}
+	var moved models.Post
+	if err := freshDB.Preload("Tags").Preload("Category").First(&moved, post.ID).Error; err != nil {
+		t.Fatalf("post not imported: %v", err)
+	}
+	if moved.Title != "Moved house" || len(moved.Tags) != 1 || moved.Category == nil || moved.Category.Slug != "notes" {
+		t.Errorf("imported post = %q, %d tags, category %v", moved.Title, len(moved.Tags), moved.Category)
+	}
+	var importedAdmin models.User
+	freshDB.First(&importedAdmin, admin.ID)
+	if !importedAdmin.IsAdmin() || bcrypt.CompareHashAndPassword([]byte(importedAdmin.Password), []byte("correct horse")) != nil {
+		t.Error("the admin should come back with their role and password")
+	}
+	var landing models.Landing
+	if err := models.LoadSetting(freshDB, models.SettingLanding, &landing); err != nil || landing.Content != "# Hi" {
+		t.Errorf("landing setting = %+v, %v", landing, err)
+	}
+}
+
+// roundTripFunc lets a test stand in for the network
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+func TestSeasonSyncPrunesDroppedEpisodesAndTakesSpecials(t *testing.T) {
+	h, db := newTestHandler(t)
+	admin := testdb.Admin(t, db)
+	show := testdb.Show(t, db, 1, 4)
+	db.Model(&models.Episode{}).Where("tmdb_id = ? AND season_number = 1 AND episode_number = 4", show.TMDBID).Update("watched", true)
+	tmdb := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"episodes":[{"episode_number":1,"name":"One"},{"episode_number":2,"name":"Two"}]}`))
+	}))
+	defer tmdb.Close()
+	h.tmdbService.BaseURL = tmdb.URL
+
+	sync := func(season string) *httptest.ResponseRecorder {
+		return serve(h.MediaSeasonSync, testRequest{method: http.MethodPost, target: "/tv/sync", user: admin, htmx: true,
+			params: map[string]string{"tmdbId": fmt.Sprint(show.TMDBID), "season": season}})
+	}
+	if rec := sync("1"); rec.Code != http.StatusOK {
+		t.Fatalf("season 1 sync = %d %q", rec.Code, rec.Body.String())
+	}
+	var numbers []int
+	db.Model(&models.Episode{}).Where("tmdb_id = ? AND season_number = 1", show.TMDBID).Order("episode_number").Pluck("episode_number", &numbers)
+	if fmt.Sprint(numbers) != "[1 2 4]" {
+		t.Errorf("episodes = %v; want the dropped unwatched one pruned and the watched one kept", numbers)
+	}
+	var season models.Season
+	db.Where("tmdb_id = ? AND season_number = 1", show.TMDBID).First(&season)
+	if season.EpisodeCount != 3 {
+		t.Errorf("episode_count = %d; want the 3 stored episodes", season.EpisodeCount)
+	}
+
+	if rec := sync("0"); rec.Code != http.StatusOK {
+		t.Fatalf("specials sync = %d %q", rec.Code, rec.Body.String())
+	}
+	var specials int64
+	db.Model(&models.Episode{}).Where("tmdb_id = ? AND season_number = 0", show.TMDBID).Count(&specials)
+	var media models.Media
+	db.First(&media, show.ID)
+	if specials != 2 || media.TotalEpisodes != 3 {
+		t.Errorf("specials = %d, total = %d; want 2 specials kept out of the total of 3", specials, media.TotalEpisodes)
+	}
+}
+
+func TestShowsWithFailedSeasonsStayDueForSync(t *testing.T) {
+	h, db := newTestHandler(t)
+	synced := time.Now().Add(-72 * time.Hour)
+	show := testdb.Show(t, db, 1, 4, func(m *models.Media) { m.LastSyncedAt = &synced })
+	tmdb := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "/season/") {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"id":%d,"name":"Show","seasons":[{"season_number":1,"episode_count":4}]}`, show.TMDBID)
+	}))
+	defer tmdb.Close()
+	h.tmdbService.BaseURL = tmdb.URL
+
+	if err := h.SyncMedia(show.TMDBID); err == nil {
+		t.Fatal("sync with a failed season reported no error")
+	}
+	var media models.Media
+	db.First(&media, show.ID)
+	if !media.LastSyncedAt.Equal(synced) || media.NextSyncAt == nil {
+		t.Errorf("last synced %v, next %v; want the old sync time kept and a retry scheduled", media.LastSyncedAt, media.NextSyncAt)
+	}
+	if !media.SyncScheduled(*media.NextSyncAt) {
+		t.Error("show should be due again once its backoff ends")
+	}
+}
+
+func TestEpisodeStillsAreHashedInTheBackground(t *testing.T) {
+	var fetched atomic.Int32
+	images := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetched.Add(1)
+		if r.URL.Path != "/t/p/w92/good.png" {
+			http.NotFound(w, r)
+			return
+		}
+		img := image.NewRGBA(image.Rect(0, 0, 8, 6))
+		png.Encode(w, img)
+	}))
+	defer images.Close()
+	server, _ := url.Parse(images.URL)
+	transport := http.DefaultTransport
+	http.DefaultTransport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		r.URL.Scheme, r.URL.Host = server.Scheme, server.Host
+		return transport.RoundTrip(r)
+	})
+	t.Cleanup(func() { http.DefaultTransport = transport })
+
+	h, db := newTestHandler(t)
+	show := testdb.Show(t, db, 1, 2)
+	h.upsertEpisodes(show.TMDBID, 1, []models.Episode{
+		{TMDBID: show.TMDBID, SeasonNumber: 1, EpisodeNumber: 1, Name: "Pilot", StillPath: "/good.png"},
+		{TMDBID: show.TMDBID, SeasonNumber: 1, EpisodeNumber: 2, Name: "Second", StillPath: "/bad.png"},
+	})
+	if n := fetched.Load(); n != 0 {
+		t.Fatalf("saving episodes fetched %d still(s); want none", n)
+	}
+
+	h.HashEpisodeStills()
+	var good, bad models.Episode
+	db.Where("tmdb_id = ? AND episode_number = 1", show.TMDBID).First(&good)
+	db.Where("tmdb_id = ? AND episode_number = 2", show.TMDBID).First(&bad)
+	if good.StillBlurhash == "" || bad.StillBlurhash != "" || bad.StillHashFailedAt == nil {
+		t.Fatalf("good hash = %q, bad hash = %q failed at %v; want the good one hashed and the bad one marked", good.StillBlurhash, bad.StillBlurhash, bad.StillHashFailedAt)
+	}
+	h.HashEpisodeStills()
+	if n := fetched.Load(); n != 2 {
+		t.Errorf("fetched %d stills over two passes; want the failed one left alone", n)
+	}
+}
+
+// stripeRoundTripper answers Stripe API calls in place of the network, recording the checkout form
+type stripeRoundTripper struct{ form url.Values }
+
+func (s *stripeRoundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
+	body, _ := io.ReadAll(r.Body)
+	s.form, _ = url.ParseQuery(string(body))
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(`{"id":"cs_test","url":"https://checkout.stripe.test/cs_test"}`)),
+		Request:    r,
+	}, nil
+}
+
+func TestTipsChargeTheCurrencysSmallestUnitAndReturnToBaseURL(t *testing.T) {
+	stripe := &stripeRoundTripper{}
+	transport := http.DefaultTransport
+	http.DefaultTransport = stripe
+	t.Cleanup(func() { http.DefaultTransport = transport })
+
+	h, _ := newTestHandler(t)
+	h.cfg.Server.BaseURL = "https://blog.example/"
+	h.cfg.Payments.StripeSecretKey = "sk_test"
+	h.cfg.Payments.Amounts = []int{500}
+	for currency, want := range map[string]string{"usd": "50000", "jpy": "500", "kwd": "500000"} {
+		h.cfg.Payments.Currency = currency
+		rec := serve(h.SupportCheckout, testRequest{method: http.MethodPost, target: "/support/checkout", form: url.Values{"amount": {"500"}}})
+		if rec.Code != http.StatusSeeOther {
+			t.Fatalf("%s checkout status = %d", currency, rec.Code)
+		}
+		if got := stripe.form.Get("line_items[0][price_data][unit_amount]"); got != want {
+			t.Errorf("%s unit_amount = %s; want %s", currency, got, want)
+		}
+		if got := stripe.form.Get("cancel_url"); got != "https://blog.example/support?cancelled=1" {
+			t.Errorf("cancel_url = %s; want it on BASE_URL", got)
+		}
+	}
+
+	for _, tc := range []struct {
+		amount   int
+		currency string
+		want     string
+	}{{1250, "usd", "12.50 USD"}, {500, "jpy", "500 JPY"}, {1500, "kwd", "1.500 KWD"}} {
+		if got := services.FormatAmount(tc.amount, tc.currency); got != tc.want {
+			t.Errorf("FormatAmount(%d, %s) = %s; want %s", tc.amount, tc.currency, got, tc.want)
+		}
+	}
+}
+
+func TestDiscountCouponsComeOffTheTip(t *testing.T) {
+	stripe := &stripeRoundTripper{}
+	transport := http.DefaultTransport
+	http.DefaultTransport = stripe
+	t.Cleanup(func() { http.DefaultTransport = transport })
+
+	h, db := newTestHandler(t)
+	h.cfg.Payments.StripeSecretKey = "sk_test"
+	h.cfg.Payments.Amounts = []int{10}
+	h.cfg.Payments.Currency = "usd"
+	db.Create(&models.Coupon{Code: "QUARTER", PercentOff: 25})
+	db.Create(&models.Coupon{Code: "FIVE", AmountOff: 5, MaxRedemptions: 1})
+	db.Create(&models.Coupon{Code: "TRIAL", TrialDays: 30})
+
+	checkout := func(code string) *httptest.ResponseRecorder {
+		stripe.form = nil
+		return serve(h.SupportCheckout, testRequest{method: http.MethodPost, target: "/support/checkout", form: url.Values{"amount": {"10"}, "coupon": {code}}})
+	}
+	for code, want := range map[string]string{"quarter": "750", "FIVE": "500"} {
+		if rec := checkout(code); rec.Code != http.StatusSeeOther {
+			t.Fatalf("%s checkout = %d %q", code, rec.Code, rec.Body.String())
+		}
+		if got := stripe.form.Get("line_items[0][price_data][unit_amount]"); got != want {
+			t.Errorf("%s unit_amount = %s; want %s", code, got, want)
+		}
+		if got := stripe.form.Get("metadata[coupon]"); got != strings.ToUpper(code) {
+			t.Errorf("%s metadata coupon = %q", code, got)
+		}
+	}
+	if rec := checkout("TRIAL"); stripe.form != nil || !strings.Contains(rec.Body.String(), "invalid or no longer available") {
+		t.Errorf("a trial code was taken at checkout: %d %q", rec.Code, rec.Body.String())
+	}
+
+	session := &services.CheckoutSession{ID: "cs_five", PaymentStatus: "paid", AmountTotal: 500, Currency: "usd"}
+	session.Metadata.Coupon = "FIVE"
+	for range 2 {
+		if _, err := h.recordPayment(session); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var coupon models.Coupon
+	db.Where("code = ?", "FIVE").First(&coupon)
+	if coupon.Redemptions != 1 {
+		t.Errorf("redemptions = %d; want the paid tip counted once", coupon.Redemptions)
+	}
+	if rec := checkout("FIVE"); stripe.form != nil || !strings.Contains(rec.Body.String(), "invalid or no longer available") {
+		t.Errorf("a used-up coupon was accepted: %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestCrawlerRulesComeFromTheAdminPage(t *testing.T) {
+	h, db := newTestHandler(t)
+	admin := testdb.Admin(t, db)
+	h.cfg.Crawlers.Disallow = "/old, /older"
+	robots := func() string {
+		return serve(h.Robots, testRequest{method: http.MethodGet, target: "/robots.txt"}).Body.String()
+	}
+
+	if out := robots(); !strings.Contains(out, "Disallow: /older\n") || !strings.Contains(out, "Allow: /posts\n") {
+		t.Errorf("before saving, robots.txt should follow the environment:\n%s", out)
+	}
+
+	save := func(form url.Values) string {
+		return serve(h.AdminCrawlersUpdate, testRequest{method: http.MethodPost, target: "/admin/crawlers", form: form, user: admin, htmx: true}).Body.String()
+	}
+	if out := save(url.Values{"disallow": {"drafts"}}); !strings.Contains(out, "isn") {
+		t.Errorf("a path without a leading slash should be refused:\n%s", out)
+	}
+	if out := save(url.Values{"block_posts": {"on"}, "disallow": {"/drafts\n\n/drafts\n/lab"}}); !strings.Contains(out, "Crawler rules saved") {
+		t.Fatalf("save failed:\n%s", out)
+	}
+	out := robots()
+	for _, want := range []string{"Disallow: /admin\n", "Disallow: /drafts\n", "Disallow: /lab\n", "Disallow: /posts\n", "Allow: /tv\n"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("robots.txt is missing %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "/older") || strings.Count(out, "/drafts") != 1 {
+		t.Errorf("saved rules should replace the environment's and drop duplicates:\n%s", out)
+	}
+	if h.crawlerRules().NoindexRestricted {
+		t.Error("unticking noindex should turn it off")
+	}
+}
+
+func TestWatchProvidersOnlyForLibraryTitles(t *testing.T) {
+	h, db := newTestHandler(t)
+	movie := testdb.Movie(t, db)
+	tmdb := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"results":{"US":{"link":"https://www.themoviedb.org/movie/1/watch?locale=US","flatrate":[{"provider_name":"Netflix"},{"provider_name":"Hulu"}]}}}`))
+	}))
+	defer tmdb.Close()
+	h.tmdbService.BaseURL = tmdb.URL
+	h.cfg.Content.WatchRegions = []string{"US"}
+
+	watch := func(id int) *httptest.ResponseRecorder {
+		return serve(h.MediaWatchProviders, testRequest{method: http.MethodGet, target: "/tv/watch/movie/" + fmt.Sprint(id),
+			params: map[string]string{"type": models.MediaTypeMovie, "tmdbId": fmt.Sprint(id)}, htmx: true})
+	}
+	rec := watch(movie.TMDBID)
+	body := rec.Body.String()
+	if rec.Code != http.StatusOK || strings.Count(body, "See where to watch") != 1 || !strings.Contains(body, "Hulu") || strings.Contains(body, "Watch on") {
+		t.Fatalf("library title = %d %q; want the providers named and one link to the region's page", rec.Code, body)
+	}
+	if rec := watch(movie.TMDBID + 999); rec.Code != http.StatusNotFound {
+		t.Errorf("title outside the library = %d; want 404", rec.Code)
+	}
+}
+
+func TestTMDBEmbedsCountAgainstTheSitesBudget(t *testing.T) {
+	h, db := newTestHandler(t)
+	var calls atomic.Int64
+	tmdb := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":1,"title":"Embedded Movie","overview":"A test."}`))
+	}))
+	defer tmdb.Close()
+	h.tmdbService.BaseURL = tmdb.URL
+	h.ServeTMDBEmbeds()
+	t.Cleanup(func() { services.SetEmbedTMDB(nil, nil) })
+
+	// IDs no other test renders, since embeds are cached for the whole process
+	if out := string(services.MarkdownToHTML("{{tmdb 990001}}\n")); !strings.Contains(out, "Embedded Movie") {
+		t.Fatalf("embed didn't use the site's client:\n%s", out)
+	}
+	h.RecordTMDBUsage()
+	var recorded int64
+	db.Model(&models.APICallHour{}).Select("COALESCE(SUM(calls), 0)").Where("service = ?", models.ProviderTMDB).Scan(&recorded)
+	if recorded == 0 || recorded != calls.Load() {
+		t.Errorf("recorded %d TMDB calls; the embed made %d", recorded, calls.Load())
+	}
+
+	h.cfg.TMDB.HourlyBudget = int(recorded)
+	before := calls.Load()
+	if out := string(services.MarkdownToHTML("{{tmdb 990002}}\n")); strings.Contains(out, "Embedded Movie") || calls.Load() != before {
+		t.Errorf("a spent budget should stop embed lookups; made %d more call(s)", calls.Load()-before)
+	}
+}
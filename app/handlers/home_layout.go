@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"mini-blog/app/models"
+	"mini-blog/app/services"
+	"mini-blog/app/templates"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/a-h/templ"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	homeLatestPosts   = 5
+	homeWatchingLimit = 12
+)
+
+// AdminHomeLayout is the homepage composer
+func (h *BaseHandler) AdminHomeLayout(c echo.Context) error {
+	return h.renderHomeLayout(c, h.homeLayout(), "", "")
+}
+
+// AdminHomeLayoutUpdate saves the chosen sections, their order, the pinned post, the announcement and widget privacy
+func (h *BaseHandler) AdminHomeLayoutUpdate(c echo.Context) error {
+	form, err := c.FormParams()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid form")
+	}
+
+	layout := models.HomeLayout{
+		Announcement:    h.trimFormValue(c, "announcement"),
+		WatchingPrivate: c.FormValue("watching_private") == "on",
+	}
+	position := make(map[string]int)
+	for _, section := range form["sections"] {
+		if models.IsValidSection(section) && position[section] == 0 {
+			position[section], _ = strconv.Atoi(c.FormValue("position_" + section))
+			layout.Sections = append(layout.Sections, section)
+		}
+	}
+	sort.SliceStable(layout.Sections, func(i, j int) bool {
+		return position[layout.Sections[i]] < position[layout.Sections[j]]
+	})
+
+	if id, err := strconv.ParseUint(c.FormValue("pinned_post_id"), 10, 64); err == nil && id > 0 {
+		var post models.Post
+		if err := h.db.Where("id = ? AND published = ?", id, true).First(&post).Error; err != nil {
+			return h.renderHomeLayout(c, layout, "", "Only published posts can be pinned")
+		}
+		layout.PinnedPostID = post.ID
+	}
+
+	if err := models.SaveSetting(h.db, models.SettingHomeLayout, layout); err != nil {
+		return h.renderHomeLayout(c, layout, "", "Failed to save the homepage")
+	}
+	return h.renderHomeLayout(c, layout, "Homepage saved", "")
+}
+
+func (h *BaseHandler) renderHomeLayout(c echo.Context, layout models.HomeLayout, successMessage, errorMessage string) error {
+	var posts []models.Post
+	h.db.Where("published = ?", true).Order("created_at desc").Find(&posts)
+
+	page := templates.HomeLayoutPage(layout, posts, successMessage, errorMessage)
+	if h.isHTMXRequest(c) {
+		return h.render(c, page)
+	}
+	return h.render(c, templates.Layout("Homepage", page, c.Request().URL.Path, c.Get("user").(*models.User)))
+}
+
+// AdminPostTogglePin pins a post to the homepage, or unpins it, from its dashboard row
+func (h *BaseHandler) AdminPostTogglePin(c echo.Context) error {
+	id, err := h.parseUintParam(c, "id")
+	if err != nil {
+		return err
+	}
+	var post models.Post
+	if err := h.db.First(&post, id).Error; err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "Post not found")
+	}
+	post.Pinned = !post.Pinned
+	if err := h.db.Model(&post).Update("pinned", post.Pinned).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to pin post")
+	}
+	return h.render(c, templates.PostPinButton(post))
+}
+
+// homeLayout returns the saved homepage layout, or the default before one is saved
+func (h *BaseHandler) homeLayout() models.HomeLayout {
+	layout := models.DefaultHomeLayout()
+	models.LoadSetting(h.db, models.SettingHomeLayout, &layout)
+	return layout
+}
+
+// homeSection builds one homepage section for the viewer; nil means there is nothing to show
+func (h *BaseHandler) homeSection(c echo.Context, section string, layout models.HomeLayout, user *models.User) (templ.Component, error) {
+	switch section {
+	case models.HomeSectionAnnouncement:
+		if layout.Announcement == "" {
+			return nil, nil
+		}
+		return templates.HomeAnnouncement(string(services.MarkdownToHTML(layout.Announcement))), nil
+
+	case models.HomeSectionPinned:
+		// The composer's pinned post leads, then posts pinned from the dashboard, newest first
+		var posts []models.Post
+		if err := h.db.Where("published = ? AND (pinned = ? OR id = ?)", true, true, layout.PinnedPostID).
+			Order(clause.OrderBy{Expression: clause.Expr{SQL: "CASE WHEN id = ? THEN 0 ELSE 1 END, created_at DESC", Vars: []interface{}{layout.PinnedPostID}}}).
+			Find(&posts).Error; err != nil {
+			return nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch posts")
+		}
+		pinned := h.getAccessiblePosts(posts, user)
+		if len(pinned) == 0 {
+			return nil, nil
+		}
+		h.localizePosts(c, pinned)
+		return templates.PinnedPosts(pinned), nil
+
+	case models.HomeSectionLatest:
+		var posts []models.Post
+		query := h.db.Where("published = ?", true).Order("created_at desc").Limit(homeLatestPosts)
+		if layout.Shows(models.HomeSectionPinned) {
+			query = query.Where("pinned = ? AND id <> ?", false, layout.PinnedPostID)
+		}
+		if err := query.Find(&posts).Error; err != nil {
+			return nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch posts")
+		}
+		accessible := h.getAccessiblePosts(posts, user)
+		h.localizePosts(c, accessible)
+		h.markFinished(user, accessible)
+		h.attachLikes(c, accessible)
+		return templates.PostsList(accessible, h.t(c, "posts.latest"), false, templates.PostsState{}, true, user), nil
+
+	case models.HomeSectionWatching:
+		if !h.cfg.TrackerEnabled() {
+			return nil, nil
+		}
+		media := h.watchingMedia(c, layout, user)
+		if len(media) == 0 {
+			return nil, nil
+		}
+		return templates.WatchingRail(media), nil
+	}
+	return nil, nil
+}
+
+// WatchingWidget renders the currently watching rail on its own, for embedding anywhere with hx-get;
+// it is empty when nothing is being watched or the widget is private
+func (h *BaseHandler) WatchingWidget(c echo.Context) error {
+	media := h.watchingMedia(c, h.homeLayout(), h.GetCurrentUser(c))
+	if len(media) == 0 {
+		return c.NoContent(http.StatusNoContent)
+	}
+	return h.render(c, templates.WatchingRail(media))
+}
+
+// watchingMedia lists the titles currently being watched, honouring the widget's privacy setting
+func (h *BaseHandler) watchingMedia(c echo.Context, layout models.HomeLayout, user *models.User) []models.Media {
+	if layout.WatchingPrivate && (user == nil || !user.IsAdmin()) {
+		return nil
+	}
+
+	var media []models.Media
+	query := h.db.Where("status = ?", models.StatusWatching).Order("updated_at desc").Limit(homeWatchingLimit)
+	if h.hideAdult(c) {
+		query = query.Scopes(models.HideAdultMedia)
+	}
+	query.Find(&media)
+	return media
+}
@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"mini-blog/app/models"
+	"mini-blog/app/services"
+	"mini-blog/app/templates"
+	"net/http"
+	"net/url"
+
+	"github.com/labstack/echo/v4"
+)
+
+const localeCookieName = "locale"
+
+// resolveLocale picks the locale for this request: user preference, then cookie, then Accept-Language
+func (h *BaseHandler) resolveLocale(c echo.Context) string {
+	if cached, ok := c.Get("locale").(string); ok {
+		return cached
+	}
+
+	locale := models.LocaleEnglish
+	if user := h.GetCurrentUser(c); user != nil && models.IsValidLocale(user.Locale) {
+		locale = user.Locale
+	} else if cookie, err := c.Cookie(localeCookieName); err == nil && models.IsValidLocale(cookie.Value) {
+		locale = cookie.Value
+	} else if accepted := services.ParseAcceptLanguage(c.Request().Header.Get("Accept-Language")); accepted != "" {
+		locale = accepted
+	}
+
+	c.Set("locale", locale)
+	return locale
+}
+
+// t translates a UI string for the current request
+func (h *BaseHandler) t(c echo.Context, key string) string {
+	return services.Translate(h.resolveLocale(c), key)
+}
+
+// localizePosts loads translations for the request locale and applies them in place
+func (h *BaseHandler) localizePosts(c echo.Context, posts []models.Post) {
+	locale := h.resolveLocale(c)
+	if len(posts) == 0 || locale == models.LocaleEnglish {
+		return
+	}
+
+	ids := make([]uint, len(posts))
+	for i, post := range posts {
+		ids[i] = post.ID
+	}
+
+	var translations []models.PostTranslation
+	h.db.Where("post_id IN ? AND locale = ?", ids, locale).Find(&translations)
+
+	byPost := make(map[uint]models.PostTranslation, len(translations))
+	for _, t := range translations {
+		byPost[t.PostID] = t
+	}
+	for i := range posts {
+		if t, ok := byPost[posts[i].ID]; ok {
+			posts[i].Translations = []models.PostTranslation{t}
+			posts[i].Localize(locale)
+		}
+	}
+}
+
+// SetLocale is the language switcher: remembers the choice in a cookie and on the user profile
+func (h *BaseHandler) SetLocale(c echo.Context) error {
+	locale := c.Param("locale")
+	if !models.IsValidLocale(locale) {
+		return echo.NewHTTPError(http.StatusBadRequest, "Unsupported language")
+	}
+
+	c.SetCookie(&http.Cookie{
+		Name:     localeCookieName,
+		Value:    locale,
+		Path:     "/",
+		MaxAge:   86400 * 365,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	if user := h.GetCurrentUser(c); user != nil {
+		h.db.Model(user).Update("locale", locale)
+	}
+
+	// Only follow same-site referers back to where the switch was made
+	return c.Redirect(http.StatusSeeOther, h.sameSiteReferer(c, "/"))
+}
+
+// Admin translation management
+func (h *BaseHandler) AdminPostTranslations(c echo.Context) error {
+	id, err := h.parseUintParam(c, "id")
+	if err != nil {
+		return err
+	}
+
+	var post models.Post
+	if err := h.db.Preload("Translations").First(&post, id).Error; err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "Post not found")
+	}
+
+	user := c.Get("user").(*models.User)
+	if h.isHTMXRequest(c) {
+		return h.render(c, templates.PostTranslationsPage(post))
+	}
+	return h.render(c, templates.Layout("Translations", templates.PostTranslationsPage(post), c.Request().URL.Path, user))
+}
+
+func (h *BaseHandler) AdminPostTranslationSave(c echo.Context) error {
+	id, err := h.parseUintParam(c, "id")
+	if err != nil {
+		return err
+	}
+
+	var post models.Post
+	if err := h.db.First(&post, id).Error; err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "Post not found")
+	}
+
+	translation := models.PostTranslation{
+		PostID:  post.ID,
+		Locale:  c.FormValue("locale"),
+		Title:   h.trimFormValue(c, "title"),
+		Content: h.trimFormValue(c, "content"),
+	}
+	if !models.IsValidLocale(translation.Locale) || translation.Locale == models.LocaleEnglish {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid language")
+	}
+	if err := h.validator.Struct(translation); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Title and content are required")
+	}
+
+	var existing models.PostTranslation
+	if h.db.Where("post_id = ? AND locale = ?", post.ID, translation.Locale).First(&existing).Error == nil {
+		existing.Title, existing.Content = translation.Title, translation.Content
+		err = h.db.Save(&existing).Error
+	} else {
+		err = h.db.Create(&translation).Error
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to save translation")
+	}
+
+	return h.AdminPostTranslations(c)
+}
+
+func (h *BaseHandler) AdminPostTranslationDelete(c echo.Context) error {
+	id, err := h.parseUintParam(c, "id")
+	if err != nil {
+		return err
+	}
+
+	if err := h.db.Unscoped().Where("post_id = ? AND locale = ?", id, c.Param("locale")).Delete(&models.PostTranslation{}).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to delete translation")
+	}
+
+	return c.NoContent(http.StatusOK)
+}
+
+// sameSiteReferer returns the referring path when it points back at this host, otherwise fallback
+func (h *BaseHandler) sameSiteReferer(c echo.Context, fallback string) string {
+	referer, err := url.Parse(c.Request().Referer())
+	if err != nil || referer.Host != c.Request().Host {
+		return fallback
+	}
+	return referer.RequestURI()
+}
@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"mini-blog/app/models"
+	"mini-blog/app/templates"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// AdminIntegrity checks the TV library for orphaned rows and drifted counts
+func (h *BaseHandler) AdminIntegrity(c echo.Context) error {
+	report, err := models.CheckLibraryIntegrity(h.db)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to check library")
+	}
+	return h.renderIntegrity(c, report, "")
+}
+
+// AdminIntegrityRepair fixes everything the check finds, then shows the library checked again
+func (h *BaseHandler) AdminIntegrityRepair(c echo.Context) error {
+	fixed, err := models.RepairLibraryIntegrity(h.db)
+	if err != nil {
+		log.Printf("Library repair failed: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to repair library")
+	}
+	report, err := models.CheckLibraryIntegrity(h.db)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to check library")
+	}
+	return h.renderIntegrity(c, report, fmt.Sprintf("Repaired %d problem(s)", fixed.Problems()))
+}
+
+func (h *BaseHandler) renderIntegrity(c echo.Context, report models.IntegrityReport, successMessage string) error {
+	page := templates.IntegrityPage(report, successMessage)
+	if h.isHTMXRequest(c) {
+		return h.render(c, page)
+	}
+	return h.render(c, templates.Layout("Library Integrity", page, c.Request().URL.Path, c.Get("user").(*models.User)))
+}
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"mini-blog/app/models"
+	"mini-blog/app/services"
+	"mini-blog/app/templates"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Landing serves / as the admin chose: the blog home, the tracker or a custom page
+func (h *BaseHandler) Landing(c echo.Context) error {
+	landing := h.landing()
+	switch landing.Kind {
+	case models.LandingTracker:
+		return c.Redirect(http.StatusFound, "/tv")
+	case models.LandingPage:
+		page := templates.LandingPage(landing.Title, string(services.MarkdownToHTML(landing.Content)))
+		return h.render(c, templates.Layout(landing.Title, page, c.Request().URL.Path, h.GetCurrentUser(c)))
+	}
+	return h.Home(c)
+}
+
+// AdminLanding picks what / serves
+func (h *BaseHandler) AdminLanding(c echo.Context) error {
+	return h.renderLanding(c, h.landing(), "", "")
+}
+
+// AdminLandingUpdate saves the landing choice and the custom page
+func (h *BaseHandler) AdminLandingUpdate(c echo.Context) error {
+	landing := models.Landing{
+		Kind:    c.FormValue("kind"),
+		Title:   h.trimFormValue(c, "title"),
+		Content: h.trimFormValue(c, "content"),
+	}
+	if !h.landingAvailable(landing.Kind) {
+		return h.renderLanding(c, landing, "", "Choose what the home page serves")
+	}
+	if landing.Kind == models.LandingPage && (landing.Title == "" || landing.Content == "") {
+		return h.renderLanding(c, landing, "", "A custom page needs a title and content")
+	}
+
+	if err := models.SaveSetting(h.db, models.SettingLanding, landing); err != nil {
+		return h.renderLanding(c, landing, "", "Failed to save the landing page")
+	}
+	return h.renderLanding(c, landing, "Landing page saved", "")
+}
+
+func (h *BaseHandler) renderLanding(c echo.Context, landing models.Landing, successMessage, errorMessage string) error {
+	var options []templates.SelectOption
+	for _, kind := range []string{models.LandingBlog, models.LandingTracker, models.LandingPage} {
+		if h.landingAvailable(kind) {
+			options = append(options, templates.SelectOption{Value: kind, Label: models.LandingNames[kind]})
+		}
+	}
+
+	page := templates.LandingSettingsPage(landing, options, successMessage, errorMessage)
+	if h.isHTMXRequest(c) {
+		return h.render(c, page)
+	}
+	return h.render(c, templates.Layout("Landing Page", page, c.Request().URL.Path, c.Get("user").(*models.User)))
+}
+
+// landing returns the saved landing choice. Before one is saved, or when SITE_MODE has since switched
+// its half of the site off, / serves whichever of the blog and the tracker is on.
+func (h *BaseHandler) landing() models.Landing {
+	landing := models.Landing{Kind: models.LandingBlog}
+	models.LoadSetting(h.db, models.SettingLanding, &landing)
+	if !h.landingAvailable(landing.Kind) {
+		landing.Kind = models.LandingBlog
+	}
+	if landing.Kind == models.LandingBlog && !h.cfg.BlogEnabled() {
+		landing.Kind = models.LandingTracker
+	}
+	return landing
+}
+
+// landingAvailable reports whether kind can be served in this SITE_MODE
+func (h *BaseHandler) landingAvailable(kind string) bool {
+	switch kind {
+	case models.LandingBlog:
+		return h.cfg.BlogEnabled()
+	case models.LandingTracker:
+		return h.cfg.TrackerEnabled()
+	}
+	return kind == models.LandingPage
+}
@@ -0,0 +1,213 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"mini-blog/app/models"
+	"mini-blog/app/services"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	libraryPageSize    = 50
+	libraryMaxPageSize = 200
+	libraryPosterBase  = "https://image.tmdb.org/t/p/w342"
+)
+
+// libraryFields maps each selectable card field to the column it needs
+var libraryFields = map[string]string{
+	"id":       "tmdb_id",
+	"type":     "type",
+	"title":    "title",
+	"poster":   "poster_path",
+	"progress": "progress",
+	"total":    "total_episodes",
+	"status":   "status",
+	"anime":    "is_anime",
+	"votes":    "vote_count", // admin-only, see services.FieldVoteCounts
+}
+
+var defaultLibraryFields = []string{"id", "type", "title", "poster", "progress", "total", "status", "anime"}
+
+// LibraryAPI returns compact library cards, newest activity first, with keyset pagination.
+// Query: ?limit=, ?cursor= (from next_cursor), ?fields=id,title,..., ?type=tv|movie, ?status=
+func (h *BaseHandler) LibraryAPI(c echo.Context) error {
+	fields := defaultLibraryFields
+	if raw := c.QueryParam("fields"); raw != "" {
+		fields = nil
+		for _, field := range strings.Split(raw, ",") {
+			field = strings.TrimSpace(field)
+			if _, ok := libraryFields[field]; !ok {
+				return echo.NewHTTPError(http.StatusBadRequest, "Unknown field: "+field)
+			}
+			fields = append(fields, field)
+		}
+	}
+
+	limit, err := strconv.Atoi(c.QueryParam("limit"))
+	if err != nil || limit <= 0 {
+		limit = libraryPageSize
+	}
+	if limit > libraryMaxPageSize {
+		limit = libraryMaxPageSize
+	}
+
+	columns := []string{"tmdb_id", "updated_at"}
+	for _, field := range fields {
+		columns = append(columns, libraryFields[field])
+	}
+
+	db := h.db.Model(&models.Media{}).Select(columns).Order("updated_at desc, tmdb_id desc").Limit(limit + 1)
+	if h.hideAdult(c) {
+		db = db.Scopes(models.HideAdultMedia)
+	}
+	if mediaType := c.QueryParam("type"); mediaType != "" {
+		if !models.IsValidMediaType(mediaType) {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid type")
+		}
+		db = db.Where("type = ?", mediaType)
+	}
+	if status := c.QueryParam("status"); status != "" {
+		if !models.IsValidStatus(status) {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid status")
+		}
+		db = db.Where("status = ?", status)
+	}
+	if cursor := c.QueryParam("cursor"); cursor != "" {
+		updatedAt, tmdbID, err := decodeLibraryCursor(cursor)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid cursor")
+		}
+		db = db.Where("(updated_at, tmdb_id) < (?, ?)", updatedAt, tmdbID)
+	}
+
+	var media []models.Media
+	if err := db.Find(&media).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to load library")
+	}
+
+	var nextCursor string
+	if len(media) > limit {
+		media = media[:limit]
+		last := media[len(media)-1]
+		nextCursor = encodeLibraryCursor(last.UpdatedAt, last.TMDBID)
+	}
+
+	policy := services.PolicyFor(h.GetCurrentUser(c))
+	items := make([]map[string]interface{}, 0, len(media))
+	for _, m := range media {
+		items = append(items, policy.Strip(libraryCard(m, fields)))
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"items":       items,
+		"next_cursor": nextCursor,
+	})
+}
+
+// EpisodeWatchedAPI sets an aired episode's watched flag from JSON {"watched": bool} (default true)
+// and returns the show's updated progress
+func (h *BaseHandler) EpisodeWatchedAPI(c echo.Context) error {
+	user, err := h.requireAdmin(c)
+	if err != nil {
+		return err
+	}
+	tmdbID, season, number, valid := h.parseEpisodeParams(c)
+	if !valid {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid parameters")
+	}
+
+	body := struct {
+		Watched *bool `json:"watched"`
+	}{}
+	if c.Request().ContentLength > 0 {
+		if err := json.NewDecoder(c.Request().Body).Decode(&body); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid JSON")
+		}
+	}
+	watched := body.Watched == nil || *body.Watched
+
+	airedBy := h.airedCutoff(c)
+	var episode models.Episode
+	if err := h.db.Where("tmdb_id = ? AND season_number = ? AND episode_number = ? AND (air_date IS NULL OR air_date <= ?)",
+		tmdbID, season, number, airedBy).First(&episode).Error; err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "No aired episode found")
+	}
+	if err := h.setEpisodeWatched(&episode, watched, airedBy, h.trackerRules(c), user); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to update episode")
+	}
+
+	var media models.Media
+	h.db.Where("tmdb_id = ?", tmdbID).First(&media)
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"id":       tmdbID,
+		"season":   season,
+		"episode":  number,
+		"watched":  watched,
+		"progress": media.Progress,
+		"total":    media.TotalEpisodes,
+		"status":   media.Status,
+	})
+}
+
+func libraryCard(m models.Media, fields []string) map[string]interface{} {
+	card := make(map[string]interface{}, len(fields))
+	for _, field := range fields {
+		switch field {
+		case "id":
+			card["id"] = m.TMDBID
+		case "type":
+			card["type"] = m.Type
+		case "title":
+			card["title"] = m.Title
+		case "poster":
+			if m.PosterPath != "" {
+				card["poster"] = libraryPosterBase + m.PosterPath
+			} else {
+				card["poster"] = nil
+			}
+		case "progress":
+			card["progress"] = m.Progress
+		case "total":
+			card["total"] = m.TotalEpisodes
+		case "status":
+			card["status"] = m.Status
+		case "anime":
+			card["anime"] = m.IsAnime
+		case "votes":
+			card["votes"] = m.VoteCount
+		}
+	}
+	return card
+}
+
+// Cursors are opaque to clients: the last row's updated_at and TMDB ID (never the internal row ID)
+func encodeLibraryCursor(updatedAt time.Time, tmdbID int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d:%d", updatedAt.UnixNano(), tmdbID)))
+}
+
+func decodeLibraryCursor(cursor string) (time.Time, int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	nanos, id, found := strings.Cut(string(raw), ":")
+	if !found {
+		return time.Time{}, 0, fmt.Errorf("malformed cursor")
+	}
+	n, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	tmdbID, err := strconv.Atoi(id)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	return time.Unix(0, n), tmdbID, nil
+}
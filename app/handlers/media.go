@@ -1,87 +1,122 @@
 package handlers
 
 import (
+	"context"
+	"encoding/csv"
+	"errors"
 	"fmt"
+	"log"
 	"mini-blog/app/models"
 	"mini-blog/app/templates"
 	"net/http"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/a-h/templ"
 	"github.com/labstack/echo/v4"
 )
 
-func (h *BaseHandler) MediaFilter(c echo.Context) error {
+const mediaSyncQueueSize = 100
+
+// MediaList renders the library grid from query-string state (?filters=&query=&sort=&page=), so
+// the page works without JavaScript and can be bookmarked; HTMX requests get just the results.
+// Admins can search TMDB instead with ?tmdb_mode=true
+func (h *BaseHandler) MediaList(c echo.Context) error {
 	user := h.GetCurrentUser(c)
-	filters := c.QueryParams()["filters"]
+	state := h.libraryState(c)
 
-	// Remove "all" filter and use unified function
-	if len(filters) == 1 && filters[0] == "all" {
-		filters = nil
+	var results templ.Component
+	if c.QueryParam("tmdb_mode") == "true" && user != nil && user.IsAdmin() {
+		results = h.tmdbSearchResults(c, state.Query, c.QueryParam("type"))
+	} else {
+		var media []models.Media
+		media, state.TotalPages = h.getMediaSorted(state, h.hideAdult(c))
+		results = templates.LibraryResults(media, state, user)
 	}
 
-	media := h.getMediaSorted(filters, "")
-	return h.render(c, templates.MediaGrid(media, user))
+	if h.isHTMXRequest(c) {
+		return h.render(c, results)
+	}
+	return h.render(c, templates.Layout(h.t(c, "nav.tv"), templates.MediaTracker(results, state, user), c.Request().URL.Path, user))
 }
 
-func (h *BaseHandler) MediaList(c echo.Context) error {
+// libraryState reads the library grid's filters, search, sort and page from the query string
+func (h *BaseHandler) libraryState(c echo.Context) templates.LibraryState {
+	state := templates.LibraryState{
+		Query: strings.TrimSpace(c.QueryParam("query")),
+		Sort:  c.QueryParam("sort"),
+		Page:  1,
+	}
+	for _, filter := range c.QueryParams()["filters"] {
+		if models.IsValidFilter(filter) {
+			state.Filters = append(state.Filters, filter)
+		}
+	}
+	if !models.IsValidLibrarySort(state.Sort) {
+		state.Sort = models.SortRecent
+	}
+	if page, err := strconv.Atoi(c.QueryParam("page")); err == nil && page > 1 {
+		state.Page = page
+	}
+	return state
+}
+
+// MediaAiring lists library episodes airing today and over the rest of the week, grouped by day
+func (h *BaseHandler) MediaAiring(c echo.Context) error {
 	user := h.GetCurrentUser(c)
-	media := h.getMediaSorted(nil, "")
+	today := h.airedCutoff(c)
+
+	db := h.db
+	if h.hideAdult(c) {
+		db = db.Scopes(models.HideAdultMedia)
+	}
+	episodes, err := models.EpisodesAiring(db, today, today.AddDate(0, 0, 6))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to load airing episodes")
+	}
+	days := models.GroupAiringByDay(episodes)
 
 	if h.isHTMXRequest(c) {
-		return h.render(c, templates.MediaGrid(media, user))
+		return h.render(c, templates.AiringPage(today, days))
 	}
-	return h.render(c, templates.Layout("TV", templates.MediaTracker(media, user), c.Request().URL.Path, user))
+	return h.render(c, templates.Layout("Airing", templates.AiringPage(today, days), c.Request().URL.Path, user))
 }
 
-func (h *BaseHandler) MediaSearch(c echo.Context) error {
+// tmdbSearchResults searches TMDB and marks which results are already in the library
+func (h *BaseHandler) tmdbSearchResults(c echo.Context, query, mediaType string) templ.Component {
 	user := h.GetCurrentUser(c)
-	query := strings.TrimSpace(c.QueryParam("query"))
-	tmdbMode := c.QueryParam("tmdb_mode") == "true"
-	mediaType := c.QueryParam("type")
-
 	if query == "" {
-		return h.render(c, templates.MediaGrid([]models.Media{}, user))
+		return templates.MediaGrid([]models.Media{}, user)
+	}
+	if mediaType == "" {
+		mediaType = "tv" // Default to TV if not specified
 	}
 
-	if tmdbMode {
-		// TMDB search with specific type
-		if user == nil || !user.IsAdmin() {
-			return echo.NewHTTPError(http.StatusForbidden, "Admin access required for TMDB search")
-		}
-		if mediaType == "" {
-			mediaType = "tv" // Default to TV if not specified
-		}
+	results, err := h.metadata.ForContext(c.Request().Context(), mediaType).Search(query, mediaType)
+	if err != nil {
+		return templates.ErrorMessage("Failed to search TMDB")
+	}
 
-		results, err := h.tmdbService.Search(query, mediaType)
-		if err != nil {
-			return h.render(c, templates.ErrorMessage("Failed to search TMDB"))
-		}
+	// Enrich with library status
+	var enrichedResults []templates.EnrichedSearchResult
+	for _, result := range results {
+		var localMedia models.Media
+		inLibrary := h.db.Where("tmdb_id = ?", result.ID).First(&localMedia).Error == nil
 
-		// Enrich with library status
-		var enrichedResults []templates.EnrichedSearchResult
-		for _, result := range results {
-			var localMedia models.Media
-			inLibrary := models.DB.Where("tmdb_id = ?", result.ID).First(&localMedia).Error == nil
-
-			enrichedResults = append(enrichedResults, templates.EnrichedSearchResult{
-				SearchResult: result,
-				InLibrary:    inLibrary,
-				LocalMedia:   localMedia,
-			})
-		}
+		enrichedResults = append(enrichedResults, templates.EnrichedSearchResult{
+			SearchResult: result,
+			InLibrary:    inLibrary,
+			LocalMedia:   localMedia,
+		})
+	}
 
-		searchResults := templates.SearchResults{
-			Results:   enrichedResults,
-			MediaType: mediaType,
-		}
-		return h.render(c, templates.MediaGrid(searchResults, user))
-	} else {
-		// Library search (all types) with last watched sorting
-		media := h.getMediaSorted(nil, query)
-		return h.render(c, templates.MediaGrid(media, user))
+	searchResults := templates.SearchResults{
+		Results:   enrichedResults,
+		MediaType: mediaType,
 	}
+	return templates.MediaGrid(searchResults, user)
 }
 
 func (h *BaseHandler) MediaAdd(c echo.Context) error {
@@ -100,26 +135,65 @@ func (h *BaseHandler) MediaAdd(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "Invalid input")
 	}
 
+	_, err = h.addMedia(tmdbID, mediaType, status, c.FormValue("is_anime"), h.airedCutoff(c), h.GetCurrentUser(c))
+	if errors.Is(err, errAlreadyTracking) {
+		return h.renderError(c, "Already tracking")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	// If HTMX request, stay in modal and show updated library version
+	if h.isHTMXRequest(c) {
+		media, seasons, episodes, allEpisodes, err := h.getMediaModalData(c.Request().Context(), tmdbID, mediaType, true)
+		if err != nil {
+			return h.render(c, templates.ErrorModal(err.Error()))
+		}
+		user := h.GetCurrentUser(c)
+		return h.renderPartial(c, h.newPartial(templates.MediaDetailModal(media, seasons, episodes, allEpisodes, user)).Card(*media, user))
+	}
+
+	// For non-HTMX requests or library updates, redirect as before
+	return c.HTML(http.StatusOK, `<script>
+		closeModal();
+		window.location.replace('/tv');
+	</script>`)
+}
+
+var errAlreadyTracking = errors.New("Already tracking")
+
+// addMedia fetches a title from TMDB and adds it to the library with status, storing every season and
+// episode of a show. animeChoice is "true" or "false" to override anime detection, or "" to keep it;
+// adding as completed marks episodes aired by airedBy watched and drafts a milestone post for user.
+func (h *BaseHandler) addMedia(tmdbID int, mediaType, status, animeChoice string, airedBy time.Time, user *models.User) (*models.Media, error) {
 	// Check if media already exists
 	var existing models.Media
-	if models.DB.Where("tmdb_id = ?", tmdbID).First(&existing).Error == nil {
-		return h.renderError(c, "Already tracking")
+	if h.db.Where("tmdb_id = ?", tmdbID).First(&existing).Error == nil {
+		return nil, errAlreadyTracking
 	}
 
-	// Fetch from TMDB
-	fetchedMedia, err := h.tmdbService.GetDetails(tmdbID, mediaType)
+	// Fetch from the type's provider
+	provider := h.metadata.For(mediaType)
+	fetchedMedia, err := provider.GetDetails(tmdbID, mediaType)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch media")
+		return nil, errors.New("Failed to fetch media")
 	}
 
 	// Set tracking fields
 	fetchedMedia.Status = status
 	fetchedMedia.AddedAt = time.Now()
-	fetchedMedia.IsAnime = c.FormValue("is_anime") == "true"
+	// Detection already set IsAnime; an explicit choice on the add form overrides it
+	if animeChoice != "" && (animeChoice == "true") != fetchedMedia.IsAnime {
+		fetchedMedia.ToggleAnime()
+	}
 
 	// Get total episodes for TV shows and store all episode data
+	var syncErrs []error
 	if mediaType == "tv" {
-		if detailedSeasons, err := h.tmdbService.GetDetailedSeasons(tmdbID); err == nil {
+		detailedSeasons, err := provider.GetDetailedSeasons(tmdbID)
+		if err != nil {
+			syncErrs = append(syncErrs, fmt.Errorf("seasons: %w", err))
+		} else {
 			totalEpisodes := 0
 			for _, season := range detailedSeasons {
 				if season.SeasonNumber > 0 { // Exclude season 0 (specials)
@@ -127,25 +201,28 @@ func (h *BaseHandler) MediaAdd(c echo.Context) error {
 
 					// Store season
 					var existingSeason models.Season
-					if models.DB.Where("tmdb_id = ? AND season_number = ?", tmdbID, season.SeasonNumber).First(&existingSeason).Error != nil {
-						models.DB.Create(&season)
+					if h.db.Where("tmdb_id = ? AND season_number = ?", tmdbID, season.SeasonNumber).First(&existingSeason).Error != nil {
+						h.db.Create(&season)
 					}
 
 					// Store all episodes for this season
-					if detailedEpisodes, err := h.tmdbService.GetDetailedEpisodes(tmdbID, season.SeasonNumber); err == nil {
+					detailedEpisodes, err := provider.GetDetailedEpisodes(tmdbID, season.SeasonNumber)
+					if err != nil {
+						syncErrs = append(syncErrs, fmt.Errorf("season %d episodes: %w", season.SeasonNumber, err))
+					} else {
 						for _, episode := range detailedEpisodes {
 							var existingEpisode models.Episode
-							if models.DB.Where("tmdb_id = ? AND season_number = ? AND episode_number = ?",
+							if h.db.Where("tmdb_id = ? AND season_number = ? AND episode_number = ?",
 								tmdbID, season.SeasonNumber, episode.EpisodeNumber).First(&existingEpisode).Error != nil {
 
 								// If adding as completed, mark aired episodes as watched
-								if status == "completed" && (episode.AirDate == nil || episode.AirDate.Before(time.Now())) {
+								if status == "completed" && (episode.AirDate == nil || !episode.AirDate.After(airedBy)) {
 									episode.Watched = true
 									now := time.Now()
 									episode.WatchedAt = &now
 								}
 
-								models.DB.Create(&episode)
+								h.db.Create(&episode)
 							}
 						}
 					}
@@ -156,33 +233,39 @@ func (h *BaseHandler) MediaAdd(c echo.Context) error {
 			// Set progress if completed (count only aired episodes)
 			if status == "completed" {
 				var airedWatchedCount int64
-				models.DB.Model(&models.Episode{}).Where("tmdb_id = ? AND watched = ? AND air_date <= ?", tmdbID, true, time.Now()).Count(&airedWatchedCount)
+				h.db.Model(&models.Episode{}).Where("tmdb_id = ? AND watched = ? AND air_date <= ?", tmdbID, true, airedBy).Count(&airedWatchedCount)
 				fetchedMedia.Progress = int(airedWatchedCount)
 			}
 		}
 	}
 
-	if err := models.DB.Create(fetchedMedia).Error; err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to add to tracker")
+	// A show missing episodes is flagged now; the sync below retries them
+	fetchedMedia.RecordSyncResult(errors.Join(syncErrs...), time.Now())
+	if err := h.db.Create(fetchedMedia).Error; err != nil {
+		return nil, errors.New("Failed to add to tracker")
+	}
+	if status == models.StatusCompleted {
+		h.draftMilestonePost(*fetchedMedia, user)
 	}
 
 	// Force immediate sync to ensure correct InProduction status in library
 	h.SyncMedia(tmdbID)
+	return fetchedMedia, nil
+}
 
-	// If HTMX request, stay in modal and show updated library version
-	if h.isHTMXRequest(c) {
-		media, seasons, episodes, allEpisodes, err := h.getMediaModalData(tmdbID, mediaType, true)
-		if err != nil {
-			return h.render(c, templates.ErrorModal(err.Error()))
-		}
-		return h.renderWithCardUpdate(c, templates.MediaDetailModal(media, seasons, episodes, allEpisodes, h.GetCurrentUser(c)), *media)
-	}
+// libraryMatch finds the library title best matching query: the shortest title containing it
+func (h *BaseHandler) libraryMatch(query string) (models.Media, error) {
+	var media models.Media
+	err := h.db.Where("title ILIKE ?", "%"+query+"%").Order("LENGTH(title), title").First(&media).Error
+	return media, err
+}
 
-	// For non-HTMX requests or library updates, redirect as before
-	return c.HTML(http.StatusOK, `<script>
-		closeModal();
-		window.location.replace('/tv');
-	</script>`)
+// nextEpisode is a show's first unwatched episode outside the specials, aired or not
+func (h *BaseHandler) nextEpisode(tmdbID int) (models.Episode, error) {
+	var next models.Episode
+	err := h.db.Where("tmdb_id = ? AND season_number > 0 AND watched = ?", tmdbID, false).
+		Order("season_number, episode_number").First(&next).Error
+	return next, err
 }
 
 func (h *BaseHandler) MediaUpdate(c echo.Context) error {
@@ -197,7 +280,7 @@ func (h *BaseHandler) MediaUpdate(c echo.Context) error {
 	}
 
 	var media models.Media
-	if err := models.DB.First(&media, id).Error; err != nil {
+	if err := h.db.First(&media, id).Error; err != nil {
 		return echo.NewHTTPError(http.StatusNotFound, "Media not found")
 	}
 
@@ -219,7 +302,7 @@ func (h *BaseHandler) MediaUpdate(c echo.Context) error {
 
 	media.Notes = h.trimFormValue(c, "notes")
 
-	if err := models.DB.Save(&media).Error; err != nil {
+	if err := h.db.Save(&media).Error; err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to update media")
 	}
 
@@ -237,7 +320,7 @@ func (h *BaseHandler) MediaDelete(c echo.Context) error {
 		return err
 	}
 
-	if err := models.DB.Delete(&models.Media{}, id).Error; err != nil {
+	if err := h.db.Delete(&models.Media{}, id).Error; err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to delete media")
 	}
 
@@ -252,25 +335,87 @@ func (h *BaseHandler) MediaModal(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "Invalid input")
 	}
 
-	useLocal := models.DB.Where("tmdb_id = ?", tmdbID).First(&models.Media{}).Error == nil
+	ctx := c.Request().Context()
+	var local models.Media
+	useLocal := h.db.Where("tmdb_id = ?", tmdbID).First(&local).Error == nil
 
-	// Sync if stale (24h)
-	if useLocal {
-		var media models.Media
-		models.DB.Where("tmdb_id = ?", tmdbID).First(&media)
-		if media.LastSyncedAt == nil || media.LastSyncedAt.Before(time.Now().Add(-24*time.Hour)) {
-			h.SyncMedia(tmdbID)
-		}
+	// Serve the saved copy straight away; a stale one (24h) is refreshed in the background
+	// unless an admin turned syncing off for it
+	syncing := false
+	if useLocal && local.SyncSchedule != models.SyncScheduleNever &&
+		(local.LastSyncedAt == nil || local.LastSyncedAt.Before(time.Now().Add(-24*time.Hour))) && local.SyncDue(time.Now()) {
+		syncing = h.queueMediaSync(tmdbID)
 	}
 
-	media, seasons, episodes, allEpisodes, err := h.getMediaModalData(tmdbID, mediaType, useLocal)
+	media, seasons, episodes, allEpisodes, err := h.getMediaModalData(ctx, tmdbID, mediaType, useLocal)
 	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return h.render(c, templates.SlowMediaModal(tmdbID, mediaType))
+		}
 		return h.render(c, templates.ErrorModal(err.Error()))
 	}
+	if media.IsAdult() && h.hideAdult(c) {
+		return echo.NewHTTPError(http.StatusNotFound, "Media not found")
+	}
 
+	if syncing {
+		return h.render(c, templates.SyncingMediaDetailModal(media, seasons, episodes, allEpisodes, user))
+	}
 	return h.render(c, templates.MediaDetailModal(media, seasons, episodes, allEpisodes, user))
 }
 
+// MediaModalRefresh is polled by a syncing modal: 204 while the sync runs, then the refreshed modal
+func (h *BaseHandler) MediaModalRefresh(c echo.Context) error {
+	tmdbID, mediaType, valid := h.parseMediaParams(c)
+	if !valid {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid input")
+	}
+
+	if _, pending := h.mediaSyncs.Load(tmdbID); pending {
+		return c.NoContent(http.StatusNoContent)
+	}
+
+	media, seasons, episodes, allEpisodes, err := h.getMediaModalData(c.Request().Context(), tmdbID, mediaType, true)
+	if err != nil {
+		return h.render(c, templates.ErrorModal(err.Error()))
+	}
+	if media.IsAdult() && h.hideAdult(c) {
+		return echo.NewHTTPError(http.StatusNotFound, "Media not found")
+	}
+	return h.render(c, templates.MediaDetailModal(media, seasons, episodes, allEpisodes, h.GetCurrentUser(c)))
+}
+
+// queueMediaSync schedules a background TMDB sync and reports whether one is pending
+func (h *BaseHandler) queueMediaSync(tmdbID int) bool {
+	if _, pending := h.mediaSyncs.LoadOrStore(tmdbID, true); pending {
+		return true
+	}
+
+	select {
+	case h.mediaSyncQueue <- tmdbID:
+		return true
+	default:
+		// Queue is full; the next open will try again
+		h.mediaSyncs.Delete(tmdbID)
+		return false
+	}
+}
+
+// RunMediaSyncWorker syncs queued media one at a time, off the request path
+func (h *BaseHandler) RunMediaSyncWorker() {
+	for tmdbID := range h.mediaSyncQueue {
+		// Pages already showed the stored data; the next open queues the sync again
+		if h.tmdbThrottled() {
+			h.mediaSyncs.Delete(tmdbID)
+			continue
+		}
+		if err := h.SyncMedia(tmdbID); err != nil {
+			log.Printf("Background sync of %d failed: %v", tmdbID, err)
+		}
+		h.mediaSyncs.Delete(tmdbID)
+	}
+}
+
 func (h *BaseHandler) MediaEpisodes(c echo.Context) error {
 	user := h.GetCurrentUser(c)
 	tmdbID, _ := strconv.Atoi(c.Param("tmdbId"))
@@ -282,47 +427,73 @@ func (h *BaseHandler) MediaEpisodes(c echo.Context) error {
 
 	// Check if show is in library first
 	var media models.Media
-	showInLibrary := models.DB.Where("tmdb_id = ?", tmdbID).First(&media).Error == nil
+	showInLibrary := h.db.Where("tmdb_id = ?", tmdbID).First(&media).Error == nil
+	if h.hideAdult(c) {
+		// A preview has to ask the provider whether the show is adult, as the modal did
+		if !showInLibrary {
+			if details, err := h.getMediaData(c.Request().Context(), tmdbID, models.MediaTypeTV, false); err == nil {
+				media = *details
+			}
+		}
+		if media.IsAdult() {
+			return echo.NewHTTPError(http.StatusNotFound, "Media not found")
+		}
+	}
 
 	var episodes []models.Episode
 	var allEpisodes []models.Episode
 
 	if showInLibrary {
 		// Show is in library - get episodes from local database
-		models.DB.Where("tmdb_id = ? AND season_number = ?", tmdbID, season).Order("episode_number ASC").Find(&episodes)
-		models.DB.Where("tmdb_id = ?", tmdbID).Order("season_number ASC, episode_number ASC").Find(&allEpisodes)
+		h.db.Where("tmdb_id = ? AND season_number = ?", tmdbID, season).Order("episode_number ASC").Find(&episodes)
+		h.db.Where("tmdb_id = ?", tmdbID).Order("season_number ASC, episode_number ASC").Find(&allEpisodes)
 
 		// Get seasons for response
 		var seasons []models.Season
-		models.DB.Where("tmdb_id = ?", tmdbID).Order("season_number ASC").Find(&seasons)
+		h.db.Where("tmdb_id = ?", tmdbID).Order("season_number ASC").Find(&seasons)
 
-		return h.render(c, templates.SeasonResponse(media, seasons, episodes, allEpisodes, season, user, "episodes"))
+		return h.renderPartial(c, h.newPartial(templates.EpisodesListWithWatched(episodes, user)).
+			Update("episode-chart", templates.EpisodeRatingChart(allEpisodes, season)).
+			Update("season-buttons", templates.SeasonButtonsContainer(media, seasons, allEpisodes, user, season)))
 	} else {
-		// Show not in library - fetch from TMDB for preview
-		if tmdbEpisodes, err := h.tmdbService.GetEpisodes(tmdbID, season); err == nil {
-			for _, tmdbEpisode := range tmdbEpisodes {
-				var airDate *time.Time
-				if tmdbEpisode.AirDate != "" {
-					if parsed, err := time.Parse("2006-01-02", tmdbEpisode.AirDate); err == nil {
-						airDate = &parsed
-					}
-				}
-
-				episodes = append(episodes, models.Episode{
-					TMDBID:        tmdbID,
-					SeasonNumber:  season,
-					EpisodeNumber: tmdbEpisode.EpisodeNumber,
-					Name:          tmdbEpisode.Name,
-					Overview:      tmdbEpisode.Overview,
-					AirDate:       airDate,
-					StillPath:     tmdbEpisode.StillPath,
-				})
-			}
+		// Show not in library - fetch from the provider for preview
+		if previewEpisodes, err := h.metadata.ForContext(c.Request().Context(), models.MediaTypeTV).GetDetailedEpisodes(tmdbID, season); err == nil {
+			episodes = previewEpisodes
 		}
 		return h.render(c, templates.EpisodesListWithWatched(episodes, user))
 	}
 }
 
+// MediaSeasonSync refreshes a single season from the modal and re-renders its episodes
+func (h *BaseHandler) MediaSeasonSync(c echo.Context) error {
+	user := h.GetCurrentUser(c)
+	tmdbID, _ := strconv.Atoi(c.Param("tmdbId"))
+	seasonNumber, err := strconv.Atoi(c.Param("season"))
+	if tmdbID == 0 || err != nil || seasonNumber < 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid input")
+	}
+
+	var media models.Media
+	if err := h.db.Where("tmdb_id = ? AND type = ?", tmdbID, models.MediaTypeTV).First(&media).Error; err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "Show not found")
+	}
+	// Full syncs skip the specials, so season 0 is created by its first refresh
+	var season models.Season
+	if err := h.db.Where("tmdb_id = ? AND season_number = ?", tmdbID, seasonNumber).First(&season).Error; err != nil && seasonNumber != 0 {
+		return echo.NewHTTPError(http.StatusNotFound, "Season not found")
+	}
+
+	if err := h.syncSeason(media, seasonNumber); err != nil {
+		log.Printf("Season sync of %d season %d failed: %v", tmdbID, seasonNumber, err)
+		return echo.NewHTTPError(http.StatusBadGateway, "Failed to refresh season")
+	}
+
+	episodes, seasons, allEpisodes, media := h.getSeasonData(h.db, tmdbID, seasonNumber)
+	return h.renderPartial(c, h.newPartial(templates.EpisodesListWithWatched(episodes, user)).
+		Update("episode-chart", templates.EpisodeRatingChart(allEpisodes, seasonNumber)).
+		Update("season-buttons", templates.SeasonButtonsContainer(media, seasons, allEpisodes, user, seasonNumber)))
+}
+
 func (h *BaseHandler) MarkEpisodeWatched(c echo.Context) error {
 	return h.markEpisodes(c, "episode")
 }
@@ -335,6 +506,38 @@ func (h *BaseHandler) MarkShowWatched(c echo.Context) error {
 	return h.markEpisodes(c, "show")
 }
 
+// MarkEpisodeRange marks every aired episode from from_season/from_episode (default S01E01)
+// through :season/:episode as watched in a single UPDATE. Already-watched episodes keep their date.
+func (h *BaseHandler) MarkEpisodeRange(c echo.Context) error {
+	tmdbID, toSeason, toEpisode, valid := h.parseEpisodeParams(c)
+	if !valid {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid parameters")
+	}
+
+	fromSeason, fromEpisode := 1, 1
+	if season, err := strconv.Atoi(c.FormValue("from_season")); err == nil && season > 0 {
+		fromSeason = season
+		if episode, err := strconv.Atoi(c.FormValue("from_episode")); err == nil && episode > 0 {
+			fromEpisode = episode
+		}
+	}
+	if fromSeason > toSeason || (fromSeason == toSeason && fromEpisode > toEpisode) {
+		return echo.NewHTTPError(http.StatusBadRequest, "Range start is after its end")
+	}
+
+	if err := h.db.Model(&models.Episode{}).
+		Where("tmdb_id = ? AND season_number > 0 AND watched = ?", tmdbID, false).
+		Where("(season_number, episode_number) >= (?, ?) AND (season_number, episode_number) <= (?, ?)", fromSeason, fromEpisode, toSeason, toEpisode).
+		Where("air_date <= ?", h.airedCutoff(c)).
+		Updates(map[string]interface{}{"watched": true, "watched_at": time.Now()}).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to update episodes")
+	}
+
+	h.recordEpisodesWatched(h.GetCurrentUser(c), tmdbID, fmt.Sprintf("S%dE%d to S%dE%d", fromSeason, fromEpisode, toSeason, toEpisode))
+	h.updateMediaProgress(tmdbID, h.airedCutoff(c), h.trackerRules(c), h.GetCurrentUser(c))
+	return h.renderEpisodeRange(c, tmdbID, toSeason)
+}
+
 func (h *BaseHandler) MediaUpdateByTMDB(c echo.Context) error {
 	return h.updateMediaAndRefreshModal(c, func(media *models.Media) error {
 		newStatus := h.trimFormValue(c, "status")
@@ -348,15 +551,15 @@ func (h *BaseHandler) MediaUpdateByTMDB(c echo.Context) error {
 		// If status is set to completed, mark all aired episodes as watched
 		if newStatus == "completed" && media.Type == "tv" {
 			now := time.Now()
-			models.DB.Model(&models.Episode{}).Where("tmdb_id = ? AND air_date <= ?", media.TMDBID, time.Now()).
+			h.db.Model(&models.Episode{}).Where("tmdb_id = ? AND air_date <= ?", media.TMDBID, h.airedCutoff(c)).
 				Updates(models.Episode{Watched: true, WatchedAt: &now})
 
 			var totalWatched int64
-			models.DB.Model(&models.Episode{}).Where("tmdb_id = ? AND watched = ?", media.TMDBID, true).Count(&totalWatched)
+			h.db.Model(&models.Episode{}).Where("tmdb_id = ? AND watched = ?", media.TMDBID, true).Count(&totalWatched)
 			media.Progress = int(totalWatched)
 		}
 
-		return models.DB.Save(media).Error
+		return h.db.Save(media).Error
 	})
 }
 
@@ -374,25 +577,63 @@ func (h *BaseHandler) MediaStatusUpdate(c echo.Context) error {
 		if media.Type == "tv" {
 			if newStatus == "completed" {
 				now := time.Now()
-				models.DB.Model(&models.Episode{}).Where("tmdb_id = ? AND air_date <= ?", media.TMDBID, time.Now()).Updates(models.Episode{Watched: true, WatchedAt: &now})
+				h.db.Model(&models.Episode{}).Where("tmdb_id = ? AND air_date <= ?", media.TMDBID, h.airedCutoff(c)).Updates(models.Episode{Watched: true, WatchedAt: &now})
 
 				var totalWatched int64
-				models.DB.Model(&models.Episode{}).Where("tmdb_id = ? AND watched = ?", media.TMDBID, true).Count(&totalWatched)
+				h.db.Model(&models.Episode{}).Where("tmdb_id = ? AND watched = ?", media.TMDBID, true).Count(&totalWatched)
 				media.Progress = int(totalWatched)
-			} else if newStatus == "planned" {
-				models.DB.Model(&models.Episode{}).Where("tmdb_id = ?", media.TMDBID).Updates(map[string]interface{}{"watched": false, "watched_at": nil})
+			} else if newStatus == "planned" && h.trackerRules(c).PlannedResets {
+				h.db.Model(&models.Episode{}).Where("tmdb_id = ?", media.TMDBID).Updates(map[string]interface{}{"watched": false, "watched_at": nil})
 				media.Progress = 0
 			}
 		}
 
-		return models.DB.Save(media).Error
+		return h.db.Save(media).Error
 	})
 }
 
 func (h *BaseHandler) MediaToggleAnime(c echo.Context) error {
 	return h.updateMediaAndRefreshModal(c, func(media *models.Media) error {
-		media.IsAnime = !media.IsAnime
-		return models.DB.Save(media).Error
+		media.ToggleAnime()
+		return h.db.Save(media).Error
+	})
+}
+
+// MediaSyncSchedule sets how often background sync refreshes a title
+func (h *BaseHandler) MediaSyncSchedule(c echo.Context) error {
+	return h.updateMediaAndRefreshModal(c, func(media *models.Media) error {
+		schedule := c.FormValue("schedule")
+		if !models.IsValidSyncSchedule(schedule) {
+			return errors.New("Invalid sync schedule")
+		}
+		return h.db.Model(media).Update("sync_schedule", schedule).Error
+	})
+}
+
+// MediaMetadataProvider switches where a show's episodes come from (provider, plus tvdb_id for TVDB)
+// and resyncs it, dropping episodes the new source doesn't list
+func (h *BaseHandler) MediaMetadataProvider(c echo.Context) error {
+	return h.updateMediaAndRefreshModal(c, func(media *models.Media) error {
+		provider := c.FormValue("provider")
+		if media.Type != models.MediaTypeTV || !models.IsValidProvider(provider) {
+			return errors.New("Invalid metadata provider")
+		}
+		if provider == models.ProviderTVDB {
+			if !h.tvdb.Enabled() {
+				return errors.New("TVDB is not configured")
+			}
+			if tvdbID, err := strconv.Atoi(h.trimFormValue(c, "tvdb_id")); err == nil && tvdbID > 0 {
+				media.TVDBID = tvdbID
+			}
+			if media.TVDBID == 0 {
+				return errors.New("Enter the show's TVDB ID")
+			}
+		}
+		media.MetadataProvider = provider
+		if err := h.db.Save(media).Error; err != nil {
+			return err
+		}
+		return h.syncMedia(media.TMDBID, true)
 	})
 }
 
@@ -414,15 +655,15 @@ func (h *BaseHandler) MediaRemove(c echo.Context) error {
 	}
 
 	// Hard delete all related data (not soft delete)
-	if err := models.DB.Unscoped().Where("tmdb_id = ?", tmdbID).Delete(&models.Episode{}).Error; err != nil {
+	if err := h.db.Unscoped().Where("tmdb_id = ?", tmdbID).Delete(&models.Episode{}).Error; err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to delete episodes")
 	}
 
-	if err := models.DB.Unscoped().Where("tmdb_id = ?", tmdbID).Delete(&models.Season{}).Error; err != nil {
+	if err := h.db.Unscoped().Where("tmdb_id = ?", tmdbID).Delete(&models.Season{}).Error; err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to delete seasons")
 	}
 
-	if err := models.DB.Unscoped().Where("tmdb_id = ?", tmdbID).Delete(&models.Media{}).Error; err != nil {
+	if err := h.db.Unscoped().Where("tmdb_id = ?", tmdbID).Delete(&models.Media{}).Error; err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to delete media")
 	}
 
@@ -437,3 +678,161 @@ func (h *BaseHandler) MediaRemove(c echo.Context) error {
 		window.location.href = '/tv';
 	</script>`)
 }
+
+// MediaNotes renders a library item's notes (also the cancel target of the notes editor)
+func (h *BaseHandler) MediaNotes(c echo.Context) error {
+	media, err := h.notesMedia(c)
+	if err != nil {
+		return err
+	}
+	return h.render(c, templates.MediaNotes(*media, h.GetCurrentUser(c)))
+}
+
+// MediaNotesEdit swaps the rendered notes for a markdown editor
+func (h *BaseHandler) MediaNotesEdit(c echo.Context) error {
+	media, err := h.notesMedia(c)
+	if err != nil {
+		return err
+	}
+	return h.render(c, templates.MediaNotesForm(*media))
+}
+
+// MediaNotesUpdate saves just the notes, without touching the rest of the item
+func (h *BaseHandler) MediaNotesUpdate(c echo.Context) error {
+	media, err := h.notesMedia(c)
+	if err != nil {
+		return err
+	}
+
+	media.Notes = h.trimFormValue(c, "notes")
+	if err := h.db.Model(media).Update("notes", media.Notes).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to save notes")
+	}
+	return h.render(c, templates.MediaNotes(*media, h.GetCurrentUser(c)))
+}
+
+func (h *BaseHandler) notesMedia(c echo.Context) (*models.Media, error) {
+	tmdbID, _ := strconv.Atoi(c.Param("tmdbId"))
+	var media models.Media
+	if err := h.db.Where("tmdb_id = ?", tmdbID).First(&media).Error; err != nil || (media.IsAdult() && h.hideAdult(c)) {
+		return nil, echo.NewHTTPError(http.StatusNotFound, "Media not found")
+	}
+	return &media, nil
+}
+
+// maxPosterChoices caps how many alternate posters the picker shows
+const maxPosterChoices = 24
+
+// tmdbImagePath matches the file paths TMDB hands out for artwork
+var tmdbImagePath = regexp.MustCompile(`^/[A-Za-z0-9_-]+\.(jpg|png)$`)
+
+// MediaPosters opens the poster picker with TMDB's alternate posters
+func (h *BaseHandler) MediaPosters(c echo.Context) error {
+	tmdbID, _ := strconv.Atoi(c.Param("tmdbId"))
+	var media models.Media
+	if err := h.db.Where("tmdb_id = ?", tmdbID).First(&media).Error; err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "Media not found")
+	}
+
+	images, err := h.metadata.ForContext(c.Request().Context(), media.Type).GetImages(tmdbID, media.Type)
+	if err != nil {
+		return h.render(c, templates.ErrorMessage("Couldn't load posters from TMDB"))
+	}
+	posters := images.Posters
+	if len(posters) > maxPosterChoices {
+		posters = posters[:maxPosterChoices]
+	}
+	return h.render(c, templates.PosterPicker(media, posters))
+}
+
+// MediaPosterSelect stores the chosen poster; an empty path goes back to TMDB's default
+func (h *BaseHandler) MediaPosterSelect(c echo.Context) error {
+	tmdbID, _ := strconv.Atoi(c.Param("tmdbId"))
+	var media models.Media
+	if err := h.db.Where("tmdb_id = ?", tmdbID).First(&media).Error; err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "Media not found")
+	}
+
+	path := c.FormValue("path")
+	switch {
+	case path == "":
+		details, err := h.metadata.ForContext(c.Request().Context(), media.Type).GetDetails(tmdbID, media.Type)
+		if err != nil {
+			return h.render(c, templates.ErrorMessage("Couldn't load the default poster from TMDB"))
+		}
+		media.PosterPath = details.PosterPath
+	case tmdbImagePath.MatchString(path):
+		media.PosterPath = path
+	default:
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid poster")
+	}
+	media.CustomPosterPath = path
+	media.PosterBlurhash = h.posterBlurhash(media.PosterPath)
+
+	if err := h.db.Model(&media).Select("poster_path", "custom_poster_path", "poster_blurhash").Updates(&media).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to save poster")
+	}
+
+	// The picker closes (empty main response) while the modal poster and grid card update
+	return h.renderPartial(c, h.newPartial(nil).
+		Update("media-poster", templates.MediaPoster(media)).
+		Card(media, h.GetCurrentUser(c)))
+}
+
+// MediaExportCSV downloads a show's episode checklist with watched flags and dates
+func (h *BaseHandler) MediaExportCSV(c echo.Context) error {
+	tmdbID, _ := strconv.Atoi(c.Param("tmdbId"))
+	if tmdbID == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid TMDB ID")
+	}
+
+	var media models.Media
+	if err := h.db.Where("tmdb_id = ? AND type = ?", tmdbID, models.MediaTypeTV).First(&media).Error; err != nil || (media.IsAdult() && h.hideAdult(c)) {
+		return echo.NewHTTPError(http.StatusNotFound, "Show not in library")
+	}
+
+	var episodes []models.Episode
+	h.db.Where("tmdb_id = ?", tmdbID).Order("season_number ASC, episode_number ASC").Find(&episodes)
+
+	slug := h.generateSlug(media.Title)
+	if slug == "" {
+		slug = strconv.Itoa(tmdbID)
+	}
+	filename := slug + "-episodes.csv"
+	c.Response().Header().Set(echo.HeaderContentType, "text/csv; charset=utf-8")
+	c.Response().Header().Set(echo.HeaderContentDisposition, `attachment; filename="`+filename+`"`)
+	c.Response().WriteHeader(http.StatusOK)
+
+	w := csv.NewWriter(c.Response())
+	w.Write([]string{"show", "season", "episode", "title", "air_date", "runtime", "watched", "watched_at"})
+	for _, episode := range episodes {
+		airDate, watchedAt := "", ""
+		if episode.AirDate != nil {
+			airDate = episode.AirDate.Format("2006-01-02")
+		}
+		if episode.WatchedAt != nil {
+			watchedAt = episode.WatchedAt.In(h.userLocation(c)).Format("2006-01-02 15:04")
+		}
+		w.Write([]string{
+			csvText(media.Title),
+			strconv.Itoa(episode.SeasonNumber),
+			strconv.Itoa(episode.EpisodeNumber),
+			csvText(episode.Name),
+			airDate,
+			strconv.Itoa(episode.Runtime),
+			strconv.FormatBool(episode.Watched),
+			watchedAt,
+		})
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// csvText makes a free-text cell safe to open in a spreadsheet: text starting like a formula gets a leading
+// apostrophe, so a title such as "=HYPERLINK(...)" shows as text instead of running
+func csvText(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
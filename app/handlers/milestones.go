@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"fmt"
+	"mini-blog/app/models"
+	"mini-blog/app/services"
+	"mini-blog/app/templates"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// AdminMilestones edits the post drafted when a library title is completed
+func (h *BaseHandler) AdminMilestones(c echo.Context) error {
+	return h.renderMilestones(c, h.milestonePosts(), "", "")
+}
+
+// AdminMilestonesUpdate saves the milestone template and whether it is on
+func (h *BaseHandler) AdminMilestonesUpdate(c echo.Context) error {
+	milestones := models.MilestonePosts{
+		Enabled: c.FormValue("enabled") == "on",
+		Title:   h.trimFormValue(c, "title"),
+		Content: h.trimFormValue(c, "content"),
+	}
+	if milestones.Title == "" {
+		return h.renderMilestones(c, milestones, "", "Title is required")
+	}
+
+	if err := models.SaveSetting(h.db, models.SettingMilestones, milestones); err != nil {
+		return h.renderMilestones(c, milestones, "", "Failed to save milestone posts")
+	}
+	return h.renderMilestones(c, milestones, "Milestone posts saved", "")
+}
+
+func (h *BaseHandler) renderMilestones(c echo.Context, milestones models.MilestonePosts, successMessage, errorMessage string) error {
+	page := templates.MilestonesPage(milestones, successMessage, errorMessage)
+	if h.isHTMXRequest(c) {
+		return h.render(c, page)
+	}
+	return h.render(c, templates.Layout("Milestone Posts", page, c.Request().URL.Path, c.Get("user").(*models.User)))
+}
+
+// milestonePosts returns the saved milestone template, or the default before one is saved
+func (h *BaseHandler) milestonePosts() models.MilestonePosts {
+	milestones := models.DefaultMilestonePosts()
+	models.LoadSetting(h.db, models.SettingMilestones, &milestones)
+	return milestones
+}
+
+// draftMilestonePost queues a post announcing that media was completed, when milestone posts are on.
+// It goes straight to review with reviewer as the reviewer, so nothing is published until an admin approves it;
+// approving with a publish time schedules it. A title already announced is not announced again.
+func (h *BaseHandler) draftMilestonePost(media models.Media, reviewer *models.User) {
+	milestones := h.milestonePosts()
+	if !milestones.Enabled || reviewer == nil || !reviewer.IsAdmin() {
+		return
+	}
+
+	title := milestoneText(milestones.Title, media)
+	var announced int64
+	h.db.Model(&models.Post{}).
+		Where("title = ? AND id IN (?)", title, h.db.Model(&models.PostMedia{}).Select("post_id").Where("tmdb_id = ?", media.TMDBID)).
+		Count(&announced)
+	if announced > 0 {
+		return
+	}
+
+	post := models.Post{
+		Title:      title,
+		Slug:       h.uniqueSlug(h.generateSlug(title)),
+		Content:    milestoneText(milestones.Content, media),
+		Visibility: models.VisibilityPublic,
+		Status:     models.PostStatusInReview,
+		ReviewerID: &reviewer.ID,
+	}
+	h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&post).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.PostMedia{PostID: post.ID, TMDBID: media.TMDBID}).Error
+	})
+}
+
+// milestoneText fills the media placeholders of a milestone template, then the post template ones
+func milestoneText(text string, media models.Media) string {
+	kind, releaseYear, rating := "show", "", "unrated"
+	if media.Type == models.MediaTypeMovie {
+		kind = "movie"
+	}
+	if media.ReleaseDate != nil {
+		releaseYear = fmt.Sprint(media.ReleaseDate.Year())
+	}
+	if media.Rating > 0 {
+		rating = fmt.Sprintf("%.1f/10", media.Rating)
+	}
+	text = strings.NewReplacer(
+		"{{title}}", media.Title,
+		"{{type}}", kind,
+		"{{episodes}}", fmt.Sprint(media.TotalEpisodes),
+		"{{rating}}", rating,
+		"{{release_year}}", releaseYear,
+	).Replace(text)
+	return services.ExpandPlaceholders(text, time.Now())
+}
@@ -0,0 +1,213 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"mini-blog/app/models"
+	"mini-blog/app/services"
+	"mini-blog/app/templates"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// AdminPostNarration renders the narration panel on the post edit page
+func (h *BaseHandler) AdminPostNarration(c echo.Context) error {
+	id, err := h.parseUintParam(c, "id")
+	if err != nil {
+		return err
+	}
+
+	var narration models.PostNarration
+	var post models.Post
+	if h.db.Where("post_id = ?", id).First(&narration).Error == nil && h.db.First(&post, id).Error == nil {
+		markStaleNarration(post, &narration)
+	}
+	return h.render(c, templates.NarrationPanel(id, &narration, h.ttsService.Enabled()))
+}
+
+// AdminPostNarrate queues audio generation for a post
+func (h *BaseHandler) AdminPostNarrate(c echo.Context) error {
+	id, err := h.parseUintParam(c, "id")
+	if err != nil {
+		return err
+	}
+	if !h.ttsService.Enabled() {
+		return echo.NewHTTPError(http.StatusServiceUnavailable, "Narration is not configured")
+	}
+
+	var post models.Post
+	if err := h.db.First(&post, id).Error; err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "Post not found")
+	}
+
+	var narration models.PostNarration
+	h.db.Where("post_id = ?", post.ID).FirstOrInit(&narration, models.PostNarration{PostID: post.ID})
+	if narration.Status == models.NarrationPending && narration.ID != 0 {
+		return h.render(c, templates.NarrationPanel(post.ID, &narration, true))
+	}
+
+	narration.Status, narration.Error = models.NarrationPending, ""
+	if err := h.db.Save(&narration).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to queue narration")
+	}
+
+	go h.generateNarration(post.ID)
+	return h.render(c, templates.NarrationPanel(post.ID, &narration, true))
+}
+
+// generateNarration synthesizes the post's audio and stores it through the storage layer
+func (h *BaseHandler) generateNarration(postID uint) {
+	var post models.Post
+	var narration models.PostNarration
+	if h.db.First(&post, postID).Error != nil || h.db.Where("post_id = ?", postID).First(&narration).Error != nil {
+		return
+	}
+
+	fail := func(err error) {
+		log.Printf("Narration failed for post %d: %v", postID, err)
+		h.db.Model(&narration).Updates(map[string]interface{}{"status": models.NarrationFailed, "error": err.Error()})
+	}
+
+	text, hash := narrationSource(post)
+	audio, err := h.ttsService.Synthesize(text)
+	if err != nil {
+		fail(err)
+		return
+	}
+
+	key := fmt.Sprintf("narration/post-%d-%s.mp3", post.ID, hash[:12])
+	url, err := h.storage.Save(key, bytes.NewReader(audio), "audio/mpeg")
+	if err != nil {
+		fail(err)
+		return
+	}
+	if narration.StorageKey != "" && narration.StorageKey != key {
+		h.storage.Delete(narration.StorageKey)
+	}
+
+	now := time.Now()
+	h.db.Model(&narration).Updates(map[string]interface{}{
+		"status":      models.NarrationReady,
+		"storage_key": key,
+		"url":         url,
+		"size":        int64(len(audio)),
+		"source_hash": hash,
+		"error":       "",
+		"ready_at":    &now,
+	})
+}
+
+// narrationSource is the text read out for a post and its hash, which changes whenever the post's wording does
+func narrationSource(post models.Post) (text, hash string) {
+	text = post.Title + ".\n\n" + services.MarkdownToText(post.Content)
+	sum := sha256.Sum256([]byte(text))
+	return text, hex.EncodeToString(sum[:])
+}
+
+// markStaleNarration flags ready audio that was generated from an earlier version of the post
+func markStaleNarration(post models.Post, narration *models.PostNarration) {
+	if narration.Status != models.NarrationReady {
+		return
+	}
+	_, hash := narrationSource(post)
+	narration.Stale = narration.SourceHash != hash
+}
+
+type podcastFeed struct {
+	XMLName xml.Name       `xml:"rss"`
+	Version string         `xml:"version,attr"`
+	Itunes  string         `xml:"xmlns:itunes,attr"`
+	Channel podcastChannel `xml:"channel"`
+}
+
+type podcastChannel struct {
+	Title       string        `xml:"title"`
+	Link        string        `xml:"link"`
+	Description string        `xml:"description"`
+	Language    string        `xml:"language"`
+	Author      string        `xml:"itunes:author"`
+	Items       []podcastItem `xml:"item"`
+}
+
+type podcastItem struct {
+	Title       string           `xml:"title"`
+	Link        string           `xml:"link"`
+	GUID        string           `xml:"guid"`
+	PubDate     string           `xml:"pubDate"`
+	Description string           `xml:"description"`
+	Enclosure   podcastEnclosure `xml:"enclosure"`
+}
+
+type podcastEnclosure struct {
+	URL    string `xml:"url,attr"`
+	Length int64  `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
+}
+
+// PodcastFeed lists narrated public posts as a podcast RSS feed
+func (h *BaseHandler) PodcastFeed(c echo.Context) error {
+	base := strings.TrimSuffix(h.cfg.Server.BaseURL, "/")
+
+	var narrations []models.PostNarration
+	h.db.Where("status = ?", models.NarrationReady).Order("ready_at desc").Limit(50).Find(&narrations)
+
+	postIDs := make([]uint, len(narrations))
+	for i, n := range narrations {
+		postIDs[i] = n.PostID
+	}
+
+	var posts []models.Post
+	h.db.Where("id IN ? AND published = ? AND visibility = ?", postIDs, true, models.VisibilityPublic).Find(&posts)
+	byID := make(map[uint]models.Post, len(posts))
+	for _, p := range posts {
+		byID[p.ID] = p
+	}
+
+	feed := podcastFeed{
+		Version: "2.0",
+		Itunes:  "http://www.itunes.com/dtds/podcast-1.0.dtd",
+		Channel: podcastChannel{
+			Title:       "NODELIKE (Narrated)",
+			Link:        base,
+			Description: "Audio versions of NODELIKE posts",
+			Language:    models.LocaleEnglish,
+			Author:      "NODELIKE",
+		},
+	}
+	for _, n := range narrations {
+		post, ok := byID[n.PostID]
+		if markStaleNarration(post, &n); !ok || n.Stale {
+			continue
+		}
+		link := base + "/posts/" + post.Slug
+		feed.Channel.Items = append(feed.Channel.Items, podcastItem{
+			Title:       post.Title,
+			Link:        link,
+			GUID:        link + "#narration-" + n.SourceHash[:12],
+			PubDate:     post.CreatedAt.UTC().Format(time.RFC1123Z),
+			Description: services.MarkdownToText(post.Content),
+			Enclosure:   podcastEnclosure{URL: absoluteURL(base, n.URL), Length: n.Size, Type: "audio/mpeg"},
+		})
+	}
+
+	out, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to build feed")
+	}
+	return c.Blob(http.StatusOK, "application/rss+xml; charset=utf-8", append([]byte(xml.Header), out...))
+}
+
+// absoluteURL prefixes site-relative storage URLs with the request origin
+func absoluteURL(base, url string) string {
+	if len(url) > 0 && url[0] == '/' {
+		return base + url
+	}
+	return url
+}
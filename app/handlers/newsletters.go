@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"log"
+	"mini-blog/app/models"
+	"mini-blog/app/services"
+	"mini-blog/app/templates"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// transparentGIF is the 1x1 open-tracking pixel
+var transparentGIF, _ = base64.StdEncoding.DecodeString("R0lGODlhAQABAIAAAAAAAP///yH5BAEAAAAALAAAAAABAAEAAAIBRAA7")
+
+// AdminNewsletters is the email analytics page: campaigns with open/click stats and a send form
+func (h *BaseHandler) AdminNewsletters(c echo.Context) error {
+	return h.renderNewsletters(c, "", "")
+}
+
+// AdminNewsletterSend emails a published post as a newsletter campaign to the verified users allowed to read it
+func (h *BaseHandler) AdminNewsletterSend(c echo.Context) error {
+	postID, err := strconv.ParseUint(c.FormValue("post_id"), 10, 64)
+	if err != nil {
+		return h.renderNewsletters(c, "", "Choose a post to send")
+	}
+
+	var post models.Post
+	if err := h.db.Where("id = ? AND published = ?", postID, true).First(&post).Error; err != nil {
+		return h.renderNewsletters(c, "", "Only published posts can be sent")
+	}
+
+	subject := h.trimFormValue(c, "subject")
+	if subject == "" {
+		subject = post.Title
+	}
+
+	now := time.Now()
+	id := post.ID
+	campaign := models.EmailCampaign{
+		Name:            post.Title,
+		Subject:         subject,
+		Kind:            models.CampaignNewsletter,
+		PostID:          &id,
+		TrackingEnabled: c.FormValue("tracking") == "on",
+		SentAt:          &now,
+	}
+	if err := h.db.Create(&campaign).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create campaign")
+	}
+
+	// Premium and admin-only posts go only to readers who could open them on the site
+	var users []models.User
+	h.db.Where("is_verified = ?", true).Find(&users)
+	var recipients []string
+	for _, user := range users {
+		if post.CanAccess(&user) {
+			recipients = append(recipients, user.Email)
+		}
+	}
+
+	postURL := strings.TrimSuffix(h.cfg.Server.BaseURL, "/") + "/posts/" + post.Slug
+	html := services.PostNewsletterHTML(post.Title, services.MarkdownToHTML(post.Content), postURL)
+	go h.deliverCampaign(campaign, models.EmailKindNewsletter, recipients, html, 0)
+
+	return h.renderNewsletters(c, "Queued for "+strconv.Itoa(len(recipients))+" recipient(s)", "")
+}
+
+// deliverCampaign queues one tracked copy per recipient, recording each send.
+// A non-zero interval spaces the sends out to throttle the campaign.
+func (h *BaseHandler) deliverCampaign(campaign models.EmailCampaign, kind string, recipients []string, html string, interval time.Duration) {
+	sendAt := time.Now()
+	for _, email := range recipients {
+		send := models.EmailSend{CampaignID: campaign.ID, Email: email, Token: services.NewEmailToken(), Status: models.EmailJobQueued}
+		if err := h.db.Create(&send).Error; err != nil {
+			continue
+		}
+
+		body := html
+		if campaign.TrackingEnabled {
+			body = services.TrackEmailHTML(html, h.cfg.Server.BaseURL, send.Token, h.cfg.Session.Key)
+		}
+		if err := h.enqueueEmail(kind, email, campaign.Subject, body, sendAt, &send.ID); err != nil {
+			log.Printf("Campaign %d: failed to queue %s: %v", campaign.ID, email, err)
+			h.db.Model(&send).Updates(map[string]interface{}{"status": models.EmailJobFailed, "error": err.Error()})
+		}
+		sendAt = sendAt.Add(interval)
+	}
+}
+
+// TrackEmailOpen records an open and serves the tracking pixel
+func (h *BaseHandler) TrackEmailOpen(c echo.Context) error {
+	token := strings.TrimSuffix(c.Param("token"), ".gif")
+	now := time.Now()
+	h.db.Model(&models.EmailSend{}).Where("token = ?", token).Updates(map[string]interface{}{
+		"opens":     gorm.Expr("opens + 1"),
+		"opened_at": gorm.Expr("COALESCE(opened_at, ?)", now),
+	})
+
+	c.Response().Header().Set(echo.HeaderCacheControl, "no-store")
+	return c.Blob(http.StatusOK, "image/gif", transparentGIF)
+}
+
+// TrackEmailClick records a click on a wrapped link and redirects to the original URL
+func (h *BaseHandler) TrackEmailClick(c echo.Context) error {
+	token, target := c.Param("token"), c.QueryParam("u")
+	if !services.VerifyTrackedURL(h.cfg.SigningKeys(), token, target, c.QueryParam("s")) {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid link")
+	}
+
+	var send models.EmailSend
+	if h.db.Where("token = ?", token).First(&send).Error == nil {
+		now := time.Now()
+		h.db.Model(&send).Updates(map[string]interface{}{
+			"clicks":     gorm.Expr("clicks + 1"),
+			"clicked_at": gorm.Expr("COALESCE(clicked_at, ?)", now),
+			// A click implies the email was opened even if images were blocked
+			"opened_at": gorm.Expr("COALESCE(opened_at, ?)", now),
+		})
+		h.db.Create(&models.EmailClick{CampaignID: send.CampaignID, SendID: send.ID, URL: target})
+	}
+
+	return c.Redirect(http.StatusFound, target)
+}
+
+// AdminNewsletterStats shows one campaign's engagement, including its most clicked links
+func (h *BaseHandler) AdminNewsletterStats(c echo.Context) error {
+	id, err := h.parseUintParam(c, "id")
+	if err != nil {
+		return err
+	}
+
+	var campaign models.EmailCampaign
+	if err := h.db.First(&campaign, id).Error; err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "Campaign not found")
+	}
+
+	stats := h.campaignStats([]uint{campaign.ID})[campaign.ID]
+
+	var links []templates.LinkClicks
+	h.db.Model(&models.EmailClick{}).
+		Select("url, COUNT(*) AS clicks").
+		Where("campaign_id = ?", campaign.ID).
+		Group("url").Order("clicks desc").Limit(20).
+		Scan(&links)
+
+	page := templates.NewsletterStatsPage(campaign, stats, links)
+	if h.isHTMXRequest(c) {
+		return h.render(c, page)
+	}
+	return h.render(c, templates.Layout("Campaign Stats", page, c.Request().URL.Path, c.Get("user").(*models.User)))
+}
+
+// campaignStats aggregates sends per campaign in one query
+func (h *BaseHandler) campaignStats(ids []uint) map[uint]models.CampaignStats {
+	var rows []models.CampaignStats
+	h.db.Model(&models.EmailSend{}).
+		Select(`campaign_id,
+			COUNT(*) FILTER (WHERE status = 'queued') AS queued,
+			COUNT(*) FILTER (WHERE status = 'sent') AS sent,
+			COUNT(*) FILTER (WHERE status = 'failed') AS failed,
+			COUNT(*) FILTER (WHERE status = 'skipped') AS skipped,
+			COUNT(opened_at) AS unique_opens,
+			COALESCE(SUM(opens), 0) AS total_opens,
+			COUNT(clicked_at) AS unique_clicks,
+			COALESCE(SUM(clicks), 0) AS total_clicks`).
+		Where("campaign_id IN ?", ids).
+		Group("campaign_id").
+		Scan(&rows)
+
+	stats := make(map[uint]models.CampaignStats, len(rows))
+	for _, row := range rows {
+		stats[row.CampaignID] = row
+	}
+	return stats
+}
+
+func (h *BaseHandler) renderNewsletters(c echo.Context, successMessage, errorMessage string) error {
+	var campaigns []models.EmailCampaign
+	h.db.Order("created_at desc").Limit(50).Find(&campaigns)
+
+	ids := make([]uint, len(campaigns))
+	for i, campaign := range campaigns {
+		ids[i] = campaign.ID
+	}
+
+	var posts []models.Post
+	h.db.Select("id", "title").Where("published = ?", true).Order("created_at desc").Limit(20).Find(&posts)
+
+	page := templates.NewslettersPage(campaigns, h.campaignStats(ids), posts, successMessage, errorMessage)
+	if h.isHTMXRequest(c) {
+		return h.render(c, page)
+	}
+	return h.render(c, templates.Layout("Email Analytics", page, c.Request().URL.Path, c.Get("user").(*models.User)))
+}
@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"fmt"
+	"mini-blog/app/models"
+	"mini-blog/app/services"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+const paletteLimit = 20
+
+// PaletteItem is a single actionable entry for the command palette
+type PaletteItem struct {
+	Kind     string `json:"kind"` // page, post, media, action
+	Title    string `json:"title"`
+	Subtitle string `json:"subtitle,omitempty"`
+	URL      string `json:"url"`
+	Method   string `json:"method"`           // GET for navigation, HX for modal fragments
+	Target   string `json:"target,omitempty"` // HTMX target for fragment items
+	score    int
+}
+
+// Palette returns role-filtered palette items, fuzzy-ranked against ?q=
+func (h *BaseHandler) Palette(c echo.Context) error {
+	user := h.GetCurrentUser(c)
+	query := strings.TrimSpace(c.QueryParam("q"))
+
+	items := h.paletteNavigation(user)
+	if h.cfg.BlogEnabled() {
+		items = append(items, h.palettePosts(user)...)
+	}
+	if h.cfg.TrackerEnabled() {
+		items = append(items, h.paletteMedia(c)...)
+	}
+	if user != nil && user.IsAdmin() {
+		items = append(items, h.paletteAdminActions()...)
+	}
+
+	var ranked []PaletteItem
+	for _, item := range items {
+		item.score = services.FuzzyScore(query, item.Title)
+		if item.score >= 0 {
+			ranked = append(ranked, item)
+		}
+	}
+
+	if query != "" {
+		sort.SliceStable(ranked, func(i, j int) bool { return ranked[i].score > ranked[j].score })
+	}
+	if len(ranked) > paletteLimit {
+		ranked = ranked[:paletteLimit]
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"query": query,
+		"items": ranked,
+	})
+}
+
+func (h *BaseHandler) paletteNavigation(user *models.User) []PaletteItem {
+	var items []PaletteItem
+	if h.cfg.BlogEnabled() {
+		items = append(items,
+			PaletteItem{Kind: "page", Title: "Home", URL: "/", Method: http.MethodGet},
+			PaletteItem{Kind: "page", Title: "All Posts", URL: "/posts", Method: http.MethodGet},
+		)
+	}
+	if h.cfg.TrackerEnabled() {
+		items = append(items, PaletteItem{Kind: "page", Title: "TV Library", URL: "/tv", Method: http.MethodGet})
+	}
+	if user == nil {
+		return append(items,
+			PaletteItem{Kind: "page", Title: "Login", URL: "/login", Method: http.MethodGet},
+			PaletteItem{Kind: "page", Title: "Sign Up", URL: "/signup", Method: http.MethodGet},
+		)
+	}
+	return append(items,
+		PaletteItem{Kind: "page", Title: "Settings", URL: "/settings", Method: http.MethodGet},
+		PaletteItem{Kind: "action", Title: "Toggle Theme", URL: "/theme", Method: http.MethodPost},
+		PaletteItem{Kind: "action", Title: "Logout", URL: "/logout", Method: http.MethodGet},
+	)
+}
+
+func (h *BaseHandler) palettePosts(user *models.User) []PaletteItem {
+	var posts []models.Post
+	h.db.Where("published = ?", true).Order("created_at desc").Limit(50).Find(&posts)
+
+	var items []PaletteItem
+	for _, post := range h.getAccessiblePosts(posts, user) {
+		items = append(items, PaletteItem{
+			Kind:     "post",
+			Title:    post.Title,
+			Subtitle: post.CreatedAt.Format("Jan 2, 2006"),
+			URL:      "/posts/" + post.Slug,
+			Method:   http.MethodGet,
+		})
+	}
+	return items
+}
+
+func (h *BaseHandler) paletteMedia(c echo.Context) []PaletteItem {
+	var media []models.Media
+	query := h.db.Select("tmdb_id", "type", "title", "status").Order("updated_at desc")
+	if h.hideAdult(c) {
+		query = query.Scopes(models.HideAdultMedia)
+	}
+	query.Find(&media)
+
+	var items []PaletteItem
+	for _, m := range media {
+		items = append(items, PaletteItem{
+			Kind:     "media",
+			Title:    m.Title,
+			Subtitle: fmt.Sprintf("%s · %s", strings.ToUpper(m.Type), m.Status),
+			URL:      fmt.Sprintf("/tv/modal/%d?type=%s", m.TMDBID, m.Type),
+			Method:   "HX",
+			Target:   "#modal-content",
+		})
+	}
+	return items
+}
+
+func (h *BaseHandler) paletteAdminActions() []PaletteItem {
+	items := []PaletteItem{
+		{Kind: "action", Title: "Admin Dashboard", URL: "/admin/dashboard", Method: http.MethodGet},
+		{Kind: "action", Title: "Users & Segments", URL: "/admin/users", Method: http.MethodGet},
+		{Kind: "action", Title: "Send Announcement", URL: "/admin/announcements", Method: http.MethodGet},
+	}
+	if h.cfg.BlogEnabled() {
+		items = append(items,
+			PaletteItem{Kind: "action", Title: "New Post", URL: "/admin/posts/new", Method: http.MethodGet},
+			PaletteItem{Kind: "action", Title: "Content Calendar", URL: "/admin/calendar", Method: http.MethodGet},
+		)
+	}
+	return items
+}
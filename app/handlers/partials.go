@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"mini-blog/app/models"
+	"mini-blog/app/templates"
+	"net/http"
+
+	"github.com/a-h/templ"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// partialResponse composes one HTMX response: an optional main component for the
+// request's own target plus any number of out-of-band updates elsewhere on the page
+type partialResponse struct {
+	main templ.Component
+	oob  []templ.Component
+	db   *gorm.DB // for loading card data
+}
+
+func (h *BaseHandler) newPartial(main templ.Component) *partialResponse {
+	return &partialResponse{main: main, db: h.db}
+}
+
+// Update swaps content into the element with id, keeping the element itself
+func (p *partialResponse) Update(id string, content templ.Component) *partialResponse {
+	p.oob = append(p.oob, templates.OOBSwap(id, "innerHTML", content))
+	return p
+}
+
+// Replace swaps out the element with id entirely
+func (p *partialResponse) Replace(id string, content templ.Component) *partialResponse {
+	p.oob = append(p.oob, templates.OOBSwap(id, "true", content))
+	return p
+}
+
+// Card refreshes a media item's grid card, wherever it is on the page
+func (p *partialResponse) Card(media models.Media, user *models.User) *partialResponse {
+	cards := []models.Media{media}
+	attachSeasons(p.db, cards)
+	return p.Replace(fmt.Sprintf("tmdb-%d", media.TMDBID), templates.UnifiedMediaCard(cards[0], user, false))
+}
+
+// renderPartial renders the whole response before writing, so a failing component can't leave half a page
+func (h *BaseHandler) renderPartial(c echo.Context, p *partialResponse) error {
+	ctx := h.renderContext(c)
+	var buf bytes.Buffer
+
+	if p.main != nil {
+		if err := p.main.Render(ctx, &buf); err != nil {
+			return err
+		}
+	}
+	for _, component := range p.oob {
+		if err := component.Render(ctx, &buf); err != nil {
+			return err
+		}
+	}
+	return c.HTMLBlob(http.StatusOK, buf.Bytes())
+}
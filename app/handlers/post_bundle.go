@@ -0,0 +1,275 @@
+package handlers
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"mini-blog/app/models"
+	"mini-blog/app/services"
+	"mini-blog/app/templates"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// maxBundlePostSize bounds one markdown file read from an import bundle
+const maxBundlePostSize = 5 << 20
+
+// AdminMarkdownExport downloads every post as a zip of markdown files with YAML front matter, one per slug
+func (h *BaseHandler) AdminMarkdownExport(c echo.Context) error {
+	var posts []models.Post
+	if err := h.db.Preload("Category").Preload("Tags").Order("created_at").Find(&posts).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to load posts")
+	}
+
+	filename := fmt.Sprintf("mini-blog-posts-%s.zip", time.Now().Format(segmentDateLayout))
+	c.Response().Header().Set(echo.HeaderContentType, "application/zip")
+	c.Response().Header().Set(echo.HeaderContentDisposition, `attachment; filename="`+filename+`"`)
+	c.Response().WriteHeader(http.StatusOK)
+
+	archive := zip.NewWriter(c.Response())
+	for _, post := range posts {
+		data, err := services.EncodePostMarkdown(postFrontMatter(post), post.Content)
+		if err != nil {
+			return err
+		}
+		w, err := archive.Create("posts/" + post.Slug + ".md")
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+	}
+	return archive.Close()
+}
+
+// AdminMarkdownImport reads a zip of markdown files with front matter, from this site or from Hugo or Jekyll,
+// creating posts for new slugs and overwriting the ones that exist. New posts without a status in their front
+// matter come in as drafts unless ?publish= is ticked. Subscribers are not emailed.
+func (h *BaseHandler) AdminMarkdownImport(c echo.Context) error {
+	header, err := c.FormFile("bundle")
+	if err != nil {
+		return h.render(c, templates.MarkdownImportResult("", nil, "Choose a zip of markdown files to import"))
+	}
+	file, err := header.Open()
+	if err != nil {
+		return h.render(c, templates.MarkdownImportResult("", nil, "Failed to read the bundle"))
+	}
+	defer file.Close()
+
+	bundle, err := zip.NewReader(file, header.Size)
+	if err != nil {
+		return h.render(c, templates.MarkdownImportResult("", nil, "That file is not a zip archive"))
+	}
+
+	user := c.Get("user").(*models.User)
+	publishNew := c.FormValue("publish") != ""
+	created, updated := 0, 0
+	var skipped []string
+	for _, entry := range bundle.File {
+		name := entry.Name
+		base := path.Base(name)
+		if entry.FileInfo().IsDir() || strings.HasPrefix(name, "__MACOSX/") || strings.HasPrefix(base, ".") || base == "_index.md" ||
+			(path.Ext(name) != ".md" && path.Ext(name) != ".markdown") {
+			continue
+		}
+		isNew, err := h.importMarkdownPost(entry, user, h.userLocation(c), publishNew)
+		switch {
+		case err != nil:
+			skipped = append(skipped, name+": "+err.Error())
+		case isNew:
+			created++
+		default:
+			updated++
+		}
+	}
+
+	summary := fmt.Sprintf("Created %d and updated %d posts.", created, updated)
+	if created+updated+len(skipped) == 0 {
+		return h.render(c, templates.MarkdownImportResult("", nil, "The bundle has no markdown files"))
+	}
+	return h.render(c, templates.MarkdownImportResult(summary, skipped, ""))
+}
+
+// importMarkdownPost saves one bundle file as a post, matched to an existing one by slug. An existing post keeps
+// its visibility and status unless the front matter sets them.
+func (h *BaseHandler) importMarkdownPost(entry *zip.File, user *models.User, loc *time.Location, publishNew bool) (bool, error) {
+	if entry.UncompressedSize64 > maxBundlePostSize {
+		return false, errors.New("file is too large")
+	}
+	f, err := entry.Open()
+	if err != nil {
+		return false, errors.New("can't be read")
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, maxBundlePostSize))
+	if err != nil {
+		return false, errors.New("can't be read")
+	}
+
+	front, body, err := services.DecodePostMarkdown(data)
+	if err != nil {
+		return false, err
+	}
+	title := strings.TrimSpace(front.Title)
+	if title == "" || body == "" {
+		return false, errors.New("title and content are required")
+	}
+
+	fileSlug, fileDate := services.PostFileSlug(entry.Name)
+	slug := h.generateSlug(front.Slug)
+	if slug == "" {
+		slug = h.generateSlug(fileSlug)
+	}
+	if slug == "" {
+		slug = h.generateSlug(title)
+	}
+	if front.Date == "" {
+		front.Date = fileDate
+	}
+
+	post := models.Post{Slug: slug}
+	err = h.db.Where("slug = ?", slug).First(&post).Error
+	isNew := errors.Is(err, gorm.ErrRecordNotFound)
+	if err != nil && !isNew {
+		return false, errors.New("failed to look up the slug")
+	}
+
+	post.Title, post.Content = title, body
+	if models.IsValidVisibility(front.Visibility) {
+		post.Visibility = front.Visibility
+	} else if isNew {
+		post.Visibility = models.VisibilityPublic
+	}
+	if status, set := importedPostStatus(front, publishNew); set || isNew {
+		post.SetStatus(status)
+	}
+	post.PublishAt = nil
+	if publishAt, ok := services.ParseFrontMatterDate(front.PublishAt, loc); ok {
+		post.PublishAt = &publishAt
+	}
+	post.ExpiresAt = nil
+	if expiresAt, ok := services.ParseFrontMatterDate(front.ExpiresAt, loc); ok {
+		post.ExpiresAt = &expiresAt
+	}
+	post.MetaDescription, post.OGImage = strings.TrimSpace(front.Description), strings.TrimSpace(front.Image)
+	post.Excerpt, post.CoverImage = strings.TrimSpace(front.Excerpt), strings.TrimSpace(front.Cover)
+	post.CategoryID, err = h.importedCategory(front)
+	if err != nil {
+		return false, errors.New("category must be at most 50 characters")
+	}
+	if err := h.validator.Struct(post); err != nil {
+		return false, errors.New("title, slug, description, excerpt or an image is too long")
+	}
+	tags := h.tagsFromNames(front.Tags)
+	if err := h.checkTags(tags); err != nil {
+		return false, errors.New("tags must be at most 50 characters")
+	}
+
+	if isNew {
+		post.AuthorID, post.Version = &user.ID, 1
+		// Keep the original publication date, so the post lands where it was in the old blog's archive
+		if date, ok := services.ParseFrontMatterDate(front.Date, loc); ok {
+			post.CreatedAt = date
+		}
+		if err := h.db.Create(&post).Error; err != nil {
+			return false, errors.New("failed to save")
+		}
+	} else {
+		if err := h.db.Model(&post).Updates(map[string]interface{}{
+			"title":            post.Title,
+			"content":          post.Content,
+			"visibility":       post.Visibility,
+			"status":           post.Status,
+			"published":        post.Published,
+			"publish_at":       post.PublishAt,
+			"expires_at":       post.ExpiresAt,
+			"category_id":      post.CategoryID,
+			"meta_description": post.MetaDescription,
+			"og_image":         post.OGImage,
+			"excerpt":          post.Excerpt,
+			"cover_image":      post.CoverImage,
+			"version":          gorm.Expr("version + 1"),
+		}).Error; err != nil {
+			return false, errors.New("failed to save")
+		}
+	}
+
+	if err := h.replacePostTags(h.db, &post, tags); err != nil {
+		return false, errors.New("failed to save tags")
+	}
+	return isNew, nil
+}
+
+// importedCategory finds or creates the post's category by name; Hugo's categories list contributes its first entry
+func (h *BaseHandler) importedCategory(front services.PostFrontMatter) (*uint, error) {
+	name := strings.TrimSpace(front.Category)
+	if name == "" && len(front.Categories) > 0 {
+		name = strings.TrimSpace(front.Categories[0])
+	}
+	slug := h.generateSlug(name)
+	if slug == "" {
+		return nil, nil
+	}
+	category := models.Category{Name: name, Slug: slug}
+	if err := h.validator.Struct(category); err != nil {
+		return nil, err
+	}
+	if err := h.db.Where(models.Category{Slug: slug}).Attrs(models.Category{Name: name}).FirstOrCreate(&category).Error; err != nil {
+		return nil, err
+	}
+	return &category.ID, nil
+}
+
+// importedPostStatus reads the workflow status from our own exports, or Hugo's draft and Jekyll's published flags.
+// Without any of them it reports false, along with the status a new post gets.
+func importedPostStatus(front services.PostFrontMatter, publishNew bool) (string, bool) {
+	if _, ok := models.PostStatusNames[front.Status]; ok {
+		return front.Status, true
+	}
+	if front.Draft || (front.Published != nil && !*front.Published) {
+		return models.PostStatusDraft, true
+	}
+	if front.Published != nil {
+		return models.PostStatusPublished, true
+	}
+	if publishNew {
+		return models.PostStatusPublished, false
+	}
+	return models.PostStatusDraft, false
+}
+
+// postFrontMatter is the header a post is exported with
+func postFrontMatter(post models.Post) services.PostFrontMatter {
+	front := services.PostFrontMatter{
+		Title:       post.Title,
+		Slug:        post.Slug,
+		Date:        post.CreatedAt.UTC().Format(time.RFC3339),
+		Draft:       post.Status != models.PostStatusPublished,
+		Status:      post.Status,
+		Visibility:  post.Visibility,
+		Description: post.MetaDescription,
+		Image:       post.OGImage,
+		Excerpt:     post.Excerpt,
+		Cover:       post.CoverImage,
+	}
+	if post.PublishAt != nil {
+		front.PublishAt = post.PublishAt.UTC().Format(time.RFC3339)
+	}
+	if post.ExpiresAt != nil {
+		front.ExpiresAt = post.ExpiresAt.UTC().Format(time.RFC3339)
+	}
+	if post.Category != nil {
+		front.Category = post.Category.Name
+	}
+	for _, tag := range post.Tags {
+		front.Tags = append(front.Tags, tag.Name)
+	}
+	return front
+}
@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"fmt"
+	"mini-blog/app/models"
+	"mini-blog/app/templates"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// AdminPostMediaPicker renders the "linked media" field of the post form; ?post= preselects a post's links
+func (h *BaseHandler) AdminPostMediaPicker(c echo.Context) error {
+	var library []models.Media
+	h.db.Select("tmdb_id", "title", "type", "release_date").Order("title asc").Find(&library)
+
+	var linked []int
+	if postID, err := strconv.ParseUint(c.QueryParam("post"), 10, 64); err == nil {
+		h.db.Model(&models.PostMedia{}).Where("post_id = ?", postID).Pluck("tmdb_id", &linked)
+	}
+	return h.render(c, templates.PostMediaPicker(library, linked))
+}
+
+// MediaRelatedPosts lists the posts that link to a title, for its modal
+func (h *BaseHandler) MediaRelatedPosts(c echo.Context) error {
+	user := h.GetCurrentUser(c)
+	tmdbID, _ := strconv.Atoi(c.Param("tmdbId"))
+
+	var posts []models.Post
+	h.db.Scopes(models.PostsVisibleTo(user)).
+		Where("id IN (?)", h.db.Model(&models.PostMedia{}).Select("post_id").Where("tmdb_id = ?", tmdbID)).
+		Order("created_at desc").
+		Find(&posts)
+	h.localizePosts(c, posts)
+	return h.render(c, templates.RelatedPosts(posts))
+}
+
+// MediaWatchProviders renders where a library title can be watched in the configured regions; empty when there is nowhere.
+// Titles outside the library are refused so the public route can't be used as a TMDB proxy.
+func (h *BaseHandler) MediaWatchProviders(c echo.Context) error {
+	tmdbID, _ := strconv.Atoi(c.Param("tmdbId"))
+	mediaType := c.Param("type")
+	if tmdbID == 0 || !models.IsValidMediaType(mediaType) {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid media")
+	}
+	var media models.Media
+	if err := h.db.Where("tmdb_id = ? AND type = ?", tmdbID, mediaType).First(&media).Error; err != nil || (media.IsAdult() && h.hideAdult(c)) {
+		return echo.NewHTTPError(http.StatusNotFound, "Media not found")
+	}
+
+	regions := make([]string, 0, len(h.cfg.Content.WatchRegions))
+	for _, region := range h.cfg.Content.WatchRegions {
+		regions = append(regions, strings.ToUpper(strings.TrimSpace(region)))
+	}
+	offers, err := h.tmdbService.WithContext(c.Request().Context()).GetWatchProviders(tmdbID, mediaType, regions)
+	if err != nil || len(offers) == 0 {
+		return c.NoContent(http.StatusOK)
+	}
+	return h.render(c, templates.WatchProviders(offers))
+}
+
+// MediaWriteReview starts a draft review of a library title, linked back to it, and opens the editor
+func (h *BaseHandler) MediaWriteReview(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+	tmdbID, _ := strconv.Atoi(c.Param("tmdbId"))
+	var media models.Media
+	if err := h.db.Where("tmdb_id = ?", tmdbID).First(&media).Error; err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "Media not found")
+	}
+
+	title := "Review: " + media.Title
+	post := models.Post{
+		Title:      title,
+		Slug:       h.uniqueSlug(h.generateSlug(title)),
+		Content:    reviewDraft(media),
+		Visibility: models.VisibilityPublic,
+		Status:     models.PostStatusDraft,
+		AuthorID:   &user.ID,
+	}
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&post).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.PostMedia{PostID: post.ID, TMDBID: media.TMDBID}).Error
+	})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create review")
+	}
+
+	return h.htmxRedirect(c, fmt.Sprintf("/admin/posts/%d/edit", post.ID))
+}
+
+// reviewDraft is the starting markdown of a review: metadata frontmatter, the poster and a heading to write under
+func reviewDraft(media models.Media) string {
+	var b strings.Builder
+	b.WriteString("---\n")
+	fmt.Fprintf(&b, "media: %s\n", strconv.Quote(media.Title))
+	fmt.Fprintf(&b, "tmdb_id: %d\n", media.TMDBID)
+	fmt.Fprintf(&b, "type: %s\n", media.Type)
+	if media.ReleaseDate != nil {
+		fmt.Fprintf(&b, "year: %d\n", media.ReleaseDate.Year())
+	}
+	if genres := media.GenreNames(); len(genres) > 0 {
+		fmt.Fprintf(&b, "genres: [%s]\n", strings.Join(genres, ", "))
+	}
+	if media.Rating > 0 {
+		fmt.Fprintf(&b, "rating: %.1f\n", media.Rating)
+	}
+	fmt.Fprintf(&b, "status: %s\n", media.Status)
+	b.WriteString("---\n\n")
+
+	if media.PosterPath != "" {
+		fmt.Fprintf(&b, "![%s](https://image.tmdb.org/t/p/w500%s)\n\n", media.Title, media.PosterPath)
+	}
+	b.WriteString("## My take\n\n")
+	return b.String()
+}
+
+// savePostMedia replaces a post's media links with the titles picked in the form, through db.
+// Forms submitted before the picker loaded leave the links alone.
+func (h *BaseHandler) savePostMedia(c echo.Context, db *gorm.DB, postID uint) error {
+	form, err := c.FormParams()
+	if err != nil {
+		return err
+	}
+	if form.Get("media_picker") == "" {
+		return nil
+	}
+
+	seen := make(map[int]bool)
+	var links []models.PostMedia
+	for _, value := range form["media"] {
+		if tmdbID, err := strconv.Atoi(value); err == nil && tmdbID > 0 && !seen[tmdbID] {
+			seen[tmdbID] = true
+			links = append(links, models.PostMedia{PostID: postID, TMDBID: tmdbID})
+		}
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("post_id = ?", postID).Delete(&models.PostMedia{}).Error; err != nil {
+			return err
+		}
+		if len(links) == 0 {
+			return nil
+		}
+		return tx.Create(&links).Error
+	})
+}
+
+// attachLinkedMedia loads the library titles a post links to, minus adult ones hidden from this viewer
+func (h *BaseHandler) attachLinkedMedia(c echo.Context, post *models.Post) {
+	query := h.db.Where("tmdb_id IN (?)", h.db.Model(&models.PostMedia{}).Select("tmdb_id").Where("post_id = ?", post.ID)).Order("title asc")
+	if h.hideAdult(c) {
+		query = query.Scopes(models.HideAdultMedia)
+	}
+	query.Find(&post.LinkedMedia)
+}
@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"fmt"
+	"mini-blog/app/models"
+	"mini-blog/app/services"
+	"mini-blog/app/templates"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// previewScope keeps preview tokens from verifying as any other signed value
+const previewScope = "post-preview"
+
+// AdminPostPreviewLink signs a /preview/:token link to an unpublished post, lasting ?days
+func (h *BaseHandler) AdminPostPreviewLink(c echo.Context) error {
+	id, err := h.parseUintParam(c, "id")
+	if err != nil {
+		return err
+	}
+	var post models.Post
+	if err := h.db.Select("id", "published").First(&post, id).Error; err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "Post not found")
+	}
+	if post.Published {
+		return echo.NewHTTPError(http.StatusBadRequest, "Post is already published")
+	}
+
+	days, _ := strconv.Atoi(c.FormValue("days"))
+	if !slices.Contains(models.PreviewLinkDays, days) {
+		days = models.PreviewLinkDays[0]
+	}
+	expires := time.Now().Add(time.Duration(days) * 24 * time.Hour)
+	link := absoluteURL(strings.TrimSuffix(h.cfg.Server.BaseURL, "/"), "/preview/"+h.previewToken(post.ID, expires))
+	return h.render(c, templates.PreviewLinkPanel(post.ID, link, expires))
+}
+
+// previewToken is "<post id>.<expiry>.<signature>". Links stay valid until they expire or SESSION_KEY changes.
+func (h *BaseHandler) previewToken(postID uint, expires time.Time) string {
+	value := fmt.Sprintf("%d.%d", postID, expires.Unix())
+	return value + "." + services.SignValue(h.cfg.Session.Key, previewScope, value)
+}
+
+// previewPostID returns the post a preview token opens, when the token is genuine and hasn't expired
+func (h *BaseHandler) previewPostID(token string) (uint, bool) {
+	id, rest, _ := strings.Cut(token, ".")
+	expiry, signature, _ := strings.Cut(rest, ".")
+	if !services.VerifyValue(h.cfg.SigningKeys(), previewScope, id+"."+expiry, signature) {
+		return 0, false
+	}
+	postID, err := strconv.ParseUint(id, 10, 64)
+	unix, expiryErr := strconv.ParseInt(expiry, 10, 64)
+	if err != nil || expiryErr != nil || time.Now().After(time.Unix(unix, 0)) {
+		return 0, false
+	}
+	return uint(postID), true
+}
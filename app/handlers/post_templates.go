@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"fmt"
+	"mini-blog/app/models"
+	"mini-blog/app/services"
+	"mini-blog/app/templates"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// Admin post template management
+func (h *BaseHandler) AdminPostTemplates(c echo.Context) error {
+	return h.renderPostTemplates(c, "")
+}
+
+func (h *BaseHandler) AdminPostTemplateCreate(c echo.Context) error {
+	tmpl := models.PostTemplate{
+		Name:       h.trimFormValue(c, "name"),
+		Title:      h.trimFormValue(c, "title"),
+		Content:    h.trimFormValue(c, "content"),
+		Visibility: c.FormValue("visibility"),
+	}
+	if !models.IsValidVisibility(tmpl.Visibility) {
+		tmpl.Visibility = models.VisibilityPublic
+	}
+	if err := h.validator.Struct(tmpl); err != nil {
+		return h.renderPostTemplates(c, "Template name is required")
+	}
+
+	if err := h.db.Create(&tmpl).Error; err != nil {
+		return h.renderPostTemplates(c, "A template with that name already exists")
+	}
+	return h.renderPostTemplates(c, "")
+}
+
+func (h *BaseHandler) AdminPostTemplateDelete(c echo.Context) error {
+	id, err := h.parseUintParam(c, "id")
+	if err != nil {
+		return err
+	}
+
+	if err := h.db.Unscoped().Delete(&models.PostTemplate{}, id).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to delete template")
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+func (h *BaseHandler) renderPostTemplates(c echo.Context, errorMessage string) error {
+	var postTemplates []models.PostTemplate
+	h.db.Order("name asc").Find(&postTemplates)
+
+	page := templates.PostTemplatesPage(postTemplates, errorMessage)
+	if h.isHTMXRequest(c) {
+		return h.render(c, page)
+	}
+	return h.render(c, templates.Layout("Post Templates", page, c.Request().URL.Path, c.Get("user").(*models.User)))
+}
+
+// postFromTemplate builds an unsaved post from ?template=ID, expanding placeholders for today
+func (h *BaseHandler) postFromTemplate(c echo.Context) *models.Post {
+	id, err := strconv.ParseUint(c.QueryParam("template"), 10, 64)
+	if err != nil {
+		return nil
+	}
+
+	var tmpl models.PostTemplate
+	if err := h.db.First(&tmpl, id).Error; err != nil {
+		return nil
+	}
+
+	now := time.Now().In(h.userLocation(c))
+	return &models.Post{
+		Title:      services.ExpandPlaceholders(tmpl.Title, now),
+		Content:    services.ExpandPlaceholders(tmpl.Content, now),
+		Visibility: tmpl.Visibility,
+	}
+}
+
+// AdminPostDuplicate copies a post into a new draft: its text, translations, category, tags, excerpt,
+// images, meta description and linked media. Schedule, pin, narration, reactions and comments stay with the original.
+func (h *BaseHandler) AdminPostDuplicate(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+	id, err := h.parseUintParam(c, "id")
+	if err != nil {
+		return err
+	}
+
+	var source models.Post
+	if err := h.db.Preload("Translations").Preload("Tags").First(&source, id).Error; err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "Post not found")
+	}
+
+	duplicate := models.Post{
+		Title:      "Copy of " + source.Title,
+		Content:    source.Content,
+		Slug:       h.uniqueSlug(source.Slug + "-copy"),
+		Visibility: source.Visibility,
+		Status:     models.PostStatusDraft,
+		AuthorID:   &user.ID,
+		CategoryID: source.CategoryID,
+		Excerpt:    source.Excerpt,
+		CoverImage: source.CoverImage,
+		Tags:       source.Tags,
+
+		MetaDescription: source.MetaDescription,
+		OGImage:         source.OGImage,
+	}
+	for _, t := range source.Translations {
+		duplicate.Translations = append(duplicate.Translations, models.PostTranslation{
+			Locale: t.Locale, Title: t.Title, Content: t.Content,
+		})
+	}
+
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&duplicate).Error; err != nil {
+			return err
+		}
+		var media []models.PostMedia
+		tx.Where("post_id = ?", source.ID).Find(&media)
+		for _, link := range media {
+			if err := tx.Create(&models.PostMedia{PostID: duplicate.ID, TMDBID: link.TMDBID}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to duplicate post")
+	}
+
+	return h.htmxRedirect(c, fmt.Sprintf("/admin/posts/%d/edit", duplicate.ID))
+}
+
+// uniqueSlug appends -2, -3, ... until slug is unused (including soft-deleted posts)
+func (h *BaseHandler) uniqueSlug(slug string) string {
+	candidate := slug
+	for i := 2; ; i++ {
+		var count int64
+		h.db.Unscoped().Model(&models.Post{}).Where("slug = ?", candidate).Count(&count)
+		if count == 0 {
+			return candidate
+		}
+		candidate = fmt.Sprintf("%s-%d", slug, i)
+	}
+}
@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"log"
+	"mini-blog/app/models"
+	"mini-blog/app/services"
+	"mini-blog/app/templates"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// postViewsSession lists the posts already counted in this browser session
+	postViewsSession = "post-views"
+	// maxSessionPostViews bounds that list so the cookie stays small; the oldest are forgotten first
+	maxSessionPostViews = 100
+	postViewDayLayout   = "2006-01-02"
+)
+
+// countPostView adds a view of post for today, once per browser session, under the same rules as page views:
+// nothing for Do Not Track, missing consent, bots or admins
+func (h *BaseHandler) countPostView(c echo.Context, post models.Post) {
+	if !h.analyticsAllowed(c) || services.IsBot(c.Request().UserAgent()) {
+		return
+	}
+	if user := h.GetCurrentUser(c); user != nil && user.IsAdmin() {
+		return
+	}
+
+	session, _ := h.store.Get(c.Request(), postViewsSession)
+	id := strconv.FormatUint(uint64(post.ID), 10)
+	seen, _ := session.Values["ids"].(string)
+	ids := strings.Split(seen, ",")
+	if slices.Contains(ids, id) {
+		return
+	}
+	if seen == "" {
+		ids = nil
+	}
+	ids = append(ids, id)
+	if len(ids) > maxSessionPostViews {
+		ids = ids[len(ids)-maxSessionPostViews:]
+	}
+	session.Values["ids"] = strings.Join(ids, ",")
+	options := *session.Options
+	options.MaxAge = 0 // gone when the browser closes
+	session.Options = &options
+	if err := session.Save(c.Request(), c.Response()); err != nil {
+		return
+	}
+
+	view := models.PostView{PostID: post.ID, Day: time.Now().UTC().Format(postViewDayLayout), Views: 1}
+	if err := h.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "post_id"}, {Name: "day"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{"views": gorm.Expr("post_views.views + 1")}),
+	}).Create(&view).Error; err != nil {
+		log.Printf("Failed to count view of post %d: %v", post.ID, err)
+	}
+}
+
+// attachViewCounts fills ViewCount on posts with their all-time views
+func (h *BaseHandler) attachViewCounts(posts []models.Post) {
+	if len(posts) == 0 {
+		return
+	}
+	ids := make([]uint, len(posts))
+	for i, post := range posts {
+		ids[i] = post.ID
+	}
+	var totals []struct {
+		PostID uint
+		Views  int64
+	}
+	h.db.Model(&models.PostView{}).Select("post_id, SUM(views) AS views").Where("post_id IN ?", ids).Group("post_id").Scan(&totals)
+	byPost := make(map[uint]int64, len(totals))
+	for _, total := range totals {
+		byPost[total.PostID] = total.Views
+	}
+	for i := range posts {
+		posts[i].ViewCount = byPost[posts[i].ID]
+	}
+}
+
+// postViewSeries is one count per UTC day over the last days days, oldest first, and their sum
+func (h *BaseHandler) postViewSeries(postID uint, days int) ([]models.AnalyticsCount, int64) {
+	today := time.Now().UTC()
+	series := make([]models.AnalyticsCount, days)
+	index := make(map[string]int, days)
+	for i := range series {
+		series[i].Label = today.AddDate(0, 0, i+1-days).Format(postViewDayLayout)
+		index[series[i].Label] = i
+	}
+
+	var rows []models.PostView
+	h.db.Where("post_id = ? AND day >= ?", postID, series[0].Label).Find(&rows)
+	var total int64
+	for _, row := range rows {
+		if i, ok := index[row.Day]; ok {
+			series[i].Views = row.Views
+			total += row.Views
+		}
+	}
+	return series, total
+}
+
+// postViewDays reads ?days= as one of the analytics page's ranges, 30 by default
+func postViewDays(c echo.Context) int {
+	days, _ := strconv.Atoi(c.QueryParam("days"))
+	if !slices.Contains(analyticsRanges, days) {
+		days = 30
+	}
+	return days
+}
+
+// AdminPostViews is the small per-post chart the dashboard opens under a post's row
+func (h *BaseHandler) AdminPostViews(c echo.Context) error {
+	id, err := h.parseUintParam(c, "id")
+	if err != nil {
+		return err
+	}
+	var post models.Post
+	if err := h.db.First(&post, id).Error; err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "Post not found")
+	}
+
+	days := postViewDays(c)
+	series, total := h.postViewSeries(post.ID, days)
+	return h.render(c, templates.PostViewsChart(post, series, total, days, analyticsRanges))
+}
+
+// PostViewsAPI returns a post's daily views for the last ?days= days (7, 30 or 90) as JSON; admins only
+func (h *BaseHandler) PostViewsAPI(c echo.Context) error {
+	if _, err := h.requireAdmin(c); err != nil {
+		return err
+	}
+	id, err := h.parseUintParam(c, "id")
+	if err != nil {
+		return err
+	}
+	var post models.Post
+	if err := h.db.First(&post, id).Error; err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "Post not found")
+	}
+
+	series, total := h.postViewSeries(post.ID, postViewDays(c))
+	daily := make([]map[string]interface{}, len(series))
+	for i, day := range series {
+		daily[i] = map[string]interface{}{"date": day.Label, "views": day.Views}
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"post_id": post.ID,
+		"slug":    post.Slug,
+		"total":   total,
+		"daily":   daily,
+	})
+}
@@ -1,75 +1,163 @@
 package handlers
 
 import (
+	"encoding/json"
+	"fmt"
+	"log"
 	"mini-blog/app/models"
 	"mini-blog/app/templates"
 	"net/http"
 	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/a-h/templ"
 	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
 )
 
 // Public Post handlers
 func (h *BaseHandler) Home(c echo.Context) error {
 	user := h.GetCurrentUser(c)
+	layout := h.homeLayout()
 
-	var posts []models.Post
-	query := models.DB.Where("published = ?", true).Order("created_at desc").Limit(5)
-
-	if err := query.Find(&posts).Error; err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch posts")
+	var sections []templ.Component
+	for _, section := range layout.Sections {
+		component, err := h.homeSection(c, section, layout, user)
+		if err != nil {
+			return err
+		}
+		if component != nil {
+			sections = append(sections, component)
+		}
 	}
 
-	accessible := h.getAccessiblePosts(posts, user)
-	return h.render(c, templates.Layout("Home", templates.PostsList(accessible, "Latest Posts", false, "", true, user), c.Request().URL.Path, user))
+	home := templates.HomePage(h.continueReading(c, user), sections)
+	return h.render(c, templates.Layout(h.t(c, "nav.home"), home, c.Request().URL.Path, user))
 }
 
+// postsPerPage is the page size of the /posts listing
+const postsPerPage = 10
+
+// postFieldLimits is the editor's error when a post fails validation
+const postFieldLimits = "Meta description must be at most 300 characters, the excerpt and image URLs at most 500"
+
+// Posts lists posts from query-string state (?search=&tag=&page=) so search and paging work without
+// JavaScript and can be bookmarked; a tag narrows the search. HTMX requests get just the results
 func (h *BaseHandler) Posts(c echo.Context) error {
 	user := h.GetCurrentUser(c)
-	searchQuery := h.trimFormValue(c, "search")
-
-	var posts []models.Post
-	query := models.DB.Where("published = ?", true)
+	state := templates.PostsState{Search: h.trimFormValue(c, "search"), Tag: strings.TrimSpace(c.QueryParam("tag")), Page: 1}
+	if page, err := strconv.Atoi(c.QueryParam("page")); err == nil && page > 1 {
+		state.Page = page
+	}
 
-	if searchQuery != "" {
-		searchTerm := "%" + searchQuery + "%"
+	title := h.t(c, "posts.all")
+	query := h.db.Model(&models.Post{}).Scopes(models.PostsVisibleTo(user))
+	if state.Tag != "" {
+		var tag models.Tag
+		if err := h.db.Where("slug = ?", state.Tag).First(&tag).Error; err != nil {
+			return echo.NewHTTPError(http.StatusNotFound, "Tag not found")
+		}
+		title = fmt.Sprintf(h.t(c, "posts.tagged"), tag.Name)
+		query = query.Where("id IN (?)", h.db.Model(&models.PostTag{}).Select("post_id").Where("tag_id = ?", tag.ID))
+	}
+	if state.Search != "" {
+		searchTerm := "%" + state.Search + "%"
 		query = query.Where("title ILIKE ? OR content ILIKE ?", searchTerm, searchTerm)
 	}
 
-	query = query.Order("created_at desc")
+	var total int64
+	if err := query.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch posts")
+	}
+	state.TotalPages = int((total + postsPerPage - 1) / postsPerPage)
 
-	if err := query.Find(&posts).Error; err != nil {
+	var posts []models.Post
+	if err := query.Preload("Tags").Order("created_at desc").Offset((state.Page - 1) * postsPerPage).Limit(postsPerPage).Find(&posts).Error; err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch posts")
 	}
 
-	accessible := h.getAccessiblePosts(posts, user)
+	h.localizePosts(c, posts)
+	h.markFinished(user, posts)
+	h.attachLikes(c, posts)
 
 	// Return just the posts content for HTMX requests
 	if h.isHTMXRequest(c) {
-		return h.render(c, templates.PostsContent(accessible, false))
+		return h.render(c, templates.PostsResults(posts, state))
 	}
 
-	return h.render(c, templates.Layout("Posts", templates.PostsList(accessible, "Blog Posts", true, searchQuery, false, user), c.Request().URL.Path, user))
+	return h.render(c, templates.Layout(h.t(c, "nav.posts"), templates.PostsList(posts, title, true, state, false, user), c.Request().URL.Path, user))
 }
 
 func (h *BaseHandler) PostView(c echo.Context) error {
-	slug := c.Param("slug")
+	user := h.GetCurrentUser(c)
+	post, err := h.loadPublishedPost(c)
+	if err != nil {
+		return err
+	}
+
+	h.db.Where("post_id = ?", post.ID).Find(&post.Translations)
+	h.db.Model(&post).Association("Tags").Find(&post.Tags)
+	if post.CategoryID != nil {
+		var category models.Category
+		if h.db.First(&category, *post.CategoryID).Error == nil {
+			post.Category = &category
+		}
+	}
+	// Audio of an earlier version would read out text the post no longer has
+	var narration models.PostNarration
+	if h.db.Where("post_id = ? AND status = ?", post.ID, models.NarrationReady).First(&narration).Error == nil {
+		if markStaleNarration(post, &narration); !narration.Stale {
+			post.Narration = &narration
+		}
+	}
+	post.Localize(h.resolveLocale(c))
+	h.attachLinkedMedia(c, &post)
+	h.noindexPost(c, post)
+	h.postMeta(c, post)
+	// A draft opened from a preview link is neither indexed, counted nor tracked as read
+	if !post.Published {
+		h.noindex(c)
+		return h.render(c, templates.Layout(post.Title, templates.PostView(post, nil), c.Request().URL.Path, user))
+	}
+	liked := []models.Post{post}
+	h.attachLikes(c, liked)
+	post = liked[0]
+	h.countPostView(c, post)
+
+	return h.render(c, templates.Layout(post.Title, templates.PostView(post, h.readingProgressFor(user, post.ID)), c.Request().URL.Path, user))
+}
+
+// loadPublishedPost finds the published post for :slug, if the current visitor may read it.
+// Under /preview/:token it is the post the signed token opens instead, published or not, for whoever holds the link.
+func (h *BaseHandler) loadPublishedPost(c echo.Context) (models.Post, error) {
 	user := h.GetCurrentUser(c)
 
+	query := h.db.Where("slug = ? AND published = ?", c.Param("slug"), true)
+	previewID, preview := h.previewPostID(c.Param("token"))
+	if preview {
+		query = h.db.Where("id = ?", previewID)
+	}
 	var post models.Post
-	if err := models.DB.Where("slug = ? AND published = ?", slug, true).First(&post).Error; err != nil {
-		return echo.NewHTTPError(http.StatusNotFound, "Post not found")
+	if err := query.First(&post).Error; err != nil {
+		return post, echo.NewHTTPError(http.StatusNotFound, "Post not found")
+	}
+	// A post hidden after reports is gone for everyone but admins
+	if post.Hidden && (user == nil || !user.IsAdmin()) {
+		return post, echo.NewHTTPError(http.StatusNotFound, "Post not found")
+	}
+	if preview {
+		return post, nil
 	}
 
 	if !post.CanAccess(user) {
 		if user == nil {
-			return echo.NewHTTPError(http.StatusUnauthorized, "Login required to view this post")
+			return post, echo.NewHTTPError(http.StatusUnauthorized, "Login required to view this post")
 		}
-		return echo.NewHTTPError(http.StatusForbidden, "Access denied")
+		return post, echo.NewHTTPError(http.StatusForbidden, "Access denied")
 	}
-
-	return h.render(c, templates.Layout(post.Title, templates.PostView(post), c.Request().URL.Path, user))
+	return post, nil
 }
 
 // Admin dashboard
@@ -78,23 +166,28 @@ func (h *BaseHandler) AdminDashboard(c echo.Context) error {
 
 	// Fetch users
 	var users []models.User
-	models.DB.Order("created_at desc").Find(&users)
+	h.db.Order("created_at desc").Find(&users)
 
 	// Fetch posts
 	var posts []models.Post
-	models.DB.Order("created_at desc").Find(&posts)
+	h.db.Order("created_at desc").Find(&posts)
+	h.attachViewCounts(posts)
 
 	// Calculate stats
 	stats := models.DashboardStats{}
-	models.DB.Model(&models.User{}).Count(&stats.TotalUsers)
-	models.DB.Model(&models.User{}).Where("role IN ?", []string{models.RolePremium, models.RoleAdmin}).Count(&stats.PremiumUsers)
-	models.DB.Model(&models.Post{}).Count(&stats.TotalPosts)
-	models.DB.Model(&models.Post{}).Where("published = ?", true).Count(&stats.PublishedPosts)
-
+	h.db.Model(&models.User{}).Count(&stats.TotalUsers)
+	h.db.Model(&models.User{}).Where("role IN ?", []string{models.RolePremium, models.RoleAdmin}).Count(&stats.PremiumUsers)
+	h.db.Model(&models.Post{}).Count(&stats.TotalPosts)
+	h.db.Model(&models.Post{}).Where("published = ?", true).Count(&stats.PublishedPosts)
+	h.db.Model(&models.Notification{}).Where("read_at IS NULL").Count(&stats.UnreadNotifications)
+	stats.TMDB = h.tmdbQuota()
+	stats.MostLiked = h.mostLiked()
+
+	page := templates.AdminDashboard(users, posts, h.adminCategories(), stats)
 	if h.isHTMXRequest(c) {
-		return h.render(c, templates.AdminDashboard(users, posts, stats))
+		return h.render(c, page)
 	}
-	return h.render(c, templates.Layout("Admin Dashboard", templates.AdminDashboard(users, posts, stats), c.Request().URL.Path, user))
+	return h.render(c, templates.Layout("Admin Dashboard", page, c.Request().URL.Path, user))
 }
 
 // Admin user management
@@ -110,15 +203,16 @@ func (h *BaseHandler) AdminUpdateUserRole(c echo.Context) error {
 	}
 
 	var targetUser models.User
-	if err := models.DB.First(&targetUser, userID).Error; err != nil {
+	if err := h.db.First(&targetUser, userID).Error; err != nil {
 		return echo.NewHTTPError(http.StatusNotFound, "User not found")
 	}
 
-	if err := models.DB.Model(&targetUser).Update("role", newRole).Error; err != nil {
+	// A role set by hand replaces any running trial
+	if err := h.db.Model(&targetUser).Updates(map[string]interface{}{"role": newRole, "trial_ends_at": nil}).Error; err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to update user role")
 	}
 
-	if err := models.DB.First(&targetUser, userID).Error; err != nil {
+	if err := h.db.First(&targetUser, userID).Error; err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to reload user")
 	}
 
@@ -128,10 +222,15 @@ func (h *BaseHandler) AdminUpdateUserRole(c echo.Context) error {
 // Admin post management
 func (h *BaseHandler) AdminPostNew(c echo.Context) error {
 	user := c.Get("user").(*models.User)
+
+	var postTemplates []models.PostTemplate
+	h.db.Order("name asc").Find(&postTemplates)
+	page := templates.PostCreatePage(postTemplates, c.QueryParam("template"), h.postFromTemplate(c), h.postCategories())
+
 	if h.isHTMXRequest(c) {
-		return h.render(c, templates.PostCreatePage())
+		return h.render(c, page)
 	}
-	return h.render(c, templates.Layout("Create New Post", templates.PostCreatePage(), c.Request().URL.Path, user))
+	return h.render(c, templates.Layout("Create New Post", page, c.Request().URL.Path, user))
 }
 
 func (h *BaseHandler) AdminPostEdit(c echo.Context) error {
@@ -141,15 +240,16 @@ func (h *BaseHandler) AdminPostEdit(c echo.Context) error {
 	}
 
 	var post models.Post
-	if err := models.DB.First(&post, id).Error; err != nil {
+	if err := h.db.Preload("Tags").First(&post, id).Error; err != nil {
 		return echo.NewHTTPError(http.StatusNotFound, "Post not found")
 	}
 
 	user := c.Get("user").(*models.User)
+	page := templates.PostEditPage(&post, h.postCategories())
 	if h.isHTMXRequest(c) {
-		return h.render(c, templates.PostEditPage(&post))
+		return h.render(c, page)
 	}
-	return h.render(c, templates.Layout("Edit Post", templates.PostEditPage(&post), c.Request().URL.Path, user))
+	return h.render(c, templates.Layout("Edit Post", page, c.Request().URL.Path, user))
 }
 
 func (h *BaseHandler) AdminPostCreate(c echo.Context) error {
@@ -168,10 +268,36 @@ func (h *BaseHandler) AdminPostCreate(c echo.Context) error {
 		visibility = models.VisibilityPublic
 	}
 
-	if err := models.DB.Create(&models.Post{
+	user := c.Get("user").(*models.User)
+	post := models.Post{
 		Title: title, Slug: slug, Content: content,
-		Visibility: visibility, Published: c.FormValue("published") == "on",
-	}).Error; err != nil {
+		Visibility: visibility, Status: models.PostStatusDraft,
+		PublishAt: h.parseFormTime(c, "publish_at"), ExpiresAt: h.parseFormTime(c, "expires_at"), AuthorID: &user.ID, CategoryID: h.postCategoryID(c),
+		MetaDescription: h.trimFormValue(c, "meta_description"), OGImage: h.trimFormValue(c, "og_image"),
+		Excerpt: h.trimFormValue(c, "excerpt"), CoverImage: h.trimFormValue(c, "cover_image"),
+	}
+	if err := h.validator.Struct(post); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, postFieldLimits)
+	}
+	if err := checkPostExpiry(&post); err != nil {
+		return err
+	}
+	tags := h.parseTags(c)
+	if err := h.checkTags(tags); err != nil {
+		return err
+	}
+
+	// All or nothing, so a failed save doesn't leave a post behind for the resubmitted form to collide with
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&post).Error; err != nil {
+			return err
+		}
+		if err := h.savePostMedia(c, tx, post.ID); err != nil {
+			return err
+		}
+		return h.replacePostTags(tx, &post, tags)
+	})
+	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create post")
 	}
 
@@ -179,6 +305,57 @@ func (h *BaseHandler) AdminPostCreate(c echo.Context) error {
 	return c.NoContent(http.StatusOK)
 }
 
+// PostCreateAPI creates a draft post from JSON {"title", "content", "slug", "visibility"}; content is markdown
+func (h *BaseHandler) PostCreateAPI(c echo.Context) error {
+	user, err := h.requireAdmin(c)
+	if err != nil {
+		return err
+	}
+
+	var body struct {
+		Title      string `json:"title"`
+		Content    string `json:"content"`
+		Slug       string `json:"slug"`
+		Visibility string `json:"visibility"`
+	}
+	if err := json.NewDecoder(c.Request().Body).Decode(&body); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid JSON")
+	}
+	title, content := strings.TrimSpace(body.Title), strings.TrimSpace(body.Content)
+	if title == "" || content == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "Title and content are required")
+	}
+
+	slug := strings.TrimSpace(body.Slug)
+	if slug == "" {
+		slug = h.generateSlug(title)
+	}
+	visibility := body.Visibility
+	if !models.IsValidVisibility(visibility) {
+		visibility = models.VisibilityPublic
+	}
+
+	post := models.Post{
+		Title: title, Slug: slug, Content: content,
+		Visibility: visibility, Status: models.PostStatusDraft, AuthorID: &user.ID,
+	}
+	var taken int64
+	h.db.Model(&models.Post{}).Where("slug = ?", slug).Count(&taken)
+	if taken > 0 {
+		return echo.NewHTTPError(http.StatusConflict, "Slug already in use: "+slug)
+	}
+	if err := h.db.Create(&post).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create post")
+	}
+
+	return c.JSON(http.StatusCreated, map[string]interface{}{
+		"id":     post.ID,
+		"slug":   post.Slug,
+		"status": post.Status,
+		"url":    strings.TrimSuffix(h.cfg.Server.BaseURL, "/") + fmt.Sprintf("/admin/posts/%d/edit", post.ID),
+	})
+}
+
 func (h *BaseHandler) AdminPostUpdate(c echo.Context) error {
 	id, err := h.parseUintParam(c, "id")
 	if err != nil {
@@ -186,7 +363,7 @@ func (h *BaseHandler) AdminPostUpdate(c echo.Context) error {
 	}
 
 	var post models.Post
-	if err := models.DB.First(&post, id).Error; err != nil {
+	if err := h.db.First(&post, id).Error; err != nil {
 		return echo.NewHTTPError(http.StatusNotFound, "Post not found")
 	}
 
@@ -204,11 +381,63 @@ func (h *BaseHandler) AdminPostUpdate(c echo.Context) error {
 	if !models.IsValidVisibility(post.Visibility) {
 		post.Visibility = models.VisibilityPublic
 	}
-	post.Published = c.FormValue("published") == "on"
+	post.PublishAt, post.ExpiresAt = h.parseFormTime(c, "publish_at"), h.parseFormTime(c, "expires_at")
+	post.CategoryID = h.postCategoryID(c)
+	post.MetaDescription, post.OGImage = h.trimFormValue(c, "meta_description"), h.trimFormValue(c, "og_image")
+	post.Excerpt, post.CoverImage = h.trimFormValue(c, "excerpt"), h.trimFormValue(c, "cover_image")
+	if err := h.validator.Struct(post); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, postFieldLimits)
+	}
+	if err := checkPostExpiry(&post); err != nil {
+		return err
+	}
+	tags := h.parseTags(c)
+	if err := h.checkTags(tags); err != nil {
+		return err
+	}
 
-	if err := models.DB.Save(&post).Error; err != nil {
+	// Only write if nobody else saved since this form was loaded; the post, its media and its tags save together
+	version, _ := strconv.Atoi(c.FormValue("version"))
+	conflict := false
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.Post{}).Where("id = ? AND version = ?", post.ID, version).Updates(map[string]interface{}{
+			"title":            post.Title,
+			"content":          post.Content,
+			"slug":             post.Slug,
+			"visibility":       post.Visibility,
+			"publish_at":       post.PublishAt,
+			"expires_at":       post.ExpiresAt,
+			"category_id":      post.CategoryID,
+			"meta_description": post.MetaDescription,
+			"og_image":         post.OGImage,
+			"excerpt":          post.Excerpt,
+			"cover_image":      post.CoverImage,
+			"version":          gorm.Expr("version + 1"),
+		})
+		if result.Error != nil {
+			return result.Error
+		}
+		if conflict = result.RowsAffected == 0; conflict {
+			return nil
+		}
+		if err := h.savePostMedia(c, tx, post.ID); err != nil {
+			return err
+		}
+		return h.replacePostTags(tx, &post, tags)
+	})
+	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to update post")
 	}
+	if conflict {
+		var current models.Post
+		if err := h.db.First(&current, post.ID).Error; err != nil {
+			return echo.NewHTTPError(http.StatusNotFound, "Post not found")
+		}
+		// Carry the latest version so resubmitting the merged form goes through
+		post.Version = current.Version
+		post.Tags = tags
+		return h.render(c, templates.PostConflictPage(&post, &current, h.postCategories()))
+	}
 
 	c.Response().Header().Set("HX-Redirect", "/admin/dashboard")
 	return c.NoContent(http.StatusOK)
@@ -220,13 +449,77 @@ func (h *BaseHandler) AdminPostDelete(c echo.Context) error {
 		return err
 	}
 
-	if err := models.DB.Delete(&models.Post{}, id).Error; err != nil {
+	if err := h.db.Delete(&models.Post{}, id).Error; err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to delete post")
 	}
 
 	return c.NoContent(http.StatusOK)
 }
 
+// parseFormTime reads a datetime-local field, such as publish_at, in the admin's timezone
+func (h *BaseHandler) parseFormTime(c echo.Context, name string) *time.Time {
+	value := h.trimFormValue(c, name)
+	if value == "" {
+		return nil
+	}
+	t, err := time.ParseInLocation("2006-01-02T15:04", value, h.userLocation(c))
+	if err != nil {
+		return nil
+	}
+	return &t
+}
+
+// checkPostExpiry rejects an expiry that comes before the post is due to publish
+func checkPostExpiry(post *models.Post) error {
+	if post.ExpiresAt != nil && post.PublishAt != nil && !post.ExpiresAt.After(*post.PublishAt) {
+		return echo.NewHTTPError(http.StatusBadRequest, "Expires at must come after publish at")
+	}
+	return nil
+}
+
+// PublishScheduledPosts publishes approved posts whose PublishAt has passed and emails them to subscribers
+func (h *BaseHandler) PublishScheduledPosts() {
+	var due []models.Post
+	if err := h.db.Where("status = ? AND publish_at IS NOT NULL AND publish_at <= ?", models.PostStatusApproved, time.Now()).Find(&due).Error; err != nil {
+		log.Printf("Failed to publish scheduled posts: %v", err)
+		return
+	}
+
+	published := 0
+	for _, post := range due {
+		// The status check skips posts an admin moved on since they were loaded
+		result := h.db.Model(&models.Post{}).Where("id = ? AND status = ?", post.ID, models.PostStatusApproved).
+			Updates(map[string]interface{}{"status": models.PostStatusPublished, "published": true})
+		if result.Error != nil {
+			log.Printf("Failed to publish scheduled post %d: %v", post.ID, result.Error)
+			continue
+		}
+		if result.RowsAffected == 0 {
+			continue
+		}
+		published++
+		post.SetStatus(models.PostStatusPublished)
+		h.notifySubscribers(post)
+	}
+	if published > 0 {
+		log.Printf("Published %d scheduled post(s)", published)
+	}
+}
+
+// UnpublishExpiredPosts moves published posts whose ExpiresAt has passed back to draft. ExpiresAt is
+// kept, so the editor shows when the post came down.
+func (h *BaseHandler) UnpublishExpiredPosts() {
+	result := h.db.Model(&models.Post{}).Where("status = ? AND expires_at IS NOT NULL AND expires_at <= ?", models.PostStatusPublished, time.Now()).
+		Updates(map[string]interface{}{"status": models.PostStatusDraft, "published": false})
+	if result.Error != nil {
+		log.Printf("Failed to unpublish expired posts: %v", result.Error)
+		return
+	}
+	if result.RowsAffected > 0 {
+		log.Printf("Unpublished %d expired post(s)", result.RowsAffected)
+	}
+}
+
 // Helper for slug generation
 func (h *BaseHandler) generateSlug(title string) string {
 	return strings.Trim(regexp.MustCompile(`-+`).ReplaceAllString(regexp.MustCompile(`\s+`).ReplaceAllString(regexp.MustCompile(`[^a-z0-9\s-]`).ReplaceAllString(strings.ToLower(title), ""), "-"), "-"), "-")
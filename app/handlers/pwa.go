@@ -0,0 +1,223 @@
+package handlers
+
+import (
+	"encoding/json"
+	"mini-blog/app/models"
+	"mini-blog/app/services"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	offlineLatestPosts  = 10
+	offlineMaxReadPosts = 20
+)
+
+// OfflinePost is a post snapshot the service worker can show without a network
+type OfflinePost struct {
+	Slug      string    `json:"slug"`
+	Title     string    `json:"title"`
+	URL       string    `json:"url"`
+	HTML      string    `json:"html"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// OfflineMedia is a watchlist entry snapshot
+type OfflineMedia struct {
+	TMDBID        int    `json:"tmdb_id"`
+	Type          string `json:"type"`
+	Title         string `json:"title"`
+	Status        string `json:"status"`
+	Progress      int    `json:"progress"`
+	TotalEpisodes int    `json:"total_episodes"`
+	PosterPath    string `json:"poster_path,omitempty"`
+}
+
+// Manifest serves the web app manifest so the site can be installed
+func (h *BaseHandler) Manifest(c echo.Context) error {
+	c.Response().Header().Set(echo.HeaderContentType, "application/manifest+json")
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"name":             "NODELIKE",
+		"short_name":       "NODELIKE",
+		"description":      "Posts and TV tracking",
+		"start_url":        "/",
+		"scope":            "/",
+		"display":          "standalone",
+		"background_color": "#ffffff",
+		"theme_color":      "#ffffff",
+		"icons": []map[string]string{
+			{"src": "/icon.svg", "sizes": "any", "type": "image/svg+xml", "purpose": "any maskable"},
+		},
+	})
+}
+
+// AppIcon serves the install icon used by the manifest
+func (h *BaseHandler) AppIcon(c echo.Context) error {
+	c.Response().Header().Set(echo.HeaderCacheControl, "public, max-age=86400")
+	return c.Blob(http.StatusOK, "image/svg+xml", []byte(appIconSVG))
+}
+
+// ServiceWorker serves the worker script from the site root so it controls every page
+func (h *BaseHandler) ServiceWorker(c echo.Context) error {
+	c.Response().Header().Set("Service-Worker-Allowed", "/")
+	c.Response().Header().Set(echo.HeaderCacheControl, "no-cache")
+	shell := []string{"/"}
+	if h.cfg.BlogEnabled() {
+		shell = append(shell, "/posts")
+	}
+	if h.cfg.TrackerEnabled() {
+		shell = append(shell, "/tv")
+	}
+	paths, _ := json.Marshal(append(shell, "/static/styles.css", "/icon.svg"))
+	script := strings.Replace(serviceWorkerJS, "SHELL_PATHS", string(paths), 1)
+	return c.Blob(http.StatusOK, "application/javascript; charset=utf-8", []byte(script))
+}
+
+// OfflineSync returns recently read posts and the watchlist for offline caching.
+// Clients pass the slugs they have read as ?read=a,b,c
+func (h *BaseHandler) OfflineSync(c echo.Context) error {
+	offlinePosts := []OfflinePost{}
+	if h.cfg.BlogEnabled() {
+		offlinePosts = h.offlinePosts(c)
+	}
+	watchlist := []OfflineMedia{}
+	if h.cfg.TrackerEnabled() {
+		watchlist = h.offlineWatchlist(c)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"generated_at": time.Now().UTC(),
+		"posts":        offlinePosts,
+		"watchlist":    watchlist,
+	})
+}
+
+func (h *BaseHandler) offlinePosts(c echo.Context) []OfflinePost {
+	user := h.GetCurrentUser(c)
+
+	var slugs []string
+	for _, slug := range strings.Split(c.QueryParam("read"), ",") {
+		if slug = strings.TrimSpace(slug); slug != "" && len(slugs) < offlineMaxReadPosts {
+			slugs = append(slugs, slug)
+		}
+	}
+
+	var posts []models.Post
+	h.db.Where("published = ?", true).Order("created_at desc").Limit(offlineLatestPosts).Find(&posts)
+	if len(slugs) > 0 {
+		var read []models.Post
+		h.db.Where("published = ? AND slug IN ?", true, slugs).Find(&read)
+		posts = append(posts, read...)
+	}
+
+	posts = h.getAccessiblePosts(posts, user)
+	h.localizePosts(c, posts)
+
+	seen := make(map[uint]bool, len(posts))
+	offlinePosts := []OfflinePost{}
+	for _, post := range posts {
+		if seen[post.ID] {
+			continue
+		}
+		seen[post.ID] = true
+		offlinePosts = append(offlinePosts, OfflinePost{
+			Slug:      post.Slug,
+			Title:     post.Title,
+			URL:       "/posts/" + post.Slug,
+			HTML:      string(services.MarkdownToHTML(post.Content)),
+			CreatedAt: post.CreatedAt,
+		})
+	}
+	return offlinePosts
+}
+
+func (h *BaseHandler) offlineWatchlist(c echo.Context) []OfflineMedia {
+	var media []models.Media
+	query := h.db.Where("status IN ?", []string{models.StatusWatching, models.StatusPlanned}).Order("updated_at desc")
+	if h.hideAdult(c) {
+		query = query.Scopes(models.HideAdultMedia)
+	}
+	query.Find(&media)
+
+	watchlist := make([]OfflineMedia, 0, len(media))
+	for _, m := range media {
+		watchlist = append(watchlist, OfflineMedia{
+			TMDBID:        m.TMDBID,
+			Type:          m.Type,
+			Title:         m.Title,
+			Status:        m.Status,
+			Progress:      m.Progress,
+			TotalEpisodes: m.TotalEpisodes,
+			PosterPath:    m.PosterPath,
+		})
+	}
+	return watchlist
+}
+
+const appIconSVG = `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 512 512"><rect width="512" height="512" fill="#111827"/><text x="256" y="340" font-family="monospace" font-size="280" font-weight="700" fill="#ffffff" text-anchor="middle">N</text></svg>`
+
+// serviceWorkerJS caches the app shell and visited pages, falling back to the
+// cache when offline. Admin and API routes are never cached. SHELL_PATHS is the
+// site's shell, which depends on SITE_MODE.
+const serviceWorkerJS = `const CACHE = 'nodelike-v1';
+const SHELL = SHELL_PATHS;
+
+self.addEventListener('install', (event) => {
+	event.waitUntil(caches.open(CACHE).then((cache) => cache.addAll(SHELL)).then(() => self.skipWaiting()));
+});
+
+self.addEventListener('activate', (event) => {
+	event.waitUntil(
+		caches.keys()
+			.then((keys) => Promise.all(keys.filter((k) => k !== CACHE).map((k) => caches.delete(k))))
+			.then(() => self.clients.claim())
+	);
+});
+
+function cacheable(url) {
+	return url.origin === self.location.origin &&
+		!url.pathname.startsWith('/admin') &&
+		!url.pathname.startsWith('/api/') &&
+		url.pathname !== '/sw.js';
+}
+
+self.addEventListener('fetch', (event) => {
+	const request = event.request;
+	const url = new URL(request.url);
+	if (request.method !== 'GET' || !cacheable(url) || request.headers.get('HX-Request')) {
+		return;
+	}
+
+	event.respondWith(
+		fetch(request)
+			.then((response) => {
+				if (response.ok) {
+					const copy = response.clone();
+					caches.open(CACHE).then((cache) => cache.put(request, copy));
+				}
+				return response;
+			})
+			.catch(() => caches.match(request).then((hit) => hit || caches.match('/')))
+	);
+});
+
+// Pages post {type: 'sync', read: [slugs]} to refresh the offline snapshot
+self.addEventListener('message', (event) => {
+	if (!event.data || event.data.type !== 'sync') {
+		return;
+	}
+	const read = (event.data.read || []).map(encodeURIComponent).join(',');
+	event.waitUntil(
+		fetch('/api/offline?read=' + read)
+			.then((response) => response.json())
+			.then((snapshot) => caches.open(CACHE).then((cache) => Promise.all([
+				cache.put('/api/offline', new Response(JSON.stringify(snapshot), {headers: {'Content-Type': 'application/json'}})),
+				...snapshot.posts.map((post) => cache.add(post.url).catch(() => null)),
+			])))
+			.catch(() => null)
+	);
+});
+`
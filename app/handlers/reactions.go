@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"mini-blog/app/models"
+	"mini-blog/app/templates"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+const (
+	// visitorCookieName holds a random ID for anonymous visitors' likes; only its hash is stored
+	visitorCookieName = "visitor"
+	mostLikedPosts    = 5
+	// anonLikesPerIP caps signed-out likes per address an hour; clearing the cookie would otherwise buy a fresh like
+	anonLikesPerIP = 30
+)
+
+// PostLikeToggle likes the post for the current visitor, or takes the like back, and re-renders the button
+func (h *BaseHandler) PostLikeToggle(c echo.Context) error {
+	post, err := h.loadPublishedPost(c)
+	if err != nil {
+		return err
+	}
+
+	userID, visitorHash := h.reactor(c, true)
+	if userID == 0 {
+		if _, allowed := h.anonLikeLimiter.Allow(h.clientKey(c)); !allowed {
+			return echo.NewHTTPError(http.StatusTooManyRequests, "Too many likes, sign in or try again later")
+		}
+	}
+	reactor := h.db.Where("post_id = ? AND user_id = ? AND visitor_hash = ? AND kind = ?", post.ID, userID, visitorHash, models.ReactionLike)
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where(reactor).Delete(&models.Reaction{})
+		if result.Error != nil || result.RowsAffected > 0 {
+			return result.Error
+		}
+		return tx.Create(&models.Reaction{PostID: post.ID, UserID: userID, VisitorHash: visitorHash, Kind: models.ReactionLike}).Error
+	})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to save like")
+	}
+
+	posts := []models.Post{post}
+	h.attachLikes(c, posts)
+	return h.render(c, templates.LikeButton(posts[0]))
+}
+
+// reactor identifies who is reacting: the signed-in user, or the hash of the anonymous visitor cookie.
+// With issue set, a visitor without the cookie gets one; otherwise they have no hash yet.
+func (h *BaseHandler) reactor(c echo.Context, issue bool) (userID uint, visitorHash string) {
+	if user := h.GetCurrentUser(c); user != nil {
+		return user.ID, ""
+	}
+
+	if cookie, err := c.Cookie(visitorCookieName); err == nil && cookie.Value != "" {
+		return 0, hashVisitor(cookie.Value)
+	}
+	if !issue {
+		return 0, ""
+	}
+	random := make([]byte, 16)
+	rand.Read(random)
+	id := hex.EncodeToString(random)
+	c.SetCookie(&http.Cookie{
+		Name:     visitorCookieName,
+		Value:    id,
+		Path:     "/",
+		MaxAge:   86400 * 365,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return 0, hashVisitor(id)
+}
+
+func hashVisitor(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:])
+}
+
+// attachLikes fills each post's like count and whether the current visitor liked it
+func (h *BaseHandler) attachLikes(c echo.Context, posts []models.Post) {
+	if len(posts) == 0 {
+		return
+	}
+	ids := make([]uint, len(posts))
+	for i, post := range posts {
+		ids[i] = post.ID
+	}
+
+	var counts []struct {
+		PostID uint
+		Count  int
+	}
+	h.db.Model(&models.Reaction{}).Select("post_id, COUNT(*) AS count").
+		Where("post_id IN ? AND kind = ?", ids, models.ReactionLike).Group("post_id").Scan(&counts)
+	byPost := make(map[uint]int, len(counts))
+	for _, count := range counts {
+		byPost[count.PostID] = count.Count
+	}
+
+	liked := map[uint]bool{}
+	if userID, visitorHash := h.reactor(c, false); userID != 0 || visitorHash != "" {
+		var likedIDs []uint
+		h.db.Model(&models.Reaction{}).
+			Where("post_id IN ? AND user_id = ? AND visitor_hash = ? AND kind = ?", ids, userID, visitorHash, models.ReactionLike).
+			Pluck("post_id", &likedIDs)
+		for _, id := range likedIDs {
+			liked[id] = true
+		}
+	}
+
+	for i := range posts {
+		posts[i].LikeCount = byPost[posts[i].ID]
+		posts[i].Liked = liked[posts[i].ID]
+	}
+}
+
+// mostLiked is the dashboard's top posts by likes, published or not
+func (h *BaseHandler) mostLiked() []models.Post {
+	var posts []models.Post
+	h.db.Model(&models.Post{}).
+		Select("posts.*, COUNT(reactions.id) AS like_count").
+		Joins("JOIN reactions ON reactions.post_id = posts.id AND reactions.kind = ?", models.ReactionLike).
+		Group("posts.id").
+		Order("like_count DESC, posts.created_at DESC").
+		Limit(mostLikedPosts).
+		Find(&posts)
+	return posts
+}
@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"mini-blog/app/models"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+const continueReadingLimit = 3
+
+// ReadingProgressBeacon records how far the current user has read a post.
+// It is hit via navigator.sendBeacon, so anonymous requests are quietly ignored.
+func (h *BaseHandler) ReadingProgressBeacon(c echo.Context) error {
+	user := h.GetCurrentUser(c)
+	if user == nil {
+		return c.NoContent(http.StatusNoContent)
+	}
+
+	percent, err := strconv.Atoi(c.FormValue("percent"))
+	if err != nil || percent < 0 || percent > 100 {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid progress")
+	}
+
+	var post models.Post
+	if err := h.db.Select("id", "title", "slug", "visibility", "published", "hidden").Where("slug = ? AND published = ?", c.Param("slug"), true).First(&post).Error; err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "Post not found")
+	}
+	if !post.CanAccess(user) {
+		return echo.NewHTTPError(http.StatusForbidden, "Access denied")
+	}
+
+	var progress models.ReadingProgress
+	h.db.Where("user_id = ? AND post_id = ?", user.ID, post.ID).
+		FirstOrInit(&progress, models.ReadingProgress{UserID: user.ID, PostID: post.ID})
+
+	progress.Percent = percent
+	finished := !progress.Completed && percent >= models.ReadingFinishedPercent
+	if finished {
+		progress.Completed = true
+	}
+
+	if err := h.db.Save(&progress).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to save progress")
+	}
+	if finished {
+		h.recordActivity(user.ID, models.ActivityPostRead, "Read "+post.Title, "/posts/"+post.Slug)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// readingProgressFor returns the user's saved progress on a post, or a fresh record
+func (h *BaseHandler) readingProgressFor(user *models.User, postID uint) *models.ReadingProgress {
+	if user == nil {
+		return nil
+	}
+	progress := &models.ReadingProgress{UserID: user.ID, PostID: postID}
+	h.db.Where("user_id = ? AND post_id = ?", user.ID, postID).First(progress)
+	return progress
+}
+
+// continueReading returns posts the user started but hasn't finished, most recent first
+func (h *BaseHandler) continueReading(c echo.Context, user *models.User) []models.ReadingProgress {
+	if user == nil {
+		return nil
+	}
+
+	var progress []models.ReadingProgress
+	h.db.Preload("Post").
+		Joins("JOIN posts ON posts.id = reading_progresses.post_id AND posts.published = ? AND posts.deleted_at IS NULL", true).
+		Where("reading_progresses.user_id = ? AND reading_progresses.completed = ? AND reading_progresses.percent >= ?",
+			user.ID, false, models.ReadingStartedPercent).
+		Order("reading_progresses.updated_at desc").
+		Limit(continueReadingLimit).
+		Find(&progress)
+
+	var accessible []models.ReadingProgress
+	for _, p := range progress {
+		if p.Post.CanAccess(user) {
+			accessible = append(accessible, p)
+		}
+	}
+
+	posts := make([]models.Post, len(accessible))
+	for i := range accessible {
+		posts[i] = accessible[i].Post
+	}
+	h.localizePosts(c, posts)
+	for i := range accessible {
+		accessible[i].Post = posts[i]
+	}
+	return accessible
+}
+
+// markFinished flags the posts the user has read to the end
+func (h *BaseHandler) markFinished(user *models.User, posts []models.Post) {
+	if user == nil || len(posts) == 0 {
+		return
+	}
+
+	ids := make([]uint, len(posts))
+	for i, post := range posts {
+		ids[i] = post.ID
+	}
+
+	var finished []uint
+	h.db.Model(&models.ReadingProgress{}).
+		Where("user_id = ? AND completed = ? AND post_id IN ?", user.ID, true, ids).
+		Pluck("post_id", &finished)
+
+	done := make(map[uint]bool, len(finished))
+	for _, id := range finished {
+		done[id] = true
+	}
+	for i := range posts {
+		posts[i].Finished = done[posts[i].ID]
+	}
+}
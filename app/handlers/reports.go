@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"fmt"
+	"mini-blog/app/models"
+	"mini-blog/app/templates"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// reportQueueSize bounds how many reports the moderation queue loads at once
+const reportQueueSize = 500
+
+// PostReport flags a post for the admins, with a reason and optional details
+func (h *BaseHandler) PostReport(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+	post, err := h.loadPublishedPost(c)
+	if err != nil {
+		return err
+	}
+	if post.AuthorID != nil && *post.AuthorID == user.ID {
+		return echo.NewHTTPError(http.StatusBadRequest, "You can't report your own post")
+	}
+	return h.createReport(c, user, models.Report{PostID: post.ID})
+}
+
+// CommentReport flags a comment under a post
+func (h *BaseHandler) CommentReport(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+	post, err := h.loadPublishedPost(c)
+	if err != nil {
+		return err
+	}
+	id, err := h.parseUintParam(c, "id")
+	if err != nil {
+		return err
+	}
+
+	var comment models.Comment
+	if err := h.db.Where("id = ? AND post_id = ?", id, post.ID).First(&comment).Error; err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "Comment not found")
+	}
+	if comment.UserID == user.ID {
+		return echo.NewHTTPError(http.StatusBadRequest, "You can't report your own comment")
+	}
+	return h.createReport(c, user, models.Report{PostID: post.ID, CommentID: &comment.ID})
+}
+
+// createReport files report from user, once per person and post or comment, and hides the target
+// when enough people have reported it
+func (h *BaseHandler) createReport(c echo.Context, user *models.User, report models.Report) error {
+	report.ReporterID = user.ID
+	report.Reason, report.Details = c.FormValue("reason"), h.trimFormValue(c, "details")
+	report.Status = models.ReportOpen
+	if err := h.validator.Struct(report); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Pick a reason and keep details under 500 characters")
+	}
+
+	var already int64
+	reportTarget(h.db.Model(&models.Report{}), report).
+		Where("reporter_id = ? AND status = ?", user.ID, models.ReportOpen).Count(&already)
+	if already == 0 {
+		if err := h.db.Create(&report).Error; err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to send report")
+		}
+		h.hideIfReported(report)
+	}
+	return h.render(c, templates.ReportSent())
+}
+
+// hideIfReported hides the report's post or comment once REPORT_HIDE_THRESHOLD people have open reports on it
+func (h *BaseHandler) hideIfReported(report models.Report) {
+	threshold := h.cfg.Moderation.ReportThreshold
+	if threshold <= 0 {
+		return
+	}
+	var reporters int64
+	reportTarget(h.db.Model(&models.Report{}), report).
+		Where("status = ?", models.ReportOpen).Distinct("reporter_id").Count(&reporters)
+	if reporters < int64(threshold) {
+		return
+	}
+
+	target, what := h.db.Model(&models.Post{}).Where("id = ?", report.PostID), "Post"
+	if report.CommentID != nil {
+		target, what = h.db.Model(&models.Comment{}).Where("id = ?", *report.CommentID), "Comment"
+	}
+	if result := target.Where("hidden = ?", false).Update("hidden", true); result.RowsAffected > 0 {
+		h.emitEvent(models.EventContentHidden, fmt.Sprintf("%s hidden after %d reports", what, reporters), "/admin/reports",
+			map[string]interface{}{"post_id": report.PostID, "comment_id": report.CommentID, "reports": reporters})
+	}
+}
+
+// AdminReports is the moderation queue: open reports grouped by post or comment, or past ones with ?status=
+func (h *BaseHandler) AdminReports(c echo.Context) error {
+	return h.renderReports(c, c.QueryParam("status"))
+}
+
+// AdminReportResolve upholds every open report on the reported post or comment and keeps it hidden
+func (h *BaseHandler) AdminReportResolve(c echo.Context) error {
+	return h.closeReports(c, models.ReportResolved)
+}
+
+// AdminReportDismiss rejects every open report on the reported post or comment and shows it again
+func (h *BaseHandler) AdminReportDismiss(c echo.Context) error {
+	return h.closeReports(c, models.ReportDismissed)
+}
+
+func (h *BaseHandler) closeReports(c echo.Context, status string) error {
+	user := c.Get("user").(*models.User)
+	id, err := h.parseUintParam(c, "id")
+	if err != nil {
+		return err
+	}
+	var report models.Report
+	if err := h.db.First(&report, id).Error; err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "Report not found")
+	}
+
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		if err := reportTarget(tx.Model(&models.Report{}), report).Where("status = ?", models.ReportOpen).
+			Updates(map[string]interface{}{"status": status, "resolved_by_id": user.ID, "resolved_at": &now}).Error; err != nil {
+			return err
+		}
+		target := tx.Model(&models.Post{}).Where("id = ?", report.PostID)
+		if report.CommentID != nil {
+			target = tx.Model(&models.Comment{}).Where("id = ?", *report.CommentID)
+		}
+		return target.Update("hidden", status == models.ReportResolved).Error
+	})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to update reports")
+	}
+	return h.renderReports(c, models.ReportOpen)
+}
+
+func (h *BaseHandler) renderReports(c echo.Context, status string) error {
+	if !slices.Contains([]string{models.ReportOpen, models.ReportResolved, models.ReportDismissed}, status) {
+		status = models.ReportOpen
+	}
+
+	var reports []models.Report
+	h.db.Preload("Reporter").Preload("Post").Preload("Comment.User").
+		Where("status = ?", status).Order("created_at desc").Limit(reportQueueSize).Find(&reports)
+
+	// One entry per post or comment, ordered by its latest report
+	var items []models.ReportedItem
+	index := map[string]int{}
+	for _, report := range reports {
+		key := fmt.Sprintf("post-%d", report.PostID)
+		if report.CommentID != nil {
+			key = fmt.Sprintf("comment-%d", *report.CommentID)
+		}
+		i, ok := index[key]
+		if !ok {
+			i = len(items)
+			index[key] = i
+			items = append(items, models.ReportedItem{Post: report.Post, Comment: report.Comment})
+		}
+		items[i].Reports = append(items[i].Reports, report)
+	}
+
+	page := templates.ReportsPage(items, status)
+	if h.isHTMXRequest(c) {
+		return h.render(c, page)
+	}
+	return h.render(c, templates.Layout("Reports", page, c.Request().URL.Path, c.Get("user").(*models.User)))
+}
+
+// reportTarget narrows a query on reports to those about the same post or comment as report
+func reportTarget(query *gorm.DB, report models.Report) *gorm.DB {
+	if report.CommentID != nil {
+		return query.Where("comment_id = ?", *report.CommentID)
+	}
+	return query.Where("post_id = ? AND comment_id IS NULL", report.PostID)
+}
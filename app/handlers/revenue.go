@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"fmt"
+	"mini-blog/app/models"
+	"mini-blog/app/services"
+	"mini-blog/app/templates"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	revenueMonths         = 12
+	revenueRecentPayments = 25
+)
+
+// AdminRevenue summarises tips and premium membership for admins
+func (h *BaseHandler) AdminRevenue(c echo.Context) error {
+	summary := h.revenueSummary(h.userLocation(c))
+
+	var payments []models.Payment
+	h.db.Order("created_at desc").Limit(revenueRecentPayments).Find(&payments)
+
+	page := templates.RevenuePage(summary, payments)
+	if h.isHTMXRequest(c) {
+		return h.render(c, page)
+	}
+	return h.render(c, templates.Layout("Revenue", page, c.Request().URL.Path, c.Get("user").(*models.User)))
+}
+
+// AdminRevenueExport downloads every recorded payment as CSV
+func (h *BaseHandler) AdminRevenueExport(c echo.Context) error {
+	var payments []models.Payment
+	h.db.Order("created_at desc").Find(&payments)
+
+	filename := fmt.Sprintf("payments-%s.csv", time.Now().Format(segmentDateLayout))
+	c.Response().Header().Set(echo.HeaderContentType, "text/csv; charset=utf-8")
+	c.Response().Header().Set(echo.HeaderContentDisposition, `attachment; filename="`+filename+`"`)
+	c.Response().WriteHeader(http.StatusOK)
+
+	w := csv.NewWriter(c.Response())
+	w.Write([]string{"id", "date", "amount", "currency", "email", "user_id", "provider", "provider_id"})
+	for _, payment := range payments {
+		userID := ""
+		if payment.UserID != nil {
+			userID = strconv.FormatUint(uint64(*payment.UserID), 10)
+		}
+		w.Write([]string{
+			strconv.FormatUint(uint64(payment.ID), 10),
+			payment.CreatedAt.UTC().Format(time.RFC3339),
+			services.DecimalAmount(payment.Amount, payment.Currency),
+			payment.Currency,
+			csvText(payment.Email),
+			userID,
+			payment.Provider,
+			payment.ProviderID,
+		})
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// revenueSummary totals tips in the site currency by calendar month in loc, and measures trial churn
+func (h *BaseHandler) revenueSummary(loc *time.Location) models.RevenueSummary {
+	now := time.Now().In(loc)
+	summary := models.RevenueSummary{Currency: h.cfg.Payments.Currency}
+
+	thisMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, loc)
+	firstMonth := thisMonth.AddDate(0, 1-revenueMonths, 0)
+	for month := firstMonth; !month.After(thisMonth); month = month.AddDate(0, 1, 0) {
+		summary.Monthly = append(summary.Monthly, models.MonthlyRevenue{Month: month})
+	}
+
+	var payments []models.Payment
+	h.db.Where("currency = ?", summary.Currency).Find(&payments)
+	for _, payment := range payments {
+		summary.AllTime += payment.Amount
+		if now.Sub(payment.CreatedAt) <= 30*24*time.Hour {
+			summary.Last30Days += payment.Amount
+			summary.Tips30Days++
+		}
+		created := payment.CreatedAt.In(loc)
+		i := (created.Year()-firstMonth.Year())*12 + int(created.Month()-firstMonth.Month())
+		if i < 0 || i >= len(summary.Monthly) {
+			continue
+		}
+		summary.Monthly[i].Amount += payment.Amount
+		summary.Monthly[i].Count++
+	}
+
+	h.db.Model(&models.User{}).Where("role = ? AND trial_ends_at IS NULL", models.RolePremium).Count(&summary.PremiumMembers)
+	h.db.Model(&models.User{}).Where("role = ? AND trial_ends_at IS NOT NULL", models.RolePremium).Count(&summary.TrialMembers)
+
+	// A trial's end is its redemption plus the coupon's length; deleted coupons still count
+	var redemptions []models.CouponRedemption
+	h.db.Where("created_at >= ?", now.AddDate(-1, 0, -30)).Find(&redemptions)
+	for _, redemption := range redemptions {
+		var coupon models.Coupon
+		if h.db.Unscoped().First(&coupon, redemption.CouponID).Error != nil {
+			continue
+		}
+		endsAt := redemption.CreatedAt.AddDate(0, 0, coupon.TrialDays)
+		if endsAt.After(now) || now.Sub(endsAt) > 30*24*time.Hour {
+			continue
+		}
+		summary.TrialsEnded++
+		var user models.User
+		if h.db.First(&user, redemption.UserID).Error != nil || user.Role == models.RoleUser {
+			summary.TrialsChurned++
+		}
+	}
+	return summary
+}
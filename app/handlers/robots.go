@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"mini-blog/app/models"
+	"mini-blog/app/services"
+	"mini-blog/app/templates"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// robotsAlwaysDisallowed are private or per-visitor paths no crawler should fetch
+var robotsAlwaysDisallowed = []string{"/admin", "/settings", "/api/", "/e/", "/email/", "/login", "/signup"}
+
+// Robots serves /robots.txt from the crawler settings
+func (h *BaseHandler) Robots(c echo.Context) error {
+	rules := h.crawlerRules()
+	var b strings.Builder
+	b.WriteString("User-agent: *\n")
+
+	for _, path := range append(slices.Clone(robotsAlwaysDisallowed), rules.Disallow...) {
+		b.WriteString("Disallow: " + path + "\n")
+	}
+	writeSectionRule(&b, "/posts", rules.BlockPosts)
+	writeSectionRule(&b, "/tv", rules.BlockTV)
+
+	c.Response().Header().Set(echo.HeaderCacheControl, "public, max-age=3600")
+	return c.String(http.StatusOK, b.String())
+}
+
+func writeSectionRule(b *strings.Builder, section string, blocked bool) {
+	if blocked {
+		b.WriteString("Disallow: " + section + "\n")
+	} else {
+		b.WriteString("Allow: " + section + "\n")
+	}
+}
+
+// AdminCrawlers edits the crawler rules
+func (h *BaseHandler) AdminCrawlers(c echo.Context) error {
+	return h.renderCrawlers(c, h.crawlerRules(), "", "")
+}
+
+// AdminCrawlersUpdate saves the crawler rules, with the extra disallowed paths one per line
+func (h *BaseHandler) AdminCrawlersUpdate(c echo.Context) error {
+	rules := models.CrawlerRules{
+		BlockPosts:        c.FormValue("block_posts") == "on",
+		BlockTV:           c.FormValue("block_tv") == "on",
+		Disallow:          parseRobotsPaths(c.FormValue("disallow")),
+		NoindexRestricted: c.FormValue("noindex_restricted") == "on",
+	}
+	for _, path := range rules.Disallow {
+		if !strings.HasPrefix(path, "/") || strings.ContainsAny(path, " \t") {
+			return h.renderCrawlers(c, rules, "", path+" isn't a path, such as /drafts")
+		}
+	}
+
+	if err := models.SaveSetting(h.db, models.SettingCrawlers, rules); err != nil {
+		return h.renderCrawlers(c, rules, "", "Failed to save crawler rules")
+	}
+	return h.renderCrawlers(c, rules, "Crawler rules saved", "")
+}
+
+func (h *BaseHandler) renderCrawlers(c echo.Context, rules models.CrawlerRules, successMessage, errorMessage string) error {
+	page := templates.CrawlersPage(rules, successMessage, errorMessage)
+	if h.isHTMXRequest(c) {
+		return h.render(c, page)
+	}
+	return h.render(c, templates.Layout("Crawlers", page, c.Request().URL.Path, c.Get("user").(*models.User)))
+}
+
+// crawlerRules returns the saved crawler rules; until an admin saves some, the CRAWL_* settings apply
+func (h *BaseHandler) crawlerRules() models.CrawlerRules {
+	rules := models.CrawlerRules{
+		BlockPosts:        h.cfg.Crawlers.BlockPosts,
+		BlockTV:           h.cfg.Crawlers.BlockTV,
+		Disallow:          parseRobotsPaths(strings.ReplaceAll(h.cfg.Crawlers.Disallow, ",", "\n")),
+		NoindexRestricted: h.cfg.Crawlers.NoindexRestricted,
+	}
+	models.LoadSetting(h.db, models.SettingCrawlers, &rules)
+	return rules
+}
+
+// parseRobotsPaths reads one path per line, dropping blanks and duplicates
+func parseRobotsPaths(text string) []string {
+	var paths []string
+	for _, path := range strings.Split(text, "\n") {
+		if path = strings.TrimSpace(path); path != "" && !slices.Contains(paths, path) {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// noindex marks the page being rendered as not for search engines
+func (h *BaseHandler) noindex(c echo.Context) {
+	c.Set("noindex", true)
+}
+
+// noindexPost hides premium and admin-only posts from search engines when the setting is on
+func (h *BaseHandler) noindexPost(c echo.Context, post models.Post) {
+	if h.crawlerRules().NoindexRestricted && post.Visibility != models.VisibilityPublic {
+		h.noindex(c)
+	}
+}
+
+// postPreviewLength is how much of a post's text stands in for a missing meta description
+const postPreviewLength = 200
+
+// postMeta sets the link preview tags for a post page, falling back to the excerpt and cover image.
+// Restricted posts only show their own meta description, so previews never quote text the viewer of
+// a shared link couldn't read.
+func (h *BaseHandler) postMeta(c echo.Context, post models.Post) {
+	baseURL := strings.TrimSuffix(h.cfg.Server.BaseURL, "/")
+	meta := services.PageMeta{
+		Title:       post.Title,
+		Description: post.MetaDescription,
+		URL:         baseURL + "/posts/" + post.Slug,
+		Image:       post.OGImage,
+	}
+	if meta.Image == "" {
+		meta.Image = post.CoverImage
+	}
+	if meta.Description == "" && post.Visibility == models.VisibilityPublic {
+		meta.Description = post.Excerpt
+		if meta.Description == "" {
+			meta.Description = services.MarkdownToText(post.Content)
+		}
+		if runes := []rune(meta.Description); len(runes) > postPreviewLength {
+			meta.Description = strings.TrimSpace(string(runes[:postPreviewLength-1])) + "…"
+		}
+	}
+	// Uploads are stored with site-relative paths; crawlers need the full URL
+	if strings.HasPrefix(meta.Image, "/") {
+		meta.Image = baseURL + meta.Image
+	}
+	c.Set("page_meta", meta)
+}
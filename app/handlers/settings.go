@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"mini-blog/app/models"
+	"mini-blog/app/services"
+	"mini-blog/app/templates"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// userLocation returns the current viewer's timezone (UTC for anonymous visitors)
+func (h *BaseHandler) userLocation(c echo.Context) *time.Location {
+	if user := h.GetCurrentUser(c); user != nil {
+		return services.LoadTimezone(user.Timezone)
+	}
+	return time.UTC
+}
+
+// airedCutoff is the latest air date that counts as aired for the current viewer
+func (h *BaseHandler) airedCutoff(c echo.Context) time.Time {
+	return services.Today(h.userLocation(c))
+}
+
+// hideAdult reports whether adult titles are hidden for this visitor; admins always see everything
+func (h *BaseHandler) hideAdult(c echo.Context) bool {
+	if !h.adultMedia().Hide {
+		return false
+	}
+	user := h.GetCurrentUser(c)
+	return user == nil || !user.IsAdmin()
+}
+
+// AdminAdultMedia edits whether adult titles are hidden
+func (h *BaseHandler) AdminAdultMedia(c echo.Context) error {
+	return h.renderAdultMedia(c, h.adultMedia(), "")
+}
+
+// AdminAdultMediaUpdate saves whether adult titles are hidden
+func (h *BaseHandler) AdminAdultMediaUpdate(c echo.Context) error {
+	setting := models.AdultMedia{Hide: c.FormValue("hide") == "on"}
+	if err := models.SaveSetting(h.db, models.SettingAdultMedia, setting); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to save setting")
+	}
+	return h.renderAdultMedia(c, setting, "Saved")
+}
+
+func (h *BaseHandler) renderAdultMedia(c echo.Context, setting models.AdultMedia, successMessage string) error {
+	page := templates.AdultMediaPage(setting, successMessage)
+	if h.isHTMXRequest(c) {
+		return h.render(c, page)
+	}
+	return h.render(c, templates.Layout("Adult Titles", page, c.Request().URL.Path, c.Get("user").(*models.User)))
+}
+
+// adultMedia returns the saved adult title setting; until an admin saves it, HIDE_ADULT_CONTENT applies
+func (h *BaseHandler) adultMedia() models.AdultMedia {
+	setting := models.AdultMedia{Hide: h.cfg.Content.HideAdult}
+	models.LoadSetting(h.db, models.SettingAdultMedia, &setting)
+	return setting
+}
+
+// trackerRules returns the current user's tracker status rules, falling back to the site defaults
+func (h *BaseHandler) trackerRules(c echo.Context) models.TrackerRules {
+	site := h.siteTrackerRules()
+	if user := h.GetCurrentUser(c); user != nil {
+		return user.TrackerRules(site)
+	}
+	return site
+}
+
+func (h *BaseHandler) siteTrackerRules() models.TrackerRules {
+	rules := models.TrackerRules{
+		AutoWatching:  h.cfg.Tracker.AutoWatching,
+		CompleteRule:  h.cfg.Tracker.CompleteRule,
+		PlannedResets: h.cfg.Tracker.PlannedResets,
+	}
+	if !models.IsValidCompleteRule(rules.CompleteRule) {
+		rules.CompleteRule = models.CompleteWhenAired
+	}
+	return rules
+}
+
+// formOverride reads a yes/no/site-default select into an optional flag
+func formOverride(value string) *bool {
+	switch value {
+	case "yes", "no":
+		flag := value == "yes"
+		return &flag
+	}
+	return nil
+}
+
+const themeCookieName = "theme"
+
+// resolveTheme returns the user's saved theme, or the cookie theme for anonymous visitors
+func (h *BaseHandler) resolveTheme(c echo.Context) string {
+	if user := h.GetCurrentUser(c); user != nil && models.IsValidTheme(user.Theme) {
+		return user.Theme
+	}
+	if cookie, err := c.Cookie(themeCookieName); err == nil && models.IsValidTheme(cookie.Value) {
+		return cookie.Value
+	}
+	return models.ThemeLight
+}
+
+// ToggleTheme flips between light and dark, persisting the choice server-side
+func (h *BaseHandler) ToggleTheme(c echo.Context) error {
+	theme := models.ThemeDark
+	if h.resolveTheme(c) == models.ThemeDark {
+		theme = models.ThemeLight
+	}
+
+	c.SetCookie(&http.Cookie{
+		Name:     themeCookieName,
+		Value:    theme,
+		Path:     "/",
+		MaxAge:   86400 * 365,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	if user := h.GetCurrentUser(c); user != nil {
+		h.db.Model(user).Update("theme", theme)
+	}
+
+	if h.isHTMXRequest(c) {
+		c.Response().Header().Set("HX-Refresh", "true")
+		return c.NoContent(http.StatusOK)
+	}
+	return c.Redirect(http.StatusSeeOther, h.sameSiteReferer(c, "/"))
+}
+
+// User settings page
+func (h *BaseHandler) SettingsPage(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+	return h.render(c, templates.Layout("Settings", templates.SettingsPage(user, h.emailPreferencesURL("/email/preferences", user.Email), h.apiTokens(user), ""), c.Request().URL.Path, user))
+}
+
+func (h *BaseHandler) SettingsUpdate(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+
+	if locale := c.FormValue("locale"); models.IsValidLocale(locale) {
+		user.Locale = locale
+	}
+
+	if timezone := h.trimFormValue(c, "timezone"); timezone != "" {
+		if _, err := time.LoadLocation(timezone); err != nil {
+			return h.render(c, templates.SettingsForm(user, "", "Unknown timezone: "+timezone))
+		}
+		user.Timezone = timezone
+	}
+
+	if dateFormat := c.FormValue("date_format"); models.IsValidDateFormat(dateFormat) {
+		user.DateFormat = dateFormat
+	}
+
+	if theme := c.FormValue("theme"); models.IsValidTheme(theme) {
+		user.Theme = theme
+	}
+
+	user.ShowSpoilers = c.FormValue("show_spoilers") == "on"
+	user.HideSupporterBadge = c.FormValue("hide_supporter_badge") == "on"
+
+	user.UpNextEmail = c.FormValue("up_next_email") == "on"
+	if hour, err := strconv.Atoi(c.FormValue("up_next_hour")); err == nil && hour >= 0 && hour <= 23 {
+		user.UpNextHour = hour
+	}
+
+	columns := []interface{}{"locale", "timezone", "date_format", "theme", "show_spoilers", "hide_supporter_badge", "up_next_email", "up_next_hour"}
+	if user.IsAdmin() {
+		user.AutoWatching = formOverride(c.FormValue("auto_watching"))
+		user.PlannedResets = formOverride(c.FormValue("planned_resets"))
+		user.CompleteRule = ""
+		if rule := c.FormValue("complete_rule"); models.IsValidCompleteRule(rule) {
+			user.CompleteRule = rule
+		}
+		columns = append(columns, "auto_watching", "complete_rule", "planned_resets")
+	}
+
+	if err := h.db.Model(user).Select(columns[0], columns[1:]...).Updates(user).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to save settings")
+	}
+
+	// Locale is cached per request; refresh so the response renders in the new language
+	c.Set("locale", nil)
+	return h.render(c, templates.SettingsForm(user, "Settings saved"))
+}
@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"mini-blog/app/models"
+	"mini-blog/app/templates"
+	"slices"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// AdminSignupRoles sets the role new accounts start with and the email domains that get premium or admin
+func (h *BaseHandler) AdminSignupRoles(c echo.Context) error {
+	return h.renderSignupRoles(c, h.signupRoles(), "", "")
+}
+
+// AdminSignupRolesUpdate saves the default role and the domain lists, one domain per line
+func (h *BaseHandler) AdminSignupRolesUpdate(c echo.Context) error {
+	roles := models.SignupRoles{
+		DefaultRole:    c.FormValue("default_role"),
+		PremiumDomains: parseDomains(c.FormValue("premium_domains")),
+		AdminDomains:   parseDomains(c.FormValue("admin_domains")),
+	}
+	if roles.DefaultRole != models.RoleUser && roles.DefaultRole != models.RolePremium {
+		return h.renderSignupRoles(c, roles, "", "New accounts start as users or premium users")
+	}
+	for _, domain := range append(slices.Clone(roles.PremiumDomains), roles.AdminDomains...) {
+		if !strings.Contains(domain, ".") || strings.ContainsAny(domain, " @,") || len(domain) > 253 {
+			return h.renderSignupRoles(c, roles, "", domain+" isn't a domain, such as example.com")
+		}
+		if slices.Contains(roles.PremiumDomains, domain) && slices.Contains(roles.AdminDomains, domain) {
+			return h.renderSignupRoles(c, roles, "", domain+" is in both lists")
+		}
+	}
+
+	if err := models.SaveSetting(h.db, models.SettingSignupRoles, roles); err != nil {
+		return h.renderSignupRoles(c, roles, "", "Failed to save signup roles")
+	}
+	return h.renderSignupRoles(c, roles, "Signup roles saved", "")
+}
+
+func (h *BaseHandler) renderSignupRoles(c echo.Context, roles models.SignupRoles, successMessage, errorMessage string) error {
+	options := []templates.SelectOption{
+		{Value: models.RoleUser, Label: models.GetRoleName(models.RoleUser)},
+		{Value: models.RolePremium, Label: models.GetRoleName(models.RolePremium)},
+	}
+	page := templates.SignupRolesPage(roles, options, successMessage, errorMessage)
+	if h.isHTMXRequest(c) {
+		return h.render(c, page)
+	}
+	return h.render(c, templates.Layout("Signup Roles", page, c.Request().URL.Path, c.Get("user").(*models.User)))
+}
+
+// signupRoles returns the saved signup roles; before any are saved, new accounts are plain users
+func (h *BaseHandler) signupRoles() models.SignupRoles {
+	roles := models.SignupRoles{DefaultRole: models.RoleUser}
+	models.LoadSetting(h.db, models.SettingSignupRoles, &roles)
+	return roles
+}
+
+// verifiedRole is the role user should have once their email is verified: ADMIN_EMAIL is always an admin,
+// and a listed domain raises, but never lowers, the role they signed up with
+func (h *BaseHandler) verifiedRole(user *models.User) string {
+	if user.Email == h.cfg.Auth.AdminEmail {
+		return models.RoleAdmin
+	}
+	role := h.signupRoles().DomainRole(user.Email)
+	if role == models.RoleAdmin || (role == models.RolePremium && user.Role == models.RoleUser) {
+		return role
+	}
+	return user.Role
+}
+
+// parseDomains reads one domain per line (commas work too), lowercased and without a leading "@"
+func parseDomains(value string) []string {
+	var domains []string
+	for _, field := range strings.FieldsFunc(value, func(r rune) bool { return r == '\n' || r == '\r' || r == ',' }) {
+		domain := strings.TrimPrefix(strings.ToLower(strings.TrimSpace(field)), "@")
+		if domain != "" && !slices.Contains(domains, domain) {
+			domains = append(domains, domain)
+		}
+	}
+	return domains
+}
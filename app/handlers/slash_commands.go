@@ -0,0 +1,280 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mini-blog/app/models"
+	"mini-blog/app/services"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	slashSearchResults = 5
+	slashCommandUsage  = "Usage:\n" +
+		"/track add [tv|movie] \"Title\" [planned|watching|completed|dropped]\n" +
+		"/track progress Title\n" +
+		"/track search [tv|movie] Title"
+)
+
+// slashCommand is a parsed /track command, e.g. add tv "Severance" watching
+type slashCommand struct {
+	action    string
+	mediaType string // "" searches TV first, then movies
+	query     string
+	status    string
+}
+
+// slow reports whether the command calls TMDB, so its reply can miss the platforms' three-second deadline
+func (cmd slashCommand) slow() bool {
+	return cmd.action == "add" || cmd.action == "search"
+}
+
+// SlackCommand answers the /track slash command from a Slack app signed with SLACK_SIGNING_SECRET
+func (h *BaseHandler) SlackCommand(c echo.Context) error {
+	if !h.slash.SlackEnabled() {
+		return echo.NewHTTPError(http.StatusNotFound, "Page not found")
+	}
+	body, err := io.ReadAll(io.LimitReader(c.Request().Body, 1<<16))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Unreadable body")
+	}
+	if err := h.slash.VerifySlack(c.Request().Header, body); err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+	}
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid command")
+	}
+
+	cmd := parseSlashCommand(form.Get("text"))
+	text := ""
+	if cmd.slow() {
+		go func() {
+			if err := h.slash.ReplySlack(form.Get("response_url"), h.runSlashCommand(cmd)); err != nil {
+				log.Printf("Failed to reply to Slack command: %v", err)
+			}
+		}()
+		text = "Working on it…"
+	} else {
+		text = h.runSlashCommand(cmd)
+	}
+	return c.JSON(http.StatusOK, map[string]string{"response_type": "ephemeral", "text": text})
+}
+
+// discordOption is a slash command option; subcommands nest further options
+type discordOption struct {
+	Name    string          `json:"name"`
+	Value   interface{}     `json:"value"`
+	Options []discordOption `json:"options"`
+}
+
+// Discord interaction and response types
+const (
+	discordPing               = 1
+	discordApplicationCommand = 2
+	discordPong               = 1
+	discordMessage            = 4
+	discordDeferredMessage    = 5
+	discordEphemeralFlag      = 1 << 6
+)
+
+// DiscordCommand answers the /track slash command from a Discord application with DISCORD_PUBLIC_KEY
+func (h *BaseHandler) DiscordCommand(c echo.Context) error {
+	if !h.slash.DiscordEnabled() {
+		return echo.NewHTTPError(http.StatusNotFound, "Page not found")
+	}
+	body, err := io.ReadAll(io.LimitReader(c.Request().Body, 1<<16))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Unreadable body")
+	}
+	// Discord requires a 401 for bad signatures before it will save the endpoint
+	if err := h.slash.VerifyDiscord(c.Request().Header, body); err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+	}
+
+	var interaction struct {
+		Type          int    `json:"type"`
+		ApplicationID string `json:"application_id"`
+		Token         string `json:"token"`
+		Data          struct {
+			Options []discordOption `json:"options"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &interaction); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid interaction")
+	}
+	switch interaction.Type {
+	case discordPing:
+		return c.JSON(http.StatusOK, map[string]int{"type": discordPong})
+	case discordApplicationCommand:
+	default:
+		return echo.NewHTTPError(http.StatusBadRequest, "Unsupported interaction")
+	}
+
+	cmd := parseSlashCommand(discordCommandText(interaction.Data.Options))
+	if cmd.slow() {
+		go func() {
+			if err := h.slash.ReplyDiscord(interaction.ApplicationID, interaction.Token, h.runSlashCommand(cmd)); err != nil {
+				log.Printf("Failed to reply to Discord command: %v", err)
+			}
+		}()
+		return c.JSON(http.StatusOK, map[string]interface{}{"type": discordDeferredMessage, "data": map[string]int{"flags": discordEphemeralFlag}})
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"type": discordMessage,
+		"data": map[string]interface{}{"content": h.runSlashCommand(cmd), "flags": discordEphemeralFlag},
+	})
+}
+
+// discordCommandText flattens options into Slack-style text, so a command registered either with
+// subcommands (add → title) or with one free-text option parses the same way
+func discordCommandText(options []discordOption) string {
+	var parts []string
+	for _, option := range options {
+		if option.Value == nil {
+			parts = append(parts, option.Name, discordCommandText(option.Options))
+			continue
+		}
+		parts = append(parts, fmt.Sprint(option.Value))
+	}
+	return strings.TrimSpace(strings.Join(parts, " "))
+}
+
+// parseSlashCommand reads `action [tv|movie] "query" [status]`; quotes are optional when no status follows
+func parseSlashCommand(text string) slashCommand {
+	text = strings.NewReplacer("“", `"`, "”", `"`).Replace(strings.TrimSpace(text))
+	action, rest, _ := strings.Cut(text, " ")
+	cmd := slashCommand{action: strings.ToLower(action)}
+
+	rest = strings.TrimSpace(rest)
+	if kind, query, ok := strings.Cut(rest, " "); ok && models.IsValidMediaType(strings.ToLower(kind)) {
+		cmd.mediaType, rest = strings.ToLower(kind), strings.TrimSpace(query)
+	}
+
+	if strings.HasPrefix(rest, `"`) {
+		if query, after, ok := strings.Cut(rest[1:], `"`); ok {
+			cmd.query, cmd.status = strings.TrimSpace(query), strings.ToLower(strings.TrimSpace(after))
+			return cmd
+		}
+	}
+	words := strings.Fields(rest)
+	if n := len(words); n > 1 && models.IsValidStatus(strings.ToLower(words[n-1])) {
+		cmd.status, words = strings.ToLower(words[n-1]), words[:n-1]
+	}
+	cmd.query = strings.Trim(strings.Join(words, " "), `"`)
+	return cmd
+}
+
+// runSlashCommand carries out a command and returns the reply, in plain text both platforms render
+func (h *BaseHandler) runSlashCommand(cmd slashCommand) string {
+	if cmd.query == "" {
+		return slashCommandUsage
+	}
+	switch cmd.action {
+	case "add":
+		return h.slashAdd(cmd)
+	case "progress":
+		return h.slashProgress(cmd.query)
+	case "search":
+		results, mediaType, err := h.slashSearch(cmd)
+		if err != nil {
+			return "TMDB search failed, try again in a moment."
+		}
+		if len(results) == 0 {
+			return fmt.Sprintf("Nothing on TMDB matches %q.", cmd.query)
+		}
+		if len(results) > slashSearchResults {
+			results = results[:slashSearchResults]
+		}
+		lines := []string{fmt.Sprintf("TMDB results for %q:", cmd.query)}
+		for _, result := range results {
+			lines = append(lines, "• "+slashResultTitle(result, mediaType))
+		}
+		return strings.Join(lines, "\n")
+	}
+	return slashCommandUsage
+}
+
+func (h *BaseHandler) slashAdd(cmd slashCommand) string {
+	status := cmd.status
+	if status == "" {
+		status = models.StatusPlanned
+	} else if !models.IsValidStatus(status) {
+		return fmt.Sprintf("Unknown status %q.\n%s", status, slashCommandUsage)
+	}
+
+	results, mediaType, err := h.slashSearch(cmd)
+	if err != nil {
+		return "TMDB search failed, try again in a moment."
+	}
+	if len(results) == 0 {
+		return fmt.Sprintf("Nothing on TMDB matches %q.", cmd.query)
+	}
+
+	title := slashResultTitle(results[0], mediaType)
+	_, err = h.addMedia(results[0].ID, mediaType, status, "", services.Today(time.UTC), nil)
+	if errors.Is(err, errAlreadyTracking) {
+		return title + " is already in the library."
+	}
+	if err != nil {
+		log.Printf("Slash command failed to add %d: %v", results[0].ID, err)
+		return fmt.Sprintf("Couldn't add %s: %v.", title, err)
+	}
+	return fmt.Sprintf("Added %s to the library as %s.", title, status)
+}
+
+// slashSearch searches TMDB for the command's type, or TV and then movies when it names none
+func (h *BaseHandler) slashSearch(cmd slashCommand) ([]services.SearchResult, string, error) {
+	types := []string{models.MediaTypeTV, models.MediaTypeMovie}
+	if cmd.mediaType != "" {
+		types = []string{cmd.mediaType}
+	}
+	for _, mediaType := range types {
+		results, err := h.metadata.For(mediaType).Search(cmd.query, mediaType)
+		if err != nil || len(results) > 0 {
+			return results, mediaType, err
+		}
+	}
+	return nil, "", nil
+}
+
+// slashProgress reports the status of the best library match for query, and a show's next episode
+func (h *BaseHandler) slashProgress(query string) string {
+	media, err := h.libraryMatch(query)
+	if err != nil {
+		return fmt.Sprintf("Nothing in the library matches %q.", query)
+	}
+	if media.Type != models.MediaTypeTV {
+		return fmt.Sprintf("%s: %s.", media.Title, media.Status)
+	}
+
+	reply := fmt.Sprintf("%s: %s, %d/%d episodes watched.", media.Title, media.Status, media.Progress, media.TotalEpisodes)
+	if next, err := h.nextEpisode(media.TMDBID); err == nil {
+		reply += fmt.Sprintf(" Next: S%02dE%02d %s", next.SeasonNumber, next.EpisodeNumber, next.Name)
+		if next.AirDate != nil && next.AirDate.After(services.Today(time.UTC)) {
+			reply += ", airing " + next.AirDate.Format("Jan 2, 2006")
+		}
+		reply += "."
+	}
+	return reply
+}
+
+// slashResultTitle names a search result with its year, e.g. Severance (2022)
+func slashResultTitle(result services.SearchResult, mediaType string) string {
+	title, date := result.Title, result.ReleaseDate
+	if mediaType == models.MediaTypeTV {
+		title, date = result.Name, result.FirstAirDate
+	}
+	if len(date) >= 4 {
+		return fmt.Sprintf("%s (%s)", title, date[:4])
+	}
+	return title
+}
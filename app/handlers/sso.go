@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"mini-blog/app/models"
+	"mini-blog/app/services"
+	"mini-blog/app/templates"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/oauth2"
+	"gorm.io/gorm"
+)
+
+const (
+	oidcStateSessionKey    = "oidc_state"
+	oidcNonceSessionKey    = "oidc_nonce"
+	oidcVerifierSessionKey = "oidc_verifier"
+)
+
+// SSOLogin sends the browser to the OpenID Connect provider, keeping state, nonce and PKCE verifier in the session
+func (h *BaseHandler) SSOLogin(c echo.Context) error {
+	if !h.oidc.Enabled() {
+		return echo.NewHTTPError(http.StatusNotFound, "Single sign-on is not configured")
+	}
+
+	state, nonce, verifier := services.NewEmailToken(), services.NewEmailToken(), oauth2.GenerateVerifier()
+	authURL, err := h.oidc.AuthCodeURL(c.Request().Context(), h.ssoRedirectURL(), state, nonce, verifier)
+	if err != nil {
+		log.Printf("SSO unavailable: %v", err)
+		return h.ssoFailed(c, "Single sign-on is unavailable right now. Try again or log in with your password.")
+	}
+
+	session, _ := h.store.Get(c.Request(), "auth-session")
+	session.Values[oidcStateSessionKey] = state
+	session.Values[oidcNonceSessionKey] = nonce
+	session.Values[oidcVerifierSessionKey] = verifier
+	if err := session.Save(c.Request(), c.Response()); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to save session")
+	}
+	return c.Redirect(http.StatusFound, authURL)
+}
+
+// SSOCallback finishes single sign-on: it checks the provider's answer, finds or creates the account and signs it in
+func (h *BaseHandler) SSOCallback(c echo.Context) error {
+	if !h.oidc.Enabled() {
+		return echo.NewHTTPError(http.StatusNotFound, "Single sign-on is not configured")
+	}
+
+	session, _ := h.store.Get(c.Request(), "auth-session")
+	state, _ := session.Values[oidcStateSessionKey].(string)
+	nonce, _ := session.Values[oidcNonceSessionKey].(string)
+	verifier, _ := session.Values[oidcVerifierSessionKey].(string)
+	// Each sign-in attempt is good for one callback
+	delete(session.Values, oidcStateSessionKey)
+	delete(session.Values, oidcNonceSessionKey)
+	delete(session.Values, oidcVerifierSessionKey)
+	session.Save(c.Request(), c.Response())
+
+	if message := c.QueryParam("error"); message != "" {
+		if description := c.QueryParam("error_description"); description != "" {
+			message = description
+		}
+		return h.ssoFailed(c, "Single sign-on was cancelled or refused: "+message)
+	}
+	if state == "" || c.QueryParam("state") != state {
+		return h.ssoFailed(c, "That sign-in link has expired. Try again.")
+	}
+
+	identity, err := h.oidc.Exchange(c.Request().Context(), h.ssoRedirectURL(), c.QueryParam("code"), nonce, verifier)
+	if err != nil {
+		log.Printf("SSO sign-in failed: %v", err)
+		return h.ssoFailed(c, "Single sign-on failed. Try again or log in with your password.")
+	}
+
+	user, err := h.ssoUser(identity)
+	if err != nil {
+		return h.ssoFailed(c, err.Error())
+	}
+	if err := h.setUserSession(c, user.ID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to save session")
+	}
+	return c.Redirect(http.StatusSeeOther, "/")
+}
+
+// ssoUser finds the account linked to identity, links an existing account by verified email, or creates one.
+// The provider's role mapping, when configured, is applied on every sign-in.
+func (h *BaseHandler) ssoUser(identity services.OIDCIdentity) (*models.User, error) {
+	identity.Email = strings.ToLower(strings.TrimSpace(identity.Email))
+	var user models.User
+	err := h.db.Where("oidc_subject = ?", identity.Subject).First(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		if identity.Email == "" {
+			return nil, errors.New("Your account at the provider has no email address")
+		}
+		err = h.db.Where("LOWER(email) = ?", identity.Email).First(&user).Error
+		switch {
+		case err == nil && !identity.EmailVerified:
+			// Linking on an unverified address would hand the account to whoever typed it in at the provider
+			return nil, errors.New("An account with this email already exists. Log in with your password, or verify the email at your provider first.")
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			user, err = h.newSSOUser(identity)
+		}
+		if err == nil {
+			subject := identity.Subject
+			user.OIDCSubject = &subject
+			err = h.db.Model(&user).Update("oidc_subject", subject).Error
+		}
+	}
+	if err != nil {
+		log.Printf("SSO account lookup for %s failed: %v", identity.Subject, err)
+		return nil, errors.New("Failed to sign in")
+	}
+
+	if user.IsBanned() {
+		return nil, errors.New("This account has been suspended")
+	}
+
+	role := identity.Role
+	// ADMIN_EMAIL only counts once the provider has checked the address; anyone can type it in unverified
+	if identity.EmailVerified && strings.EqualFold(identity.Email, h.cfg.Auth.AdminEmail) {
+		role = models.RoleAdmin
+	}
+	updates := map[string]interface{}{}
+	if role != "" && role != user.Role {
+		updates["role"] = role
+		// An admin-granted role replaces a trial
+		updates["trial_ends_at"] = nil
+	}
+	if !user.IsVerified && identity.EmailVerified {
+		// The provider vouched for this person; a pending signup code is no longer needed
+		updates["is_verified"], updates["otp"], updates["otp_expiry"] = true, "", nil
+	}
+	if len(updates) > 0 {
+		if err := h.db.Model(&user).Updates(updates).Error; err != nil {
+			log.Printf("Failed to update SSO user %d: %v", user.ID, err)
+			return nil, errors.New("Failed to sign in")
+		}
+	}
+	return &user, nil
+}
+
+// newSSOUser creates an account for a first-time SSO sign-in, with a random password nobody knows
+func (h *BaseHandler) newSSOUser(identity services.OIDCIdentity) (models.User, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(services.NewEmailToken()), bcrypt.DefaultCost)
+	if err != nil {
+		return models.User{}, err
+	}
+	name := strings.TrimSpace(identity.Name)
+	if name == "" {
+		name, _, _ = strings.Cut(identity.Email, "@")
+	}
+	if runes := []rune(name); len(runes) > 100 {
+		name = string(runes[:100])
+	}
+	user := models.User{
+		Email:      identity.Email,
+		Password:   string(hash),
+		Name:       name,
+		Role:       h.signupRoles().DefaultRole,
+		IsVerified: identity.EmailVerified,
+	}
+	if identity.EmailVerified {
+		user.Role = h.verifiedRole(&user)
+	}
+	if err := h.db.Create(&user).Error; err != nil {
+		return models.User{}, err
+	}
+	h.emailService.SendWelcomeEmail(user.Email, user.Name, user.IsAdmin())
+	return user, nil
+}
+
+func (h *BaseHandler) ssoRedirectURL() string {
+	return strings.TrimSuffix(h.cfg.Server.BaseURL, "/") + "/auth/oidc/callback"
+}
+
+// ssoFailed shows the login page with message, since SSO arrives by full-page navigation rather than HTMX
+func (h *BaseHandler) ssoFailed(c echo.Context, message string) error {
+	return h.render(c, templates.Layout(h.t(c, "auth.login"), templates.LoginForm(message), "/login"))
+}
@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"mini-blog/app/models"
+	"sync"
+	"time"
+)
+
+const (
+	stillHashBatch      = 200 // stills hashed per pass
+	stillHashWorkers    = 4   // downloads at once
+	stillHashRetryAfter = 24 * time.Hour
+)
+
+// HashEpisodeStills fills in placeholder hashes for episode stills that have none, a batch per pass with a few
+// downloads at once. A still that couldn't be fetched isn't tried again for stillHashRetryAfter.
+func (h *BaseHandler) HashEpisodeStills() {
+	var episodes []models.Episode
+	h.db.Select("id", "still_path").
+		Where("still_path <> '' AND (still_blurhash = '' OR still_blurhash IS NULL)").
+		Where("still_hash_failed_at IS NULL OR still_hash_failed_at < ?", time.Now().Add(-stillHashRetryAfter)).
+		Order("id desc").Limit(stillHashBatch).Find(&episodes)
+
+	queue := make(chan models.Episode)
+	var wg sync.WaitGroup
+	for i := 0; i < stillHashWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for episode := range queue {
+				updates := map[string]interface{}{"still_hash_failed_at": nil}
+				if hash := h.stillBlurhash(episode.StillPath); hash != "" {
+					updates["still_blurhash"] = hash
+				} else {
+					updates["still_hash_failed_at"] = time.Now()
+				}
+				// A sync may have swapped the still meanwhile; its new path waits for the next pass
+				h.db.Model(&models.Episode{}).Where("id = ? AND still_path = ?", episode.ID, episode.StillPath).UpdateColumns(updates)
+			}
+		}()
+	}
+	for _, episode := range episodes {
+		queue <- episode
+	}
+	close(queue)
+	wg.Wait()
+}
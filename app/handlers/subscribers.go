@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"mini-blog/app/models"
+	"mini-blog/app/services"
+	"mini-blog/app/templates"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// subscribeResendAfter keeps the form from mailing the same unconfirmed address over and over
+const subscribeResendAfter = 10 * time.Minute
+
+// Subscribe signs an address up for new posts and emails it a confirmation link (double opt-in)
+func (h *BaseHandler) Subscribe(c echo.Context) error {
+	subscriber := models.Subscriber{Email: strings.ToLower(h.trimFormValue(c, "email"))}
+	if err := h.validator.Struct(subscriber); err != nil {
+		return h.render(c, templates.SubscribeForm(subscriber.Email, "", h.t(c, "subscribe.invalid")))
+	}
+
+	err := h.db.Where("email = ?", subscriber.Email).First(&subscriber).Error
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		subscriber.Token = services.NewEmailToken()
+		if err := h.db.Create(&subscriber).Error; err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to subscribe")
+		}
+	case err != nil:
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to subscribe")
+	case subscriber.Confirmed() || time.Since(subscriber.UpdatedAt) < subscribeResendAfter:
+		// Same answer as a fresh signup, so the form doesn't reveal who is subscribed
+		return h.render(c, templates.SubscribeForm("", h.t(c, "subscribe.sent"), ""))
+	default:
+		h.db.Model(&subscriber).Update("updated_at", time.Now())
+	}
+
+	confirmURL := strings.TrimSuffix(h.cfg.Server.BaseURL, "/") + "/subscribe/confirm/" + subscriber.Token
+	// Through the queue, so an address that bounced or complained isn't mailed again
+	if err := h.enqueueEmail(models.EmailKindTransactional, subscriber.Email, "Confirm your subscription", services.SubscribeConfirmHTML(confirmURL), time.Now(), nil); err != nil {
+		log.Printf("Failed to queue subscription confirmation to %s: %v", subscriber.Email, err)
+		return h.render(c, templates.SubscribeForm(subscriber.Email, "", h.t(c, "subscribe.failed")))
+	}
+	return h.render(c, templates.SubscribeForm("", h.t(c, "subscribe.sent"), ""))
+}
+
+// SubscribeConfirm is the link in the confirmation email; from then on the address gets new posts
+func (h *BaseHandler) SubscribeConfirm(c echo.Context) error {
+	var subscriber models.Subscriber
+	if err := h.db.Where("token = ?", c.Param("token")).First(&subscriber).Error; err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "Invalid or expired link")
+	}
+
+	if !subscriber.Confirmed() {
+		now := time.Now()
+		if err := h.db.Model(&subscriber).Update("confirmed_at", &now).Error; err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to confirm subscription")
+		}
+		// Opting in again undoes an earlier unsubscribe from newsletters
+		h.db.Model(&models.EmailPreference{}).Where("email = ?", subscriber.Email).Update("newsletters", true)
+	}
+
+	page := templates.SubscribeConfirmed(subscriber.Email)
+	return h.render(c, templates.Layout(h.t(c, "subscribe.confirmed"), page, c.Request().URL.Path, h.GetCurrentUser(c)))
+}
+
+// notifySubscribers emails a newly published public post to confirmed subscribers.
+// It sends once per post, so a post taken back to draft and republished isn't mailed again.
+func (h *BaseHandler) notifySubscribers(post models.Post) {
+	if post.Visibility != models.VisibilityPublic {
+		return
+	}
+
+	var sent int64
+	h.db.Model(&models.EmailCampaign{}).Where("kind = ? AND post_id = ?", models.CampaignNewPost, post.ID).Count(&sent)
+	if sent > 0 {
+		return
+	}
+	var recipients []string
+	h.db.Model(&models.Subscriber{}).Where("confirmed_at IS NOT NULL").Pluck("email", &recipients)
+	if len(recipients) == 0 {
+		return
+	}
+
+	now := time.Now()
+	id := post.ID
+	campaign := models.EmailCampaign{
+		Name:    post.Title,
+		Subject: post.Title,
+		Kind:    models.CampaignNewPost,
+		PostID:  &id,
+		SentAt:  &now,
+	}
+	if err := h.db.Create(&campaign).Error; err != nil {
+		log.Printf("Failed to create subscriber campaign for post %d: %v", post.ID, err)
+		return
+	}
+
+	postURL := strings.TrimSuffix(h.cfg.Server.BaseURL, "/") + "/posts/" + post.Slug
+	html := services.PostNewsletterHTML(post.Title, services.MarkdownToHTML(post.Content), postURL)
+	h.deliverCampaign(campaign, models.EmailKindNewsletter, recipients, html, 0)
+}
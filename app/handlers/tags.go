@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"mini-blog/app/models"
+	"mini-blog/app/templates"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// maxPostTags bounds the tags typed into one post form
+const maxPostTags = 20
+
+// Tags lists every tag with the number of posts the visitor can read under it
+func (h *BaseHandler) Tags(c echo.Context) error {
+	user := h.GetCurrentUser(c)
+
+	var tags []models.Tag
+	visible := h.db.Model(&models.Post{}).Select("id").Scopes(models.PostsVisibleTo(user))
+	if err := h.db.Model(&models.Tag{}).
+		Select("tags.*, COUNT(post_tags.post_id) AS post_count").
+		Joins("JOIN post_tags ON post_tags.tag_id = tags.id AND post_tags.post_id IN (?)", visible).
+		Group("tags.id").
+		Order("tags.name").
+		Find(&tags).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch tags")
+	}
+
+	page := templates.TagsPage(tags)
+	if h.isHTMXRequest(c) {
+		return h.render(c, page)
+	}
+	return h.render(c, templates.Layout(h.t(c, "tags.title"), page, c.Request().URL.Path, user))
+}
+
+// parseTags reads the post form's comma-separated tags field into unsaved tags, dropping duplicates
+func (h *BaseHandler) parseTags(c echo.Context) []models.Tag {
+	return h.tagsFromNames(strings.Split(c.FormValue("tags"), ","))
+}
+
+// tagsFromNames turns typed tag names into unsaved tags, dropping blanks and duplicates
+func (h *BaseHandler) tagsFromNames(names []string) []models.Tag {
+	var tags []models.Tag
+	seen := map[string]bool{}
+	for _, name := range names {
+		name = strings.Join(strings.Fields(name), " ")
+		slug := h.generateSlug(name)
+		if name == "" || slug == "" || seen[slug] || len(tags) == maxPostTags {
+			continue
+		}
+		seen[slug] = true
+		tags = append(tags, models.Tag{Name: name, Slug: slug})
+	}
+	return tags
+}
+
+// errTagTooLong is the answer to a tag over the length limit
+var errTagTooLong = echo.NewHTTPError(http.StatusBadRequest, "Tags must be at most 50 characters")
+
+// checkTags validates typed tags before anything is saved
+func (h *BaseHandler) checkTags(tags []models.Tag) error {
+	for _, tag := range tags {
+		if err := h.validator.Struct(tag); err != nil {
+			return errTagTooLong
+		}
+	}
+	return nil
+}
+
+// replacePostTags sets a post's tags to tags (already checked) through db, saving the ones new to the site.
+// An existing tag keeps the name it was first typed with.
+func (h *BaseHandler) replacePostTags(db *gorm.DB, post *models.Post, tags []models.Tag) error {
+	for i := range tags {
+		if err := db.Where(models.Tag{Slug: tags[i].Slug}).Attrs(models.Tag{Name: tags[i].Name}).FirstOrCreate(&tags[i]).Error; err != nil {
+			return err
+		}
+	}
+	if len(tags) == 0 {
+		return db.Model(post).Association("Tags").Clear()
+	}
+	return db.Model(post).Association("Tags").Replace(tags)
+}
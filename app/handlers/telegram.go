@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mini-blog/app/models"
+	"mini-blog/app/services"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const telegramUsage = "/next – the next episode of every show you're watching\n" +
+	"/today – episodes airing today\n" +
+	"/watched Show – mark the next episode of Show watched\n" +
+	"/watched Show S01E03 – mark that episode watched"
+
+// telegramEpisodeCode matches an episode given as S01E03
+var telegramEpisodeCode = regexp.MustCompile(`(?i)^s(\d{1,3})e(\d{1,4})$`)
+
+// TelegramWebhook answers bot commands from the admin's chat, replying in the webhook response
+func (h *BaseHandler) TelegramWebhook(c echo.Context) error {
+	if !h.telegram.Enabled() {
+		return echo.NewHTTPError(http.StatusNotFound, "Page not found")
+	}
+	if err := h.telegram.VerifyWebhook(c.Request().Header); err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+	}
+
+	var update services.TelegramUpdate
+	if err := json.NewDecoder(io.LimitReader(c.Request().Body, 1<<16)).Decode(&update); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid update")
+	}
+	// Anything but a text message from the admin's chat is acknowledged and ignored
+	if update.Message == nil || !h.telegram.IsAdminChat(update.Message.Chat.ID) {
+		return c.NoContent(http.StatusOK)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"method":  "sendMessage",
+		"chat_id": update.Message.Chat.ID,
+		"text":    h.runTelegramCommand(update.Message.Text),
+	})
+}
+
+// runTelegramCommand carries out a bot command and returns the reply
+func (h *BaseHandler) runTelegramCommand(text string) string {
+	command, args, _ := strings.Cut(strings.TrimSpace(text), " ")
+	// Commands picked from the menu in a group arrive as /next@SomeBot
+	command, _, _ = strings.Cut(strings.ToLower(command), "@")
+	admin := h.telegramAdmin()
+	today := services.Today(h.telegramLocation(admin))
+
+	switch command {
+	case "/next":
+		episodes, err := models.NextEpisodes(h.db, today)
+		if err != nil {
+			return "Couldn't load your shows."
+		}
+		if len(episodes) == 0 {
+			return "Nothing to catch up on."
+		}
+		return "Up next:\n" + telegramEpisodeList(episodes)
+	case "/today":
+		episodes, err := models.EpisodesAiring(h.db, today, today)
+		if err != nil {
+			return "Couldn't load today's episodes."
+		}
+		if len(episodes) == 0 {
+			return "Nothing airing today."
+		}
+		return "Airing today:\n" + telegramEpisodeList(episodes)
+	case "/watched":
+		return h.telegramWatched(strings.TrimSpace(args), today, admin)
+	}
+	return telegramUsage
+}
+
+// telegramWatched marks the named show's next aired episode, or the one given as S01E03, watched
+func (h *BaseHandler) telegramWatched(args string, airedBy time.Time, admin *models.User) string {
+	words := strings.Fields(args)
+	season, number := 0, 0
+	if n := len(words); n > 1 {
+		if match := telegramEpisodeCode.FindStringSubmatch(words[n-1]); match != nil {
+			season, _ = strconv.Atoi(match[1])
+			number, _ = strconv.Atoi(match[2])
+			words = words[:n-1]
+		}
+	}
+	if len(words) == 0 {
+		return telegramUsage
+	}
+
+	query := strings.Join(words, " ")
+	media, err := h.libraryMatch(query)
+	if err != nil || media.Type != models.MediaTypeTV {
+		return fmt.Sprintf("No show in the library matches %q.", query)
+	}
+
+	var episode models.Episode
+	if season == 0 {
+		if episode, err = h.nextEpisode(media.TMDBID); err != nil {
+			return fmt.Sprintf("You've watched every episode of %s.", media.Title)
+		}
+	} else if err := h.db.Where("tmdb_id = ? AND season_number = ? AND episode_number = ?", media.TMDBID, season, number).First(&episode).Error; err != nil {
+		return fmt.Sprintf("%s has no S%02dE%02d.", media.Title, season, number)
+	}
+	if episode.AirDate != nil && episode.AirDate.After(airedBy) {
+		return fmt.Sprintf("%s S%02dE%02d hasn't aired yet.", media.Title, episode.SeasonNumber, episode.EpisodeNumber)
+	}
+
+	rules := h.siteTrackerRules()
+	if admin != nil {
+		rules = admin.TrackerRules(rules)
+	}
+	if err := h.setEpisodeWatched(&episode, true, airedBy, rules, admin); err != nil {
+		return "Couldn't save that, try again."
+	}
+
+	reply := fmt.Sprintf("Marked %s S%02dE%02d %s watched.", media.Title, episode.SeasonNumber, episode.EpisodeNumber, episode.Name)
+	if next, err := h.nextEpisode(media.TMDBID); err == nil {
+		reply += fmt.Sprintf("\nNext: S%02dE%02d %s", next.SeasonNumber, next.EpisodeNumber, next.Name)
+	}
+	return reply
+}
+
+// SendTelegramEpisodeAlerts tells the admin's chat, once a day, which library episodes air today
+func (h *BaseHandler) SendTelegramEpisodeAlerts() {
+	if !h.telegram.Enabled() {
+		return
+	}
+	today := services.Today(h.telegramLocation(h.telegramAdmin()))
+	day := today.Format("2006-01-02")
+
+	var sentOn string
+	models.LoadSetting(h.db, models.SettingTelegramAlerts, &sentOn)
+	if sentOn == day {
+		return
+	}
+
+	episodes, err := models.EpisodesAiring(h.db, today, today)
+	if err != nil {
+		log.Printf("Failed to load episodes for Telegram alerts: %v", err)
+		return
+	}
+	if len(episodes) > 0 {
+		if err := h.telegram.SendMessage("New episodes today:\n" + telegramEpisodeList(episodes)); err != nil {
+			log.Printf("Failed to send Telegram alert: %v", err)
+			return
+		}
+	}
+	models.SaveSetting(h.db, models.SettingTelegramAlerts, day)
+}
+
+// telegramAdmin is the admin whose timezone and tracker rules the bot follows
+func (h *BaseHandler) telegramAdmin() *models.User {
+	var admin models.User
+	if err := h.db.Where("role = ?", models.RoleAdmin).Order("id").First(&admin).Error; err != nil {
+		return nil
+	}
+	return &admin
+}
+
+func (h *BaseHandler) telegramLocation(admin *models.User) *time.Location {
+	if admin == nil {
+		return time.UTC
+	}
+	return services.LoadTimezone(admin.Timezone)
+}
+
+func telegramEpisodeList(episodes []models.AiringEpisode) string {
+	lines := make([]string, 0, len(episodes))
+	for _, episode := range episodes {
+		lines = append(lines, fmt.Sprintf("• %s S%02dE%02d %s", episode.ShowTitle, episode.SeasonNumber, episode.EpisodeNumber, episode.Name))
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,195 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mini-blog/app/models"
+	"mini-blog/app/services"
+	"mini-blog/app/templates"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// SupportPage is the tip jar: a few one-time amounts paid through Stripe Checkout
+func (h *BaseHandler) SupportPage(c echo.Context) error {
+	if !h.stripe.Enabled() {
+		return echo.NewHTTPError(http.StatusNotFound, "Page not found")
+	}
+	errorMessage := ""
+	if c.QueryParam("cancelled") != "" {
+		errorMessage = "Checkout was cancelled, nothing was charged."
+	}
+	return h.renderSupport(c, errorMessage)
+}
+
+func (h *BaseHandler) renderSupport(c echo.Context, errorMessage string) error {
+	user := h.GetCurrentUser(c)
+	page := templates.SupportPage(h.cfg.Payments.Amounts, h.cfg.Payments.Currency, errorMessage)
+	return h.render(c, templates.Layout("Support", page, c.Request().URL.Path, user))
+}
+
+// SupportCheckout sends the visitor to Stripe Checkout for one of the offered amounts, less any discount coupon
+func (h *BaseHandler) SupportCheckout(c echo.Context) error {
+	if !h.stripe.Enabled() {
+		return echo.NewHTTPError(http.StatusNotFound, "Page not found")
+	}
+	amount, _ := strconv.Atoi(c.FormValue("amount"))
+	if !containsAmount(h.cfg.Payments.Amounts, amount) {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid amount")
+	}
+	// Amounts are offered in whole units; Stripe wants the smallest unit
+	total := services.ToMinorUnits(amount, h.cfg.Payments.Currency)
+
+	code := strings.ToUpper(h.trimFormValue(c, "coupon"))
+	if code != "" {
+		var coupon models.Coupon
+		if err := h.db.Where("code = ?", code).First(&coupon).Error; err != nil || !coupon.Discount() || !coupon.Redeemable(time.Now()) {
+			return h.renderSupport(c, "That code is invalid or no longer available")
+		}
+		if total = h.discountedTip(coupon, total); total <= 0 {
+			return h.renderSupport(c, "That code covers the whole tip, pick a larger amount")
+		}
+	}
+
+	reference, email := "", ""
+	if user := h.GetCurrentUser(c); user != nil {
+		reference, email = strconv.FormatUint(uint64(user.ID), 10), user.Email
+	}
+	// The Host header is the client's to choose, so return links are built from BASE_URL
+	base := strings.TrimSuffix(h.cfg.Server.BaseURL, "/")
+	session, err := h.stripe.CreateCheckoutSession(total,
+		base+"/support/thanks?session_id={CHECKOUT_SESSION_ID}", base+"/support?cancelled=1", reference, email, code)
+	if err != nil {
+		log.Printf("Failed to start checkout: %v", err)
+		return echo.NewHTTPError(http.StatusBadGateway, "Payments are unavailable right now")
+	}
+	return c.Redirect(http.StatusSeeOther, session.URL)
+}
+
+// discountedTip is total, in the currency's smallest unit, less the coupon's discount
+func (h *BaseHandler) discountedTip(coupon models.Coupon, total int) int {
+	return total - total*coupon.PercentOff/100 - services.ToMinorUnits(coupon.AmountOff, h.cfg.Payments.Currency)
+}
+
+// SupportThanks is where Checkout returns the payer; it records the tip in case the webhook hasn't yet
+func (h *BaseHandler) SupportThanks(c echo.Context) error {
+	session, err := h.stripe.GetCheckoutSession(c.QueryParam("session_id"))
+	if err != nil || !session.Paid() {
+		return c.Redirect(http.StatusSeeOther, "/support")
+	}
+	payment, err := h.recordPayment(session)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to record payment")
+	}
+
+	user := h.GetCurrentUser(c)
+	return h.render(c, templates.Layout("Thank you", templates.SupportThanks(payment), c.Request().URL.Path, user))
+}
+
+// StripeWebhook records tips from checkout.session.completed events, signed with STRIPE_WEBHOOK_SECRET
+func (h *BaseHandler) StripeWebhook(c echo.Context) error {
+	payload, err := io.ReadAll(io.LimitReader(c.Request().Body, 1<<16))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Unreadable body")
+	}
+	if err := h.stripe.VerifyWebhook(payload, c.Request().Header.Get("Stripe-Signature")); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	var event struct {
+		Type string `json:"type"`
+		Data struct {
+			Object services.CheckoutSession `json:"object"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid event")
+	}
+	session := &event.Data.Object
+	switch event.Type {
+	// Delayed payment methods report completion first and the money later
+	case "checkout.session.completed", "checkout.session.async_payment_succeeded":
+		if session.Paid() {
+			if _, err := h.recordPayment(session); err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, "Failed to record payment")
+			}
+		}
+	case "checkout.session.async_payment_failed":
+		h.emitEvent(models.EventPaymentFailed, "A tip from "+paymentFrom(session.CustomerDetails.Email)+" failed to go through", "", map[string]interface{}{
+			"provider":    "stripe",
+			"provider_id": session.ID,
+			"amount":      session.AmountTotal,
+			"currency":    session.Currency,
+			"email":       session.CustomerDetails.Email,
+		})
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+// recordPayment stores a paid session once, however many times it is reported, and marks its user a supporter
+func (h *BaseHandler) recordPayment(session *services.CheckoutSession) (*models.Payment, error) {
+	payment := models.Payment{
+		Email:      session.CustomerDetails.Email,
+		Amount:     session.AmountTotal,
+		Currency:   session.Currency,
+		Provider:   "stripe",
+		ProviderID: session.ID,
+		Coupon:     session.Metadata.Coupon,
+	}
+	if id, err := strconv.ParseUint(session.ClientReferenceID, 10, 64); err == nil {
+		userID := uint(id)
+		payment.UserID = &userID
+	}
+
+	created := false
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("provider_id = ?", session.ID).FirstOrCreate(&payment)
+		if result.Error != nil {
+			return result.Error
+		}
+		created = result.RowsAffected > 0
+		// Discounts count as redeemed once paid, so abandoned checkouts don't use up a limited coupon
+		if created && payment.Coupon != "" {
+			if err := tx.Model(&models.Coupon{}).Where("code = ?", payment.Coupon).Update("redemptions", gorm.Expr("redemptions + 1")).Error; err != nil {
+				return err
+			}
+		}
+		if payment.UserID == nil {
+			return nil
+		}
+		return tx.Model(&models.User{}).Where("id = ?", *payment.UserID).Update("supporter", true).Error
+	})
+	if err != nil {
+		return nil, fmt.Errorf("record payment %s: %w", session.ID, err)
+	}
+
+	if created {
+		title := fmt.Sprintf("Tip of %s from %s", services.FormatAmount(payment.Amount, payment.Currency), paymentFrom(payment.Email))
+		h.emitEvent(models.EventPaymentSucceeded, title, "", payment)
+	}
+	return &payment, nil
+}
+
+// paymentFrom names a payer in notifications
+func paymentFrom(email string) string {
+	if email == "" {
+		return "an anonymous visitor"
+	}
+	return email
+}
+
+func containsAmount(amounts []int, amount int) bool {
+	for _, a := range amounts {
+		if a == amount {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"fmt"
+	"html/template"
+	"log"
+	"mini-blog/app/models"
+	"mini-blog/app/services"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// tmdbQuotaWarnPercent of a budget raises an admin alert
+	tmdbQuotaWarnPercent = 80
+	// tmdbQuotaThrottlePercent of a budget pauses background syncs until the period rolls over
+	tmdbQuotaThrottlePercent = 95
+)
+
+// tmdbQuotaAlerts is stored under SettingTMDBQuotaAlerts: the last hour and day each budget warned for
+type tmdbQuotaAlerts struct {
+	Hour time.Time `json:"hour"`
+	Day  time.Time `json:"day"`
+}
+
+// RecordTMDBUsage moves TMDB calls counted in memory into the hourly totals, then alerts if a budget is nearly spent
+func (h *BaseHandler) RecordTMDBUsage() {
+	for hour, calls := range h.tmdbService.DrainUsage() {
+		err := h.db.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "service"}, {Name: "hour"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{"calls": gorm.Expr("api_call_hours.calls + excluded.calls")}),
+		}).Create(&models.APICallHour{Service: models.ProviderTMDB, Hour: hour, Calls: calls}).Error
+		if err != nil {
+			log.Printf("Failed to record %d TMDB calls for %s: %v", calls, hour.Format(time.RFC3339), err)
+		}
+	}
+	h.alertTMDBQuota(h.tmdbQuota())
+}
+
+// ServeTMDBEmbeds routes {{tmdb}} shortcode lookups through this site's TMDB client, so they are recorded
+// with its usage and pause when its budget is nearly spent
+func (h *BaseHandler) ServeTMDBEmbeds() {
+	services.SetEmbedTMDB(h.tmdbService, h.tmdbThrottled)
+}
+
+// tmdbQuota totals TMDB calls for the dashboard: the last 24 hours, this hour and today (UTC)
+func (h *BaseHandler) tmdbQuota() models.TMDBQuota {
+	now := time.Now().UTC()
+	thisHour := now.Truncate(time.Hour)
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	since := thisHour.Add(-23 * time.Hour)
+	if midnight.Before(since) {
+		since = midnight
+	}
+
+	var rows []models.APICallHour
+	h.db.Where("service = ? AND hour >= ?", models.ProviderTMDB, since).Find(&rows)
+	byHour := make(map[time.Time]int64, len(rows))
+	for _, row := range rows {
+		byHour[row.Hour.UTC()] = row.Calls
+	}
+
+	quota := models.TMDBQuota{HourlyBudget: h.cfg.TMDB.HourlyBudget, DailyBudget: h.cfg.TMDB.DailyBudget}
+	for hour := thisHour.Add(-23 * time.Hour); !hour.After(thisHour); hour = hour.Add(time.Hour) {
+		quota.Hours = append(quota.Hours, models.APICallHour{Service: models.ProviderTMDB, Hour: hour, Calls: byHour[hour]})
+	}
+	quota.HourCalls = byHour[thisHour]
+	for hour, calls := range byHour {
+		if !hour.Before(midnight) {
+			quota.DayCalls += calls
+		}
+	}
+	return quota
+}
+
+// tmdbThrottled reports whether background syncs should hold off because a TMDB budget is almost spent
+func (h *BaseHandler) tmdbThrottled() bool {
+	quota := h.tmdbQuota()
+	return (quota.HourlyBudget > 0 && quota.HourPercent() >= tmdbQuotaThrottlePercent) ||
+		(quota.DailyBudget > 0 && quota.DayPercent() >= tmdbQuotaThrottlePercent)
+}
+
+// alertTMDBQuota notifies admins, once per hour or day, when usage passes tmdbQuotaWarnPercent of a budget
+func (h *BaseHandler) alertTMDBQuota(quota models.TMDBQuota) {
+	var alerted tmdbQuotaAlerts
+	models.LoadSetting(h.db, models.SettingTMDBQuotaAlerts, &alerted)
+
+	now := time.Now().UTC()
+	hour, day := now.Truncate(time.Hour), time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	var warnings []string
+	if quota.HourlyBudget > 0 && quota.HourPercent() >= tmdbQuotaWarnPercent && !alerted.Hour.Equal(hour) {
+		alerted.Hour = hour
+		warnings = append(warnings, fmt.Sprintf("%d of %d TMDB calls used this hour", quota.HourCalls, quota.HourlyBudget))
+	}
+	if quota.DailyBudget > 0 && quota.DayPercent() >= tmdbQuotaWarnPercent && !alerted.Day.Equal(day) {
+		alerted.Day = day
+		warnings = append(warnings, fmt.Sprintf("%d of %d TMDB calls used today", quota.DayCalls, quota.DailyBudget))
+	}
+	if len(warnings) == 0 {
+		return
+	}
+	if err := models.SaveSetting(h.db, models.SettingTMDBQuotaAlerts, alerted); err != nil {
+		log.Printf("Failed to save TMDB quota alert: %v", err)
+	}
+
+	for _, warning := range warnings {
+		h.emitEvent(models.EventTMDBQuota, warning, "/admin/dashboard", map[string]interface{}{
+			"hour_calls": quota.HourCalls, "hourly_budget": quota.HourlyBudget,
+			"day_calls": quota.DayCalls, "daily_budget": quota.DailyBudget,
+		})
+		if h.cfg.Auth.AdminEmail == "" {
+			continue
+		}
+		body := template.HTML(fmt.Sprintf("<p>%s. Background syncs pause at %d%% of a budget until the hour or day rolls over.</p>",
+			template.HTMLEscapeString(warning), tmdbQuotaThrottlePercent))
+		if err := h.enqueueEmail(models.EmailKindTransactional, h.cfg.Auth.AdminEmail, "TMDB budget nearly spent",
+			services.AnnouncementHTML("TMDB budget nearly spent", body), time.Now(), nil); err != nil {
+			log.Printf("Failed to queue TMDB quota email: %v", err)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"mini-blog/app/models"
+	"mini-blog/app/services"
+	"strings"
+	"time"
+)
+
+// SendUpNextEmails queues the morning "up next" email for every opted-in user whose send hour has come
+// in their timezone; a user gets at most one a day, and none on days with nothing to list
+func (h *BaseHandler) SendUpNextEmails() {
+	var users []models.User
+	h.db.Where("up_next_email = ?", true).Find(&users)
+
+	for _, user := range users {
+		loc := services.LoadTimezone(user.Timezone)
+		now := time.Now().In(loc)
+		today := services.Today(loc)
+		sentToday := user.UpNextSentAt != nil && user.UpNextSentAt.In(loc).Format(time.DateOnly) == now.Format(time.DateOnly)
+		if now.Hour() < user.UpNextHour || sentToday {
+			continue
+		}
+		h.db.Model(&user).Update("up_next_sent_at", now)
+
+		db := h.db
+		if h.adultMedia().Hide && !user.IsAdmin() {
+			db = db.Scopes(models.HideAdultMedia)
+		}
+		airing, err := models.EpisodesAiring(db, today, today)
+		if err != nil {
+			log.Printf("Failed to load today's episodes for %s: %v", user.Email, err)
+			continue
+		}
+		next, err := models.NextEpisodes(db, today)
+		if err != nil {
+			log.Printf("Failed to load next episodes for %s: %v", user.Email, err)
+			continue
+		}
+		if len(airing) == 0 && len(next) == 0 {
+			continue
+		}
+
+		// Air dates, like today, are calendar dates at UTC midnight
+		ctx := services.WithTimezone(context.Background(), time.UTC, user.DateFormat)
+		html := services.UpNextHTML(services.FormatDate(ctx, today, "long"), airing, next,
+			strings.TrimSuffix(h.cfg.Server.BaseURL, "/")+"/tv/airing")
+		if err := h.enqueueEmail(models.EmailKindDigest, user.Email, "Up next today", html, time.Now(), nil); err != nil {
+			log.Printf("Failed to queue up next email for %s: %v", user.Email, err)
+		}
+	}
+}
@@ -0,0 +1,249 @@
+package handlers
+
+import (
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"mime/multipart"
+	"mini-blog/app/models"
+	"mini-blog/app/services"
+	"mini-blog/app/templates"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	_ "golang.org/x/image/webp"
+)
+
+// AdminUploads is the media library: every uploaded image with the posts that reference it
+func (h *BaseHandler) AdminUploads(c echo.Context) error {
+	return h.renderUploads(c, "", "")
+}
+
+// uploadResult is one stored file in AdminUploadCreate's JSON response
+type uploadResult struct {
+	ID       uint   `json:"id"`
+	Filename string `json:"filename"`
+	URL      string `json:"url"`
+	Markdown string `json:"markdown"`
+}
+
+// AdminUploadCreate stores one or more images from the "files" multipart field.
+// Clients that accept JSON (editor drag-and-drop, scripts) get the stored URLs and markdown back
+// instead of the media library page.
+func (h *BaseHandler) AdminUploadCreate(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+	wantsJSON := strings.Contains(c.Request().Header.Get(echo.HeaderAccept), echo.MIMEApplicationJSON)
+
+	form, err := c.MultipartForm()
+	if err != nil || len(form.File["files"]) == 0 {
+		if wantsJSON {
+			return echo.NewHTTPError(http.StatusBadRequest, "Choose at least one image to upload")
+		}
+		return h.renderUploads(c, "", "Choose at least one image to upload")
+	}
+
+	var failed []string
+	results := []uploadResult{}
+	for _, header := range form.File["files"] {
+		upload, err := h.storeUpload(header, user)
+		if err != nil {
+			failed = append(failed, header.Filename+" ("+err.Error()+")")
+			continue
+		}
+		results = append(results, uploadResult{ID: upload.ID, Filename: upload.Filename, URL: upload.URL, Markdown: upload.Markdown()})
+	}
+
+	if wantsJSON {
+		status := http.StatusCreated
+		if len(results) == 0 {
+			status = http.StatusUnprocessableEntity
+		}
+		return c.JSON(status, map[string]interface{}{"uploads": results, "failed": failed})
+	}
+	if len(failed) > 0 {
+		return h.renderUploads(c, "", "Failed to upload: "+strings.Join(failed, ", "))
+	}
+	return h.renderUploads(c, fmt.Sprintf("Uploaded %d file(s)", len(form.File["files"])), "")
+}
+
+// storeUpload writes an image through the storage layer and records it
+func (h *BaseHandler) storeUpload(header *multipart.FileHeader, user *models.User) (*models.Upload, error) {
+	file, err := header.Open()
+	if err != nil {
+		return nil, fmt.Errorf("unreadable file")
+	}
+	defer file.Close()
+
+	// Sniff the real type rather than trusting the client's Content-Type
+	sniff := make([]byte, 512)
+	n, _ := file.Read(sniff)
+	contentType := http.DetectContentType(sniff[:n])
+	if !strings.HasPrefix(contentType, "image/") {
+		return nil, fmt.Errorf("not an image")
+	}
+	if !slices.Contains(h.cfg.Limits.ImageTypes, contentType) {
+		return nil, fmt.Errorf("%s images aren't allowed", strings.TrimPrefix(contentType, "image/"))
+	}
+	if limit := h.cfg.Limits.ImageDimension; limit > 0 {
+		if _, err := file.Seek(0, 0); err != nil {
+			return nil, fmt.Errorf("unreadable file")
+		}
+		size, _, err := image.DecodeConfig(file)
+		if err != nil {
+			return nil, fmt.Errorf("unreadable image")
+		}
+		if size.Width > limit || size.Height > limit {
+			return nil, fmt.Errorf("%dx%d is over the %dpx limit", size.Width, size.Height, limit)
+		}
+	}
+	if _, err := file.Seek(0, 0); err != nil {
+		return nil, fmt.Errorf("unreadable file")
+	}
+
+	key := services.NewStorageKey(header.Filename, contentType)
+	url, err := h.storage.Save(key, file, contentType)
+	if err != nil {
+		return nil, fmt.Errorf("storage error")
+	}
+
+	upload := models.Upload{
+		Key:          key,
+		Filename:     header.Filename,
+		ContentType:  contentType,
+		Size:         header.Size,
+		URL:          url,
+		UploadedByID: &user.ID,
+	}
+	if err := h.db.Create(&upload).Error; err != nil {
+		h.storage.Delete(key)
+		return nil, fmt.Errorf("database error")
+	}
+	return &upload, nil
+}
+
+// AdminUploadDelete removes a single upload from the gallery, refusing while a post still uses it
+func (h *BaseHandler) AdminUploadDelete(c echo.Context) error {
+	id, err := h.parseUintParam(c, "id")
+	if err != nil {
+		return err
+	}
+
+	var upload models.Upload
+	if err := h.db.First(&upload, id).Error; err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "Upload not found")
+	}
+	if posts := h.uploadUsage([]models.Upload{upload})[upload.ID]; len(posts) > 0 {
+		return h.renderUploads(c, "", fmt.Sprintf("%s is still used in %d post(s)", upload.Filename, len(posts)))
+	}
+
+	if err := h.db.Unscoped().Delete(&upload).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to delete upload")
+	}
+	h.storage.Delete(upload.Key)
+	return h.renderUploads(c, "Deleted "+upload.Filename, "")
+}
+
+// AdminUploadsBulkDelete removes the selected uploads, skipping any still referenced by a post
+func (h *BaseHandler) AdminUploadsBulkDelete(c echo.Context) error {
+	form, _ := c.FormParams()
+
+	var ids []uint
+	for _, raw := range form["ids"] {
+		if id, err := strconv.ParseUint(raw, 10, 64); err == nil {
+			ids = append(ids, uint(id))
+		}
+	}
+
+	var uploads []models.Upload
+	if c.FormValue("scope") == "unused" {
+		h.db.Find(&uploads)
+	} else if len(ids) > 0 {
+		h.db.Where("id IN ?", ids).Find(&uploads)
+	}
+	if len(uploads) == 0 {
+		return h.renderUploads(c, "", "Select uploads to delete")
+	}
+
+	usage := h.uploadUsage(uploads)
+	deleted, skipped := 0, 0
+	for _, upload := range uploads {
+		if len(usage[upload.ID]) > 0 {
+			skipped++
+			continue
+		}
+		if err := h.db.Unscoped().Delete(&upload).Error; err != nil {
+			continue
+		}
+		h.storage.Delete(upload.Key)
+		deleted++
+	}
+
+	message := fmt.Sprintf("Deleted %d upload(s)", deleted)
+	if skipped > 0 {
+		message += fmt.Sprintf(", kept %d still used in posts", skipped)
+	}
+	return h.renderUploads(c, message, "")
+}
+
+// AdminUploadPicker renders a compact, searchable grid for inserting images into the post editor
+func (h *BaseHandler) AdminUploadPicker(c echo.Context) error {
+	return h.render(c, templates.UploadPicker(h.searchUploads(c.QueryParam("q")), c.QueryParam("q")))
+}
+
+func (h *BaseHandler) searchUploads(query string) []models.Upload {
+	var uploads []models.Upload
+	db := h.db.Order("created_at desc")
+	if query = strings.TrimSpace(query); query != "" {
+		db = db.Where("filename ILIKE ?", "%"+query+"%")
+	}
+	db.Find(&uploads)
+	return uploads
+}
+
+// uploadUsage maps upload IDs to the posts whose content, translations, cover or preview image reference the upload URL
+func (h *BaseHandler) uploadUsage(uploads []models.Upload) map[uint][]models.Post {
+	usage := make(map[uint][]models.Post)
+	if len(uploads) == 0 {
+		return usage
+	}
+
+	var posts []models.Post
+	h.db.Select("id", "title", "content", "cover_image", "og_image").Preload("Translations").Find(&posts)
+
+	for _, upload := range uploads {
+		for _, post := range posts {
+			if postReferences(post, upload.URL) {
+				usage[upload.ID] = append(usage[upload.ID], post)
+			}
+		}
+	}
+	return usage
+}
+
+func postReferences(post models.Post, url string) bool {
+	if strings.Contains(post.Content, url) || strings.Contains(post.CoverImage, url) || strings.Contains(post.OGImage, url) {
+		return true
+	}
+	for _, t := range post.Translations {
+		if strings.Contains(t.Content, url) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *BaseHandler) renderUploads(c echo.Context, successMessage, errorMessage string) error {
+	query := c.QueryParam("q")
+	uploads := h.searchUploads(query)
+	page := templates.UploadsPage(uploads, h.uploadUsage(uploads), query, successMessage, errorMessage)
+
+	if h.isHTMXRequest(c) {
+		return h.render(c, page)
+	}
+	return h.render(c, templates.Layout("Media Library", page, c.Request().URL.Path, c.Get("user").(*models.User)))
+}
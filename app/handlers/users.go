@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"fmt"
+	"mini-blog/app/models"
+	"mini-blog/app/templates"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const segmentDateLayout = "2006-01-02"
+
+// AdminUsers is the filterable user table; ?segment= applies a saved segment
+func (h *BaseHandler) AdminUsers(c echo.Context) error {
+	segment, segmentID := h.segmentFromRequest(c)
+	return h.renderUsers(c, segment, segmentID, "", "")
+}
+
+// AdminUsersExport downloads the filtered users as CSV
+func (h *BaseHandler) AdminUsersExport(c echo.Context) error {
+	segment, _ := h.segmentFromRequest(c)
+
+	var users []models.User
+	segment.Apply(h.db.Model(&models.User{})).Order("created_at desc").Find(&users)
+
+	filename := fmt.Sprintf("users-%s.csv", time.Now().Format(segmentDateLayout))
+	c.Response().Header().Set(echo.HeaderContentType, "text/csv; charset=utf-8")
+	c.Response().Header().Set(echo.HeaderContentDisposition, `attachment; filename="`+filename+`"`)
+	c.Response().WriteHeader(http.StatusOK)
+
+	w := csv.NewWriter(c.Response())
+	w.Write([]string{"id", "name", "email", "role", "verified", "signed_up", "last_seen"})
+	for _, user := range users {
+		lastSeen := ""
+		if user.LastSeenAt != nil {
+			lastSeen = user.LastSeenAt.UTC().Format(time.RFC3339)
+		}
+		w.Write([]string{
+			strconv.FormatUint(uint64(user.ID), 10),
+			csvText(user.Name),
+			csvText(user.Email),
+			user.Role,
+			strconv.FormatBool(user.IsVerified),
+			user.CreatedAt.UTC().Format(time.RFC3339),
+			lastSeen,
+		})
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// AdminSegmentCreate saves the current filters as a named segment
+func (h *BaseHandler) AdminSegmentCreate(c echo.Context) error {
+	segment, _ := h.segmentFromRequest(c)
+	segment.ID = 0
+	segment.Name = h.trimFormValue(c, "name")
+
+	if err := h.validator.Struct(segment); err != nil {
+		return h.renderUsers(c, segment, 0, "", "Give the segment a name")
+	}
+	if err := h.db.Create(&segment).Error; err != nil {
+		return h.renderUsers(c, segment, 0, "", "A segment with that name already exists")
+	}
+	return h.renderUsers(c, segment, segment.ID, "Saved segment "+segment.Name, "")
+}
+
+// AdminSegmentDelete removes a saved segment
+func (h *BaseHandler) AdminSegmentDelete(c echo.Context) error {
+	id, err := h.parseUintParam(c, "id")
+	if err != nil {
+		return err
+	}
+
+	if err := h.db.Unscoped().Delete(&models.Segment{}, id).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to delete segment")
+	}
+
+	return h.renderUsers(c, models.Segment{}, 0, "Segment deleted", "")
+}
+
+// segmentFromRequest returns the saved segment named by ?segment=, or an ad-hoc one built from the filter fields
+func (h *BaseHandler) segmentFromRequest(c echo.Context) (models.Segment, uint) {
+	var segment models.Segment
+	if id, err := strconv.ParseUint(c.QueryParam("segment"), 10, 64); err == nil {
+		if h.db.First(&segment, id).Error == nil {
+			return segment, segment.ID
+		}
+	}
+
+	if role := c.FormValue("role"); models.IsValidRole(role) {
+		segment.Role = role
+	}
+	if verified := c.FormValue("verified"); verified == models.SegmentVerified || verified == models.SegmentUnverified {
+		segment.Verified = verified
+	}
+	if from, err := time.Parse(segmentDateLayout, c.FormValue("from")); err == nil {
+		segment.SignedUpFrom = &from
+	}
+	if to, err := time.Parse(segmentDateLayout, c.FormValue("to")); err == nil {
+		segment.SignedUpTo = &to
+	}
+	if activity := c.FormValue("activity"); activity == models.ActivityActive || activity == models.ActivityInactive {
+		segment.Activity = activity
+		segment.ActivityDays, _ = strconv.Atoi(c.FormValue("activity_days"))
+	}
+	return segment, 0
+}
+
+func (h *BaseHandler) renderUsers(c echo.Context, segment models.Segment, segmentID uint, successMessage, errorMessage string) error {
+	var users []models.User
+	segment.Apply(h.db.Model(&models.User{})).Order("created_at desc").Find(&users)
+
+	var segments []models.Segment
+	h.db.Order("name asc").Find(&segments)
+
+	page := templates.UsersPage(users, segment, segmentID, segments, successMessage, errorMessage)
+	if h.isHTMXRequest(c) {
+		return h.render(c, page)
+	}
+	return h.render(c, templates.Layout("Users", page, c.Request().URL.Path, c.Get("user").(*models.User)))
+}
@@ -0,0 +1,295 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"mini-blog/app/models"
+	"mini-blog/app/services"
+	"mini-blog/app/templates"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// importCandidates is how many TMDB results an ambiguous title offers for review
+const importCandidates = 5
+
+// AdminWatchImport shows the viewing-history upload form and the current import's titles
+func (h *BaseHandler) AdminWatchImport(c echo.Context) error {
+	return h.renderWatchImport(c, "")
+}
+
+// AdminWatchImportItems re-renders the titles while the import page polls a running import
+func (h *BaseHandler) AdminWatchImportItems(c echo.Context) error {
+	items := h.watchImportItems()
+	// Restarts the worker if a restart cut a run short
+	if models.ImportBusy(items) {
+		go h.runWatchImport(c.Get("user").(*models.User))
+	}
+	return h.render(c, templates.WatchImportItems(items))
+}
+
+// AdminNetflixImport replaces the current import with the titles in an uploaded ViewingActivity.csv and matches them in the background
+func (h *BaseHandler) AdminNetflixImport(c echo.Context) error {
+	header, err := c.FormFile("history")
+	if err != nil {
+		return h.renderWatchImport(c, "Choose a viewing activity CSV to import")
+	}
+	file, err := header.Open()
+	if err != nil {
+		return h.renderWatchImport(c, "Failed to read the file")
+	}
+	defer file.Close()
+
+	titles, err := services.ParseNetflixCSV(file, c.FormValue("day_first") == "on")
+	if err != nil {
+		return h.renderWatchImport(c, "Couldn't read the CSV: "+err.Error())
+	}
+	if len(titles) == 0 {
+		return h.renderWatchImport(c, "The CSV has no viewing activity")
+	}
+
+	items := make([]models.WatchImportItem, 0, len(titles))
+	for _, title := range titles {
+		views, _ := json.Marshal(title.Views)
+		items = append(items, models.WatchImportItem{Title: title.Title, MediaType: title.MediaType, Views: string(views), Status: models.ImportPending})
+	}
+	if err := h.db.Where("1 = 1").Delete(&models.WatchImportItem{}).Error; err != nil {
+		return h.renderWatchImport(c, "Failed to clear the previous import")
+	}
+	if err := h.db.CreateInBatches(&items, 200).Error; err != nil {
+		return h.renderWatchImport(c, "Failed to save the import")
+	}
+
+	go h.runWatchImport(c.Get("user").(*models.User))
+	return h.renderWatchImport(c, "")
+}
+
+// AdminWatchImportResolve settles a title under review: tmdb_id picks a candidate, manual_id is a TMDB ID typed in
+// instead, and action=skip leaves the title out
+func (h *BaseHandler) AdminWatchImportResolve(c echo.Context) error {
+	id, err := h.parseUintParam(c, "id")
+	if err != nil {
+		return err
+	}
+	var item models.WatchImportItem
+	if err := h.db.First(&item, id).Error; err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "Import item not found")
+	}
+	if item.Status == models.ImportQueued || item.Status == models.ImportImported {
+		return echo.NewHTTPError(http.StatusConflict, "Already imported")
+	}
+
+	updates := map[string]interface{}{"status": models.ImportSkipped}
+	if c.FormValue("action") != "skip" {
+		choice := h.trimFormValue(c, "manual_id")
+		if choice == "" {
+			choice = c.FormValue("tmdb_id")
+		}
+		tmdbID, err := strconv.Atoi(choice)
+		if err != nil || tmdbID <= 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, "Pick a title or enter a TMDB ID")
+		}
+		updates = map[string]interface{}{"status": models.ImportMatched, "tmdb_id": tmdbID, "note": ""}
+	}
+	if err := h.db.Model(&item).Updates(updates).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to save")
+	}
+	h.db.First(&item, id)
+	return h.render(c, templates.WatchImportRow(item))
+}
+
+// AdminWatchImportApply adds every matched title to the library and marks its viewings watched, in the background
+func (h *BaseHandler) AdminWatchImportApply(c echo.Context) error {
+	if err := h.db.Model(&models.WatchImportItem{}).Where("status = ?", models.ImportMatched).
+		Update("status", models.ImportQueued).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to start the import")
+	}
+	go h.runWatchImport(c.Get("user").(*models.User))
+	return h.render(c, templates.WatchImportItems(h.watchImportItems()))
+}
+
+// AdminWatchImportClear discards the current import; titles already applied stay in the library
+func (h *BaseHandler) AdminWatchImportClear(c echo.Context) error {
+	if err := h.db.Where("status <> ?", models.ImportQueued).Delete(&models.WatchImportItem{}).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to clear the import")
+	}
+	return h.renderWatchImport(c, "")
+}
+
+// runWatchImport works through pending searches and queued titles until none are left
+func (h *BaseHandler) runWatchImport(admin *models.User) {
+	if !h.watchImportMu.TryLock() {
+		return
+	}
+	defer h.watchImportMu.Unlock()
+
+	rules := admin.TrackerRules(h.siteTrackerRules())
+	airedBy := services.Today(services.LoadTimezone(admin.Timezone))
+	for {
+		var item models.WatchImportItem
+		if err := h.db.Where("status IN ?", []string{models.ImportPending, models.ImportQueued}).Order("id").First(&item).Error; err != nil {
+			return
+		}
+		if item.Status == models.ImportPending {
+			h.matchWatchImportItem(&item)
+		} else {
+			h.applyWatchImportItem(&item, airedBy, rules)
+		}
+		if err := h.db.Save(&item).Error; err != nil {
+			log.Printf("Failed to save import item %d: %v", item.ID, err)
+			return
+		}
+	}
+}
+
+// matchWatchImportItem matches a title to the library or TMDB, leaving it for review unless exactly one result has its name
+func (h *BaseHandler) matchWatchImportItem(item *models.WatchImportItem) {
+	var media models.Media
+	if h.db.Where("type = ? AND LOWER(title) = LOWER(?)", item.MediaType, item.Title).First(&media).Error == nil {
+		item.TMDBID, item.Status = media.TMDBID, models.ImportMatched
+		return
+	}
+
+	results, err := h.metadata.For(item.MediaType).Search(item.Title, item.MediaType)
+	if err != nil {
+		item.Status, item.Note = models.ImportReview, "TMDB search failed, enter a TMDB ID"
+		return
+	}
+	if len(results) > importCandidates {
+		results = results[:importCandidates]
+	}
+
+	candidates := make([]models.ImportCandidate, 0, len(results))
+	exact := 0
+	for _, result := range results {
+		candidate := models.ImportCandidate{TMDBID: result.ID, Title: result.Title, Year: result.ReleaseDate}
+		if item.MediaType == models.MediaTypeTV {
+			candidate.Title, candidate.Year = result.Name, result.FirstAirDate
+		}
+		if len(candidate.Year) >= 4 {
+			candidate.Year = candidate.Year[:4]
+		}
+		if strings.EqualFold(candidate.Title, item.Title) {
+			exact++
+			item.TMDBID = result.ID
+		}
+		candidates = append(candidates, candidate)
+	}
+	encoded, _ := json.Marshal(candidates)
+	item.Candidates = string(encoded)
+
+	switch {
+	case exact == 1:
+		item.Status = models.ImportMatched
+	case len(candidates) == 0:
+		item.TMDBID, item.Status, item.Note = 0, models.ImportReview, "Nothing on TMDB matches, enter a TMDB ID"
+	default:
+		item.TMDBID, item.Status = 0, models.ImportReview
+	}
+}
+
+// applyWatchImportItem adds a matched title to the library and marks its viewings watched on their dates.
+// No milestone posts are drafted: the titles were finished long ago.
+func (h *BaseHandler) applyWatchImportItem(item *models.WatchImportItem, airedBy time.Time, rules models.TrackerRules) {
+	var media models.Media
+	if h.db.Where("tmdb_id = ?", item.TMDBID).First(&media).Error != nil {
+		status := models.StatusWatching
+		if item.MediaType == models.MediaTypeMovie {
+			status = models.StatusCompleted
+		}
+		added, err := h.addMedia(item.TMDBID, item.MediaType, status, "", airedBy, nil)
+		if err != nil {
+			item.Status, item.Note = models.ImportFailed, err.Error()
+			return
+		}
+		media = *added
+	}
+
+	views := item.ViewList()
+	if media.Type == models.MediaTypeMovie {
+		first := views[0].Date
+		for _, view := range views {
+			if view.Date.Before(first) {
+				first = view.Date
+			}
+		}
+		if err := h.db.Model(&media).Updates(map[string]interface{}{"status": models.StatusCompleted, "watched_at": first}).Error; err != nil {
+			item.Status, item.Note = models.ImportFailed, "Failed to mark watched"
+			return
+		}
+		item.Status, item.Note = models.ImportImported, ""
+		return
+	}
+
+	var episodes []models.Episode
+	h.db.Where("tmdb_id = ?", media.TMDBID).Order("season_number, episode_number").Find(&episodes)
+	missed := 0
+	for _, view := range views {
+		episode := matchImportedEpisode(episodes, view)
+		if episode == nil {
+			missed++
+			continue
+		}
+		// The first viewing wins, so rewatches don't move an episode's date forward
+		h.db.Model(&models.Episode{}).Where("id = ? AND (watched = ? OR watched_at IS NULL OR watched_at > ?)", episode.ID, false, view.Date).
+			Updates(map[string]interface{}{"watched": true, "watched_at": view.Date})
+	}
+	h.updateMediaProgress(media.TMDBID, airedBy, rules, nil)
+
+	item.Status, item.Note = models.ImportImported, ""
+	if missed > 0 {
+		item.Note = fmt.Sprintf("%d of %d viewings matched no episode", missed, len(views))
+	}
+}
+
+// matchImportedEpisode finds a viewing's episode by name, in its season first since Netflix's
+// "Part 2" need not be TMDB's season 2, and by number for titles like "Episode 3"
+func matchImportedEpisode(episodes []models.Episode, view models.WatchView) *models.Episode {
+	name := strings.TrimSpace(view.Episode)
+	var elsewhere *models.Episode
+	for i := range episodes {
+		if !strings.EqualFold(episodes[i].Name, name) {
+			continue
+		}
+		if episodes[i].SeasonNumber == view.Season {
+			return &episodes[i]
+		}
+		if elsewhere == nil {
+			elsewhere = &episodes[i]
+		}
+	}
+	if elsewhere != nil {
+		return elsewhere
+	}
+
+	if number, ok := strings.CutPrefix(strings.ToLower(name), "episode "); ok {
+		if n, err := strconv.Atoi(strings.TrimSpace(number)); err == nil {
+			for i := range episodes {
+				if episodes[i].SeasonNumber == view.Season && episodes[i].EpisodeNumber == n {
+					return &episodes[i]
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (h *BaseHandler) watchImportItems() []models.WatchImportItem {
+	var items []models.WatchImportItem
+	// Titles needing a decision first, then by title
+	h.db.Order(fmt.Sprintf("CASE status WHEN '%s' THEN 0 ELSE 1 END, title", models.ImportReview)).Find(&items)
+	return items
+}
+
+func (h *BaseHandler) renderWatchImport(c echo.Context, errorMessage string) error {
+	page := templates.WatchImportPage(h.watchImportItems(), errorMessage)
+	if h.isHTMXRequest(c) {
+		return h.render(c, page)
+	}
+	return h.render(c, templates.Layout("Import Watch History", page, c.Request().URL.Path, c.Get("user").(*models.User)))
+}
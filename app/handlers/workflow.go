@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"mini-blog/app/models"
+	"mini-blog/app/templates"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// AdminPostWorkflow renders the workflow panel for the post edit page
+func (h *BaseHandler) AdminPostWorkflow(c echo.Context) error {
+	post, err := h.loadWorkflowPost(c)
+	if err != nil {
+		return err
+	}
+	return h.renderWorkflowPanel(c, post, "")
+}
+
+// AdminPostTransition moves a post through the editorial workflow (to=in_review|approved|draft|published)
+func (h *BaseHandler) AdminPostTransition(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+	post, err := h.loadWorkflowPost(c)
+	if err != nil {
+		return err
+	}
+
+	to := c.FormValue("to")
+	if !models.CanTransitionPost(post.Status, to) {
+		return h.renderWorkflowPanel(c, post, "Can't move a "+models.GetPostStatusName(post.Status)+" post to "+models.GetPostStatusName(to))
+	}
+
+	if to == models.PostStatusInReview {
+		if reviewerID, err := strconv.ParseUint(c.FormValue("reviewer_id"), 10, 64); err == nil && reviewerID > 0 {
+			id := uint(reviewerID)
+			post.ReviewerID = &id
+		}
+	}
+
+	if msg := h.workflowGate(user, post, to); msg != "" {
+		return h.renderWorkflowPanel(c, post, msg)
+	}
+
+	post.SetStatus(to)
+	// Dropping back to draft shouldn't leave a stale schedule behind for the publisher
+	if to == models.PostStatusDraft && post.PublishAt != nil && !post.PublishAt.After(time.Now()) {
+		post.PublishAt = nil
+	}
+	// Publishing again by hand means the old expiry no longer applies
+	if to == models.PostStatusPublished && post.IsExpired() {
+		post.ExpiresAt = nil
+	}
+
+	if err := h.db.Model(post).Updates(map[string]interface{}{
+		"status":      post.Status,
+		"published":   post.Published,
+		"reviewer_id": post.ReviewerID,
+		"publish_at":  post.PublishAt,
+		"expires_at":  post.ExpiresAt,
+	}).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to update post status")
+	}
+	if to == models.PostStatusPublished {
+		go h.notifySubscribers(*post)
+	}
+
+	return h.renderWorkflowPanel(c, post, "")
+}
+
+// workflowGate returns why user may not make this transition, or "" when allowed
+func (h *BaseHandler) workflowGate(user *models.User, post *models.Post, to string) string {
+	isAuthor := post.AuthorID != nil && *post.AuthorID == user.ID
+	isReviewer := post.ReviewerID != nil && *post.ReviewerID == user.ID
+
+	switch {
+	case to == models.PostStatusInReview:
+		if post.ReviewerID == nil {
+			return "Assign a reviewer before submitting for review"
+		}
+		var reviewer models.User
+		if err := h.db.First(&reviewer, *post.ReviewerID).Error; err != nil || !reviewer.IsAdmin() {
+			return "Reviewer must be an admin"
+		}
+	case to == models.PostStatusApproved:
+		if !isReviewer {
+			return "Only the assigned reviewer can approve this post"
+		}
+		// With nobody else to ask, the only admin signs off their own posts
+		if isAuthor && h.hasOtherAdmin(user) {
+			return "Authors can't approve their own posts"
+		}
+	case to == models.PostStatusDraft && post.Status == models.PostStatusInReview:
+		if !isReviewer && !isAuthor {
+			return "Only the author or reviewer can send this post back to draft"
+		}
+	case to == models.PostStatusPublished:
+		if !user.IsAdmin() {
+			return "Only admins can publish posts"
+		}
+	}
+	return ""
+}
+
+// AdminPostCommentCreate adds an inline review comment to a post that isn't published yet
+func (h *BaseHandler) AdminPostCommentCreate(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+	post, err := h.loadWorkflowPost(c)
+	if err != nil {
+		return err
+	}
+
+	if post.Status == models.PostStatusPublished {
+		return h.renderWorkflowPanel(c, post, "Review comments are closed once a post is published")
+	}
+
+	comment := models.ReviewComment{PostID: post.ID, UserID: user.ID, Body: h.trimFormValue(c, "body")}
+	if err := h.validator.Struct(comment); err != nil {
+		return h.renderWorkflowPanel(c, post, "Comment can't be empty")
+	}
+	if err := h.db.Create(&comment).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to add comment")
+	}
+
+	return h.renderWorkflowPanel(c, post, "")
+}
+
+// AdminPostCommentResolve toggles a review comment's resolved flag
+func (h *BaseHandler) AdminPostCommentResolve(c echo.Context) error {
+	post, err := h.loadWorkflowPost(c)
+	if err != nil {
+		return err
+	}
+
+	commentID, err := h.parseUintParam(c, "commentId")
+	if err != nil {
+		return err
+	}
+
+	if err := h.db.Model(&models.ReviewComment{}).
+		Where("id = ? AND post_id = ?", commentID, post.ID).
+		Update("resolved", c.FormValue("resolved") == "true").Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to update comment")
+	}
+
+	return h.renderWorkflowPanel(c, post, "")
+}
+
+func (h *BaseHandler) loadWorkflowPost(c echo.Context) (*models.Post, error) {
+	id, err := h.parseUintParam(c, "id")
+	if err != nil {
+		return nil, err
+	}
+
+	var post models.Post
+	if err := h.db.Preload("Author").Preload("Reviewer").First(&post, id).Error; err != nil {
+		return nil, echo.NewHTTPError(http.StatusNotFound, "Post not found")
+	}
+	return &post, nil
+}
+
+// renderWorkflowPanel re-renders the status, reviewer and comments panel on the edit page
+func (h *BaseHandler) renderWorkflowPanel(c echo.Context, post *models.Post, errorMessage string) error {
+	var comments []models.ReviewComment
+	h.db.Preload("User").Where("post_id = ?", post.ID).Order("created_at asc").Find(&comments)
+
+	if post.ReviewerID != nil && (post.Reviewer == nil || post.Reviewer.ID != *post.ReviewerID) {
+		post.Reviewer = &models.User{}
+		h.db.First(post.Reviewer, *post.ReviewerID)
+	}
+
+	return h.render(c, templates.PostWorkflowPanel(post, comments, h.reviewerOptions(), c.Get("user").(*models.User), errorMessage))
+}
+
+// hasOtherAdmin reports whether an admin besides user could review their posts
+func (h *BaseHandler) hasOtherAdmin(user *models.User) bool {
+	var count int64
+	h.db.Model(&models.User{}).Where("role = ? AND id <> ?", models.RoleAdmin, user.ID).Count(&count)
+	return count > 0
+}
+
+// reviewerOptions lists the users who may review posts
+func (h *BaseHandler) reviewerOptions() []models.User {
+	var reviewers []models.User
+	h.db.Select("id", "name", "email").Where("role = ?", models.RoleAdmin).Order("name asc").Find(&reviewers)
+	return reviewers
+}
@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"mini-blog/app/models"
+	"mini-blog/app/templates"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// reviewTopGenres is how many genres a year review lists
+const reviewTopGenres = 5
+
+// YearReview shows the "Year in Review" for ?year= (default: the current year)
+func (h *BaseHandler) YearReview(c echo.Context) error {
+	user := h.GetCurrentUser(c)
+	loc := h.userLocation(c)
+	year := time.Now().In(loc).Year()
+	if requested, err := strconv.Atoi(c.QueryParam("year")); err == nil && requested > 1900 && requested <= year {
+		year = requested
+	}
+
+	review, err := h.buildYearReview(year, loc, h.hideAdult(c))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to build year in review")
+	}
+
+	page := templates.YearReviewPage(review, nil, user != nil && user.IsAdmin())
+	if h.isHTMXRequest(c) {
+		return h.render(c, page)
+	}
+	return h.render(c, templates.Layout("Year in Review", page, c.Request().URL.Path, user))
+}
+
+// YearReviewShare freezes a year's review into a public snapshot and returns its link
+func (h *BaseHandler) YearReviewShare(c echo.Context) error {
+	year, err := strconv.Atoi(c.Param("year"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid year")
+	}
+
+	// Snapshots are public, so they follow the visitor view of adult titles
+	review, err := h.buildYearReview(year, h.userLocation(c), h.adultMedia().Hide)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to build year in review")
+	}
+	data, err := json.Marshal(review)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to save snapshot")
+	}
+
+	token := make([]byte, 16)
+	rand.Read(token)
+	snapshot := models.YearReviewSnapshot{Year: year, Token: hex.EncodeToString(token), Data: string(data)}
+	if err := h.db.Create(&snapshot).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to save snapshot")
+	}
+
+	shareURL := strings.TrimSuffix(h.cfg.Server.BaseURL, "/") + "/tv/review/s/" + snapshot.Token
+	return h.render(c, templates.YearReviewShareLink(shareURL))
+}
+
+// YearReviewSnapshot renders a shared review exactly as it was when shared
+func (h *BaseHandler) YearReviewSnapshot(c echo.Context) error {
+	var snapshot models.YearReviewSnapshot
+	if err := h.db.Where("token = ?", c.Param("token")).First(&snapshot).Error; err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "Snapshot not found")
+	}
+
+	var review models.YearReview
+	if err := json.Unmarshal([]byte(snapshot.Data), &review); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Snapshot is unreadable")
+	}
+
+	// Share links are unlisted: reachable by anyone holding one, but kept out of search results
+	if h.crawlerRules().NoindexRestricted {
+		h.noindex(c)
+	}
+
+	page := templates.YearReviewPage(review, &snapshot.CreatedAt, false)
+	return h.render(c, templates.Layout("Year in Review "+strconv.Itoa(review.Year), page, c.Request().URL.Path, h.GetCurrentUser(c)))
+}
+
+// reviewEpisode is a watched episode with the show fields a review needs
+type reviewEpisode struct {
+	models.Episode
+	ShowTitle string
+	Genres    string
+}
+
+// buildYearReview summarizes the episodes watched during year in loc
+func (h *BaseHandler) buildYearReview(year int, loc *time.Location, hideAdult bool) (models.YearReview, error) {
+	review := models.YearReview{Year: year}
+	start := time.Date(year, time.January, 1, 0, 0, 0, 0, loc)
+	end := start.AddDate(1, 0, 0)
+
+	db := h.db
+	if hideAdult {
+		db = db.Scopes(models.HideAdultMedia).Session(&gorm.Session{})
+	}
+
+	var episodes []reviewEpisode
+	err := db.Table("episodes").
+		Select("episodes.*, media.title AS show_title, media.genres").
+		Joins("JOIN media ON media.tmdb_id = episodes.tmdb_id AND media.deleted_at IS NULL").
+		Where("episodes.deleted_at IS NULL AND episodes.watched = ? AND episodes.watched_at >= ? AND episodes.watched_at < ?", true, start, end).
+		Order("episodes.watched_at").
+		Scan(&episodes).Error
+	if err != nil {
+		return review, err
+	}
+
+	// A show counts as completed this year if it's completed and its last watch falls in the year
+	var completed int64
+	lastWatches := h.db.Model(&models.Episode{}).Select("tmdb_id").Where("watched = ?", true).
+		Group("tmdb_id").Having("MAX(watched_at) >= ? AND MAX(watched_at) < ?", start, end)
+	if err := db.Model(&models.Media{}).
+		Where("media.status = ? AND media.type = ? AND media.tmdb_id IN (?)", models.StatusCompleted, models.MediaTypeTV, lastWatches).
+		Count(&completed).Error; err != nil {
+		return review, err
+	}
+	review.ShowsCompleted = int(completed)
+
+	if len(episodes) == 0 {
+		return review, nil
+	}
+
+	months := make(map[time.Month]int)
+	genres := make(map[string]int)
+	showGenres := make(map[int][]string)
+	for _, episode := range episodes {
+		review.EpisodesWatched++
+		review.Minutes += episode.Runtime
+		months[episode.WatchedAt.In(loc).Month()]++
+
+		names, ok := showGenres[episode.TMDBID]
+		if !ok {
+			var parsed []struct {
+				Name string `json:"name"`
+			}
+			json.Unmarshal([]byte(episode.Genres), &parsed)
+			for _, genre := range parsed {
+				names = append(names, genre.Name)
+			}
+			showGenres[episode.TMDBID] = names
+		}
+		for _, name := range names {
+			genres[name]++
+		}
+	}
+
+	for month := time.January; month <= time.December; month++ {
+		if months[month] > review.BusiestCount {
+			review.BusiestMonth, review.BusiestCount = month, months[month]
+		}
+	}
+
+	for name, count := range genres {
+		review.TopGenres = append(review.TopGenres, models.GenreCount{Name: name, Count: count})
+	}
+	sort.Slice(review.TopGenres, func(i, j int) bool {
+		if review.TopGenres[i].Count != review.TopGenres[j].Count {
+			return review.TopGenres[i].Count > review.TopGenres[j].Count
+		}
+		return review.TopGenres[i].Name < review.TopGenres[j].Name
+	})
+	if len(review.TopGenres) > reviewTopGenres {
+		review.TopGenres = review.TopGenres[:reviewTopGenres]
+	}
+
+	review.FirstWatch = reviewWatch(episodes[0])
+	review.LastWatch = reviewWatch(episodes[len(episodes)-1])
+	return review, nil
+}
+
+func reviewWatch(episode reviewEpisode) *models.ReviewWatch {
+	return &models.ReviewWatch{
+		ShowTitle:     episode.ShowTitle,
+		SeasonNumber:  episode.SeasonNumber,
+		EpisodeNumber: episode.EpisodeNumber,
+		Name:          episode.Name,
+		WatchedAt:     *episode.WatchedAt,
+	}
+}
@@ -0,0 +1,145 @@
+package models
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// ArchiveVersion is bumped whenever the archive layout changes incompatibly
+const ArchiveVersion = 1
+
+// ArchiveManifest describes an application export; it is stored alongside one JSON file per table
+type ArchiveManifest struct {
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+	Passwords bool      `json:"passwords"` // whether user password hashes were included
+	Tables    []string  `json:"tables"`
+}
+
+// ArchiveRows is one table's rows keyed by column name, so the archive stays independent of the database engine
+type ArchiveRows []map[string]interface{}
+
+// archiveModels lists what an export covers, parents before children so an import satisfies foreign keys.
+// Email campaigns, sends and queued jobs are delivery history and are left out, as are watch history imports in progress,
+// API tokens' recent errors, API call counts, page and post views and users' activity logs.
+var archiveModels = []interface{}{
+	&User{}, &Setting{}, &Segment{}, &EmailPreference{}, &EmailSuppression{}, &Subscriber{}, &Coupon{}, &CouponRedemption{}, &Payment{}, &Webhook{}, &APIToken{}, &RevokedToken{},
+	&PostTemplate{}, &Upload{}, &Category{}, &Post{}, &PostTranslation{}, &PostNarration{}, &ReviewComment{}, &Comment{}, &Report{}, &ReadingProgress{}, &Reaction{}, &Tag{}, &PostTag{},
+	&Media{}, &Season{}, &Episode{}, &PostMedia{}, &YearReviewSnapshot{},
+}
+
+// ArchiveTables returns the table names covered by an export, in import order
+func ArchiveTables(db *gorm.DB) ([]string, error) {
+	tables := make([]string, 0, len(archiveModels))
+	for _, model := range archiveModels {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(model); err != nil {
+			return nil, err
+		}
+		tables = append(tables, stmt.Schema.Table)
+	}
+	return tables, nil
+}
+
+// ExportTable reads every row of a table, soft-deleted ones included.
+// One-time codes are never exported; password hashes only when includePasswords is set.
+func ExportTable(db *gorm.DB, table string, includePasswords bool) (ArchiveRows, error) {
+	// GORM only scans into the unnamed map slice type
+	var rows []map[string]interface{}
+	if err := db.Table(table).Order("id").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	if table == "users" {
+		for _, row := range rows {
+			delete(row, "otp")
+			delete(row, "otp_expiry")
+			if !includePasswords {
+				delete(row, "password")
+			}
+		}
+	}
+	return rows, nil
+}
+
+// IsFreshInstall reports whether nothing but the bootstrap admin exists yet, the only state an import may replace
+func IsFreshInstall(db *gorm.DB) bool {
+	var users, posts, media int64
+	db.Unscoped().Model(&User{}).Count(&users)
+	db.Unscoped().Model(&Post{}).Count(&posts)
+	db.Unscoped().Model(&Media{}).Count(&media)
+	return users <= 1 && posts == 0 && media == 0
+}
+
+// ImportArchive replaces the covered tables with the archived rows, keeping their IDs.
+// Tables missing from the archive are left empty; unknown tables are ignored.
+// importer keeps their current password if the archive has their email but no hash.
+func ImportArchive(db *gorm.DB, data map[string]ArchiveRows, importer *User) error {
+	tables, err := ArchiveTables(db)
+	if err != nil {
+		return err
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		// Children first, so the bootstrap admin and anything hanging off it can go
+		for i := len(tables) - 1; i >= 0; i-- {
+			if err := tx.Exec("DELETE FROM " + tx.Statement.Quote(tables[i])).Error; err != nil {
+				return err
+			}
+		}
+
+		for _, table := range tables {
+			rows := data[table]
+			if len(rows) == 0 {
+				continue
+			}
+			if table == "users" {
+				if err := fillMissingPasswords(rows, importer); err != nil {
+					return err
+				}
+			}
+			if err := tx.Table(table).CreateInBatches([]map[string]interface{}(rows), 200).Error; err != nil {
+				return fmt.Errorf("%s: %w", table, err)
+			}
+		}
+
+		// Rows were inserted with explicit IDs, so move each sequence past them
+		if tx.Dialector.Name() == "postgres" {
+			for _, table := range tables {
+				quoted := tx.Statement.Quote(table)
+				if err := tx.Exec("SELECT setval(pg_get_serial_sequence(?, 'id'), COALESCE((SELECT MAX(id) FROM "+quoted+"), 0) + 1, false)", table).Error; err != nil {
+					return fmt.Errorf("%s sequence: %w", table, err)
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// fillMissingPasswords gives users exported without a hash an unguessable one, so password sign-in stays
+// closed to them until they get a new password
+func fillMissingPasswords(rows ArchiveRows, importer *User) error {
+	for _, row := range rows {
+		if hash, ok := row["password"].(string); ok && hash != "" {
+			continue
+		}
+		if email, _ := row["email"].(string); importer != nil && email == importer.Email {
+			row["password"] = importer.Password
+			continue
+		}
+		buf := make([]byte, 16)
+		if _, err := rand.Read(buf); err != nil {
+			return err
+		}
+		hash, err := bcrypt.GenerateFromPassword([]byte(hex.EncodeToString(buf)), bcrypt.DefaultCost)
+		if err != nil {
+			return err
+		}
+		row["password"] = string(hash)
+	}
+	return nil
+}
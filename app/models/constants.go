@@ -14,12 +14,142 @@ const (
 	VisibilityAdmin   = "admin"
 )
 
+// Post workflow states
+const (
+	PostStatusDraft     = "draft"
+	PostStatusInReview  = "in_review"
+	PostStatusApproved  = "approved"
+	PostStatusPublished = "published"
+)
+
+// Narration states
+const (
+	NarrationPending = "pending"
+	NarrationReady   = "ready"
+	NarrationFailed  = "failed"
+)
+
+// Email campaign kinds
+const (
+	CampaignNewsletter   = "newsletter"
+	CampaignAnnouncement = "announcement"
+	CampaignNewPost      = "new_post" // sent to subscribers when a post is first published
+)
+
+// Announcement audiences
+const (
+	AudienceAll     = "all"
+	AudiencePremium = "premium"
+	AudienceAdmins  = "admins"
+)
+
+// Segment filters
+const (
+	SegmentVerified   = "yes"
+	SegmentUnverified = "no"
+
+	ActivityActive      = "active"
+	ActivityInactive    = "inactive"
+	DefaultActivityDays = 30
+)
+
+// Email kinds, used by the queue to honour recipient preferences
+const (
+	EmailKindTransactional = "transactional" // OTPs and account mail, always sent
+	EmailKindNewsletter    = "newsletter"
+	EmailKindAnnouncement  = "announcement" // shares the newsletter opt-out
+	EmailKindEpisodeAlert  = "episode_alert"
+	EmailKindDigest        = "digest"
+)
+
+// Email queue job states
+const (
+	EmailJobQueued  = "queued"
+	EmailJobSent    = "sent"
+	EmailJobFailed  = "failed"
+	EmailJobSkipped = "skipped"
+)
+
+// Why an address is on the suppression list
+const (
+	SuppressionBounce    = "bounce"
+	SuppressionComplaint = "complaint"
+)
+
+// Outgoing webhook delivery states
+const (
+	WebhookQueued    = "queued"
+	WebhookDelivered = "delivered"
+	WebhookFailed    = "failed"
+)
+
+// Site events, sent to the admin notification center and outgoing webhooks
+const (
+	EventPaymentSucceeded    = "payment.succeeded"
+	EventPaymentFailed       = "payment.failed"
+	EventSubscriptionCreated = "subscription.created" // premium started (e.g. a coupon trial)
+	EventSubscriptionEnded   = "subscription.ended"   // premium lapsed when a trial ran out
+	EventTMDBQuota           = "tmdb.quota"           // TMDB calls neared the hourly or daily budget
+	EventContentHidden       = "content.hidden"       // a post or comment was hidden after reports
+	EventUserBanned          = "user.banned"
+	EventUserUnbanned        = "user.unbanned"
+)
+
+// What a user's activity log records
+const (
+	ActivitySignIn         = "sign_in"
+	ActivityPostRead       = "post.read"
+	ActivityEpisodeWatched = "episode.watched"
+	ActivityComment        = "comment"
+	ActivityOTPFailed      = "otp.failed"
+	ActivitySupport        = "support" // an admin reset, verified or signed out the account
+)
+
+// Content report reasons
+const (
+	ReportSpam       = "spam"
+	ReportHarassment = "harassment"
+	ReportOffTopic   = "off_topic"
+	ReportOther      = "other"
+)
+
+// Content report states
+const (
+	ReportOpen      = "open"
+	ReportResolved  = "resolved"  // upheld: the content stays hidden
+	ReportDismissed = "dismissed" // rejected: the content is shown again
+)
+
+// Analytics modes
+const (
+	AnalyticsOn      = "on"      // count every visitor who doesn't send Do Not Track
+	AnalyticsConsent = "consent" // count only visitors who accepted the consent banner
+	AnalyticsOff     = "off"
+)
+
+// ReactionLike is the only reaction so far
+const ReactionLike = "like"
+
 // Media types
 const (
 	MediaTypeTV    = "tv"
 	MediaTypeMovie = "movie"
 )
 
+// Sources of a show's seasons and episodes
+const (
+	ProviderTMDB = "tmdb"
+	ProviderTVDB = "tvdb"
+)
+
+// How often background sync refreshes a title; SyncScheduleAuto syncs watching and planned titles every 48 hours
+const (
+	SyncScheduleAuto   = "auto"
+	SyncScheduleDaily  = "daily"
+	SyncScheduleWeekly = "weekly"
+	SyncScheduleNever  = "never"
+)
+
 // Media tracking statuses
 const (
 	StatusWatching  = "watching"
@@ -28,6 +158,90 @@ const (
 	StatusDropped   = "dropped"
 )
 
+// Watch history import item statuses
+const (
+	ImportPending  = "pending"  // waiting for its TMDB search
+	ImportReview   = "review"   // ambiguous or unmatched, an admin picks the title
+	ImportMatched  = "matched"  // matched to a title, waiting to be applied
+	ImportQueued   = "queued"   // being added to the library and marked watched
+	ImportImported = "imported" // done
+	ImportSkipped  = "skipped"  // left out by an admin
+	ImportFailed   = "failed"
+)
+
+// CertificationNC17 is the adults-only film rating
+const CertificationNC17 = "NC-17"
+
+// Library grid sort keys
+const (
+	SortRecent       = "recent" // latest watch activity first
+	SortRating       = "rating" // manual rating
+	SortEpisodeScore = "score"  // score derived from episode ratings
+)
+
+// When the tracker marks a show completed
+const (
+	CompleteWhenAired = "aired" // every aired episode watched
+	CompleteWhenEnded = "ended" // every aired episode watched and the series has ended
+	CompleteManually  = "off"   // only when set by hand
+)
+
+// Homepage sections an admin can arrange
+const (
+	HomeSectionAnnouncement = "announcement"
+	HomeSectionPinned       = "pinned"
+	HomeSectionLatest       = "latest"
+	HomeSectionWatching     = "watching"
+)
+
+// What / serves
+const (
+	LandingBlog    = "blog"
+	LandingTracker = "tracker"
+	LandingPage    = "page"
+)
+
+// Keys of site-wide settings stored in the database
+const (
+	SettingHomeLayout  = "home_layout"
+	SettingLanding     = "landing"
+	SettingSignupRoles = "signup_roles"
+	SettingMilestones  = "milestone_posts"
+	SettingCrawlers    = "crawlers"
+	SettingAdultMedia  = "adult_media"
+	// Date (YYYY-MM-DD) of the last Telegram new-episode alert
+	SettingTelegramAlerts = "telegram_alerts_sent_on"
+	// Hour and day of the last TMDB budget alerts, so each budget warns once per period
+	SettingTMDBQuotaAlerts = "tmdb_quota_alerted"
+	// Names of the data migrations already applied, see RunMigrations
+	SettingMigrations = "migrations"
+)
+
+// Supported UI locales
+const (
+	LocaleEnglish = "en"
+	LocaleSpanish = "es"
+)
+
+// Date format preferences
+const (
+	DateFormatUS  = "us"
+	DateFormatEU  = "eu"
+	DateFormatISO = "iso"
+)
+
+// UI themes
+const (
+	ThemeLight = "light"
+	ThemeDark  = "dark"
+)
+
+// Reading progress thresholds (percent of a post scrolled)
+const (
+	ReadingStartedPercent  = 5
+	ReadingFinishedPercent = 90
+)
+
 // Validation maps
 var (
 	ValidRoles = map[string]bool{
@@ -42,6 +256,21 @@ var (
 		VisibilityAdmin:   true,
 	}
 
+	PostStatusNames = map[string]string{
+		PostStatusDraft:     "Draft",
+		PostStatusInReview:  "In Review",
+		PostStatusApproved:  "Approved",
+		PostStatusPublished: "Published",
+	}
+
+	// PostTransitions lists the workflow moves allowed from each state
+	PostTransitions = map[string][]string{
+		PostStatusDraft:     {PostStatusInReview},
+		PostStatusInReview:  {PostStatusApproved, PostStatusDraft},
+		PostStatusApproved:  {PostStatusPublished, PostStatusDraft},
+		PostStatusPublished: {PostStatusDraft},
+	}
+
 	ValidMediaTypes = map[string]bool{
 		MediaTypeTV:    true,
 		MediaTypeMovie: true,
@@ -54,11 +283,126 @@ var (
 		StatusDropped:   true,
 	}
 
+	ProviderNames = map[string]string{
+		ProviderTMDB: "TMDB",
+		ProviderTVDB: "TVDB",
+	}
+
+	SyncScheduleNames = map[string]string{
+		SyncScheduleAuto:   "Every 2 days while watching or planned",
+		SyncScheduleDaily:  "Daily",
+		SyncScheduleWeekly: "Weekly",
+		SyncScheduleNever:  "Never",
+	}
+
+	SupportedLocales = []string{LocaleEnglish, LocaleSpanish}
+
+	ValidLocales = map[string]bool{
+		LocaleEnglish: true,
+		LocaleSpanish: true,
+	}
+
+	LocaleNames = map[string]string{
+		LocaleEnglish: "English",
+		LocaleSpanish: "Español",
+	}
+
+	ValidThemes = map[string]bool{
+		ThemeLight: true,
+		ThemeDark:  true,
+	}
+
+	DateFormatNames = map[string]string{
+		DateFormatUS:  "January 2, 2006",
+		DateFormatEU:  "2 January 2006",
+		DateFormatISO: "2006-01-02",
+	}
+
+	// Timezones offered on the settings page (any IANA name is accepted)
+	CommonTimezones = []string{
+		"UTC",
+		"America/Los_Angeles",
+		"America/Denver",
+		"America/Chicago",
+		"America/New_York",
+		"America/Sao_Paulo",
+		"Europe/London",
+		"Europe/Berlin",
+		"Europe/Madrid",
+		"Asia/Kolkata",
+		"Asia/Singapore",
+		"Asia/Tokyo",
+		"Australia/Sydney",
+	}
+
 	RoleNames = map[string]string{
 		RoleAdmin:   "Admin",
 		RolePremium: "Premium",
 		RoleUser:    "User",
 	}
+
+	// Library grid filters, in display order
+	LibraryFilters = []string{"tv", "movie", "anime-tv", "anime-movie"}
+
+	LibraryFilterNames = map[string]string{
+		"tv":          "TV Shows",
+		"movie":       "Movies",
+		"anime-tv":    "Anime TV",
+		"anime-movie": "Anime Movies",
+	}
+
+	LibrarySortNames = map[string]string{
+		SortRecent:       "Recently watched",
+		SortRating:       "My rating",
+		SortEpisodeScore: "Episode score",
+	}
+
+	CompleteRuleNames = map[string]string{
+		CompleteWhenAired: "When all aired episodes are watched",
+		CompleteWhenEnded: "Only once the series has ended",
+		CompleteManually:  "Never automatically",
+	}
+
+	HomeSections = []string{HomeSectionAnnouncement, HomeSectionPinned, HomeSectionLatest, HomeSectionWatching}
+
+	HomeSectionNames = map[string]string{
+		HomeSectionAnnouncement: "Announcement",
+		HomeSectionPinned:       "Pinned post",
+		HomeSectionLatest:       "Latest posts",
+		HomeSectionWatching:     "Currently watching",
+	}
+
+	// PreviewLinkDays are how long a draft's preview link can last; the first is the default
+	PreviewLinkDays = []int{7, 1, 30}
+
+	LandingNames = map[string]string{
+		LandingBlog:    "Blog home",
+		LandingTracker: "TV tracker",
+		LandingPage:    "Custom page",
+	}
+
+	Events = []string{EventPaymentSucceeded, EventPaymentFailed, EventSubscriptionCreated, EventSubscriptionEnded, EventTMDBQuota, EventContentHidden, EventUserBanned, EventUserUnbanned}
+
+	EventNames = map[string]string{
+		EventPaymentSucceeded:    "Tip received",
+		EventPaymentFailed:       "Payment failed",
+		EventSubscriptionCreated: "Premium started",
+		EventSubscriptionEnded:   "Premium ended",
+		EventTMDBQuota:           "TMDB budget nearly spent",
+		EventContentHidden:       "Reported content hidden",
+		EventUserBanned:          "User banned",
+		EventUserUnbanned:        "User unbanned",
+	}
+
+	ReportReasons = []string{ReportSpam, ReportHarassment, ReportOffTopic, ReportOther}
+
+	Audiences = []string{AudienceAll, AudiencePremium, AudienceAdmins}
+
+	AudienceNames = map[string]string{
+		AudienceAll:     "All verified users",
+		AudiencePremium: "Premium members",
+		AudienceAdmins:  "Admins",
+	}
 )
 
 // Validation functions
@@ -66,4 +410,27 @@ func IsValidRole(role string) bool      { return ValidRoles[role] }
 func IsValidVisibility(vis string) bool { return ValidVisibilities[vis] }
 func IsValidMediaType(mt string) bool   { return ValidMediaTypes[mt] }
 func IsValidStatus(status string) bool  { return ValidStatuses[status] }
+func IsValidProvider(p string) bool     { _, ok := ProviderNames[p]; return ok }
+func IsValidSyncSchedule(s string) bool { _, ok := SyncScheduleNames[s]; return ok }
+func IsValidLocale(locale string) bool  { return ValidLocales[locale] }
+func IsValidTheme(theme string) bool    { return ValidThemes[theme] }
+func IsValidDateFormat(f string) bool   { _, ok := DateFormatNames[f]; return ok }
+func IsValidAudience(a string) bool     { _, ok := AudienceNames[a]; return ok }
+func IsValidCompleteRule(r string) bool { _, ok := CompleteRuleNames[r]; return ok }
+func IsValidLibrarySort(s string) bool  { _, ok := LibrarySortNames[s]; return ok }
+func IsValidFilter(f string) bool       { _, ok := LibraryFilterNames[f]; return ok }
+func IsValidSection(s string) bool      { _, ok := HomeSectionNames[s]; return ok }
+func IsValidLanding(l string) bool      { _, ok := LandingNames[l]; return ok }
+func IsValidEvent(e string) bool        { _, ok := EventNames[e]; return ok }
 func GetRoleName(role string) string    { return RoleNames[role] }
+func GetPostStatusName(s string) string { return PostStatusNames[s] }
+
+// CanTransitionPost reports whether the workflow allows moving from one state to another
+func CanTransitionPost(from, to string) bool {
+	for _, next := range PostTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"mini-blog/app/config"
+	"slices"
 
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/driver/postgres"
@@ -11,9 +12,8 @@ import (
 	"gorm.io/gorm/logger"
 )
 
-var DB *gorm.DB
-
-func ConnectDB(cfg *config.Config) {
+// ConnectDB opens the database for one site; every hosted site has its own
+func ConnectDB(cfg *config.Config) *gorm.DB {
 	dsn := fmt.Sprintf("host=%s user=%s dbname=%s port=%s sslmode=%s",
 		cfg.DB.Host, cfg.DB.User, cfg.DB.Name, cfg.DB.Port, cfg.DB.SSLMode)
 
@@ -21,8 +21,7 @@ func ConnectDB(cfg *config.Config) {
 		dsn += fmt.Sprintf(" password=%s", cfg.DB.Password)
 	}
 
-	var err error
-	DB, err = gorm.Open(postgres.Open(dsn), &gorm.Config{
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{
 		Logger: logger.Default.LogMode(logger.Silent),
 	})
 
@@ -30,19 +29,76 @@ func ConnectDB(cfg *config.Config) {
 		log.Fatal("Failed to connect to database:", err)
 	}
 
-	log.Println("Connected to database")
+	log.Printf("Connected to database %s", cfg.DB.Name)
+	return db
 }
 
-func RunMigrations() {
-	if err := DB.AutoMigrate(&User{}, &Post{}, &Media{}, &Episode{}, &Season{}); err != nil {
+func RunMigrations(db *gorm.DB) {
+	if err := db.SetupJoinTable(&Post{}, "Tags", &PostTag{}); err != nil {
+		log.Fatalf("Failed to set up post tags: %v", err)
+	}
+	if err := db.AutoMigrate(&User{}, &Post{}, &PostTranslation{}, &ReadingProgress{}, &ReviewComment{}, &PostTemplate{}, &Upload{}, &PostNarration{}, &EmailCampaign{}, &EmailSend{}, &EmailClick{}, &EmailJob{}, &EmailPreference{}, &Segment{}, &Media{}, &Episode{}, &Season{}, &YearReviewSnapshot{}, &Setting{}, &PostMedia{}, &Coupon{}, &CouponRedemption{}, &Payment{}, &Notification{}, &Webhook{}, &WebhookDelivery{}, &APIToken{}, &Comment{}, &WatchImportItem{}, &Tag{}, &PostTag{}, &Category{}, &APITokenError{}, &APICallHour{}, &Reaction{}, &Subscriber{}, &PageView{}, &RevokedToken{}, &Report{}, &PostView{}, &EmailSuppression{}, &Activity{}); err != nil {
 		log.Fatalf("Failed to run migrations: %v", err)
 	}
+
+	if err := runDataMigrations(db); err != nil {
+		log.Fatalf("Failed to run data migrations: %v", err)
+	}
 	log.Println("Database migrations completed successfully")
 }
 
-func CreateInitialAdmin(cfg *config.Config) {
+// dataMigrations backfill rows for columns added after data existed. Each runs once per database, in order,
+// and is recorded under SettingMigrations; append new ones and never rename old ones.
+var dataMigrations = []struct {
+	name string
+	run  func(db *gorm.DB) error
+}{
+	// Posts published before workflow states existed start out as published
+	{"post_status", func(db *gorm.DB) error {
+		return db.Model(&Post{}).Where("published = ? AND status = ?", true, PostStatusDraft).Update("status", PostStatusPublished).Error
+	}},
+	// Season watched counts and episode scores are denormalized; later on, integrity repair recomputes the counts and each sync a show's scores
+	{"season_counts", func(db *gorm.DB) error {
+		return db.Exec(seasonCountsSQL).Error
+	}},
+	{"episode_scores", func(db *gorm.DB) error {
+		return db.Exec(episodeScoresSQL + " WHERE media.type = 'tv'").Error
+	}},
+	// Anime flags set by hand before detection existed are kept as overrides
+	{"anime_manual", func(db *gorm.DB) error {
+		return db.Model(&Media{}).Where("is_anime = ? AND anime_detected = ? AND anime_manual = ?", true, false, false).Update("anime_manual", true).Error
+	}},
+	// Campaign sends recorded before the email queue existed were sent directly
+	{"email_send_status", func(db *gorm.DB) error {
+		return db.Model(&EmailSend{}).Where("status = ? AND error <> ''", EmailJobSent).Update("status", EmailJobFailed).Error
+	}},
+}
+
+// runDataMigrations applies the data migrations this database hasn't had yet
+func runDataMigrations(db *gorm.DB) error {
+	var applied []string
+	if err := LoadSetting(db, SettingMigrations, &applied); err != nil {
+		return err
+	}
+	for _, migration := range dataMigrations {
+		if slices.Contains(applied, migration.name) {
+			continue
+		}
+		if err := migration.run(db); err != nil {
+			return fmt.Errorf("%s: %w", migration.name, err)
+		}
+		applied = append(applied, migration.name)
+		if err := SaveSetting(db, SettingMigrations, applied); err != nil {
+			return err
+		}
+		log.Printf("Applied data migration %s", migration.name)
+	}
+	return nil
+}
+
+func CreateInitialAdmin(db *gorm.DB, cfg *config.Config) {
 	var count int64
-	DB.Model(&User{}).Count(&count)
+	db.Model(&User{}).Count(&count)
 
 	// Create admin user if no users exist
 	if count == 0 && cfg.Auth.AdminEmail != "" {
@@ -56,7 +112,7 @@ func CreateInitialAdmin(cfg *config.Config) {
 			Role:       RoleAdmin,
 		}
 
-		if err := DB.Create(&admin).Error; err != nil {
+		if err := db.Create(&admin).Error; err != nil {
 			log.Printf("Failed to create admin user: %v", err)
 		} else {
 			log.Printf("Admin user created: %s (password: admin123)", cfg.Auth.AdminEmail)
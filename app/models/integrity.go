@@ -0,0 +1,102 @@
+package models
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// IntegrityReport lists TV library rows that disagree with each other
+type IntegrityReport struct {
+	OrphanedSeasons  int64
+	OrphanedEpisodes int64
+	// Shows whose TotalEpisodes differs from their stored episodes (specials excluded)
+	TotalMismatches []IntegrityMismatch
+	// Shows whose Progress differs from their watched episodes
+	ProgressMismatches []IntegrityMismatch
+}
+
+// IntegrityMismatch is one show's stored count next to the count its episode rows give
+type IntegrityMismatch struct {
+	TMDBID int
+	Title  string
+	Stored int
+	Actual int
+}
+
+// Problems is how many rows a repair would touch
+func (r IntegrityReport) Problems() int {
+	return int(r.OrphanedSeasons+r.OrphanedEpisodes) + len(r.TotalMismatches) + len(r.ProgressMismatches)
+}
+
+// Seasons and episodes belong to a live show through tmdb_id; soft-deleted shows leave them behind
+const orphanedRowsSQL = `deleted_at IS NULL AND NOT EXISTS (
+	SELECT 1 FROM media WHERE media.tmdb_id = %s.tmdb_id AND media.type = 'tv' AND media.deleted_at IS NULL
+)`
+
+const (
+	actualTotalSQL = `(SELECT COUNT(*) FROM episodes
+	WHERE episodes.tmdb_id = media.tmdb_id AND episodes.season_number > 0 AND episodes.deleted_at IS NULL)`
+	actualProgressSQL = `(SELECT COUNT(*) FROM episodes
+	WHERE episodes.tmdb_id = media.tmdb_id AND episodes.watched = true AND episodes.deleted_at IS NULL)`
+)
+
+// CheckLibraryIntegrity finds orphaned seasons and episodes and shows whose denormalized counts have drifted
+func CheckLibraryIntegrity(db *gorm.DB) (IntegrityReport, error) {
+	var report IntegrityReport
+	if err := db.Model(&Season{}).Where(orphanedSQL("seasons")).Count(&report.OrphanedSeasons).Error; err != nil {
+		return report, err
+	}
+	if err := db.Model(&Episode{}).Where(orphanedSQL("episodes")).Count(&report.OrphanedEpisodes).Error; err != nil {
+		return report, err
+	}
+
+	var err error
+	if report.TotalMismatches, err = countMismatches(db, "total_episodes", actualTotalSQL); err != nil {
+		return report, err
+	}
+	if report.ProgressMismatches, err = countMismatches(db, "progress", actualProgressSQL); err != nil {
+		return report, err
+	}
+	return report, nil
+}
+
+// RepairLibraryIntegrity deletes orphaned rows and recounts drifted shows, returning what it found beforehand
+func RepairLibraryIntegrity(db *gorm.DB) (IntegrityReport, error) {
+	report, err := CheckLibraryIntegrity(db)
+	if err != nil || report.Problems() == 0 {
+		return report, err
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where(orphanedSQL("episodes")).Delete(&Episode{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Where(orphanedSQL("seasons")).Delete(&Season{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Exec("UPDATE media SET total_episodes = " + actualTotalSQL + " WHERE media.type = 'tv' AND media.deleted_at IS NULL").Error; err != nil {
+			return err
+		}
+		if err := tx.Exec("UPDATE media SET progress = " + actualProgressSQL + " WHERE media.type = 'tv' AND media.deleted_at IS NULL").Error; err != nil {
+			return err
+		}
+		return tx.Exec(seasonCountsSQL).Error
+	})
+	return report, err
+}
+
+func orphanedSQL(table string) string {
+	return fmt.Sprintf(orphanedRowsSQL, table)
+}
+
+// countMismatches lists TV shows whose column disagrees with the count actualSQL gives
+func countMismatches(db *gorm.DB, column, actualSQL string) ([]IntegrityMismatch, error) {
+	var mismatches []IntegrityMismatch
+	err := db.Model(&Media{}).
+		Select("tmdb_id, title, "+column+" AS stored, "+actualSQL+" AS actual").
+		Where("type = ? AND "+column+" <> "+actualSQL, MediaTypeTV).
+		Order("title").
+		Scan(&mismatches).Error
+	return mismatches, err
+}
@@ -0,0 +1,68 @@
+package models_test
+
+import (
+	"mini-blog/app/models"
+	"mini-blog/app/testdb"
+	"testing"
+)
+
+func TestRepairLibraryIntegrity(t *testing.T) {
+	db := testdb.Open(t)
+	show := testdb.Show(t, db, 2, 3)
+	gone := testdb.Show(t, db, 1, 2)
+
+	// A deleted show leaves its rows behind, and a drifted count on the one that stays
+	db.Delete(gone)
+	db.Model(&models.Episode{}).Where("tmdb_id = ? AND season_number = 1", show.TMDBID).Update("watched", true)
+	db.Model(show).Updates(map[string]interface{}{"total_episodes": 10, "progress": 0})
+
+	report, err := models.CheckLibraryIntegrity(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.OrphanedSeasons != 1 || report.OrphanedEpisodes != 2 {
+		t.Errorf("orphans = %d seasons, %d episodes; want 1, 2", report.OrphanedSeasons, report.OrphanedEpisodes)
+	}
+	if len(report.TotalMismatches) != 1 || report.TotalMismatches[0].Actual != 6 {
+		t.Errorf("total mismatches = %+v; want one with 6 episodes", report.TotalMismatches)
+	}
+	if len(report.ProgressMismatches) != 1 || report.ProgressMismatches[0].Actual != 3 {
+		t.Errorf("progress mismatches = %+v; want one with 3 watched", report.ProgressMismatches)
+	}
+
+	if _, err := models.RepairLibraryIntegrity(db); err != nil {
+		t.Fatal(err)
+	}
+	if report, _ := models.CheckLibraryIntegrity(db); report.Problems() != 0 {
+		t.Errorf("after repair: %+v", report)
+	}
+	var repaired models.Media
+	db.First(&repaired, show.ID)
+	if repaired.TotalEpisodes != 6 || repaired.Progress != 3 {
+		t.Errorf("repaired show has %d/%d episodes; want 3/6", repaired.Progress, repaired.TotalEpisodes)
+	}
+	var season models.Season
+	db.Where("tmdb_id = ? AND season_number = 1", show.TMDBID).First(&season)
+	if season.WatchedCount != 3 {
+		t.Errorf("season 1 watched count = %d; want 3", season.WatchedCount)
+	}
+}
+
+func TestDataMigrationsRunOnce(t *testing.T) {
+	db := testdb.Open(t)
+	show := testdb.Show(t, db, 1, 3)
+	db.Model(&models.Media{}).Where("id = ?", show.ID).Updates(map[string]interface{}{"is_anime": true, "anime_detected": false, "anime_manual": false})
+
+	// testdb.Open already migrated, so booting again must leave rows changed since alone
+	models.RunMigrations(db)
+	var media models.Media
+	db.First(&media, show.ID)
+	if media.AnimeManual {
+		t.Error("the anime backfill ran again on a migrated database")
+	}
+
+	var applied []string
+	if err := models.LoadSetting(db, models.SettingMigrations, &applied); err != nil || len(applied) == 0 {
+		t.Errorf("applied migrations = %v, %v; want them recorded", applied, err)
+	}
+}
@@ -1,6 +1,11 @@
 package models
 
 import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
 	"time"
 
 	"gorm.io/gorm"
@@ -16,17 +21,253 @@ type BaseModel struct {
 
 type Post struct {
 	BaseModel
-	Title      string `json:"title" gorm:"not null" validate:"required,min=1,max=255"`
-	Content    string `json:"content" gorm:"type:text" validate:"required,min=1"`
-	Slug       string `json:"slug" gorm:"unique;not null" validate:"required,min=1,max=255"`
-	Published  bool   `json:"published" gorm:"default:false"`
+	Title      string     `json:"title" gorm:"not null" validate:"required,min=1,max=255"`
+	Content    string     `json:"content" gorm:"type:text" validate:"required,min=1"`
+	Slug       string     `json:"slug" gorm:"unique;not null" validate:"required,min=1,max=255"`
+	Published  bool       `json:"published" gorm:"default:false"`
+	Visibility string     `json:"visibility" gorm:"default:public" validate:"required,oneof=public premium admin"`
+	PublishAt  *time.Time `json:"publish_at" gorm:"index"` // scheduled publish time for approved posts
+	ExpiresAt  *time.Time `json:"expires_at" gorm:"index"` // unpublished automatically once passed, for time-limited posts
+	Status     string     `json:"status" gorm:"size:16;default:draft;index"`
+	Version    int        `json:"version" gorm:"not null;default:1"` // bumped on every edit for optimistic locking
+	AuthorID   *uint      `json:"author_id"`
+	ReviewerID *uint      `json:"reviewer_id"`
+	CategoryID *uint      `json:"category_id" gorm:"index"`
+	Author     *User      `json:"author,omitempty"`
+	Reviewer   *User      `json:"reviewer,omitempty"`
+	Category   *Category  `json:"category,omitempty"`
+
+	// Link previews: MetaDescription falls back to the opening text, OGImage to no image
+	MetaDescription string `json:"meta_description" gorm:"size:300" validate:"max=300"`
+	OGImage         string `json:"og_image" gorm:"size:500" validate:"max=500"`
+
+	// Excerpt stands in for the opening text on cards and in feeds; CoverImage heads the card and the post
+	Excerpt    string `json:"excerpt" gorm:"size:500" validate:"max=500"`
+	CoverImage string `json:"cover_image" gorm:"size:500" validate:"max=500"`
+
+	// Hidden takes the post down after reports, whatever its status; only admins still see it
+	Hidden bool `json:"hidden" gorm:"default:false;index"`
+
+	// Pinned posts are featured above the latest posts on the homepage, newest first
+	Pinned bool `json:"pinned" gorm:"default:false;index"`
+
+	Translations []PostTranslation `json:"translations,omitempty"`
+	Narration    *PostNarration    `json:"narration,omitempty"`
+	Tags         []Tag             `json:"tags,omitempty" gorm:"many2many:post_tags"`
+
+	// Finished is set per viewer from their reading progress; not persisted
+	Finished bool `json:"finished,omitempty" gorm:"-"`
+	// LinkedMedia is attached from PostMedia when a post is shown; not persisted
+	LinkedMedia []Media `json:"linked_media,omitempty" gorm:"-"`
+	// LikeCount is filled from reactions when a post is listed or shown, Liked per viewer; not persisted
+	LikeCount int  `json:"like_count,omitempty" gorm:"->;-:migration"`
+	Liked     bool `json:"liked,omitempty" gorm:"-"`
+	// ViewCount is filled from PostView totals on the admin dashboard; not persisted
+	ViewCount int64 `json:"view_count,omitempty" gorm:"->;-:migration"`
+}
+
+// Reaction is a like on a post from a signed-in user, or from an anonymous visitor identified
+// by a hash of their visitor cookie (UserID 0). One per post and reactor.
+type Reaction struct {
+	ID          uint      `json:"id" gorm:"primaryKey"`
+	PostID      uint      `json:"post_id" gorm:"not null;uniqueIndex:idx_reaction"`
+	UserID      uint      `json:"user_id" gorm:"not null;default:0;uniqueIndex:idx_reaction"`
+	VisitorHash string    `json:"-" gorm:"size:64;not null;default:'';uniqueIndex:idx_reaction"`
+	Kind        string    `json:"kind" gorm:"size:16;not null;default:like;uniqueIndex:idx_reaction"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Category files a post under one section of the blog, listed at /category/:slug
+type Category struct {
+	BaseModel
+	Name        string `json:"name" gorm:"not null" validate:"required,max=50"`
+	Slug        string `json:"slug" gorm:"uniqueIndex;not null" validate:"required,max=60"`
+	Description string `json:"description" gorm:"type:text" validate:"max=500"`
+
+	// PostCount and PublishedCount are filled by the dashboard query; not persisted
+	PostCount      int `json:"post_count,omitempty" gorm:"->;-:migration"`
+	PublishedCount int `json:"published_count,omitempty" gorm:"->;-:migration"`
+}
+
+// Tag groups posts by topic; /posts?tag= filters by its slug
+type Tag struct {
+	BaseModel
+	Name string `json:"name" gorm:"not null" validate:"required,max=50"`
+	Slug string `json:"slug" gorm:"uniqueIndex;not null"`
+
+	// PostCount is filled by the tags index query; not persisted
+	PostCount int `json:"post_count,omitempty" gorm:"->;-:migration"`
+}
+
+// PostTag is the post_tags join table of Post.Tags; its own ID keeps it exportable like other tables
+type PostTag struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	PostID    uint      `json:"post_id" gorm:"uniqueIndex:idx_post_tag;not null"`
+	TagID     uint      `json:"tag_id" gorm:"uniqueIndex:idx_post_tag;index;not null"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// TagNames lists a post's tags as typed in the post form, e.g. "go, htmx"
+func (p *Post) TagNames() string {
+	names := make([]string, 0, len(p.Tags))
+	for _, tag := range p.Tags {
+		names = append(names, tag.Name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// PostTranslation holds a localized title and body for a post
+type PostTranslation struct {
+	BaseModel
+	PostID  uint   `json:"post_id" gorm:"uniqueIndex:idx_post_locale;not null"`
+	Locale  string `json:"locale" gorm:"uniqueIndex:idx_post_locale;size:8;not null" validate:"required"`
+	Title   string `json:"title" gorm:"not null" validate:"required,min=1,max=255"`
+	Content string `json:"content" gorm:"type:text" validate:"required,min=1"`
+}
+
+// PostNarration is the generated audio version of a post
+type PostNarration struct {
+	BaseModel
+	PostID     uint       `json:"post_id" gorm:"uniqueIndex;not null"`
+	Status     string     `json:"status" gorm:"size:16;default:pending"`
+	StorageKey string     `json:"-"`
+	URL        string     `json:"url"`
+	Size       int64      `json:"size"`
+	Error      string     `json:"error,omitempty"`
+	SourceHash string     `json:"-"` // hash of the narrated text, to spot stale audio
+	ReadyAt    *time.Time `json:"ready_at"`
+
+	// Stale is set when the post's text no longer matches SourceHash; not persisted
+	Stale bool `json:"stale,omitempty" gorm:"-"`
+}
+
+// PostTemplate is a reusable post skeleton; Title and Content may contain placeholders like {{date}}
+type PostTemplate struct {
+	BaseModel
+	Name       string `json:"name" gorm:"unique;not null" validate:"required,min=1,max=100"`
+	Title      string `json:"title"`
+	Content    string `json:"content" gorm:"type:text"`
 	Visibility string `json:"visibility" gorm:"default:public" validate:"required,oneof=public premium admin"`
 }
 
+// Upload is a file stored through the storage layer (images for posts)
+type Upload struct {
+	BaseModel
+	Key          string `json:"key" gorm:"uniqueIndex;not null"`
+	Filename     string `json:"filename" gorm:"not null"`
+	ContentType  string `json:"content_type"`
+	Size         int64  `json:"size"`
+	URL          string `json:"url" gorm:"not null"`
+	UploadedByID *uint  `json:"uploaded_by_id"`
+}
+
+// Markdown is the image embed to paste into a post
+func (u Upload) Markdown() string {
+	return fmt.Sprintf("![%s](%s)", u.Filename, u.URL)
+}
+
+// ReviewComment is an inline editorial note left on a post before it is published
+type ReviewComment struct {
+	BaseModel
+	PostID   uint   `json:"post_id" gorm:"index;not null"`
+	UserID   uint   `json:"user_id" gorm:"not null"`
+	User     User   `json:"user"`
+	Body     string `json:"body" gorm:"type:text;not null" validate:"required,min=1"`
+	Resolved bool   `json:"resolved" gorm:"default:false"`
+}
+
+// Comment is a reader's comment under a published post
+type Comment struct {
+	BaseModel
+	PostID uint   `json:"post_id" gorm:"index;not null"`
+	UserID uint   `json:"user_id" gorm:"index;not null"`
+	User   User   `json:"user"`
+	Body   string `json:"body" gorm:"type:text;not null" validate:"required,min=1,max=2000"`
+	// Hidden takes the comment down after reports; only admins still see it
+	Hidden bool `json:"hidden" gorm:"default:false;index"`
+
+	// ParentID is the comment this one replies to; nil for a top-level comment
+	ParentID *uint     `json:"parent_id" gorm:"index"`
+	Replies  []Comment `json:"replies,omitempty" gorm:"-"` // filled in when a thread is rendered
+}
+
+// Report is a signed-in user's flag on a post or one of its comments, waiting in the moderation queue
+type Report struct {
+	BaseModel
+	ReporterID   uint       `json:"reporter_id" gorm:"index;not null"`
+	PostID       uint       `json:"post_id" gorm:"index;not null"` // for comment reports, the comment's post
+	CommentID    *uint      `json:"comment_id" gorm:"index"`       // nil when the post itself is reported
+	Reason       string     `json:"reason" gorm:"size:16;not null" validate:"required,oneof=spam harassment off_topic other"`
+	Details      string     `json:"details" gorm:"size:500" validate:"max=500"`
+	Status       string     `json:"status" gorm:"size:16;default:open;index"`
+	ResolvedByID *uint      `json:"resolved_by_id"`
+	ResolvedAt   *time.Time `json:"resolved_at"`
+	Reporter     *User      `json:"-"`
+	Post         *Post      `json:"-"`
+	Comment      *Comment   `json:"-"`
+}
+
+// ReportedItem is one post or comment in the moderation queue with the reports against it, newest first
+type ReportedItem struct {
+	Post    *Post
+	Comment *Comment // nil for a reported post
+	Reports []Report
+}
+
+// PostMedia links a post to a library title it talks about (e.g. a review of a show)
+type PostMedia struct {
+	BaseModel
+	PostID uint `json:"post_id" gorm:"uniqueIndex:idx_post_media;not null"`
+	TMDBID int  `json:"tmdb_id" gorm:"uniqueIndex:idx_post_media;index;not null"`
+}
+
+// IsScheduled reports whether an unpublished post is waiting on its PublishAt time
+func (p *Post) IsScheduled() bool {
+	return !p.Published && p.PublishAt != nil
+}
+
+// SessionRevoked reports whether a JWT issued at issued could predate SessionsRevokedAt. JWTs carry whole
+// seconds, so one issued in the same second as the revocation is treated as older.
+func (u *User) SessionRevoked(issued time.Time) bool {
+	return u.SessionsRevokedAt != nil && !issued.After(*u.SessionsRevokedAt)
+}
+
+// IsExpired reports whether the post's ExpiresAt has passed
+func (p *Post) IsExpired() bool {
+	return p.ExpiresAt != nil && !p.ExpiresAt.After(time.Now())
+}
+
+// SetStatus moves the post to a workflow state, keeping Published in sync
+func (p *Post) SetStatus(status string) {
+	p.Status = status
+	p.Published = status == PostStatusPublished
+}
+
+// CalendarDate is the date a post is shown under on the content calendar
+func (p *Post) CalendarDate() time.Time {
+	if p.PublishAt != nil {
+		return *p.PublishAt
+	}
+	return p.CreatedAt
+}
+
+// Localize swaps in the translation for locale when one exists
+func (p *Post) Localize(locale string) {
+	for _, t := range p.Translations {
+		if t.Locale == locale {
+			p.Title, p.Content = t.Title, t.Content
+			return
+		}
+	}
+}
+
 func (p *Post) CanAccess(user *User) bool {
 	if !p.Published {
 		return false
 	}
+	if p.Hidden && (user == nil || !user.IsAdmin()) {
+		return false
+	}
 
 	if p.Visibility == VisibilityPublic {
 		return true
@@ -43,6 +284,25 @@ func (p *Post) CanAccess(user *User) bool {
 	return user.IsPremium()
 }
 
+// PostsVisibleTo is a scope matching the published posts CanAccess allows for user
+func PostsVisibleTo(user *User) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		db = db.Where("published = ?", true)
+		if user == nil || !user.IsAdmin() {
+			db = db.Where("hidden = ?", false)
+		}
+		switch {
+		case user == nil:
+			return db.Where("visibility = ?", VisibilityPublic)
+		case user.IsAdmin():
+			return db
+		case user.IsPremium():
+			return db.Where("visibility <> ?", VisibilityAdmin)
+		}
+		return db.Where("visibility = ?", VisibilityPublic)
+	}
+}
+
 type User struct {
 	BaseModel
 	Email      string     `json:"email" gorm:"unique;not null" validate:"required,email"`
@@ -50,8 +310,101 @@ type User struct {
 	Name       string     `json:"name" gorm:"not null" validate:"required,min=1,max=100"`
 	Role       string     `json:"role" gorm:"default:user" validate:"required,oneof=user admin premium"`
 	IsVerified bool       `json:"is_verified" gorm:"default:false"`
+	Locale     string     `json:"locale" gorm:"size:8"`
+	Timezone   string     `json:"timezone" gorm:"size:64"`
+	DateFormat string     `json:"date_format" gorm:"size:8"`
+	Theme      string     `json:"theme" gorm:"size:8"`
 	OTP        string     `json:"-" gorm:"size:6"`
 	OTPExpiry  *time.Time `json:"-"`
+	// OTPAttempts counts wrong codes until the account is verified; each resend allows a few more, up to a cap
+	OTPAttempts int `json:"-"`
+	// SessionsRevokedAt signs out every session started before it
+	SessionsRevokedAt *time.Time `json:"-"`
+	LastSeenAt        *time.Time `json:"last_seen_at" gorm:"index"`
+	// OIDCSubject links the account to its single sign-on identity (the provider's sub claim)
+	OIDCSubject *string `json:"-" gorm:"column:oidc_subject;size:255;uniqueIndex"`
+	// ShowSpoilers reveals [spoiler] blocks without a click
+	ShowSpoilers bool `json:"show_spoilers"`
+	// TrialEndsAt is when premium from a trial coupon lapses; nil when premium (if any) was granted by an admin
+	TrialEndsAt *time.Time `json:"trial_ends_at" gorm:"index"`
+	// Supporter is set once the user has tipped; HideSupporterBadge keeps that private
+	Supporter          bool `json:"supporter"`
+	HideSupporterBadge bool `json:"hide_supporter_badge"`
+	// UpNextEmail opts into a morning email of today's episodes and what to watch next,
+	// sent at UpNextHour in the user's timezone; UpNextSentAt stops a second one the same day
+	UpNextEmail  bool       `json:"up_next_email"`
+	UpNextHour   int        `json:"up_next_hour" gorm:"default:7"`
+	UpNextSentAt *time.Time `json:"-"`
+
+	// Tracker rule overrides; nil/empty falls back to the site defaults
+	AutoWatching  *bool  `json:"auto_watching"`
+	CompleteRule  string `json:"complete_rule" gorm:"size:8"`
+	PlannedResets *bool  `json:"planned_resets"`
+
+	// BannedAt suspends the account without deleting anything: sign-in and API access stop and its
+	// comments are hidden from everyone but admins. BannedByID and BanReason record who banned it and why.
+	BannedAt   *time.Time `json:"banned_at" gorm:"index"`
+	BannedByID *uint      `json:"banned_by_id"`
+	BanReason  string     `json:"ban_reason" gorm:"size:500" validate:"max=500"`
+}
+
+// IsBanned reports whether an admin has suspended the account
+func (u *User) IsBanned() bool {
+	return u != nil && u.BannedAt != nil
+}
+
+// ShowsSupporterBadge reports whether the supporter badge goes next to the user's name
+func (u *User) ShowsSupporterBadge() bool {
+	return u != nil && u.Supporter && !u.HideSupporterBadge
+}
+
+// TrackerRules control the tracker's automatic status changes
+type TrackerRules struct {
+	AutoWatching  bool   // watching an episode moves a show to "watching"
+	CompleteRule  string // see CompleteWhenAired and friends
+	PlannedResets bool   // setting a show back to "planned" unmarks its episodes
+}
+
+// TrackerRules layers the user's overrides on top of the site defaults
+func (u *User) TrackerRules(site TrackerRules) TrackerRules {
+	rules := site
+	if u.AutoWatching != nil {
+		rules.AutoWatching = *u.AutoWatching
+	}
+	if IsValidCompleteRule(u.CompleteRule) {
+		rules.CompleteRule = u.CompleteRule
+	}
+	if u.PlannedResets != nil {
+		rules.PlannedResets = *u.PlannedResets
+	}
+	return rules
+}
+
+// NextStatus is the status a show should have after its watched episodes changed
+func (r TrackerRules) NextStatus(current string, watched, aired int64, inProduction bool) string {
+	allWatched := aired > 0 && watched >= aired
+	switch {
+	case watched == 0:
+		return StatusPlanned
+	case allWatched && (r.CompleteRule == CompleteWhenAired || (r.CompleteRule == CompleteWhenEnded && !inProduction)):
+		return StatusCompleted
+	case current == StatusCompleted && r.CompleteRule == CompleteManually:
+		// Completed by hand; leave it alone
+		return current
+	case r.AutoWatching:
+		return StatusWatching
+	}
+	return current
+}
+
+// ReadingProgress tracks how far a user has scrolled through a post
+type ReadingProgress struct {
+	BaseModel
+	UserID    uint `json:"user_id" gorm:"uniqueIndex:idx_user_post;not null"`
+	PostID    uint `json:"post_id" gorm:"uniqueIndex:idx_user_post;not null"`
+	Percent   int  `json:"percent" validate:"min=0,max=100"`
+	Completed bool `json:"completed" gorm:"default:false"`
+	Post      Post `json:"post,omitempty"`
 }
 
 func (u *User) IsAdmin() bool {
@@ -74,17 +427,129 @@ type Media struct {
 	Popularity  float64    `json:"popularity"`
 	VoteCount   int        `json:"vote_count"`
 	VoteAverage float64    `json:"vote_average"`
-	IsAnime     bool       `json:"is_anime" gorm:"default:false"`
+	IsAnime     bool       `json:"is_anime" gorm:"default:false"` // what filters use: AnimeDetected unless AnimeManual
+	Adult       bool       `json:"adult" gorm:"default:false"`
+	// Rating board certification (e.g. PG-13, TV-MA) for the certification region
+	Certification string `json:"certification" gorm:"size:16"`
+	// Poster picked by an admin; kept over TMDB's default on every sync
+	CustomPosterPath string `json:"custom_poster_path,omitempty"`
+	// Anime as detected from TMDB genres, origin and keywords on add and sync;
+	// AnimeManual means an admin's toggle overrides detection
+	AnimeDetected bool `json:"anime_detected"`
+	AnimeManual   bool `json:"anime_manual"`
+	// Blurhash of PosterPath, painted while the poster loads
+	PosterBlurhash string `json:"poster_blurhash,omitempty"`
+	// Where a show's seasons and episodes come from; TVDB stands in when TMDB's are wrong or missing
+	MetadataProvider string `json:"metadata_provider" gorm:"size:8;default:tmdb"`
+	TVDBID           int    `json:"tvdb_id,omitempty"` // from TMDB's external IDs, or entered by an admin
+	// How often background sync refreshes the title, set by an admin; see SyncInterval
+	SyncSchedule string `json:"sync_schedule" gorm:"size:8;default:auto"`
 
 	// Single user tracking fields
 	Status        string     `json:"status" gorm:"default:planned" validate:"oneof=watching completed planned dropped"`
 	Progress      int        `json:"progress"`       // episodes watched for TV
 	TotalEpisodes int        `json:"total_episodes"` // total episodes (cached from TMDB)
 	Rating        float64    `json:"rating" validate:"min=0,max=10"`
+	EpisodeScore  float64    `json:"episode_score"` // derived from episode ratings, see RefreshEpisodeScores
 	Notes         string     `json:"notes" gorm:"type:text"`
 	AddedAt       time.Time  `json:"added_at" gorm:"autoCreateTime"`
 	LastSyncedAt  *time.Time `json:"last_synced_at" gorm:"index"`
 	InProduction  bool       `json:"in_production" gorm:"default:true"` // false if show has ended
+	WatchedAt     *time.Time `json:"watched_at,omitempty"`              // when a movie was watched, from a history import
+	// Why the last sync failed, if it did; repeated failures wait longer before the next automatic try
+	LastSyncError string     `json:"last_sync_error,omitempty" gorm:"type:text"`
+	SyncFailures  int        `json:"sync_failures"`
+	NextSyncAt    *time.Time `json:"next_sync_at,omitempty"`
+
+	Seasons []Season `json:"seasons,omitempty" gorm:"-"` // attached for grid progress by the handlers' attachSeasons
+}
+
+// maxSyncBackoff caps the wait after repeated sync failures
+const maxSyncBackoff = 7 * 24 * time.Hour
+
+// RecordSyncResult clears the sync error after a good sync, or counts a failure and pushes the next
+// automatic sync back: an hour after the first failure, doubling each time up to a week
+func (m *Media) RecordSyncResult(err error, now time.Time) {
+	if err == nil {
+		m.LastSyncError, m.SyncFailures, m.NextSyncAt = "", 0, nil
+		return
+	}
+	m.LastSyncError = err.Error()
+	m.SyncFailures++
+	backoff := maxSyncBackoff
+	if m.SyncFailures < 10 {
+		backoff = min(time.Hour<<(m.SyncFailures-1), maxSyncBackoff)
+	}
+	next := now.Add(backoff)
+	m.NextSyncAt = &next
+}
+
+// SyncInterval is how long background sync leaves the title between refreshes, 0 when it never syncs it
+func (m *Media) SyncInterval() time.Duration {
+	switch m.SyncSchedule {
+	case SyncScheduleDaily:
+		return 24 * time.Hour
+	case SyncScheduleWeekly:
+		return 7 * 24 * time.Hour
+	case SyncScheduleNever:
+		return 0
+	}
+	if m.Status != StatusWatching && m.Status != StatusPlanned {
+		return 0
+	}
+	return 48 * time.Hour
+}
+
+// SyncScheduled reports whether background sync should refresh the title now:
+// its schedule's interval has passed since the last sync and it isn't backing off after failures
+func (m *Media) SyncScheduled(now time.Time) bool {
+	interval := m.SyncInterval()
+	if interval == 0 {
+		return false
+	}
+	return (m.LastSyncedAt == nil || !m.LastSyncedAt.After(now.Add(-interval))) && m.SyncDue(now)
+}
+
+// SyncDue reports whether an automatic sync may run now, i.e. the title isn't backing off after failures
+func (m *Media) SyncDue(now time.Time) bool {
+	return m.NextSyncAt == nil || !m.NextSyncAt.After(now)
+}
+
+// IsAdult reports whether the title is hidden when adult content is turned off
+func (m *Media) IsAdult() bool {
+	return m.Adult || m.Certification == CertificationNC17
+}
+
+// DetectedAnime records TMDB's anime verdict, which becomes IsAnime unless an admin overrode it
+func (m *Media) DetectedAnime(detected bool) {
+	m.AnimeDetected = detected
+	if !m.AnimeManual {
+		m.IsAnime = detected
+	}
+}
+
+// ToggleAnime flips IsAnime by hand; flipping back to the detected value hands control back to detection
+func (m *Media) ToggleAnime() {
+	m.IsAnime = !m.IsAnime
+	m.AnimeManual = m.IsAnime != m.AnimeDetected
+}
+
+// GenreNames decodes the cached TMDB genres
+func (m *Media) GenreNames() []string {
+	var genres []struct {
+		Name string `json:"name"`
+	}
+	json.Unmarshal([]byte(m.Genres), &genres)
+	names := make([]string, 0, len(genres))
+	for _, genre := range genres {
+		names = append(names, genre.Name)
+	}
+	return names
+}
+
+// HideAdultMedia is a scope excluding adult titles from queries on (or joined with) the media table
+func HideAdultMedia(db *gorm.DB) *gorm.DB {
+	return db.Where("media.adult = ? AND media.certification IS DISTINCT FROM ?", false, CertificationNC17)
 }
 
 // Episode model to store complete episode data locally with single-user tracking
@@ -95,11 +560,14 @@ type Episode struct {
 	EpisodeNumber int        `json:"episode_number" gorm:"not null"`
 	Name          string     `json:"name" gorm:"not null"`
 	Overview      string     `json:"overview" gorm:"type:text"`
-	AirDate       *time.Time `json:"air_date"`
-	Runtime       int        `json:"runtime"`    // Runtime in minutes
-	StillPath     string     `json:"still_path"` // Episode screenshot
-	VoteAverage   float64    `json:"vote_average"`
-	VoteCount     int        `json:"vote_count"`
+	AirDate       *time.Time `json:"air_date" gorm:"index"`
+	Runtime       int        `json:"runtime"`                  // Runtime in minutes
+	StillPath     string     `json:"still_path"`               // Episode screenshot
+	StillBlurhash string     `json:"still_blurhash,omitempty"` // placeholder for StillPath
+	// StillHashFailedAt holds off hashing the still again for a while after it couldn't be fetched
+	StillHashFailedAt *time.Time `json:"-"`
+	VoteAverage       float64    `json:"vote_average"`
+	VoteCount         int        `json:"vote_count"`
 
 	// Single user tracking fields
 	Watched   bool       `json:"watched" gorm:"default:false"`
@@ -115,13 +583,692 @@ type Season struct {
 	Overview     string     `json:"overview" gorm:"type:text"`
 	AirDate      *time.Time `json:"air_date"`
 	EpisodeCount int        `json:"episode_count"`
+	WatchedCount int        `json:"watched_count"` // denormalized from episodes, see RefreshSeasonCounts
 	PosterPath   string     `json:"poster_path"`
 }
 
+// RefreshSeasonCounts recomputes each season's watched episode count for a show in one statement
+func RefreshSeasonCounts(db *gorm.DB, tmdbID int) error {
+	return db.Exec(seasonCountsSQL+" WHERE seasons.tmdb_id = ?", tmdbID).Error
+}
+
+const seasonCountsSQL = `UPDATE seasons SET watched_count = (
+	SELECT COUNT(*) FROM episodes
+	WHERE episodes.tmdb_id = seasons.tmdb_id
+		AND episodes.season_number = seasons.season_number
+		AND episodes.watched = true
+		AND episodes.deleted_at IS NULL
+)`
+
+// RefreshEpisodeScores recomputes a show's vote-weighted average of its rated episodes
+func RefreshEpisodeScores(db *gorm.DB, tmdbID int) error {
+	return db.Exec(episodeScoresSQL+" WHERE media.tmdb_id = ?", tmdbID).Error
+}
+
+const episodeScoresSQL = `UPDATE media SET episode_score = COALESCE((
+	SELECT SUM(vote_average * vote_count) / SUM(vote_count) FROM episodes
+	WHERE episodes.tmdb_id = media.tmdb_id
+		AND episodes.season_number > 0
+		AND episodes.vote_count > 0
+		AND episodes.deleted_at IS NULL
+), 0)`
+
+// AiringEpisode is an episode on the airing calendar, with the show it belongs to
+type AiringEpisode struct {
+	Episode
+	ShowTitle  string
+	PosterPath string
+}
+
+// AiringDay groups the episodes airing on one calendar date
+type AiringDay struct {
+	Date     time.Time
+	Episodes []AiringEpisode
+}
+
+// EpisodesAiring returns library TV episodes whose air date falls between from and to (inclusive)
+func EpisodesAiring(db *gorm.DB, from, to time.Time) ([]AiringEpisode, error) {
+	var episodes []AiringEpisode
+	err := db.Table("episodes").
+		Select("episodes.*, media.title AS show_title, media.poster_path").
+		Joins("JOIN media ON media.tmdb_id = episodes.tmdb_id AND media.type = ? AND media.deleted_at IS NULL", "tv").
+		Where("episodes.deleted_at IS NULL AND episodes.air_date BETWEEN ? AND ?", from, to).
+		Order("episodes.air_date, media.title, episodes.season_number, episodes.episode_number").
+		Scan(&episodes).Error
+	return episodes, err
+}
+
+// NextEpisodes returns the first unwatched, already aired episode of every show being watched, by show title
+func NextEpisodes(db *gorm.DB, airedBy time.Time) ([]AiringEpisode, error) {
+	var episodes []AiringEpisode
+	err := db.Table("episodes").
+		Select("DISTINCT ON (episodes.tmdb_id) episodes.*, media.title AS show_title, media.poster_path").
+		Joins("JOIN media ON media.tmdb_id = episodes.tmdb_id AND media.type = ? AND media.deleted_at IS NULL", "tv").
+		Where("media.status = ?", StatusWatching).
+		Where("episodes.deleted_at IS NULL AND episodes.watched = ? AND episodes.season_number > 0 AND episodes.air_date <= ?", false, airedBy).
+		Order("episodes.tmdb_id, episodes.season_number, episodes.episode_number").
+		Scan(&episodes).Error
+	sort.Slice(episodes, func(i, j int) bool { return episodes[i].ShowTitle < episodes[j].ShowTitle })
+	return episodes, err
+}
+
+// GroupAiringByDay splits date-ordered episodes into one AiringDay per air date
+func GroupAiringByDay(episodes []AiringEpisode) []AiringDay {
+	var days []AiringDay
+	for _, episode := range episodes {
+		if n := len(days); n > 0 && days[n-1].Date.Equal(*episode.AirDate) {
+			days[n-1].Episodes = append(days[n-1].Episodes, episode)
+			continue
+		}
+		days = append(days, AiringDay{Date: *episode.AirDate, Episodes: []AiringEpisode{episode}})
+	}
+	return days
+}
+
+// YearReview summarizes a calendar year of watching
+type YearReview struct {
+	Year            int          `json:"year"`
+	ShowsCompleted  int          `json:"shows_completed"`
+	EpisodesWatched int          `json:"episodes_watched"`
+	Minutes         int          `json:"minutes"`
+	TopGenres       []GenreCount `json:"top_genres"`
+	BusiestMonth    time.Month   `json:"busiest_month"` // 0 when nothing was watched
+	BusiestCount    int          `json:"busiest_count"`
+	FirstWatch      *ReviewWatch `json:"first_watch,omitempty"`
+	LastWatch       *ReviewWatch `json:"last_watch,omitempty"`
+}
+
+// GenreCount is how many episodes of a genre were watched
+type GenreCount struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// ReviewWatch is a single watched episode highlighted in a review
+type ReviewWatch struct {
+	ShowTitle     string    `json:"show_title"`
+	SeasonNumber  int       `json:"season_number"`
+	EpisodeNumber int       `json:"episode_number"`
+	Name          string    `json:"name"`
+	WatchedAt     time.Time `json:"watched_at"`
+}
+
+// YearReviewSnapshot freezes a YearReview behind a public share token
+type YearReviewSnapshot struct {
+	BaseModel
+	Year  int    `json:"year" gorm:"index"`
+	Token string `json:"-" gorm:"size:32;uniqueIndex;not null"`
+	Data  string `json:"-" gorm:"type:text"` // JSON-encoded YearReview
+}
+
+// Setting is a site-wide value edited from the admin UI, stored as JSON under Key
+type Setting struct {
+	BaseModel
+	Key   string `json:"key" gorm:"size:64;uniqueIndex;not null"`
+	Value string `json:"value" gorm:"type:text"`
+}
+
+// LoadSetting decodes the setting stored under key into v, leaving v as is when it was never saved
+func LoadSetting(db *gorm.DB, key string, v interface{}) error {
+	var setting Setting
+	if err := db.Where("key = ?", key).Limit(1).Find(&setting).Error; err != nil {
+		return err
+	}
+	if setting.ID == 0 {
+		return nil
+	}
+	return json.Unmarshal([]byte(setting.Value), v)
+}
+
+// SaveSetting stores v as JSON under key
+func SaveSetting(db *gorm.DB, key string, v interface{}) error {
+	value, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	var setting Setting
+	db.Where("key = ?", key).FirstOrInit(&setting, Setting{Key: key})
+	setting.Value = string(value)
+	return db.Save(&setting).Error
+}
+
+// HomeLayout is the homepage as composed by an admin, stored under SettingHomeLayout
+type HomeLayout struct {
+	Sections     []string `json:"sections"` // top to bottom
+	PinnedPostID uint     `json:"pinned_post_id"`
+	Announcement string   `json:"announcement"` // markdown
+	// WatchingPrivate keeps the currently watching widget to admins
+	WatchingPrivate bool `json:"watching_private"`
+}
+
+// Shows reports whether section is on the homepage
+func (l HomeLayout) Shows(section string) bool {
+	for _, s := range l.Sections {
+		if s == section {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultHomeLayout is the homepage until an admin composes one
+func DefaultHomeLayout() HomeLayout {
+	return HomeLayout{Sections: []string{HomeSectionPinned, HomeSectionLatest}}
+}
+
+// Landing is what / serves, stored under SettingLanding. Title and Content are the custom page
+type Landing struct {
+	Kind    string `json:"kind"`
+	Title   string `json:"title"`
+	Content string `json:"content"` // markdown
+}
+
+// CrawlerRules, stored under SettingCrawlers, are what /robots.txt asks of crawlers and whether restricted
+// pages carry a noindex tag. Disallow holds extra paths, each starting with "/".
+type CrawlerRules struct {
+	BlockPosts        bool     `json:"block_posts"`
+	BlockTV           bool     `json:"block_tv"`
+	Disallow          []string `json:"disallow"`
+	NoindexRestricted bool     `json:"noindex_restricted"`
+}
+
+// AdultMedia, stored under SettingAdultMedia, is whether adult/NC-17 titles are hidden from everyone but admins
+type AdultMedia struct {
+	Hide bool `json:"hide"`
+}
+
+// SignupRoles, stored under SettingSignupRoles, is the role new accounts start with and the email domains
+// granted more once the address is verified. Domains are lowercase, without the "@".
+type SignupRoles struct {
+	DefaultRole    string   `json:"default_role"` // user or premium
+	PremiumDomains []string `json:"premium_domains"`
+	AdminDomains   []string `json:"admin_domains"`
+}
+
+// DomainRole is the role email's domain grants, or "" when it isn't listed
+func (r SignupRoles) DomainRole(email string) string {
+	_, domain, _ := strings.Cut(strings.ToLower(strings.TrimSpace(email)), "@")
+	switch {
+	case domain == "":
+		return ""
+	case slices.Contains(r.AdminDomains, domain):
+		return RoleAdmin
+	case slices.Contains(r.PremiumDomains, domain):
+		return RolePremium
+	}
+	return ""
+}
+
+// Coupon is a code that either starts a premium trial of TrialDays when redeemed in settings,
+// or takes PercentOff or AmountOff off a tip at checkout
+type Coupon struct {
+	BaseModel
+	Code           string     `json:"code" gorm:"size:32;uniqueIndex;not null" validate:"required,max=32"`
+	TrialDays      int        `json:"trial_days" validate:"min=0,max=365"`
+	PercentOff     int        `json:"percent_off" validate:"min=0,max=100"`
+	AmountOff      int        `json:"amount_off" validate:"min=0"` // in whole units of TIP_CURRENCY
+	MaxRedemptions int        `json:"max_redemptions"`             // 0 is unlimited
+	Redemptions    int        `json:"redemptions"`
+	ExpiresAt      *time.Time `json:"expires_at"` // last moment the code can be redeemed
+}
+
+// Discount reports whether the coupon is a tip discount rather than a trial
+func (c Coupon) Discount() bool {
+	return c.PercentOff > 0 || c.AmountOff > 0
+}
+
+// Redeemable reports whether the coupon can still be redeemed at now
+func (c Coupon) Redeemable(now time.Time) bool {
+	if c.ExpiresAt != nil && now.After(*c.ExpiresAt) {
+		return false
+	}
+	return c.MaxRedemptions == 0 || c.Redemptions < c.MaxRedemptions
+}
+
+// CouponRedemption records a user's trial; there is one per user, so each account gets a single trial
+type CouponRedemption struct {
+	BaseModel
+	CouponID uint `json:"coupon_id" gorm:"index;not null"`
+	UserID   uint `json:"user_id" gorm:"uniqueIndex;not null"`
+}
+
+// Payment is a one-time tip, recorded once the provider reports it paid
+type Payment struct {
+	BaseModel
+	UserID     *uint  `json:"user_id" gorm:"index"` // nil for tips from visitors who were not signed in
+	Email      string `json:"email"`
+	Amount     int    `json:"amount"` // in the currency's smallest unit
+	Currency   string `json:"currency" gorm:"size:3"`
+	Provider   string `json:"provider" gorm:"size:16"`
+	ProviderID string `json:"provider_id" gorm:"size:255;uniqueIndex"` // e.g. the Stripe Checkout Session ID
+	Coupon     string `json:"coupon,omitempty" gorm:"size:32"`         // code of the discount applied, if any
+}
+
+// Notification is an entry in the admin notification center
+type Notification struct {
+	BaseModel
+	Event  string     `json:"event" gorm:"size:32;index"`
+	Title  string     `json:"title" gorm:"not null"`
+	Link   string     `json:"link"`
+	ReadAt *time.Time `json:"read_at" gorm:"index"`
+}
+
+// Activity is one thing a user did, listed back to them on their settings page. Like a Notification it
+// keeps its own title and link, so an entry reads the same after what it points at is gone.
+type Activity struct {
+	BaseModel
+	UserID uint   `json:"user_id" gorm:"index;not null"`
+	Event  string `json:"event" gorm:"size:32"`
+	Title  string `json:"title" gorm:"not null"`
+	Link   string `json:"link"`
+}
+
+// Webhook is an outgoing endpoint that receives site events as signed JSON POSTs
+type Webhook struct {
+	BaseModel
+	URL    string `json:"url" gorm:"not null" validate:"required,url"`
+	Secret string `json:"-" gorm:"size:64;not null"` // HMAC-SHA256 key for the X-Webhook-Signature header
+	Events string `json:"events"`                    // comma-separated; empty subscribes to every event
+	Active bool   `json:"active"`
+}
+
+// Wants reports whether the webhook subscribes to event
+func (w Webhook) Wants(event string) bool {
+	if w.Events == "" {
+		return true
+	}
+	for _, e := range strings.Split(w.Events, ",") {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// APIToken lets scripts and the CLI client call the site as its user; only a hash of the token is stored
+type APIToken struct {
+	BaseModel
+	UserID     uint       `json:"user_id" gorm:"index;not null"`
+	Name       string     `json:"name" gorm:"not null" validate:"required,max=100"`
+	TokenHash  string     `json:"-" gorm:"size:64;uniqueIndex;not null"` // hex SHA-256 of the token
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CallCount  int64      `json:"call_count" gorm:"not null;default:0"`
+	ErrorCount int64      `json:"error_count" gorm:"not null;default:0"` // calls answered with a 4xx or 5xx
+
+	Errors []APITokenError `json:"-" gorm:"foreignKey:TokenID"`
+
+	// RateLimit, RateLimitRemaining and RateLimitReset are the token's current rate-limit window; not persisted
+	RateLimit          int       `json:"-" gorm:"-"`
+	RateLimitRemaining int       `json:"-" gorm:"-"`
+	RateLimitReset     time.Time `json:"-" gorm:"-"`
+}
+
+// APITokenError is one of a token's most recent failed calls, kept so its owner can see what went wrong
+type APITokenError struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	TokenID   uint      `json:"token_id" gorm:"index;not null"`
+	Method    string    `json:"method" gorm:"size:8"`
+	Path      string    `json:"path"`
+	Status    int       `json:"status"`
+	Message   string    `json:"message"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// RevokedToken is a JWT turned away before it expires; rows past ExpiresAt can go, as the token is dead anyway
+type RevokedToken struct {
+	ID        string    `json:"id" gorm:"primaryKey;size:32"` // the token's jti claim
+	ExpiresAt time.Time `json:"expires_at" gorm:"index;not null"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// WatchImportItem is one title from an uploaded viewing history (e.g. Netflix's ViewingActivity.csv),
+// matched to TMDB and then applied to the library
+type WatchImportItem struct {
+	BaseModel
+	Title      string `json:"title" gorm:"not null"`
+	MediaType  string `json:"media_type" gorm:"size:8;not null"`
+	Views      string `json:"views" gorm:"type:text"`      // JSON []WatchView
+	Candidates string `json:"candidates" gorm:"type:text"` // JSON []ImportCandidate from the TMDB search
+	TMDBID     int    `json:"tmdb_id"`
+	Status     string `json:"status" gorm:"size:16;index;not null"`
+	Note       string `json:"note"`
+}
+
+// ImportBusy reports whether any of items still waits for its TMDB search or to be applied
+func ImportBusy(items []WatchImportItem) bool {
+	for _, item := range items {
+		if item.Status == ImportPending || item.Status == ImportQueued {
+			return true
+		}
+	}
+	return false
+}
+
+// WatchView is one viewing in an import; Season and Episode are empty for movies
+type WatchView struct {
+	Season  int       `json:"season,omitempty"`
+	Episode string    `json:"episode,omitempty"`
+	Date    time.Time `json:"date"`
+}
+
+// ImportCandidate is a TMDB search result offered when reviewing an import
+type ImportCandidate struct {
+	TMDBID int    `json:"tmdb_id"`
+	Title  string `json:"title"`
+	Year   string `json:"year"`
+}
+
+// ViewList decodes the item's viewings
+func (i *WatchImportItem) ViewList() []WatchView {
+	var views []WatchView
+	json.Unmarshal([]byte(i.Views), &views)
+	return views
+}
+
+// CandidateList decodes the item's TMDB candidates
+func (i *WatchImportItem) CandidateList() []ImportCandidate {
+	var candidates []ImportCandidate
+	json.Unmarshal([]byte(i.Candidates), &candidates)
+	return candidates
+}
+
+// WebhookDelivery is one queued POST of an event to a webhook, retried with backoff by the queue worker
+type WebhookDelivery struct {
+	BaseModel
+	WebhookID uint      `json:"webhook_id" gorm:"index;not null"`
+	Event     string    `json:"event" gorm:"size:32"`
+	Payload   string    `json:"payload" gorm:"type:text"`
+	Status    string    `json:"status" gorm:"size:16;default:queued;index"`
+	Attempts  int       `json:"attempts"`
+	Error     string    `json:"error,omitempty"`
+	SendAt    time.Time `json:"send_at" gorm:"index"`
+}
+
+// MilestonePosts is the post drafted when a library title is completed, stored under SettingMilestones.
+// Title and Content take the media placeholders ({{title}}, {{type}}, {{episodes}}, {{rating}},
+// {{release_year}}) as well as the post template ones.
+type MilestonePosts struct {
+	Enabled bool   `json:"enabled"`
+	Title   string `json:"title"`
+	Content string `json:"content"` // markdown
+}
+
+// DefaultMilestonePosts is the milestone template until an admin edits it; it starts switched off
+func DefaultMilestonePosts() MilestonePosts {
+	return MilestonePosts{
+		Title:   "Finished {{title}}",
+		Content: "Just finished **{{title}}** ({{release_year}}). My rating: {{rating}}.",
+	}
+}
+
+// EmailCampaign is one bulk email send (e.g. a newsletter issue)
+type EmailCampaign struct {
+	BaseModel
+	Name            string     `json:"name" gorm:"not null"`
+	Subject         string     `json:"subject" gorm:"not null"`
+	Kind            string     `json:"kind" gorm:"size:32;index"`
+	PostID          *uint      `json:"post_id"`
+	Audience        string     `json:"audience,omitempty" gorm:"size:16"`
+	TrackingEnabled bool       `json:"tracking_enabled"`
+	SentAt          *time.Time `json:"sent_at"`
+}
+
+// EmailSend is a single recipient of a campaign, identified in tracking URLs by Token
+type EmailSend struct {
+	BaseModel
+	CampaignID uint       `json:"campaign_id" gorm:"index;not null"`
+	Email      string     `json:"email" gorm:"not null"`
+	Token      string     `json:"-" gorm:"uniqueIndex;size:32;not null"`
+	Status     string     `json:"status" gorm:"size:16;default:sent;index"` // mirrors the queue job: queued, sent, failed, skipped
+	Error      string     `json:"error,omitempty"`
+	OpenedAt   *time.Time `json:"opened_at"`
+	Opens      int        `json:"opens"`
+	ClickedAt  *time.Time `json:"clicked_at"`
+	Clicks     int        `json:"clicks"`
+}
+
+// EmailClick records one tracked link click
+type EmailClick struct {
+	BaseModel
+	CampaignID uint   `json:"campaign_id" gorm:"index;not null"`
+	SendID     uint   `json:"send_id" gorm:"index;not null"`
+	URL        string `json:"url" gorm:"type:text;not null"`
+}
+
+// EmailJob is a queued outgoing email, delivered by the queue worker
+type EmailJob struct {
+	BaseModel
+	Kind     string     `json:"kind" gorm:"size:32;not null"`
+	To       string     `json:"to" gorm:"not null"`
+	Subject  string     `json:"subject" gorm:"not null"`
+	HTML     string     `json:"-" gorm:"type:text"`
+	Status   string     `json:"status" gorm:"size:16;default:queued;index"`
+	Attempts int        `json:"attempts"`
+	Error    string     `json:"error,omitempty"`
+	SendAt   time.Time  `json:"send_at" gorm:"index"`
+	SentAt   *time.Time `json:"sent_at"`
+	SendID   *uint      `json:"send_id"` // campaign recipient row, when part of a campaign
+}
+
+// EmailPreference records which optional emails an address wants; no row means everything is on.
+// No gorm defaults on the flags: a default would swallow explicit false values on create.
+type EmailPreference struct {
+	BaseModel
+	Email         string `json:"email" gorm:"uniqueIndex;not null"`
+	Newsletters   bool   `json:"newsletters"`
+	EpisodeAlerts bool   `json:"episode_alerts"`
+	Digests       bool   `json:"digests"`
+}
+
+// EmailSuppression stops the queue from mailing an address that hard-bounced or marked our mail as spam.
+// Rows come from the email provider's webhooks; an admin can clear one to try the address again.
+type EmailSuppression struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	Email     string    `json:"email" gorm:"size:255;uniqueIndex;not null"`
+	Reason    string    `json:"reason" gorm:"size:16;not null"` // bounce or complaint
+	Detail    string    `json:"detail" gorm:"size:500"`         // the provider's bounce message, when it sent one
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Allows reports whether this address accepts emails of kind
+func (p *EmailPreference) Allows(kind string) bool {
+	switch kind {
+	case EmailKindNewsletter, EmailKindAnnouncement:
+		return p.Newsletters
+	case EmailKindEpisodeAlert:
+		return p.EpisodeAlerts
+	case EmailKindDigest:
+		return p.Digests
+	default:
+		return true
+	}
+}
+
+// Subscriber is an email address signed up for new posts without an account; it gets mail once ConfirmedAt is set
+type Subscriber struct {
+	BaseModel
+	Email       string     `json:"email" gorm:"uniqueIndex;not null" validate:"required,email,max=254"`
+	Token       string     `json:"-" gorm:"uniqueIndex;size:32;not null"`
+	ConfirmedAt *time.Time `json:"confirmed_at"`
+}
+
+// Confirmed reports whether the address finished double opt-in
+func (s *Subscriber) Confirmed() bool {
+	return s.ConfirmedAt != nil
+}
+
+// CampaignStats aggregates delivery and engagement for a campaign
+type CampaignStats struct {
+	CampaignID   uint
+	Queued       int64
+	Sent         int64
+	Failed       int64
+	Skipped      int64
+	UniqueOpens  int64
+	TotalOpens   int64
+	UniqueClicks int64
+	TotalClicks  int64
+}
+
+func (s CampaignStats) OpenRate() float64  { return rate(s.UniqueOpens, s.Sent) }
+func (s CampaignStats) ClickRate() float64 { return rate(s.UniqueClicks, s.Sent) }
+
+func rate(part, total int64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(part) / float64(total) * 100
+}
+
+// Segment is a saved user filter, shared by the admin user table, CSV exports and announcements
+type Segment struct {
+	BaseModel
+	Name         string     `json:"name" gorm:"uniqueIndex;not null" validate:"required,max=100"`
+	Role         string     `json:"role" gorm:"size:16"`
+	Verified     string     `json:"verified" gorm:"size:8"` // "", yes, no
+	SignedUpFrom *time.Time `json:"signed_up_from"`
+	SignedUpTo   *time.Time `json:"signed_up_to"` // inclusive
+	Activity     string     `json:"activity" gorm:"size:16"`
+	ActivityDays int        `json:"activity_days"`
+}
+
+// Apply narrows a users query to the segment's filters
+func (s *Segment) Apply(db *gorm.DB) *gorm.DB {
+	if s.Role != "" {
+		db = db.Where("role = ?", s.Role)
+	}
+	switch s.Verified {
+	case SegmentVerified:
+		db = db.Where("is_verified = ?", true)
+	case SegmentUnverified:
+		db = db.Where("is_verified = ?", false)
+	}
+	if s.SignedUpFrom != nil {
+		db = db.Where("created_at >= ?", *s.SignedUpFrom)
+	}
+	if s.SignedUpTo != nil {
+		db = db.Where("created_at < ?", s.SignedUpTo.AddDate(0, 0, 1))
+	}
+
+	cutoff := time.Now().AddDate(0, 0, -s.activityWindow())
+	switch s.Activity {
+	case ActivityActive:
+		db = db.Where("last_seen_at >= ?", cutoff)
+	case ActivityInactive:
+		db = db.Where("last_seen_at IS NULL OR last_seen_at < ?", cutoff)
+	}
+	return db
+}
+
+func (s *Segment) activityWindow() int {
+	if s.ActivityDays <= 0 {
+		return DefaultActivityDays
+	}
+	return s.ActivityDays
+}
+
 // DashboardStats for admin dashboard
 type DashboardStats struct {
-	TotalUsers     int64
-	PremiumUsers   int64
-	TotalPosts     int64
-	PublishedPosts int64
+	TotalUsers          int64
+	PremiumUsers        int64
+	TotalPosts          int64
+	PublishedPosts      int64
+	UnreadNotifications int64
+	TMDB                TMDBQuota
+	MostLiked           []Post // with LikeCount
+}
+
+// RevenueSummary is the admin revenue page: tips in the site currency plus premium membership churn
+type RevenueSummary struct {
+	Currency       string
+	Last30Days     int // smallest currency unit
+	AllTime        int
+	Tips30Days     int
+	Monthly        []MonthlyRevenue // oldest first
+	PremiumMembers int64            // premium granted by an admin
+	TrialMembers   int64
+	TrialsEnded    int // trials that ran out in the last 30 days
+	TrialsChurned  int // of those, users who went back to a regular account
+}
+
+// ChurnRate is the share of recently ended trials that did not stay premium, in percent
+func (r RevenueSummary) ChurnRate() float64 {
+	if r.TrialsEnded == 0 {
+		return 0
+	}
+	return float64(r.TrialsChurned) * 100 / float64(r.TrialsEnded)
+}
+
+// MonthlyRevenue is one calendar month of tips
+type MonthlyRevenue struct {
+	Month  time.Time
+	Amount int
+	Count  int
+}
+
+// APICallHour counts one external API's calls in one UTC hour
+type APICallHour struct {
+	ID      uint      `json:"id" gorm:"primaryKey"`
+	Service string    `json:"service" gorm:"size:16;uniqueIndex:idx_api_call_hour;not null"`
+	Hour    time.Time `json:"hour" gorm:"uniqueIndex:idx_api_call_hour;not null"`
+	Calls   int64     `json:"calls" gorm:"not null;default:0"`
+}
+
+// TMDBQuota is TMDB usage against the configured budgets; a zero budget is untracked
+type TMDBQuota struct {
+	Hours        []APICallHour // the last 24 hours, oldest first
+	HourCalls    int64         // this hour so far
+	DayCalls     int64         // since midnight UTC
+	HourlyBudget int
+	DailyBudget  int
+}
+
+// HourPercent is this hour's share of the hourly budget
+func (q TMDBQuota) HourPercent() int { return budgetPercent(q.HourCalls, q.HourlyBudget) }
+
+// DayPercent is today's share of the daily budget
+func (q TMDBQuota) DayPercent() int { return budgetPercent(q.DayCalls, q.DailyBudget) }
+
+func budgetPercent(calls int64, budget int) int {
+	if budget <= 0 {
+		return 0
+	}
+	return int(calls * 100 / int64(budget))
+}
+
+// PageView is one counted visit to a public page. It holds nothing that identifies the visitor:
+// no IP address, cookie, user agent or user.
+type PageView struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	Path      string    `json:"path" gorm:"size:255;not null"`
+	Referrer  string    `json:"referrer,omitempty" gorm:"size:255"` // the referring site's host; empty for direct visits and internal links
+	Country   string    `json:"country,omitempty" gorm:"size:2"`    // ISO code from the proxy in front, when it sends one, else from GeoIP
+	City      string    `json:"city,omitempty" gorm:"size:100"`     // "Berlin, DE", from GeoIP when it is set up
+	CreatedAt time.Time `json:"created_at" gorm:"index"`
+}
+
+// PostView counts a post's views on one UTC day. A visitor is counted once per post per browser session,
+// under the same rules as PageView; nothing about them is stored.
+type PostView struct {
+	ID     uint   `json:"id" gorm:"primaryKey"`
+	PostID uint   `json:"post_id" gorm:"not null;uniqueIndex:idx_post_view_day"`
+	Day    string `json:"day" gorm:"size:10;not null;uniqueIndex:idx_post_view_day"` // 2006-01-02
+	Views  int64  `json:"views" gorm:"not null;default:0"`
+}
+
+// AnalyticsSummary is the admin analytics page for the last Days days
+type AnalyticsSummary struct {
+	Days      int
+	Views     int64
+	Daily     []AnalyticsCount // one per day, oldest first, labelled 2006-01-02
+	Pages     []AnalyticsCount
+	Referrers []AnalyticsCount
+	Countries []AnalyticsCount
+	Cities    []AnalyticsCount
+}
+
+// AnalyticsCount is the views for one page, referrer, country or day
+type AnalyticsCount struct {
+	Label string
+	Views int64
 }
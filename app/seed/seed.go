@@ -0,0 +1,257 @@
+// Package seed fills a site's database with sample data, started as `mini-blog seed --demo`,
+// so a new checkout has something to click through and screenshot.
+package seed
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"mini-blog/app/config"
+	"mini-blog/app/models"
+	"os"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+const usage = `Usage: mini-blog seed --demo [-site NAME] [--force]
+
+  --demo      create sample users, posts of each visibility and a small library with watch history
+  -site NAME  the TENANTS site to seed; the first one by default
+  --force     seed a site whose ENV is production anyway
+
+Demo data only goes into a fresh install: no posts, no media and at most the initial admin.
+Every demo account signs in with the password "` + DemoPassword + `", so production sites are refused.
+`
+
+// DemoPassword is the password of every demo account
+const DemoPassword = "demo1234"
+
+// ErrNotFresh is returned by Demo for a database that already has content
+var ErrNotFresh = errors.New("the database already has content; demo data only goes into a fresh install")
+
+// ErrProduction is returned for a production site without --force, since every demo account shares DemoPassword
+var ErrProduction = errors.New("ENV is production and demo accounts share a published password; pass --force to seed anyway")
+
+// checkEnv refuses to seed a production site unless forced
+func checkEnv(cfg *config.Config, force bool) error {
+	if cfg.Env == "production" && !force {
+		return ErrProduction
+	}
+	return nil
+}
+
+// Run executes the seed command line in args and returns the process exit code
+func Run(args []string) int {
+	flags := flag.NewFlagSet("seed", flag.ContinueOnError)
+	flags.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	demo := flags.Bool("demo", false, "create demo data")
+	siteName := flags.String("site", "", "TENANTS site to seed")
+	force := flags.Bool("force", false, "seed a production site")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if !*demo {
+		flags.Usage()
+		return 2
+	}
+
+	sites := config.LoadSites()
+	site := sites[0]
+	if *siteName != "" {
+		found := false
+		for _, s := range sites {
+			if s.Name == *siteName {
+				site, found = s, true
+			}
+		}
+		if !found {
+			fmt.Fprintf(os.Stderr, "error: no site named %q in TENANTS\n", *siteName)
+			return 1
+		}
+	}
+
+	if err := checkEnv(site.Config, *force); err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 1
+	}
+
+	db := models.ConnectDB(site.Config)
+	models.RunMigrations(db)
+	if err := Demo(db, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 1
+	}
+	return 0
+}
+
+// Demo creates the demo users, posts and library in one transaction, reporting what it made to out
+func Demo(db *gorm.DB, out io.Writer) error {
+	if !models.IsFreshInstall(db) {
+		return ErrNotFresh
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(DemoPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	return db.Transaction(func(tx *gorm.DB) error {
+		users := []*models.User{
+			{Name: "Demo Admin", Email: "admin@demo.test", Role: models.RoleAdmin},
+			{Name: "Priya Member", Email: "premium@demo.test", Role: models.RolePremium},
+			{Name: "Sam Reader", Email: "reader@demo.test", Role: models.RoleUser},
+		}
+		for _, user := range users {
+			user.Password, user.IsVerified = string(hash), true
+			if err := tx.Create(user).Error; err != nil {
+				return fmt.Errorf("user %s: %w", user.Email, err)
+			}
+			fmt.Fprintf(out, "user     %-20s %s\n", user.Email, user.Role)
+		}
+		admin := users[0]
+
+		reviews := models.Category{Name: "Reviews", Slug: "reviews", Description: "What I thought of what I watched."}
+		notes := models.Category{Name: "Site Notes", Slug: "site-notes", Description: "News about this blog."}
+		for _, category := range []*models.Category{&reviews, &notes} {
+			if err := tx.Create(category).Error; err != nil {
+				return fmt.Errorf("category %s: %w", category.Slug, err)
+			}
+		}
+
+		tomorrow := now.Add(24 * time.Hour)
+		posts := []models.Post{
+			demoPost("welcome", "Welcome to the demo blog", models.VisibilityPublic, models.PostStatusPublished, &notes,
+				"This site was filled by `mini-blog seed --demo`.\n\n## What's here\n\n- Posts of every visibility\n- A small TV library with watch history\n- Three accounts to sign in as", now.AddDate(0, 0, -20)),
+			demoPost("breaking-bad-rewatch", "Breaking Bad holds up", models.VisibilityPublic, models.PostStatusPublished, &reviews,
+				"Rewatching from the start. The **pilot** still sets everything up in under an hour.\n\n> Say my name.", now.AddDate(0, 0, -9)),
+			demoPost("severance-season-two", "Severance, season two", models.VisibilityPremium, models.PostStatusPublished, &reviews,
+				"Members get the long version: every theory about the goats, ranked.", now.AddDate(0, 0, -4)),
+			demoPost("traffic-report", "Traffic report", models.VisibilityAdmin, models.PostStatusPublished, &notes,
+				"Visible to admins only. Good for checking what readers can't see.", now.AddDate(0, 0, -2)),
+			demoPost("draft-the-bear", "The Bear (draft)", models.VisibilityPublic, models.PostStatusDraft, &reviews,
+				"Half-written thoughts on season three.", now.AddDate(0, 0, -1)),
+			demoPost("scheduled-dune", "Dune, a second look", models.VisibilityPublic, models.PostStatusApproved, &reviews,
+				"Approved and scheduled to publish tomorrow.", now),
+		}
+		posts[len(posts)-1].PublishAt = &tomorrow
+		for i := range posts {
+			posts[i].AuthorID = &admin.ID
+			if err := tx.Create(&posts[i]).Error; err != nil {
+				return fmt.Errorf("post %s: %w", posts[i].Slug, err)
+			}
+			fmt.Fprintf(out, "post     %-28s %s, %s\n", posts[i].Slug, posts[i].Visibility, posts[i].Status)
+		}
+
+		// Likes from both members on the public review
+		for _, user := range users[1:] {
+			if err := tx.Create(&models.Reaction{PostID: posts[1].ID, UserID: user.ID, Kind: models.ReactionLike}).Error; err != nil {
+				return err
+			}
+		}
+
+		return seedLibrary(tx, out, now)
+	})
+}
+
+func demoPost(slug, title, visibility, status string, category *models.Category, content string, created time.Time) models.Post {
+	return models.Post{
+		BaseModel:  models.BaseModel{CreatedAt: created, UpdatedAt: created},
+		Title:      title,
+		Slug:       slug,
+		Content:    content,
+		Visibility: visibility,
+		Status:     status,
+		Published:  status == models.PostStatusPublished,
+		Version:    1,
+		CategoryID: &category.ID,
+	}
+}
+
+// demoShow is a show for the library: seasons lists each season's episode count, watched how many
+// episodes (in order) have been seen
+type demoShow struct {
+	tmdbID  int
+	title   string
+	status  string
+	seasons []int
+	watched int
+	ended   bool
+}
+
+// seedLibrary adds real TMDB IDs with placeholder episodes; they are never marked synced,
+// so with TMDB configured the first open of each title fetches its real details and episodes
+func seedLibrary(tx *gorm.DB, out io.Writer, now time.Time) error {
+	shows := []demoShow{
+		{tmdbID: 1396, title: "Breaking Bad", status: models.StatusWatching, seasons: []int{7, 13}, watched: 10, ended: true},
+		{tmdbID: 95396, title: "Severance", status: models.StatusCompleted, seasons: []int{9}, watched: 9},
+		{tmdbID: 136315, title: "The Bear", status: models.StatusPlanned, seasons: []int{8, 10}},
+	}
+	for _, show := range shows {
+		total := 0
+		for _, count := range show.seasons {
+			total += count
+		}
+		media := models.Media{
+			TMDBID:        show.tmdbID,
+			Type:          models.MediaTypeTV,
+			Title:         show.title,
+			Status:        show.status,
+			Progress:      show.watched,
+			TotalEpisodes: total,
+			InProduction:  !show.ended,
+			SyncSchedule:  models.SyncScheduleAuto,
+		}
+		if err := tx.Create(&media).Error; err != nil {
+			return fmt.Errorf("show %s: %w", show.title, err)
+		}
+
+		seen := 0
+		firstAired := now.AddDate(-2, 0, 0)
+		for s, count := range show.seasons {
+			season := models.Season{TMDBID: show.tmdbID, SeasonNumber: s + 1, Name: fmt.Sprintf("Season %d", s+1), EpisodeCount: count}
+			if err := tx.Create(&season).Error; err != nil {
+				return err
+			}
+			for e := 1; e <= count; e++ {
+				aired := firstAired.AddDate(0, s*6, 7*(e-1))
+				episode := models.Episode{
+					TMDBID:        show.tmdbID,
+					SeasonNumber:  s + 1,
+					EpisodeNumber: e,
+					Name:          fmt.Sprintf("Episode %d", e),
+					AirDate:       &aired,
+					Runtime:       47,
+				}
+				if seen < show.watched {
+					watchedAt := now.AddDate(0, 0, seen-show.watched)
+					episode.Watched, episode.WatchedAt = true, &watchedAt
+					seen++
+				}
+				if err := tx.Create(&episode).Error; err != nil {
+					return err
+				}
+			}
+		}
+		if err := models.RefreshSeasonCounts(tx, show.tmdbID); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "show     %-28s %s, %d/%d watched\n", show.title, show.status, show.watched, total)
+	}
+
+	watchedAt := now.AddDate(0, -1, 0)
+	movies := []models.Media{
+		{TMDBID: 438631, Type: models.MediaTypeMovie, Title: "Dune", Status: models.StatusCompleted, Rating: 8, WatchedAt: &watchedAt},
+		{TMDBID: 666277, Type: models.MediaTypeMovie, Title: "Past Lives", Status: models.StatusPlanned},
+	}
+	for i := range movies {
+		movies[i].SyncSchedule = models.SyncScheduleAuto
+		if err := tx.Create(&movies[i]).Error; err != nil {
+			return fmt.Errorf("movie %s: %w", movies[i].Title, err)
+		}
+		fmt.Fprintf(out, "movie    %-28s %s\n", movies[i].Title, movies[i].Status)
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package seed
+
+import (
+	"errors"
+	"io"
+	"mini-blog/app/config"
+	"mini-blog/app/models"
+	"mini-blog/app/testdb"
+	"testing"
+)
+
+func TestDemo(t *testing.T) {
+	db := testdb.Open(t)
+	if err := Demo(db, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+
+	var published []models.Post
+	db.Where("published = ?", true).Find(&published)
+	visibilities := map[string]bool{}
+	for _, post := range published {
+		visibilities[post.Visibility] = true
+	}
+	for _, visibility := range []string{models.VisibilityPublic, models.VisibilityPremium, models.VisibilityAdmin} {
+		if !visibilities[visibility] {
+			t.Errorf("no published %s post", visibility)
+		}
+	}
+
+	// The seeded counts agree with the episodes, as after a sync
+	if report, err := models.CheckLibraryIntegrity(db); err != nil || report.Problems() != 0 {
+		t.Errorf("library integrity = %+v, %v", report, err)
+	}
+
+	if err := Demo(db, io.Discard); !errors.Is(err, ErrNotFresh) {
+		t.Errorf("second seed = %v; want ErrNotFresh", err)
+	}
+}
+
+func TestProductionNeedsForce(t *testing.T) {
+	cfg := &config.Config{Env: "production"}
+	if err := checkEnv(cfg, false); !errors.Is(err, ErrProduction) {
+		t.Errorf("production without --force = %v; want ErrProduction", err)
+	}
+	if err := checkEnv(cfg, true); err != nil {
+		t.Errorf("production with --force = %v", err)
+	}
+	if err := checkEnv(&config.Config{Env: "development"}, false); err != nil {
+		t.Errorf("development = %v", err)
+	}
+}
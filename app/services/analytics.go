@@ -0,0 +1,73 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// botMarkers are user agent fragments of crawlers and link previewers, which aren't counted as visits
+var botMarkers = []string{"bot", "crawl", "spider", "slurp", "preview", "fetch", "monitor", "curl", "wget", "python-requests", "headless"}
+
+// DoNotTrack reports whether the request asks not to be tracked, through DNT or Global Privacy Control
+func DoNotTrack(r *http.Request) bool {
+	return r.Header.Get("DNT") == "1" || r.Header.Get("Sec-GPC") == "1"
+}
+
+// IsBot reports whether userAgent looks automated; an empty user agent counts as one
+func IsBot(userAgent string) bool {
+	userAgent = strings.ToLower(userAgent)
+	if userAgent == "" {
+		return true
+	}
+	for _, marker := range botMarkers {
+		if strings.Contains(userAgent, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// ReferrerHost reduces a Referer header to the referring site's host, or "" when it is this site (host) or unusable.
+// Paths and queries are dropped so nothing about the visitor's browsing elsewhere is kept.
+func ReferrerHost(referer, host string) string {
+	u, err := url.Parse(referer)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return ""
+	}
+	referrer := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+	if referrer == "" || referrer == strings.TrimPrefix(strings.ToLower(stripPort(host)), "www.") {
+		return ""
+	}
+	return referrer
+}
+
+// CountryCode normalizes a proxy's country header to an ISO 3166 code, dropping unknown ("XX") and Tor ("T1") markers
+func CountryCode(value string) string {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	if len(value) != 2 || value == "XX" || value == "T1" || value[0] < 'A' || value[0] > 'Z' || value[1] < 'A' || value[1] > 'Z' {
+		return ""
+	}
+	return value
+}
+
+func stripPort(host string) string {
+	if i := strings.LastIndex(host, ":"); i != -1 && !strings.Contains(host[i:], "]") {
+		return host[:i]
+	}
+	return host
+}
+
+type analyticsConsentContextKey struct{}
+
+// WithAnalyticsConsentPrompt tells Layout to ask the visitor whether page views may be counted
+func WithAnalyticsConsentPrompt(ctx context.Context) context.Context {
+	return context.WithValue(ctx, analyticsConsentContextKey{}, true)
+}
+
+// AnalyticsConsentPromptFromContext reports whether the consent banner should show
+func AnalyticsConsentPromptFromContext(ctx context.Context) bool {
+	prompt, _ := ctx.Value(analyticsConsentContextKey{}).(bool)
+	return prompt
+}
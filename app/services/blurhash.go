@@ -0,0 +1,228 @@
+package services
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"strings"
+	"sync"
+)
+
+// Blurhash (https://blurha.sh) squeezes an image into a short string of a few
+// cosine components, enough to paint a blurred placeholder while the real image loads.
+
+const (
+	blurhashChars = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+	// blurhashPreviewSize is the width and height placeholders are painted at; CSS stretches them
+	blurhashPreviewSize = 32
+)
+
+// blurhashPreviews caches data URIs by hash, since the same posters render on every grid
+var blurhashPreviews sync.Map
+
+// EncodeBlurhash encodes img with xComponents by yComponents cosine components (1-9 each)
+func EncodeBlurhash(img image.Image, xComponents, yComponents int) (string, error) {
+	if xComponents < 1 || xComponents > 9 || yComponents < 1 || yComponents > 9 {
+		return "", fmt.Errorf("blurhash components must be between 1 and 9")
+	}
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width == 0 || height == 0 {
+		return "", fmt.Errorf("blurhash of an empty image")
+	}
+
+	// Linearise once; every component walks every pixel
+	pixels := make([][3]float64, width*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			r, g, b, _ := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+			pixels[y*width+x] = [3]float64{sRGBToLinear(int(r >> 8)), sRGBToLinear(int(g >> 8)), sRGBToLinear(int(b >> 8))}
+		}
+	}
+
+	factors := make([][3]float64, 0, xComponents*yComponents)
+	for j := 0; j < yComponents; j++ {
+		for i := 0; i < xComponents; i++ {
+			normalisation := 2.0
+			if i == 0 && j == 0 {
+				normalisation = 1
+			}
+			var factor [3]float64
+			for y := 0; y < height; y++ {
+				for x := 0; x < width; x++ {
+					basis := math.Cos(math.Pi*float64(i)*float64(x)/float64(width)) *
+						math.Cos(math.Pi*float64(j)*float64(y)/float64(height))
+					pixel := pixels[y*width+x]
+					factor[0] += basis * pixel[0]
+					factor[1] += basis * pixel[1]
+					factor[2] += basis * pixel[2]
+				}
+			}
+			scale := normalisation / float64(width*height)
+			factors = append(factors, [3]float64{factor[0] * scale, factor[1] * scale, factor[2] * scale})
+		}
+	}
+
+	var hash strings.Builder
+	hash.WriteString(encodeBase83((xComponents-1)+(yComponents-1)*9, 1))
+
+	maxValue := 1.0
+	if len(factors) > 1 {
+		actualMax := 0.0
+		for _, factor := range factors[1:] {
+			actualMax = math.Max(actualMax, math.Max(math.Abs(factor[0]), math.Max(math.Abs(factor[1]), math.Abs(factor[2]))))
+		}
+		quantisedMax := clampInt(int(math.Floor(actualMax*166-0.5)), 0, 82)
+		maxValue = float64(quantisedMax+1) / 166
+		hash.WriteString(encodeBase83(quantisedMax, 1))
+	} else {
+		hash.WriteString(encodeBase83(0, 1))
+	}
+
+	dc := factors[0]
+	hash.WriteString(encodeBase83(linearToSRGB(dc[0])<<16+linearToSRGB(dc[1])<<8+linearToSRGB(dc[2]), 4))
+	for _, factor := range factors[1:] {
+		quant := func(v float64) int {
+			return clampInt(int(math.Floor(signPow(v/maxValue, 0.5)*9+9.5)), 0, 18)
+		}
+		hash.WriteString(encodeBase83(quant(factor[0])*19*19+quant(factor[1])*19+quant(factor[2]), 2))
+	}
+	return hash.String(), nil
+}
+
+// DecodeBlurhash paints hash at width by height
+func DecodeBlurhash(hash string, width, height int) (image.Image, error) {
+	if len(hash) < 6 {
+		return nil, fmt.Errorf("blurhash too short")
+	}
+	sizeFlag, err := decodeBase83(hash[:1])
+	if err != nil {
+		return nil, err
+	}
+	xComponents, yComponents := sizeFlag%9+1, sizeFlag/9+1
+	if len(hash) != 4+2*xComponents*yComponents {
+		return nil, fmt.Errorf("blurhash length does not match its components")
+	}
+
+	quantisedMax, err := decodeBase83(hash[1:2])
+	if err != nil {
+		return nil, err
+	}
+	maxValue := float64(quantisedMax+1) / 166
+
+	colors := make([][3]float64, xComponents*yComponents)
+	dc, err := decodeBase83(hash[2:6])
+	if err != nil {
+		return nil, err
+	}
+	colors[0] = [3]float64{sRGBToLinear(dc >> 16), sRGBToLinear((dc >> 8) & 255), sRGBToLinear(dc & 255)}
+	for i := 1; i < len(colors); i++ {
+		ac, err := decodeBase83(hash[4+i*2 : 6+i*2])
+		if err != nil {
+			return nil, err
+		}
+		unquant := func(q int) float64 {
+			return signPow(float64(q-9)/9, 2) * maxValue
+		}
+		colors[i] = [3]float64{unquant(ac / (19 * 19)), unquant((ac / 19) % 19), unquant(ac % 19)}
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			var pixel [3]float64
+			for j := 0; j < yComponents; j++ {
+				for i := 0; i < xComponents; i++ {
+					basis := math.Cos(math.Pi*float64(x)*float64(i)/float64(width)) *
+						math.Cos(math.Pi*float64(y)*float64(j)/float64(height))
+					c := colors[j*xComponents+i]
+					pixel[0] += c[0] * basis
+					pixel[1] += c[1] * basis
+					pixel[2] += c[2] * basis
+				}
+			}
+			img.SetNRGBA(x, y, color.NRGBA{
+				R: uint8(linearToSRGB(pixel[0])),
+				G: uint8(linearToSRGB(pixel[1])),
+				B: uint8(linearToSRGB(pixel[2])),
+				A: 255,
+			})
+		}
+	}
+	return img, nil
+}
+
+// BlurhashDataURI is hash painted as a small PNG data URI for an <img> placeholder, or "" if hash is unusable
+func BlurhashDataURI(hash string) string {
+	if hash == "" {
+		return ""
+	}
+	if uri, ok := blurhashPreviews.Load(hash); ok {
+		return uri.(string)
+	}
+
+	uri := ""
+	if img, err := DecodeBlurhash(hash, blurhashPreviewSize, blurhashPreviewSize); err == nil {
+		var buf bytes.Buffer
+		if png.Encode(&buf, img) == nil {
+			uri = "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes())
+		}
+	}
+	blurhashPreviews.Store(hash, uri)
+	return uri
+}
+
+func encodeBase83(value, length int) string {
+	out := make([]byte, length)
+	for i := length - 1; i >= 0; i-- {
+		out[i] = blurhashChars[value%83]
+		value /= 83
+	}
+	return string(out)
+}
+
+func decodeBase83(s string) (int, error) {
+	value := 0
+	for _, r := range s {
+		digit := strings.IndexRune(blurhashChars, r)
+		if digit < 0 {
+			return 0, fmt.Errorf("invalid blurhash character %q", r)
+		}
+		value = value*83 + digit
+	}
+	return value, nil
+}
+
+func sRGBToLinear(value int) float64 {
+	v := float64(value) / 255
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+func linearToSRGB(value float64) int {
+	v := math.Max(0, math.Min(1, value))
+	if v <= 0.0031308 {
+		return int(v*12.92*255 + 0.5)
+	}
+	return int((1.055*math.Pow(v, 1/2.4)-0.055)*255 + 0.5)
+}
+
+func signPow(value, exp float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(value), exp), value)
+}
+
+func clampInt(value, low, high int) int {
+	if value < low {
+		return low
+	}
+	if value > high {
+		return high
+	}
+	return value
+}
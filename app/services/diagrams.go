@@ -0,0 +1,134 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gomarkdown/markdown/ast"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	// diagramMissTTL keeps a diagram that failed to render from being retried on every page view
+	diagramMissTTL = 10 * time.Minute
+	// diagramFetchTimeout bounds the wait a render takes on a diagram it hasn't seen
+	diagramFetchTimeout = 5 * time.Second
+	// maxDiagramCache bounds the rendered diagrams kept; the cache starts over when it fills
+	maxDiagramCache = 500
+	maxDiagramSize  = 1 << 20
+)
+
+// diagramLanguages maps a fenced block's language to the Kroki diagram type that renders it
+var diagramLanguages = map[string]string{
+	"mermaid":  "mermaid",
+	"plantuml": "plantuml",
+	"puml":     "plantuml",
+}
+
+type diagramEntry struct {
+	svg     []byte // nil when rendering failed
+	expires time.Time
+}
+
+var (
+	diagramServer  atomic.Pointer[string]
+	diagramMu      sync.Mutex
+	diagramCache   = map[string]diagramEntry{}
+	diagramFlights singleflight.Group
+	diagramClient  = &http.Client{Timeout: diagramFetchTimeout}
+)
+
+// SetDiagramServer sets the Kroki server that turns mermaid and plantuml blocks into SVG, such as
+// "https://kroki.io"; empty leaves them as plain code. It applies to every site in the process.
+func SetDiagramServer(url string) {
+	url = strings.TrimSuffix(strings.TrimSpace(url), "/")
+	diagramServer.Store(&url)
+}
+
+// renderDiagram is the code block hook for mermaid and plantuml. The SVG is inlined as an image, so
+// nothing in it can script the page, and it shows in feeds as well. A block that can't be rendered
+// stays as its source.
+func renderDiagram(w io.Writer, block *ast.CodeBlock, language string) bool {
+	kind, ok := diagramLanguages[language]
+	if !ok {
+		return false
+	}
+	svg := cachedDiagram(kind, block.Literal)
+	if svg == nil {
+		return false
+	}
+	fmt.Fprintf(w, `<p class="diagram"><img src="data:image/svg+xml;base64,%s" alt="%s diagram" style="max-width:100%%;height:auto"></p>`,
+		base64.StdEncoding.EncodeToString(svg), kind)
+	return true
+}
+
+// cachedDiagram returns the SVG for source, rendering it when it hasn't been seen. Entries are keyed by
+// a hash of the source, so an edited diagram is simply a new entry.
+func cachedDiagram(kind string, source []byte) []byte {
+	server := diagramServer.Load()
+	if server == nil || *server == "" {
+		return nil
+	}
+	sum := sha256.Sum256(append([]byte(kind+"\n"), source...))
+	key := hex.EncodeToString(sum[:])
+
+	diagramMu.Lock()
+	entry, ok := diagramCache[key]
+	diagramMu.Unlock()
+	if ok && (entry.svg != nil || time.Now().Before(entry.expires)) {
+		return entry.svg
+	}
+
+	svg, _, _ := diagramFlights.Do(key, func() (interface{}, error) {
+		svg, err := fetchDiagram(*server, kind, source)
+		entry := diagramEntry{svg: svg}
+		if err != nil {
+			fmt.Printf("Diagram %s failed: %v\n", kind, err)
+			entry = diagramEntry{expires: time.Now().Add(diagramMissTTL)}
+		}
+		diagramMu.Lock()
+		if len(diagramCache) >= maxDiagramCache {
+			diagramCache = map[string]diagramEntry{}
+		}
+		diagramCache[key] = entry
+		diagramMu.Unlock()
+		return entry.svg, nil
+	})
+	return svg.([]byte)
+}
+
+func fetchDiagram(server, kind string, source []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), diagramFetchTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, server+"/"+kind+"/svg", bytes.NewReader(source))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "text/plain")
+	resp, err := diagramClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("diagram server returned %d", resp.StatusCode)
+	}
+	svg, err := io.ReadAll(io.LimitReader(resp.Body, maxDiagramSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(svg) > maxDiagramSize {
+		return nil, fmt.Errorf("diagram is larger than %d bytes", maxDiagramSize)
+	}
+	return svg, nil
+}
@@ -2,9 +2,12 @@ package services
 
 import (
 	"fmt"
+	"html/template"
 	"math/rand"
 	"mini-blog/app/config"
+	"mini-blog/app/models"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/resend/resend-go/v2"
@@ -91,3 +94,142 @@ func (e *EmailService) SendWelcomeEmail(email, name string, isAdmin bool) error
 	_, err := e.client.Emails.Send(params)
 	return err
 }
+
+// Send delivers an arbitrary HTML email (logged instead when Resend isn't configured)
+func (e *EmailService) Send(to, subject, html string) error {
+	return e.SendWithHeaders(to, subject, html, nil)
+}
+
+// SendWithHeaders is Send with extra message headers, such as List-Unsubscribe
+func (e *EmailService) SendWithHeaders(to, subject, html string, headers map[string]string) error {
+	if e.cfg.Auth.ResendAPIKey == "" {
+		fmt.Printf("✅ Email to %s: %s\n", to, subject)
+		return nil
+	}
+
+	params := &resend.SendEmailRequest{
+		From:    "NODELIKE <onboarding@nodelike.com>",
+		To:      []string{to},
+		Subject: subject,
+		Html:    html,
+		Headers: headers,
+	}
+
+	_, err := e.client.Emails.Send(params)
+	return err
+}
+
+// PostNewsletterHTML wraps a rendered post in the newsletter layout
+func PostNewsletterHTML(title string, body template.HTML, postURL string) string {
+	return fmt.Sprintf(`
+		<div style="font-family: Arial, sans-serif; max-width: 600px; margin: 0 auto; padding: 20px;">
+			<h2 style="color: #333;">%s</h2>
+			<div style="color: #333; line-height: 1.6;">%s</div>
+			<div style="text-align: center; margin: 30px 0;">
+				<a href="%s" style="background-color: #007bff; color: white; padding: 12px 24px; text-decoration: none; border-radius: 6px; display: inline-block;">
+					Read on NODELIKE
+				</a>
+			</div>
+			<p>Best regards,<br>NODELIKE Team</p>
+		</div>
+		`, template.HTMLEscapeString(title), body, postURL)
+}
+
+// AnnouncementHTML wraps a rendered announcement in the email layout
+func AnnouncementHTML(subject string, body template.HTML) string {
+	return fmt.Sprintf(`
+		<div style="font-family: Arial, sans-serif; max-width: 600px; margin: 0 auto; padding: 20px;">
+			<h2 style="color: #333;">%s</h2>
+			<div style="color: #333; line-height: 1.6;">%s</div>
+			<p>Best regards,<br>NODELIKE Team</p>
+		</div>
+		`, template.HTMLEscapeString(subject), body)
+}
+
+// SubscribeConfirmHTML asks a new subscriber to confirm their address before any post is sent to it
+func SubscribeConfirmHTML(confirmURL string) string {
+	return fmt.Sprintf(`
+		<div style="font-family: Arial, sans-serif; max-width: 600px; margin: 0 auto; padding: 20px;">
+			<h2 style="color: #333;">Confirm your subscription</h2>
+			<p>Someone, hopefully you, asked to get new NODELIKE posts by email at this address.</p>
+			<div style="text-align: center; margin: 30px 0;">
+				<a href="%s" style="background-color: #007bff; color: white; padding: 12px 24px; text-decoration: none; border-radius: 6px; display: inline-block;">
+					Yes, send me new posts
+				</a>
+			</div>
+			<p>If you didn't ask for this, ignore this email and you won't hear from us again.</p>
+			<p>Best regards,<br>NODELIKE Team</p>
+		</div>
+		`, confirmURL)
+}
+
+// ReauthCodeHTML carries the one-time code an admin asked for to confirm a destructive action
+func ReauthCodeHTML(name, code string) string {
+	return fmt.Sprintf(`
+		<div style="font-family: Arial, sans-serif; max-width: 600px; margin: 0 auto; padding: 20px;">
+			<h2 style="color: #333;">Confirm it's you</h2>
+			<p>Hi %s,</p>
+			<p>Use this code to confirm an admin action on NODELIKE:</p>
+			<div style="background-color: #f8f9fa; padding: 20px; border-radius: 8px; text-align: center; margin: 20px 0;">
+				<h1 style="color: #007bff; letter-spacing: 4px; margin: 0;">%s</h1>
+			</div>
+			<p>This code will expire in 10 minutes. If you didn't ask for it, change your password: someone may be signed in as you.</p>
+			<p>Best regards,<br>NODELIKE Team</p>
+		</div>
+		`, template.HTMLEscapeString(name), code)
+}
+
+// PasswordResetHTML carries a link, sent by an admin, to choose a new password
+func PasswordResetHTML(name, link string) string {
+	return fmt.Sprintf(`
+		<div style="font-family: Arial, sans-serif; max-width: 600px; margin: 0 auto; padding: 20px;">
+			<h2 style="color: #333;">Reset your password</h2>
+			<p>Hi %s,</p>
+			<p>Our support team sent you this link to choose a new password for NODELIKE:</p>
+			<p style="text-align: center; margin: 20px 0;"><a href="%s" style="background-color: #007bff; color: #fff; padding: 12px 24px; border-radius: 6px; text-decoration: none;">Choose a new password</a></p>
+			<p>The link works once and expires in 24 hours. Choosing a new password signs you out everywhere else.</p>
+			<p>Best regards,<br>NODELIKE Team</p>
+		</div>
+		`, template.HTMLEscapeString(name), template.HTMLEscapeString(link))
+}
+
+// UpNextHTML is the morning email: episodes airing today and the next one to watch of each show in progress
+func UpNextHTML(date string, airing, next []models.AiringEpisode, airingURL string) string {
+	var sections strings.Builder
+	for _, section := range []struct {
+		title    string
+		episodes []models.AiringEpisode
+	}{{"Airing today", airing}, {"Up next", next}} {
+		if len(section.episodes) == 0 {
+			continue
+		}
+		fmt.Fprintf(&sections, `<h3 style="color: #333; margin-top: 24px;">%s</h3><ul style="color: #333; line-height: 1.6; padding-left: 20px;">`, section.title)
+		for _, episode := range section.episodes {
+			fmt.Fprintf(&sections, `<li><strong>%s</strong> · S%02dE%02d %s</li>`,
+				template.HTMLEscapeString(episode.ShowTitle), episode.SeasonNumber, episode.EpisodeNumber, template.HTMLEscapeString(episode.Name))
+		}
+		sections.WriteString(`</ul>`)
+	}
+
+	return fmt.Sprintf(`
+		<div style="font-family: Arial, sans-serif; max-width: 600px; margin: 0 auto; padding: 20px;">
+			<h2 style="color: #333;">Up next for %s</h2>
+			%s
+			<div style="text-align: center; margin: 30px 0;">
+				<a href="%s" style="background-color: #007bff; color: white; padding: 12px 24px; text-decoration: none; border-radius: 6px; display: inline-block;">
+					See the week's schedule
+				</a>
+			</div>
+			<p>Best regards,<br>NODELIKE Team</p>
+		</div>
+		`, template.HTMLEscapeString(date), sections.String(), airingURL)
+}
+
+// PreferencesFooterHTML is appended to optional emails so recipients can opt out
+func PreferencesFooterHTML(preferencesURL, unsubscribeURL string) string {
+	return fmt.Sprintf(`
+		<div style="font-family: Arial, sans-serif; max-width: 600px; margin: 0 auto; padding: 20px; color: #999; font-size: 12px; text-align: center;">
+			<a href="%s" style="color: #999;">Email preferences</a> · <a href="%s" style="color: #999;">Unsubscribe</a>
+		</div>
+		`, preferencesURL, unsubscribeURL)
+}
@@ -0,0 +1,40 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+var hrefPattern = regexp.MustCompile(`href="(https?://[^"]+)"`)
+
+// NewEmailToken returns a random identifier for one recipient's copy of an email
+func NewEmailToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// TrackEmailHTML wraps every absolute link for click tracking and appends an open pixel
+func TrackEmailHTML(html, baseURL, token, secret string) string {
+	baseURL = strings.TrimSuffix(baseURL, "/")
+	html = hrefPattern.ReplaceAllStringFunc(html, func(match string) string {
+		target := hrefPattern.FindStringSubmatch(match)[1]
+		return fmt.Sprintf(`href="%s/e/c/%s?u=%s&s=%s"`, baseURL, token, url.QueryEscape(target), SignTrackedURL(secret, token, target))
+	})
+	pixel := fmt.Sprintf(`<img src="%s/e/o/%s.gif" width="1" height="1" alt="" style="display:none"/>`, baseURL, token)
+	return html + pixel
+}
+
+// SignTrackedURL binds a redirect target to a token so click links can't be used as open redirects
+func SignTrackedURL(secret, token, target string) string {
+	return SignValue(secret, "click:"+token, target)
+}
+
+// VerifyTrackedURL checks a click link signature against any of secrets
+func VerifyTrackedURL(secrets []string, token, target, signature string) bool {
+	return VerifyValue(secrets, "click:"+token, target, signature)
+}
@@ -0,0 +1,255 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gomarkdown/markdown/ast"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	// embedTTL is how long fetched card metadata is reused before it's looked up again
+	embedTTL = 24 * time.Hour
+	// embedMissTTL keeps a failed lookup from being retried on every page view
+	embedMissTTL = 10 * time.Minute
+	// embedFetchTimeout bounds the wait a render takes on a cold card
+	embedFetchTimeout = 3 * time.Second
+)
+
+// EmbedCard is the metadata behind a {{tmdb}} or {{tweet}} shortcode
+type EmbedCard struct {
+	Title       string
+	Subtitle    string
+	Description string
+	Image       string
+	URL         string
+}
+
+type embedEntry struct {
+	card    *EmbedCard // nil when the lookup failed
+	expires time.Time
+}
+
+var (
+	embedTMDB    atomic.Pointer[embedTMDBClient]
+	embedMu      sync.Mutex
+	embedCache   = map[string]embedEntry{}
+	embedFlights singleflight.Group
+	embedClient  = &http.Client{Timeout: embedFetchTimeout}
+
+	shortcodeLine = regexp.MustCompile(`\A[ \t]*\{\{[ \t]*(youtube|tmdb|tweet)[ \t]+([^{}\n]+?)[ \t]*\}\}[ \t]*(?:\r?\n|\z)`)
+	youtubeID     = regexp.MustCompile(`^[A-Za-z0-9_-]{11}$`)
+	tmdbURL       = regexp.MustCompile(`themoviedb\.org/(movie|tv)/(\d+)`)
+	tweetURL      = regexp.MustCompile(`^https?://(?:www\.|mobile\.)?(?:twitter|x)\.com/\w+/status/(\d+)`)
+	tweetText     = regexp.MustCompile(`(?s)<p[^>]*>(.*?)</p>`)
+)
+
+// embedTMDBClient is the TMDB client {{tmdb}} lookups go through, and whether its budget says to hold off
+type embedTMDBClient struct {
+	tmdb      *TMDBService
+	throttled func() bool
+}
+
+// SetEmbedTMDB gives {{tmdb}} shortcodes a TMDB client; without one they render as plain links. Pass a site's
+// own client, so lookups count towards that site's TMDB usage, and its throttle check, so they stop with its
+// background syncs when the budget is nearly spent. It applies to every site in the process; nil turns lookups off.
+func SetEmbedTMDB(tmdb *TMDBService, throttled func() bool) {
+	if tmdb == nil {
+		embedTMDB.Store(nil)
+		return
+	}
+	embedTMDB.Store(&embedTMDBClient{tmdb: tmdb, throttled: throttled})
+}
+
+// embedShortcode is a block parser hook that expands a shortcode standing alone on its line:
+// {{youtube ID}}, {{tmdb [movie|tv] ID}} and {{tweet URL}}. Shortcodes inside code blocks or
+// running text are left as written.
+func embedShortcode(data []byte) (ast.Node, []byte, int) {
+	match := shortcodeLine.FindSubmatch(data)
+	if match == nil {
+		return nil, nil, 0
+	}
+	var markup string
+	switch kind, arg := string(match[1]), strings.TrimSpace(string(match[2])); kind {
+	case "youtube":
+		markup = youtubeEmbed(arg)
+	case "tmdb":
+		markup = tmdbEmbed(arg)
+	case "tweet":
+		markup = tweetEmbed(arg)
+	}
+	if markup == "" {
+		return nil, nil, 0
+	}
+	return &ast.HTMLBlock{Leaf: ast.Leaf{Literal: []byte(markup)}}, nil, len(match[0])
+}
+
+// youtubeEmbed takes a video ID or a youtube.com / youtu.be link and plays it from the no-cookie domain
+func youtubeEmbed(arg string) string {
+	id := arg
+	if parsed, err := url.Parse(arg); err == nil && parsed.Host != "" {
+		switch strings.TrimPrefix(parsed.Host, "www.") {
+		case "youtu.be":
+			id = strings.TrimPrefix(parsed.Path, "/")
+		case "youtube.com", "m.youtube.com":
+			id = parsed.Query().Get("v")
+			if rest, ok := strings.CutPrefix(parsed.Path, "/shorts/"); ok {
+				id = rest
+			}
+		}
+	}
+	if !youtubeID.MatchString(id) {
+		return ""
+	}
+	return fmt.Sprintf(`<div class="embed embed-youtube" style="position:relative;padding-bottom:56.25%%;height:0;overflow:hidden;margin:1.5em 0">`+
+		`<iframe src="https://www.youtube-nocookie.com/embed/%s" title="YouTube video" loading="lazy" allowfullscreen `+
+		`allow="accelerometer; encrypted-media; gyroscope; picture-in-picture" style="position:absolute;inset:0;width:100%%;height:100%%;border:0"></iframe></div>`, id)
+}
+
+// tmdbEmbed takes "603", "movie 603", "tv 1396", "tv/1396" or a themoviedb.org link; a bare ID is a movie
+func tmdbEmbed(arg string) string {
+	mediaType, rawID := "movie", arg
+	if match := tmdbURL.FindStringSubmatch(arg); match != nil {
+		mediaType, rawID = match[1], match[2]
+	} else if fields := strings.FieldsFunc(arg, func(r rune) bool { return r == ' ' || r == '/' }); len(fields) == 2 {
+		mediaType, rawID = strings.ToLower(fields[0]), fields[1]
+	}
+	id, err := strconv.Atoi(rawID)
+	if err != nil || id <= 0 || (mediaType != "movie" && mediaType != "tv") {
+		return ""
+	}
+
+	link := fmt.Sprintf("https://www.themoviedb.org/%s/%d", mediaType, id)
+	card := cachedEmbed(fmt.Sprintf("tmdb:%s:%d", mediaType, id), func(ctx context.Context) (*EmbedCard, error) {
+		client := embedTMDB.Load()
+		if client == nil {
+			return nil, fmt.Errorf("no TMDB client")
+		}
+		if client.throttled() {
+			return nil, fmt.Errorf("TMDB budget nearly spent")
+		}
+		media, err := client.tmdb.WithContext(ctx).GetDetails(id, mediaType)
+		if err != nil {
+			return nil, err
+		}
+		card := &EmbedCard{Title: media.Title, Description: media.Overview, URL: link}
+		if media.ReleaseDate != nil {
+			card.Subtitle = strconv.Itoa(media.ReleaseDate.Year())
+		}
+		if media.PosterPath != "" {
+			card.Image = "https://image.tmdb.org/t/p/w154" + media.PosterPath
+		}
+		return card, nil
+	})
+	if card == nil {
+		return embedLink(link, link)
+	}
+	return embedCardHTML(card)
+}
+
+// tweetEmbed quotes a tweet from Twitter's oEmbed endpoint as our own markup: only its text and author
+// are kept, so no third-party script or HTML reaches the page
+func tweetEmbed(arg string) string {
+	match := tweetURL.FindStringSubmatch(arg)
+	if match == nil {
+		return ""
+	}
+	link := arg
+	card := cachedEmbed("tweet:"+match[1], func(ctx context.Context) (*EmbedCard, error) {
+		endpoint := "https://publish.twitter.com/oembed?omit_script=1&dnt=true&url=" + url.QueryEscape(link)
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := embedClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("oEmbed returned %d", resp.StatusCode)
+		}
+		var oembed struct {
+			URL        string `json:"url"`
+			AuthorName string `json:"author_name"`
+			HTML       string `json:"html"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&oembed); err != nil {
+			return nil, err
+		}
+		card := &EmbedCard{Title: oembed.AuthorName, URL: link}
+		if text := tweetText.FindStringSubmatch(oembed.HTML); text != nil {
+			card.Description = strings.TrimSpace(html.UnescapeString(htmlTags.ReplaceAllString(strings.ReplaceAll(text[1], "<br>", "\n"), "")))
+		}
+		return card, nil
+	})
+	if card == nil {
+		return embedLink(link, link)
+	}
+	return fmt.Sprintf(`<blockquote class="embed embed-tweet" style="border-left:3px solid #1d9bf0;margin:1.5em 0;padding:0.5em 1em">`+
+		`<p style="white-space:pre-line">%s</p><p>— <a href="%s" target="_blank" rel="noopener">%s</a></p></blockquote>`,
+		html.EscapeString(card.Description), html.EscapeString(card.URL), html.EscapeString(card.Title))
+}
+
+// cachedEmbed returns the card under key, fetching it when missing or stale. Concurrent renders of the
+// same card share one fetch. A nil card means the lookup failed; it's retried after embedMissTTL.
+func cachedEmbed(key string, fetch func(ctx context.Context) (*EmbedCard, error)) *EmbedCard {
+	embedMu.Lock()
+	entry, ok := embedCache[key]
+	embedMu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.card
+	}
+
+	card, _, _ := embedFlights.Do(key, func() (interface{}, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), embedFetchTimeout)
+		defer cancel()
+		card, err := fetch(ctx)
+		entry := embedEntry{card: card, expires: time.Now().Add(embedTTL)}
+		if err != nil {
+			fmt.Printf("Embed %s failed: %v\n", key, err)
+			entry = embedEntry{expires: time.Now().Add(embedMissTTL)}
+		}
+		embedMu.Lock()
+		embedCache[key] = entry
+		embedMu.Unlock()
+		return entry.card, nil
+	})
+	return card.(*EmbedCard)
+}
+
+func embedCardHTML(card *EmbedCard) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, `<a href="%s" target="_blank" rel="noopener" class="embed embed-card" style="display:flex;gap:1em;margin:1.5em 0;padding:0.75em;border:1px solid #e5e7eb;text-decoration:none;color:inherit">`, html.EscapeString(card.URL))
+	if card.Image != "" {
+		fmt.Fprintf(&b, `<img src="%s" alt="" loading="lazy" style="width:92px;height:auto;flex-shrink:0;margin:0">`, html.EscapeString(card.Image))
+	}
+	fmt.Fprintf(&b, `<span><strong>%s</strong>`, html.EscapeString(card.Title))
+	if card.Subtitle != "" {
+		fmt.Fprintf(&b, ` <span style="color:#6b7280">(%s)</span>`, html.EscapeString(card.Subtitle))
+	}
+	description := card.Description
+	if runes := []rune(description); len(runes) > 280 {
+		description = strings.TrimSpace(string(runes[:280])) + "…"
+	}
+	if description != "" {
+		fmt.Fprintf(&b, `<br><span style="font-size:0.9em;color:#4b5563">%s</span>`, html.EscapeString(description))
+	}
+	b.WriteString(`</span></a>`)
+	return b.String()
+}
+
+func embedLink(href, text string) string {
+	return fmt.Sprintf(`<p><a href="%s" target="_blank" rel="noopener">%s</a></p>`, html.EscapeString(href), html.EscapeString(text))
+}
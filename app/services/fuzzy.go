@@ -0,0 +1,49 @@
+package services
+
+import (
+	"strings"
+	"unicode"
+)
+
+// FuzzyScore scores how well query matches target as an in-order subsequence.
+// It returns -1 when query does not match; higher scores are better matches.
+func FuzzyScore(query, target string) int {
+	query = strings.ToLower(strings.TrimSpace(query))
+	if query == "" {
+		return 0
+	}
+
+	target = strings.ToLower(target)
+	if strings.Contains(target, query) {
+		// Substring hits outrank scattered matches, prefix hits most of all. Shorter targets rank higher,
+		// down to 0 for very long ones, since -1 means no match.
+		score := max(1000-len(target), 0)
+		if strings.HasPrefix(target, query) {
+			score += 500
+		}
+		return score
+	}
+
+	queryRunes := []rune(query)
+	score, qi, streak := 0, 0, 0
+	prev := ' '
+	for _, r := range target {
+		if qi < len(queryRunes) && r == queryRunes[qi] {
+			streak++
+			score += 10 * streak
+			// Reward matches at word boundaries ("gt" → "Game of Thrones")
+			if !unicode.IsLetter(prev) && !unicode.IsDigit(prev) {
+				score += 15
+			}
+			qi++
+		} else {
+			streak = 0
+		}
+		prev = r
+	}
+
+	if qi < len(queryRunes) {
+		return -1
+	}
+	return score
+}
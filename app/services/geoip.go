@@ -0,0 +1,139 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"mini-blog/app/config"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// geoIPTTL is how long a looked-up address is reused; failed lookups are kept as long, so an outage
+	// doesn't turn every request into another call
+	geoIPTTL = 24 * time.Hour
+	// geoIPTimeout bounds the wait a sign-in or page view takes on an address it hasn't seen
+	geoIPTimeout = 2 * time.Second
+	// maxGeoIPCache bounds the addresses kept; the cache starts over when it fills
+	maxGeoIPCache = 10000
+)
+
+// GeoLocation is where an address is, as far as GeoIP knows; either part may be empty
+type GeoLocation struct {
+	Country     string // ISO 3166 code
+	CountryName string
+	City        string
+}
+
+// String is "City, Country", or whichever of the two is known
+func (l GeoLocation) String() string {
+	switch {
+	case l.City != "" && l.CountryName != "":
+		return l.City + ", " + l.CountryName
+	case l.CountryName != "":
+		return l.CountryName
+	}
+	return l.City
+}
+
+type geoIPEntry struct {
+	location GeoLocation
+	expires  time.Time
+}
+
+// GeoIPService places client addresses through MaxMind's GeoIP2 / GeoLite2 web service, so sign-ins and
+// analytics can show a country and city. Addresses themselves are never stored.
+type GeoIPService struct {
+	cfg    *config.Config
+	client *http.Client
+	mu     sync.Mutex
+	cache  map[string]geoIPEntry
+}
+
+func NewGeoIPService(cfg *config.Config) *GeoIPService {
+	return &GeoIPService{
+		cfg:    cfg,
+		client: &http.Client{Timeout: geoIPTimeout},
+		cache:  map[string]geoIPEntry{},
+	}
+}
+
+// Enabled reports whether a MaxMind account is configured
+func (s *GeoIPService) Enabled() bool {
+	return s.cfg.GeoIP.AccountID != "" && s.cfg.GeoIP.LicenseKey != ""
+}
+
+// Lookup returns where ip is. Private and loopback addresses, and any lookup that fails, are nowhere.
+func (s *GeoIPService) Lookup(ip string) GeoLocation {
+	addr, err := netip.ParseAddr(ip)
+	if !s.Enabled() || err != nil || !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return GeoLocation{}
+	}
+	key := addr.Unmap().String()
+
+	s.mu.Lock()
+	entry, ok := s.cache[key]
+	s.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.location
+	}
+
+	location, err := s.fetch(key)
+	if err != nil {
+		fmt.Printf("GeoIP lookup failed: %v\n", err)
+	}
+	s.mu.Lock()
+	if len(s.cache) >= maxGeoIPCache {
+		s.cache = map[string]geoIPEntry{}
+	}
+	s.cache[key] = geoIPEntry{location: location, expires: time.Now().Add(geoIPTTL)}
+	s.mu.Unlock()
+	return location
+}
+
+func (s *GeoIPService) fetch(ip string) (GeoLocation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), geoIPTimeout)
+	defer cancel()
+	endpoint := strings.TrimSuffix(s.cfg.GeoIP.URL, "/") + "/geoip/v2.1/city/" + ip
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return GeoLocation{}, err
+	}
+	req.SetBasicAuth(s.cfg.GeoIP.AccountID, s.cfg.GeoIP.LicenseKey)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return GeoLocation{}, err
+	}
+	defer resp.Body.Close()
+	// 404 is an address MaxMind has nothing on, which isn't worth logging
+	if resp.StatusCode == http.StatusNotFound {
+		return GeoLocation{}, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return GeoLocation{}, fmt.Errorf("GeoIP returned %d", resp.StatusCode)
+	}
+
+	var result struct {
+		City struct {
+			Names map[string]string `json:"names"`
+		} `json:"city"`
+		Country struct {
+			ISOCode string            `json:"iso_code"`
+			Names   map[string]string `json:"names"`
+		} `json:"country"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return GeoLocation{}, err
+	}
+	return GeoLocation{
+		Country:     CountryCode(result.Country.ISOCode),
+		CountryName: result.Country.Names["en"],
+		City:        result.City.Names["en"],
+	}, nil
+}
@@ -0,0 +1,71 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"sync/atomic"
+
+	"github.com/alecthomas/chroma/v2"
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/alecthomas/chroma/v2/styles"
+	"github.com/gomarkdown/markdown/ast"
+)
+
+// DefaultCodeTheme is the chroma style code blocks get unless CODE_THEME names another
+const DefaultCodeTheme = "github"
+
+var (
+	codeStyle atomic.Pointer[chroma.Style]
+	// Inline styles, so highlighted posts need no extra stylesheet and read the same in feeds and emails
+	codeFormatter = chromahtml.New(chromahtml.WithClasses(false), chromahtml.TabWidth(4))
+)
+
+func init() {
+	codeStyle.Store(styles.Get(DefaultCodeTheme))
+}
+
+// SetCodeTheme picks the chroma style for highlighted code blocks, such as "github", "monokai" or "dracula".
+// It applies to every site in the process.
+func SetCodeTheme(name string) error {
+	style, ok := styles.Registry[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		return fmt.Errorf("unknown code theme %q (try one of %s)", name, strings.Join(styles.Names(), ", "))
+	}
+	codeStyle.Store(style)
+	return nil
+}
+
+// highlightCode is a render hook that colours fenced code blocks tagged with a language chroma knows,
+// and draws mermaid and plantuml blocks as diagrams. Other blocks fall through to the renderer's plain <pre><code>.
+func highlightCode(w io.Writer, node ast.Node, entering bool) (ast.WalkStatus, bool) {
+	block, ok := node.(*ast.CodeBlock)
+	if !ok || !entering || !block.IsFenced {
+		return ast.GoToNext, false
+	}
+	// The info string may carry more than the language, as in "go title=main.go"
+	language, _, _ := strings.Cut(strings.TrimSpace(string(block.Info)), " ")
+	if language == "" {
+		return ast.GoToNext, false
+	}
+	if renderDiagram(w, block, strings.ToLower(language)) {
+		return ast.GoToNext, true
+	}
+	lexer := lexers.Get(language)
+	if lexer == nil {
+		return ast.GoToNext, false
+	}
+
+	tokens, err := chroma.Coalesce(lexer).Tokenise(nil, string(block.Literal))
+	if err != nil {
+		return ast.GoToNext, false
+	}
+	var out bytes.Buffer
+	if err := codeFormatter.Format(&out, codeStyle.Load(), tokens); err != nil {
+		return ast.GoToNext, false
+	}
+	w.Write(out.Bytes())
+	return ast.GoToNext, true
+}
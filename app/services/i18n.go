@@ -0,0 +1,218 @@
+package services
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+
+	"mini-blog/app/models"
+)
+
+type localeContextKey struct{}
+
+// translations holds the UI string catalog keyed by locale, then message key
+var translations = map[string]map[string]string{
+	models.LocaleEnglish: {
+		"nav.home":         "Home",
+		"nav.posts":        "Posts",
+		"nav.tv":           "TV",
+		"nav.admin":        "Admin",
+		"nav.login":        "Login",
+		"nav.logout":       "Logout",
+		"nav.signup":       "Sign Up",
+		"nav.support":      "Tip Jar",
+		"posts.latest":     "Latest Posts",
+		"posts.all":        "Blog Posts",
+		"posts.create":     "Create Post",
+		"posts.empty":      "No posts found.",
+		"posts.read_more":  "Read more →",
+		"posts.view_all":   "View All Posts →",
+		"posts.back":       "← Back to all posts",
+		"posts.continue":   "Continue reading",
+		"posts.finished":   "Read",
+		"posts.like":       "Like",
+		"posts.likes":      "Likes",
+		"posts.pinned":     "Pinned",
+		"posts.search":     "Search posts by title or content...",
+		"posts.tagged":     "Posts tagged %s",
+		"tags.title":       "Tags",
+		"tags.empty":       "No tags yet.",
+		"tags.browse":      "Browse tags",
+		"comments.title":   "Comments",
+		"comments.empty":   "No comments yet.",
+		"comments.write":   "Add a comment…",
+		"comments.submit":  "Comment",
+		"comments.login":   "Log in to comment.",
+		"comments.delete":  "Delete",
+		"comments.reply":   "Reply",
+		"comments.replies": "%d replies",
+		"comments.cancel":  "Cancel",
+		"auth.login":       "Login",
+		"auth.signup":      "Sign Up",
+		"auth.verify":      "Verify Your Email",
+		"media.tracker":    "Media Tracker",
+		"media.search":     "Search media library...",
+		"media.search_all": "Search library or toggle TMDB...",
+		"media.watching":   "Currently watching",
+
+		"subscribe.title":            "Get new posts by email",
+		"subscribe.prompt":           "No account needed. We'll email you a link to confirm first.",
+		"subscribe.placeholder":      "you@example.com",
+		"subscribe.submit":           "Subscribe",
+		"subscribe.sent":             "Check your inbox for a link to confirm your subscription.",
+		"subscribe.invalid":          "Enter a valid email address.",
+		"subscribe.failed":           "We couldn't send the confirmation email. Please try again later.",
+		"subscribe.confirmed":        "You're subscribed",
+		"subscribe.confirmed_detail": "New posts will be sent to %s.",
+		"analytics.prompt":           "May we count your visit? We only record the page, the site that linked here and your country: no cookies for tracking, nothing that identifies you.",
+		"analytics.allow":            "Allow",
+		"analytics.deny":             "No thanks",
+
+		"report.link":       "Report",
+		"report.post":       "Report this post",
+		"report.reason":     "Why are you reporting this?",
+		"report.spam":       "Spam",
+		"report.harassment": "Harassment or hate",
+		"report.off_topic":  "Off-topic",
+		"report.other":      "Something else",
+		"report.details":    "Anything else we should know? (optional)",
+		"report.submit":     "Send report",
+		"report.sent":       "Thanks. An admin will take a look.",
+	},
+	models.LocaleSpanish: {
+		"nav.home":         "Inicio",
+		"nav.posts":        "Artículos",
+		"nav.tv":           "TV",
+		"nav.admin":        "Admin",
+		"nav.login":        "Entrar",
+		"nav.logout":       "Salir",
+		"nav.signup":       "Registrarse",
+		"nav.support":      "Propinas",
+		"posts.latest":     "Últimos artículos",
+		"posts.all":        "Artículos del blog",
+		"posts.create":     "Crear artículo",
+		"posts.empty":      "No se encontraron artículos.",
+		"posts.read_more":  "Leer más →",
+		"posts.view_all":   "Ver todos los artículos →",
+		"posts.back":       "← Volver a los artículos",
+		"posts.continue":   "Seguir leyendo",
+		"posts.finished":   "Leído",
+		"posts.like":       "Me gusta",
+		"posts.likes":      "Me gusta",
+		"posts.pinned":     "Destacado",
+		"posts.search":     "Buscar artículos por título o contenido...",
+		"posts.tagged":     "Artículos con la etiqueta %s",
+		"tags.title":       "Etiquetas",
+		"tags.empty":       "Todavía no hay etiquetas.",
+		"tags.browse":      "Ver etiquetas",
+		"comments.title":   "Comentarios",
+		"comments.empty":   "Todavía no hay comentarios.",
+		"comments.write":   "Escribe un comentario…",
+		"comments.submit":  "Comentar",
+		"comments.login":   "Inicia sesión para comentar.",
+		"comments.delete":  "Eliminar",
+		"comments.reply":   "Responder",
+		"comments.replies": "%d respuestas",
+		"comments.cancel":  "Cancelar",
+		"auth.login":       "Entrar",
+		"auth.signup":      "Registrarse",
+		"auth.verify":      "Verifica tu correo",
+		"media.tracker":    "Seguimiento de series",
+		"media.search":     "Buscar en la biblioteca...",
+		"media.search_all": "Buscar en la biblioteca o activar TMDB...",
+		"media.watching":   "Viendo ahora",
+
+		"subscribe.title":            "Recibe los artículos nuevos por correo",
+		"subscribe.prompt":           "No necesitas cuenta. Antes te enviaremos un enlace para confirmar.",
+		"subscribe.placeholder":      "tu@ejemplo.com",
+		"subscribe.submit":           "Suscribirme",
+		"subscribe.sent":             "Revisa tu bandeja de entrada para confirmar la suscripción.",
+		"subscribe.invalid":          "Introduce un correo electrónico válido.",
+		"subscribe.failed":           "No pudimos enviar el correo de confirmación. Inténtalo más tarde.",
+		"subscribe.confirmed":        "Ya estás suscrito",
+		"subscribe.confirmed_detail": "Los artículos nuevos se enviarán a %s.",
+		"analytics.prompt":           "¿Podemos contar tu visita? Solo guardamos la página, el sitio que te enlazó y tu país: sin cookies de seguimiento ni nada que te identifique.",
+		"analytics.allow":            "Permitir",
+		"analytics.deny":             "No, gracias",
+
+		"report.link":       "Denunciar",
+		"report.post":       "Denunciar este artículo",
+		"report.reason":     "¿Por qué lo denuncias?",
+		"report.spam":       "Spam",
+		"report.harassment": "Acoso u odio",
+		"report.off_topic":  "Fuera de tema",
+		"report.other":      "Otro motivo",
+		"report.details":    "¿Algo más que debamos saber? (opcional)",
+		"report.submit":     "Enviar denuncia",
+		"report.sent":       "Gracias. Un administrador lo revisará.",
+	},
+}
+
+// Translate returns the message for key in the given locale, falling back to English and then the key itself
+func Translate(locale, key string) string {
+	if msg, ok := translations[locale][key]; ok {
+		return msg
+	}
+	if msg, ok := translations[models.LocaleEnglish][key]; ok {
+		return msg
+	}
+	return key
+}
+
+// WithLocale stores the request locale on the context used for rendering
+func WithLocale(ctx context.Context, locale string) context.Context {
+	return context.WithValue(ctx, localeContextKey{}, locale)
+}
+
+// LocaleFromContext returns the locale set by WithLocale, defaulting to English
+func LocaleFromContext(ctx context.Context) string {
+	if locale, ok := ctx.Value(localeContextKey{}).(string); ok && locale != "" {
+		return locale
+	}
+	return models.LocaleEnglish
+}
+
+// T translates key using the locale carried on ctx (used from templates)
+func T(ctx context.Context, key string) string {
+	return Translate(LocaleFromContext(ctx), key)
+}
+
+// ParseAcceptLanguage picks the best supported locale from an Accept-Language header
+func ParseAcceptLanguage(header string) string {
+	type candidate struct {
+		locale string
+		q      float64
+	}
+
+	var candidates []candidate
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		tag := strings.ToLower(strings.TrimSpace(fields[0]))
+		if tag == "" {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range fields[1:] {
+			if value, found := strings.CutPrefix(strings.TrimSpace(param), "q="); found {
+				if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+
+		// Match on the primary subtag so "es-MX" resolves to "es"
+		base, _, _ := strings.Cut(tag, "-")
+		if models.IsValidLocale(base) {
+			candidates = append(candidates, candidate{locale: base, q: q})
+		}
+	}
+
+	if len(candidates) == 0 {
+		return ""
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].q > candidates[j].q })
+	return candidates[0].locale
+}
@@ -1,26 +1,110 @@
 package services
 
 import (
+	"html"
 	"html/template"
+	"io"
+	"regexp"
+	"strings"
 
 	"github.com/gomarkdown/markdown"
-	"github.com/gomarkdown/markdown/html"
+	"github.com/gomarkdown/markdown/ast"
+	mdhtml "github.com/gomarkdown/markdown/html"
 	"github.com/gomarkdown/markdown/parser"
 )
 
+// StripFrontmatter drops a leading "---" delimited metadata block written by a generated review draft.
+// Only blocks carrying its tmdb_id key count, so a post that opens with a horizontal rule keeps its text.
+func StripFrontmatter(markdownText string) string {
+	if match := frontBlock.FindStringIndex(markdownText); match != nil && reviewKey.MatchString(markdownText[:match[1]]) {
+		return markdownText[match[1]:]
+	}
+	return markdownText
+}
+
 func MarkdownToHTML(markdownText string) template.HTML {
+	markdownText = StripFrontmatter(markdownText)
 	if markdownText == "" {
 		return template.HTML("")
 	}
 
-	extensions := parser.CommonExtensions | parser.AutoHeadingIDs
+	// Common extensions already cover tables ("| a | b |") and definition lists ("Term" then ": definition");
+	// footnotes are "[^1]" with "[^1]: note" anywhere below
+	extensions := parser.CommonExtensions | parser.AutoHeadingIDs | parser.Footnotes
 	p := parser.NewWithExtensions(extensions)
+	p.Opts.ParserHook = func(data []byte) (ast.Node, []byte, int) {
+		if node, content, consumed := embedShortcode(data); consumed > 0 {
+			return node, content, consumed
+		}
+		return spoilerBlock(data)
+	}
+	p.RegisterInline('[', spoilerSpan(p.RegisterInline('[', nil)))
+
+	opts := mdhtml.RendererOptions{
+		Flags:                      mdhtml.CommonFlags | mdhtml.HrefTargetBlank | mdhtml.FootnoteReturnLinks,
+		FootnoteReturnLinkContents: "↩",
+		RenderNodeHook: func(w io.Writer, node ast.Node, entering bool) (ast.WalkStatus, bool) {
+			if status, handled := renderSpoiler(w, node, entering); handled {
+				return status, true
+			}
+			return highlightCode(w, node, entering)
+		},
+	}
+	renderer := mdhtml.NewRenderer(opts)
 
-	opts := html.RendererOptions{
-		Flags: html.CommonFlags | html.HrefTargetBlank,
+	htmlBytes := markdown.ToHTML([]byte(markdownText), p, renderer)
+	return template.HTML(renderTaskItems(wrapTables(string(htmlBytes))))
+}
+
+// UserMarkdownToHTML renders untrusted markdown: raw HTML is dropped, links are limited to safe
+// protocols, and "- [ ]" / "- [x]" list items become checkboxes
+func UserMarkdownToHTML(markdownText string) template.HTML {
+	if markdownText == "" {
+		return template.HTML("")
 	}
-	renderer := html.NewRenderer(opts)
+
+	p := parser.NewWithExtensions(parser.CommonExtensions)
+	p.Opts.ParserHook = spoilerBlock
+	p.RegisterInline('[', spoilerSpan(p.RegisterInline('[', nil)))
+	renderer := mdhtml.NewRenderer(mdhtml.RendererOptions{
+		Flags:          mdhtml.CommonFlags | mdhtml.SkipHTML | mdhtml.Safelink | mdhtml.HrefTargetBlank | mdhtml.NofollowLinks,
+		RenderNodeHook: renderSpoiler,
+	})
 
 	htmlBytes := markdown.ToHTML([]byte(markdownText), p, renderer)
-	return template.HTML(htmlBytes)
+	return template.HTML(renderTaskItems(string(htmlBytes)))
+}
+
+// renderTaskItems turns "- [ ]" / "- [x]" list items into disabled checkboxes. The item gets the
+// task-list-item class so the stylesheet can drop its bullet.
+func renderTaskItems(html string) string {
+	return taskItems.ReplaceAllStringFunc(html, func(item string) string {
+		tag, box := strings.Replace(item[:len(item)-4], "<li>", `<li class="task-list-item">`, 1), item[len(item)-4:]
+		if box == "[ ] " {
+			return tag + `<input type="checkbox" disabled> `
+		}
+		return tag + `<input type="checkbox" disabled checked> `
+	})
+}
+
+// wrapTables puts each table in a horizontally scrolling box, so wide tables don't stretch narrow screens
+func wrapTables(html string) string {
+	html = strings.ReplaceAll(html, "<table>", `<div class="table-wrap"><table>`)
+	return strings.ReplaceAll(html, "</table>", "</table></div>")
+}
+
+var (
+	taskItems  = regexp.MustCompile(`<li>(?:<p>)?\[[ xX]\] `)
+	frontBlock = regexp.MustCompile(`\A---\r?\n(?s:.*?)\r?\n---\r?\n`)
+	reviewKey  = regexp.MustCompile(`(?m)^tmdb_id:`)
+	htmlTags   = regexp.MustCompile(`<[^>]*>`)
+	whitespace = regexp.MustCompile(`[ \t]+`)
+	blankLines = regexp.MustCompile(`\n{3,}`)
+)
+
+// MarkdownToText renders markdown and strips it down to readable plain text
+func MarkdownToText(markdownText string) string {
+	text := htmlTags.ReplaceAllString(string(MarkdownToHTML(markdownText)), "")
+	text = whitespace.ReplaceAllString(html.UnescapeString(text), " ")
+	return strings.TrimSpace(blankLines.ReplaceAllString(text, "\n\n"))
 }
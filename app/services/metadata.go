@@ -0,0 +1,55 @@
+package services
+
+import (
+	"context"
+	"mini-blog/app/models"
+)
+
+// EpisodeSource supplies a show's seasons and episodes, stamped with its TMDB ID.
+// A TVDB series is one, standing in for shows whose TMDB episode data is wrong.
+type EpisodeSource interface {
+	GetDetailedSeasons(tmdbID int) ([]models.Season, error)
+	GetDetailedEpisodes(tmdbID int, seasonNumber int) ([]models.Episode, error)
+}
+
+// MetadataProvider is a catalogue for one or more media types: search, details, episodes and artwork.
+// Library rows are keyed by TMDB ID, so a provider maps its own IDs to and from TMDB's.
+type MetadataProvider interface {
+	EpisodeSource
+	Search(query string, mediaType string) ([]SearchResult, error)
+	GetDetails(tmdbID int, mediaType string) (*models.Media, error)
+	GetImages(tmdbID int, mediaType string) (*Images, error)
+	// Scoped returns a copy whose requests are cancelled with ctx, e.g. at a request deadline
+	Scoped(ctx context.Context) MetadataProvider
+}
+
+// Providers picks the metadata provider for each media type; types without one of their own use the fallback
+type Providers struct {
+	fallback MetadataProvider
+	byType   map[string]MetadataProvider
+}
+
+// NewProviders serves every media type from TMDB; Register plugs in others, e.g. AniList or IGDB
+func NewProviders(tmdb *TMDBService) *Providers {
+	return &Providers{fallback: tmdb, byType: map[string]MetadataProvider{}}
+}
+
+// Register serves mediaType from provider
+func (p *Providers) Register(mediaType string, provider MetadataProvider) {
+	p.byType[mediaType] = provider
+}
+
+// For returns the provider for mediaType
+func (p *Providers) For(mediaType string) MetadataProvider {
+	if provider, ok := p.byType[mediaType]; ok {
+		return provider
+	}
+	return p.fallback
+}
+
+// ForContext returns the provider for mediaType, scoped to ctx
+func (p *Providers) ForContext(ctx context.Context, mediaType string) MetadataProvider {
+	return p.For(mediaType).Scoped(ctx)
+}
+
+var _ MetadataProvider = (*TMDBService)(nil)
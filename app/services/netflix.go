@@ -0,0 +1,118 @@
+package services
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"mini-blog/app/models"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// netflixSeason matches the part of a Netflix title naming the season, e.g. "Season 2" or "Part 1"
+var netflixSeason = regexp.MustCompile(`(?i)^(?:season|series|part|volume|vol\.|chapter|book|collection|temporada|parte)\s+(\d+)$`)
+
+// netflixLimitedSeries marks single-season shows, which Netflix titles without a season number
+var netflixLimitedSeries = regexp.MustCompile(`(?i)^(?:limited series|miniseries|mini-series)$`)
+
+// NetflixTitle is a show or movie from a viewing-activity export with every time it was watched
+type NetflixTitle struct {
+	Title     string
+	MediaType string
+	Views     []models.WatchView
+}
+
+// ParseNetflixCSV reads Netflix's ViewingActivity.csv (columns Title and Date), grouping episodes by show.
+// Exports use the account's locale for dates; dayFirst reads 15/1/23 rather than 1/15/23.
+func ParseNetflixCSV(r io.Reader, dayFirst bool) ([]NetflixTitle, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, errors.New("the file is empty or not a CSV")
+	}
+	titleColumn, dateColumn := -1, -1
+	for i, name := range header {
+		switch strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))) {
+		case "title":
+			titleColumn = i
+		case "date":
+			dateColumn = i
+		}
+	}
+	if titleColumn < 0 || dateColumn < 0 {
+		return nil, errors.New("the CSV needs Title and Date columns")
+	}
+
+	var titles []NetflixTitle
+	index := map[string]int{}
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if titleColumn >= len(record) || dateColumn >= len(record) || strings.TrimSpace(record[titleColumn]) == "" {
+			continue
+		}
+		date, err := parseNetflixDate(record[dateColumn], dayFirst)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: unreadable date %q", line, record[dateColumn])
+		}
+
+		show, view := splitNetflixTitle(strings.TrimSpace(record[titleColumn]))
+		view.Date = date
+		mediaType := models.MediaTypeMovie
+		if view.Season > 0 {
+			mediaType = models.MediaTypeTV
+		}
+
+		key := mediaType + "\x00" + strings.ToLower(show)
+		i, ok := index[key]
+		if !ok {
+			i = len(titles)
+			index[key] = i
+			titles = append(titles, NetflixTitle{Title: show, MediaType: mediaType})
+		}
+		titles[i].Views = append(titles[i].Views, view)
+	}
+	return titles, nil
+}
+
+// splitNetflixTitle splits "Show: Season 1: Episode Name" into the show and the viewed episode;
+// a title without a season part is taken as a movie, colons and all
+func splitNetflixTitle(title string) (string, models.WatchView) {
+	parts := strings.Split(title, ": ")
+	for i := 1; i < len(parts); i++ {
+		season := 0
+		if match := netflixSeason.FindStringSubmatch(strings.TrimSpace(parts[i])); match != nil {
+			season, _ = strconv.Atoi(match[1])
+		} else if netflixLimitedSeries.MatchString(strings.TrimSpace(parts[i])) {
+			season = 1
+		}
+		if season > 0 {
+			return strings.Join(parts[:i], ": "), models.WatchView{Season: season, Episode: strings.Join(parts[i+1:], ": ")}
+		}
+	}
+	return title, models.WatchView{}
+}
+
+func parseNetflixDate(value string, dayFirst bool) (time.Time, error) {
+	layouts := []string{"1/2/06", "1/2/2006", "2006-01-02"}
+	if dayFirst {
+		layouts = []string{"2/1/06", "2/1/2006", "2006-01-02"}
+	}
+	value = strings.TrimSpace(value)
+	for _, layout := range layouts {
+		if date, err := time.Parse(layout, value); err == nil {
+			return date, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unreadable date %q", value)
+}
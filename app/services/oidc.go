@@ -0,0 +1,168 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mini-blog/app/config"
+	"mini-blog/app/models"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+// OIDCIdentity is who the provider says signed in
+type OIDCIdentity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+	Role          string // mapped from the role claim; "" when no mapping is configured
+}
+
+// OIDCService signs people in through a generic OpenID Connect provider with the authorization code flow and PKCE
+type OIDCService struct {
+	cfg *config.Config
+
+	mu       sync.Mutex
+	provider *oidc.Provider // discovered on first use, so the site starts while the provider is down
+}
+
+func NewOIDCService(cfg *config.Config) *OIDCService {
+	return &OIDCService{cfg: cfg}
+}
+
+// Enabled reports whether an issuer and client are configured
+func (s *OIDCService) Enabled() bool {
+	return s.cfg.OIDC.Issuer != "" && s.cfg.OIDC.ClientID != ""
+}
+
+// AuthCodeURL is where to send the browser to sign in. state, nonce and verifier must come back to Exchange.
+func (s *OIDCService) AuthCodeURL(ctx context.Context, redirectURL, state, nonce, verifier string) (string, error) {
+	oauth, err := s.oauthConfig(ctx, redirectURL)
+	if err != nil {
+		return "", err
+	}
+	return oauth.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier)), nil
+}
+
+// Exchange redeems the code from the callback and checks the ID token's signature, audience, expiry and nonce
+func (s *OIDCService) Exchange(ctx context.Context, redirectURL, code, nonce, verifier string) (OIDCIdentity, error) {
+	oauth, err := s.oauthConfig(ctx, redirectURL)
+	if err != nil {
+		return OIDCIdentity{}, err
+	}
+	token, err := oauth.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return OIDCIdentity{}, fmt.Errorf("exchange code: %w", err)
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return OIDCIdentity{}, errors.New("provider sent no id_token")
+	}
+	idToken, err := s.provider.Verifier(&oidc.Config{ClientID: s.cfg.OIDC.ClientID}).Verify(ctx, rawIDToken)
+	if err != nil {
+		return OIDCIdentity{}, fmt.Errorf("verify id_token: %w", err)
+	}
+	if idToken.Nonce != nonce {
+		return OIDCIdentity{}, errors.New("id_token nonce mismatch")
+	}
+
+	var claims map[string]interface{}
+	if err := idToken.Claims(&claims); err != nil {
+		return OIDCIdentity{}, fmt.Errorf("read id_token claims: %w", err)
+	}
+	identity := OIDCIdentity{Subject: idToken.Subject, Role: s.mapRole(claimValues(claims, s.cfg.OIDC.RoleClaim))}
+	identity.Email, _ = claims["email"].(string)
+	identity.EmailVerified, _ = claims["email_verified"].(bool)
+	identity.Name, _ = claims["name"].(string)
+	if identity.Name == "" {
+		identity.Name, _ = claims["preferred_username"].(string)
+	}
+	return identity, nil
+}
+
+func (s *OIDCService) oauthConfig(ctx context.Context, redirectURL string) (*oauth2.Config, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.provider == nil {
+		provider, err := oidc.NewProvider(ctx, strings.TrimSuffix(s.cfg.OIDC.Issuer, "/"))
+		if err != nil {
+			return nil, fmt.Errorf("discover %s: %w", s.cfg.OIDC.Issuer, err)
+		}
+		s.provider = provider
+	}
+
+	scopes := s.cfg.OIDC.Scopes
+	if !slices.Contains(scopes, oidc.ScopeOpenID) {
+		scopes = append([]string{oidc.ScopeOpenID}, scopes...)
+	}
+	return &oauth2.Config{
+		ClientID:     s.cfg.OIDC.ClientID,
+		ClientSecret: s.cfg.OIDC.ClientSecret,
+		Endpoint:     s.provider.Endpoint(),
+		RedirectURL:  redirectURL,
+		Scopes:       scopes,
+	}, nil
+}
+
+// mapRole turns role claim values into the site's role, admin winning over premium
+func (s *OIDCService) mapRole(values []string) string {
+	admin, premium := s.cfg.OIDC.AdminValues, s.cfg.OIDC.PremiumValues
+	if len(admin) == 0 && len(premium) == 0 {
+		return ""
+	}
+	for _, value := range values {
+		if slices.Contains(admin, value) {
+			return models.RoleAdmin
+		}
+	}
+	for _, value := range values {
+		if slices.Contains(premium, value) {
+			return models.RolePremium
+		}
+	}
+	return models.RoleUser
+}
+
+// claimValues reads a string or list of strings at a dotted claim path, such as "groups" or "realm_access.roles"
+func claimValues(claims map[string]interface{}, path string) []string {
+	var value interface{} = claims
+	for _, key := range strings.Split(path, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = object[key]
+	}
+
+	switch value := value.(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		var values []string
+		for _, item := range value {
+			if item, ok := item.(string); ok {
+				values = append(values, item)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+type ssoContextKey struct{}
+
+// WithSSO tells the login page to offer single sign-on, labelled with the provider's name
+func WithSSO(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, ssoContextKey{}, name)
+}
+
+// SSOFromContext returns the provider name for the login button, or "" without SSO
+func SSOFromContext(ctx context.Context) string {
+	name, _ := ctx.Value(ssoContextKey{}).(string)
+	return name
+}
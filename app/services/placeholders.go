@@ -0,0 +1,19 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ExpandPlaceholders fills post template placeholders ({{date}}, {{year}}, {{month}}, {{week}}, {{weekday}}) for t
+func ExpandPlaceholders(text string, t time.Time) string {
+	_, week := t.ISOWeek()
+	return strings.NewReplacer(
+		"{{date}}", t.Format("2006-01-02"),
+		"{{year}}", t.Format("2006"),
+		"{{month}}", t.Format("January"),
+		"{{week}}", fmt.Sprint(week),
+		"{{weekday}}", t.Format("Monday"),
+	).Replace(text)
+}
@@ -0,0 +1,62 @@
+package services
+
+import (
+	"context"
+
+	"mini-blog/app/models"
+)
+
+// Admin-only presentation fields. Templates and JSON responses ask the viewer's Policy
+// before showing any of these, instead of checking roles themselves
+const (
+	FieldVoteCounts = "votes"    // TMDB vote counts
+	FieldControls   = "controls" // tracking buttons, editors and pickers
+)
+
+var adminOnlyFields = map[string]bool{
+	FieldVoteCounts: true,
+	FieldControls:   true,
+}
+
+// Policy decides which admin-only fields and controls a viewer sees; the zero value is a public visitor
+type Policy struct {
+	admin bool
+}
+
+// PolicyFor returns the presentation policy for user (nil for anonymous visitors)
+func PolicyFor(user *models.User) Policy {
+	return Policy{admin: user != nil && user.IsAdmin()}
+}
+
+// Shows reports whether the viewer may see field
+func (p Policy) Shows(field string) bool {
+	return p.admin || !adminOnlyFields[field]
+}
+
+// Strip removes the keys of a JSON object the viewer may not see
+func (p Policy) Strip(object map[string]interface{}) map[string]interface{} {
+	for key := range object {
+		if !p.Shows(key) {
+			delete(object, key)
+		}
+	}
+	return object
+}
+
+type policyContextKey struct{}
+
+// WithPolicy stores the viewer's presentation policy for templates
+func WithPolicy(ctx context.Context, policy Policy) context.Context {
+	return context.WithValue(ctx, policyContextKey{}, policy)
+}
+
+// PolicyFromContext returns the viewer's policy, defaulting to a public visitor
+func PolicyFromContext(ctx context.Context) Policy {
+	policy, _ := ctx.Value(policyContextKey{}).(Policy)
+	return policy
+}
+
+// Shows reports whether the viewer rendering ctx may see field
+func Shows(ctx context.Context, field string) bool {
+	return PolicyFromContext(ctx).Shows(field)
+}
@@ -0,0 +1,146 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// jekyllDatePrefix matches the date Jekyll puts in front of post filenames, e.g. 2023-05-01-my-post.md
+var jekyllDatePrefix = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2})-(.+)$`)
+
+// frontMatterDateLayouts are the date formats Hugo, Jekyll and our own exports write
+var frontMatterDateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02 15:04:05 -0700",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// PostFrontMatter is the YAML header of a post in a markdown bundle. Exports fill the site's own fields;
+// imports also understand the Hugo and Jekyll spellings (draft, published, categories, description, image).
+type PostFrontMatter struct {
+	Title       string     `yaml:"title"`
+	Slug        string     `yaml:"slug,omitempty"`
+	Date        string     `yaml:"date,omitempty"`
+	Draft       bool       `yaml:"draft,omitempty"`
+	Published   *bool      `yaml:"published,omitempty"` // Jekyll's "published: false"
+	Status      string     `yaml:"status,omitempty"`
+	Visibility  string     `yaml:"visibility,omitempty"`
+	PublishAt   string     `yaml:"publish_at,omitempty"`
+	ExpiresAt   string     `yaml:"expires_at,omitempty"`
+	Category    string     `yaml:"category,omitempty"`
+	Categories  StringList `yaml:"categories,omitempty"`
+	Tags        StringList `yaml:"tags,omitempty"`
+	Description string     `yaml:"description,omitempty"`
+	Image       string     `yaml:"image,omitempty"`
+	Excerpt     string     `yaml:"excerpt,omitempty"`
+	Cover       string     `yaml:"cover,omitempty"`
+}
+
+// StringList reads a YAML list of strings, or a single string of comma- or space-separated words as Jekyll allows
+type StringList []string
+
+func (l *StringList) UnmarshalYAML(node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		separator := " "
+		if strings.Contains(node.Value, ",") {
+			separator = ","
+		}
+		*l = nil
+		for _, value := range strings.Split(node.Value, separator) {
+			if value = strings.TrimSpace(value); value != "" {
+				*l = append(*l, value)
+			}
+		}
+		return nil
+	case yaml.SequenceNode:
+		var values []string
+		if err := node.Decode(&values); err != nil {
+			return err
+		}
+		*l = values
+		return nil
+	}
+	return fmt.Errorf("line %d: expected a list of strings", node.Line)
+}
+
+// EncodePostMarkdown writes a post as YAML front matter between --- lines followed by its markdown
+func EncodePostMarkdown(front PostFrontMatter, body string) ([]byte, error) {
+	header, err := yaml.Marshal(front)
+	if err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	b.WriteString("---\n")
+	b.Write(header)
+	b.WriteString("---\n\n")
+	b.WriteString(strings.TrimSpace(body))
+	b.WriteString("\n")
+	return b.Bytes(), nil
+}
+
+// DecodePostMarkdown splits a markdown file into its YAML front matter and body.
+// Files without front matter are an error, since a post needs at least a title.
+func DecodePostMarkdown(data []byte) (PostFrontMatter, string, error) {
+	text := strings.ReplaceAll(strings.TrimPrefix(string(data), "\ufeff"), "\r\n", "\n")
+	if strings.HasPrefix(text, "+++\n") {
+		return PostFrontMatter{}, "", errors.New("TOML front matter is not supported; convert it to YAML")
+	}
+	rest, ok := strings.CutPrefix(text, "---\n")
+	if !ok {
+		return PostFrontMatter{}, "", errors.New("no front matter")
+	}
+	header, body, ok := strings.Cut(rest, "\n---")
+	if !ok {
+		return PostFrontMatter{}, "", errors.New("front matter is not closed with ---")
+	}
+	// The closing line may be "---" or "---" followed by trailing spaces
+	if line, after, _ := strings.Cut(body, "\n"); strings.TrimSpace(line) == "" {
+		body = after
+	} else {
+		return PostFrontMatter{}, "", errors.New("front matter is not closed with ---")
+	}
+
+	var front PostFrontMatter
+	if err := yaml.Unmarshal([]byte(header), &front); err != nil {
+		return PostFrontMatter{}, "", fmt.Errorf("front matter: %w", err)
+	}
+	return front, strings.TrimSpace(body), nil
+}
+
+// PostFileSlug is the slug a bundle file stands for when its front matter names none:
+// the filename without extension and Jekyll's date prefix, plus that date if there was one
+func PostFileSlug(name string) (slug, date string) {
+	base := path.Base(name)
+	if base == "index.md" || base == "_index.md" {
+		// Hugo page bundles keep the post in <slug>/index.md
+		base = path.Base(path.Dir(name))
+	}
+	base = strings.TrimSuffix(strings.TrimSuffix(base, ".md"), ".markdown")
+	if match := jekyllDatePrefix.FindStringSubmatch(base); match != nil {
+		return match[2], match[1]
+	}
+	return base, ""
+}
+
+// ParseFrontMatterDate reads a front matter date in any of the formats static site generators write,
+// in loc when the value has no zone of its own
+func ParseFrontMatterDate(value string, loc *time.Location) (time.Time, bool) {
+	value = strings.TrimSpace(value)
+	for _, layout := range frontMatterDateLayouts {
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,70 @@
+package services
+
+import (
+	"sync"
+	"time"
+)
+
+// RateLimiter allows each key a fixed number of calls per window, counted in memory
+type RateLimiter struct {
+	limit  int
+	window time.Duration
+
+	mu      sync.Mutex
+	windows map[uint]*rateWindow
+}
+
+type rateWindow struct {
+	start time.Time
+	calls int
+}
+
+// RateLimitStatus is a key's standing in its current window
+type RateLimitStatus struct {
+	Limit     int
+	Remaining int
+	Reset     time.Time
+}
+
+func NewRateLimiter(limit int, window time.Duration) *RateLimiter {
+	return &RateLimiter{limit: limit, window: window, windows: map[uint]*rateWindow{}}
+}
+
+// Allow counts a call for key, reporting false once the window's limit is spent
+func (l *RateLimiter) Allow(key uint) (RateLimitStatus, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	w := l.current(key, time.Now())
+	if w.calls >= l.limit {
+		return l.status(w), false
+	}
+	w.calls++
+	return l.status(w), true
+}
+
+// Status reports key's current window without counting a call
+func (l *RateLimiter) Status(key uint) RateLimitStatus {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.status(l.current(key, time.Now()))
+}
+
+func (l *RateLimiter) current(key uint, now time.Time) *rateWindow {
+	w, ok := l.windows[key]
+	if !ok || now.Sub(w.start) >= l.window {
+		// Finished windows are dropped on the way, so idle keys don't pile up
+		for k, old := range l.windows {
+			if now.Sub(old.start) >= l.window {
+				delete(l.windows, k)
+			}
+		}
+		w = &rateWindow{start: now}
+		l.windows[key] = w
+	}
+	return w
+}
+
+func (l *RateLimiter) status(w *rateWindow) RateLimitStatus {
+	return RateLimitStatus{Limit: l.limit, Remaining: l.limit - w.calls, Reset: w.start.Add(l.window)}
+}
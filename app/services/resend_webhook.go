@@ -0,0 +1,62 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// resendWebhookTolerance rejects webhooks signed longer ago than this, so a captured one can't be replayed later
+const resendWebhookTolerance = 5 * time.Minute
+
+// ResendEvent is the part of a Resend webhook the suppression list needs
+type ResendEvent struct {
+	Type string `json:"type"` // email.bounced, email.complained, ...
+	Data struct {
+		To     []string `json:"to"`
+		Bounce struct {
+			Type    string `json:"type"` // Permanent, Transient or Undetermined
+			SubType string `json:"subType"`
+			Message string `json:"message"`
+		} `json:"bounce"`
+	} `json:"data"`
+}
+
+// VerifyWebhook checks Resend's svix-id, svix-timestamp and svix-signature headers against the raw body,
+// using the whsec_ signing secret from RESEND_WEBHOOK_SECRET
+func (e *EmailService) VerifyWebhook(payload []byte, header http.Header) error {
+	secret := e.cfg.Auth.ResendWebhookSecret
+	if secret == "" {
+		return fmt.Errorf("webhook secret is not configured")
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(secret, "whsec_"))
+	if err != nil {
+		return fmt.Errorf("webhook secret is not a whsec_ key")
+	}
+
+	id, timestamp := header.Get("svix-id"), header.Get("svix-timestamp")
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if id == "" || err != nil {
+		return fmt.Errorf("missing webhook id or timestamp")
+	}
+	if age := time.Since(time.Unix(seconds, 0)); age > resendWebhookTolerance || age < -resendWebhookTolerance {
+		return fmt.Errorf("webhook timestamp is out of range")
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(id + "." + timestamp + "."))
+	mac.Write(payload)
+	expected := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	// Several space-separated "v1,<signature>" entries while the secret is being rotated
+	for _, entry := range strings.Fields(header.Get("svix-signature")) {
+		if version, signature, _ := strings.Cut(entry, ","); version == "v1" && hmac.Equal([]byte(expected), []byte(signature)) {
+			return nil
+		}
+	}
+	return fmt.Errorf("webhook signature mismatch")
+}
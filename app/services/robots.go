@@ -0,0 +1,37 @@
+package services
+
+import "context"
+
+type noindexContextKey struct{}
+
+// WithNoindex asks Layout to keep the page out of search engines
+func WithNoindex(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noindexContextKey{}, true)
+}
+
+// NoindexFromContext reports whether the page should carry a noindex robots meta tag
+func NoindexFromContext(ctx context.Context) bool {
+	noindex, _ := ctx.Value(noindexContextKey{}).(bool)
+	return noindex
+}
+
+// PageMeta describes a page to link previews through OpenGraph and Twitter Card tags
+type PageMeta struct {
+	Title       string
+	Description string
+	URL         string
+	Image       string // absolute URL; without one the card is a plain summary
+}
+
+type pageMetaContextKey struct{}
+
+// WithPageMeta asks Layout to emit link preview tags for the page
+func WithPageMeta(ctx context.Context, meta PageMeta) context.Context {
+	return context.WithValue(ctx, pageMetaContextKey{}, meta)
+}
+
+// PageMetaFromContext returns the page's link preview details, if it has any
+func PageMetaFromContext(ctx context.Context) (PageMeta, bool) {
+	meta, ok := ctx.Value(pageMetaContextKey{}).(PageMeta)
+	return meta, ok
+}
@@ -0,0 +1,134 @@
+package services
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mini-blog/app/config"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// S3Storage keeps files in an S3-compatible bucket, signing requests with AWS Signature Version 4.
+// Objects are addressed path-style ({endpoint}/{bucket}/{key}), which R2 and MinIO also accept.
+type S3Storage struct {
+	Bucket    string
+	Region    string
+	Endpoint  string
+	AccessKey string
+	SecretKey string
+	PublicURL string
+	client    *http.Client
+}
+
+func NewS3Storage(cfg *config.Config) *S3Storage {
+	endpoint := strings.TrimSuffix(cfg.Storage.S3Endpoint, "/")
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", cfg.Storage.S3Region)
+	}
+	publicURL := strings.TrimSuffix(cfg.Storage.S3PublicURL, "/")
+	if publicURL == "" {
+		publicURL = endpoint + "/" + cfg.Storage.S3Bucket
+	}
+	return &S3Storage{
+		Bucket:    cfg.Storage.S3Bucket,
+		Region:    cfg.Storage.S3Region,
+		Endpoint:  endpoint,
+		AccessKey: cfg.Storage.S3AccessKeyID,
+		SecretKey: cfg.Storage.S3SecretAccessKey,
+		PublicURL: publicURL,
+		client:    &http.Client{Timeout: 60 * time.Second},
+	}
+}
+
+func (s *S3Storage) Save(key string, body io.Reader, contentType string) (string, error) {
+	// The payload is hashed into the signature, so it is read up front
+	payload, err := io.ReadAll(body)
+	if err != nil {
+		return "", err
+	}
+	if err := s.do(http.MethodPut, key, payload, contentType); err != nil {
+		return "", err
+	}
+	return s.PublicURL + "/" + key, nil
+}
+
+func (s *S3Storage) Delete(key string) error {
+	return s.do(http.MethodDelete, key, nil, "")
+}
+
+func (s *S3Storage) do(method, key string, payload []byte, contentType string) error {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	objectPath := "/" + url.PathEscape(s.Bucket) + "/" + strings.Join(segments, "/")
+
+	req, err := http.NewRequest(method, s.Endpoint+objectPath, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	s.sign(req, objectPath, payload, time.Now().UTC())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("s3 request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	// A missing object is already deleted
+	if resp.StatusCode >= 300 && !(method == http.MethodDelete && resp.StatusCode == http.StatusNotFound) {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("s3 %s answered %d: %s", method, resp.StatusCode, strings.TrimSpace(string(detail)))
+	}
+	return nil
+}
+
+// sign adds SigV4 headers covering host, date and payload hash
+func (s *S3Storage) sign(req *http.Request, objectPath string, payload []byte, now time.Time) {
+	payloadHash := sha256Hex(payload)
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	const signedHeaders = "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		objectPath,
+		"",
+		"host:" + req.URL.Host + "\nx-amz-content-sha256:" + payloadHash + "\nx-amz-date:" + amzDate + "\n",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := day + "/" + s.Region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	signingKey := hmacSHA256([]byte("AWS4"+s.SecretKey), day)
+	for _, part := range []string{s.Region, "s3", "aws4_request"} {
+		signingKey = hmacSHA256(signingKey, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.AccessKey, scope, signedHeaders, signature))
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,64 @@
+package services
+
+import (
+	"fmt"
+	"mini-blog/app/config"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/sessions"
+)
+
+// sessionMaxAge keeps people signed in for a week
+const sessionMaxAge = 86400 * 7
+
+// NewSessionStore builds the cookie store behind login sessions from the SESSION_* settings.
+// Cookies are signed with SESSION_KEY and still read with SESSION_PREVIOUS_KEYS, and are re-signed
+// with the current key whenever the session is saved.
+func NewSessionStore(cfg *config.Config) (*sessions.CookieStore, error) {
+	var sameSite http.SameSite
+	switch strings.ToLower(cfg.Session.SameSite) {
+	case "lax", "":
+		sameSite = http.SameSiteLaxMode
+	case "strict":
+		sameSite = http.SameSiteStrictMode
+	case "none":
+		// Forms carry no CSRF token; SameSite is what keeps other sites from posting them as the user
+		return nil, fmt.Errorf("SESSION_SAMESITE=none would let any site submit forms as a signed-in user; use lax or strict")
+	default:
+		return nil, fmt.Errorf("SESSION_SAMESITE must be lax or strict, not %q", cfg.Session.SameSite)
+	}
+
+	var secure bool
+	switch strings.ToLower(cfg.Session.Secure) {
+	case "auto", "":
+		secure = cfg.Env == "production"
+	case "true":
+		secure = true
+	case "false":
+		secure = false
+	default:
+		return nil, fmt.Errorf("SESSION_SECURE must be auto, true or false, not %q", cfg.Session.Secure)
+	}
+	if cfg.Session.Key == "" {
+		return nil, fmt.Errorf("SESSION_KEY is empty")
+	}
+	// Each key is a hash key without an encryption key; the first one signs
+	keyPairs := [][]byte{[]byte(cfg.Session.Key), nil}
+	for _, key := range cfg.Session.PreviousKeys {
+		if key = strings.TrimSpace(key); key != "" {
+			keyPairs = append(keyPairs, []byte(key), nil)
+		}
+	}
+
+	store := sessions.NewCookieStore(keyPairs...)
+	store.Options = &sessions.Options{
+		Path:     "/",
+		Domain:   cfg.Session.Domain,
+		MaxAge:   sessionMaxAge,
+		HttpOnly: true,
+		Secure:   secure,
+		SameSite: sameSite,
+	}
+	return store, nil
+}
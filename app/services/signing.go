@@ -0,0 +1,25 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// SignValue returns a short HMAC of value scoped to purpose, for tamper-proof links
+func SignValue(secret, purpose, value string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(purpose + "|" + value))
+	return hex.EncodeToString(mac.Sum(nil))[:32]
+}
+
+// VerifyValue checks a signature produced by SignValue with any of secrets, the current key first and then
+// the ones it replaced, so links signed before a key rotation keep working
+func VerifyValue(secrets []string, purpose, value, signature string) bool {
+	for _, secret := range secrets {
+		if secret != "" && hmac.Equal([]byte(SignValue(secret, purpose, value)), []byte(signature)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,27 @@
+package services
+
+import "context"
+
+type siteModeContextKey struct{}
+
+// siteSections records which halves of the site are turned off; the zero value serves both
+type siteSections struct {
+	noBlog, noTracker bool
+}
+
+// WithSiteSections tells Layout whether the blog and the /tv tracker are served, so it only links those
+func WithSiteSections(ctx context.Context, blog, tracker bool) context.Context {
+	return context.WithValue(ctx, siteModeContextKey{}, siteSections{noBlog: !blog, noTracker: !tracker})
+}
+
+// BlogFromContext reports whether the site serves posts
+func BlogFromContext(ctx context.Context) bool {
+	sections, _ := ctx.Value(siteModeContextKey{}).(siteSections)
+	return !sections.noBlog
+}
+
+// TrackerFromContext reports whether the site serves the /tv tracker
+func TrackerFromContext(ctx context.Context) bool {
+	sections, _ := ctx.Value(siteModeContextKey{}).(siteSections)
+	return !sections.noTracker
+}
@@ -0,0 +1,129 @@
+package services
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"mini-blog/app/config"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	discordAPI = "https://discord.com/api/v10"
+	// slashCommandTolerance is how old a signed command may be before it is treated as a replay
+	slashCommandTolerance = 5 * time.Minute
+)
+
+// SlashCommandService checks Slack and Discord slash command signatures and posts late replies
+type SlashCommandService struct {
+	cfg    *config.Config
+	client *http.Client
+}
+
+func NewSlashCommandService(cfg *config.Config) *SlashCommandService {
+	return &SlashCommandService{cfg: cfg, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// SlackEnabled and DiscordEnabled report whether each platform's secret is configured
+func (s *SlashCommandService) SlackEnabled() bool {
+	return s.cfg.Integrations.SlackSigningSecret != ""
+}
+
+func (s *SlashCommandService) DiscordEnabled() bool {
+	return s.cfg.Integrations.DiscordPublicKey != ""
+}
+
+// VerifySlack checks X-Slack-Signature, an HMAC of the timestamp and raw body with the signing secret
+func (s *SlashCommandService) VerifySlack(header http.Header, body []byte) error {
+	timestamp := header.Get("X-Slack-Request-Timestamp")
+	if err := checkSlashTimestamp(timestamp); err != nil {
+		return err
+	}
+
+	mac := hmac.New(sha256.New, []byte(s.cfg.Integrations.SlackSigningSecret))
+	mac.Write([]byte("v0:" + timestamp + ":"))
+	mac.Write(body)
+	expected := "v0=" + hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(expected), []byte(header.Get("X-Slack-Signature"))) {
+		return fmt.Errorf("slack signature mismatch")
+	}
+	return nil
+}
+
+// VerifyDiscord checks X-Signature-Ed25519 against the application's public key
+func (s *SlashCommandService) VerifyDiscord(header http.Header, body []byte) error {
+	publicKey, err := hex.DecodeString(s.cfg.Integrations.DiscordPublicKey)
+	if err != nil || len(publicKey) != ed25519.PublicKeySize {
+		return fmt.Errorf("discord public key is invalid")
+	}
+	signature, err := hex.DecodeString(header.Get("X-Signature-Ed25519"))
+	if err != nil {
+		return fmt.Errorf("discord signature is not hex")
+	}
+
+	timestamp := header.Get("X-Signature-Timestamp")
+	if err := checkSlashTimestamp(timestamp); err != nil {
+		return err
+	}
+	if !ed25519.Verify(publicKey, append([]byte(timestamp), body...), signature) {
+		return fmt.Errorf("discord signature mismatch")
+	}
+	return nil
+}
+
+// ReplySlack posts a late reply to a command's response_url, visible only to whoever ran it
+func (s *SlashCommandService) ReplySlack(responseURL, text string) error {
+	target, err := url.Parse(responseURL)
+	if err != nil || target.Scheme != "https" || target.Host != "hooks.slack.com" {
+		return fmt.Errorf("unexpected slack response URL")
+	}
+	return s.send(http.MethodPost, responseURL, map[string]string{"response_type": "ephemeral", "text": text})
+}
+
+// ReplyDiscord fills in the deferred reply to an interaction
+func (s *SlashCommandService) ReplyDiscord(applicationID, token, text string) error {
+	endpoint := fmt.Sprintf("%s/webhooks/%s/%s/messages/@original", discordAPI, url.PathEscape(applicationID), url.PathEscape(token))
+	return s.send(http.MethodPatch, endpoint, map[string]string{"content": text})
+}
+
+func (s *SlashCommandService) send(method, endpoint string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(method, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("slash command reply failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("slash command reply answered %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// checkSlashTimestamp rejects missing or stale request timestamps (Unix seconds)
+func checkSlashTimestamp(timestamp string) error {
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("missing request timestamp")
+	}
+	if age := time.Since(time.Unix(seconds, 0)); age > slashCommandTolerance || age < -slashCommandTolerance {
+		return fmt.Errorf("request is too old")
+	}
+	return nil
+}
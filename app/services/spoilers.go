@@ -0,0 +1,87 @@
+package services
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/gomarkdown/markdown/ast"
+	"github.com/gomarkdown/markdown/parser"
+)
+
+var (
+	spoilerOpen  = []byte("[spoiler]")
+	spoilerClose = []byte("[/spoiler]")
+)
+
+// spoilerAttrs finish the click-to-reveal wrapper's opening tag; Layout styles .spoiler and reveals it on click
+const spoilerAttrs = ` class="spoiler" tabindex="0" title="Spoiler: click to reveal">`
+
+// SpoilerBlock is a [spoiler] ... [/spoiler] pair on lines of their own, holding whole blocks
+type SpoilerBlock struct {
+	ast.Container
+}
+
+// SpoilerSpan is [spoiler]...[/spoiler] within one paragraph
+type SpoilerSpan struct {
+	ast.Container
+}
+
+// spoilerBlock is a block parser hook for a [spoiler] line, the blocks below it and a closing [/spoiler]
+// line. The blocks between are parsed as usual, so code blocks inside stay code.
+func spoilerBlock(data []byte) (ast.Node, []byte, int) {
+	line, _, _ := bytes.Cut(data, []byte("\n"))
+	if !bytes.Equal(bytes.TrimSpace(line), spoilerOpen) {
+		return nil, nil, 0
+	}
+	start := len(line) + 1
+	for offset := start; offset < len(data); {
+		line, _, found := bytes.Cut(data[offset:], []byte("\n"))
+		if bytes.Equal(bytes.TrimSpace(line), spoilerClose) {
+			end := offset + len(line)
+			if found {
+				end++
+			}
+			return &SpoilerBlock{}, data[start:offset], end
+		}
+		if !found {
+			break
+		}
+		offset += len(line) + 1
+	}
+	return nil, nil, 0
+}
+
+// spoilerSpan wraps the inline '[' parser: [spoiler]...[/spoiler] closed within the same paragraph
+// becomes a spoiler, and anything else is left to the link parser
+func spoilerSpan(link parser.InlineParser) parser.InlineParser {
+	return func(p *parser.Parser, data []byte, offset int) (int, ast.Node) {
+		text := data[offset:]
+		if bytes.HasPrefix(text, spoilerOpen) {
+			if end := bytes.Index(text, spoilerClose); end > len(spoilerOpen) {
+				span := &SpoilerSpan{}
+				p.Inline(span, text[len(spoilerOpen):end])
+				return end + len(spoilerClose), span
+			}
+		}
+		return link(p, data, offset)
+	}
+}
+
+// renderSpoiler is a render hook writing the wrapper around a spoiler's children
+func renderSpoiler(w io.Writer, node ast.Node, entering bool) (ast.WalkStatus, bool) {
+	tag := ""
+	switch node.(type) {
+	case *SpoilerBlock:
+		tag = "div"
+	case *SpoilerSpan:
+		tag = "span"
+	default:
+		return ast.GoToNext, false
+	}
+	if entering {
+		io.WriteString(w, "<"+tag+spoilerAttrs)
+	} else {
+		io.WriteString(w, "</"+tag+">")
+	}
+	return ast.GoToNext, true
+}
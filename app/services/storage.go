@@ -0,0 +1,100 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"mini-blog/app/config"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+var nonSlugChars = regexp.MustCompile(`[^a-z0-9]+`)
+
+// Storage persists uploaded files and returns their public URL
+type Storage interface {
+	Save(key string, body io.Reader, contentType string) (string, error)
+	Delete(key string) error
+}
+
+// DiskStorage writes files under Dir and serves them from BaseURL
+type DiskStorage struct {
+	Dir     string
+	BaseURL string
+}
+
+// NewStorage picks the configured storage: an S3 bucket for STORAGE_DRIVER=s3, otherwise the local disk
+func NewStorage(cfg *config.Config) Storage {
+	if cfg.Storage.Driver == "s3" {
+		return NewS3Storage(cfg)
+	}
+	return &DiskStorage{Dir: cfg.Storage.Dir, BaseURL: strings.TrimSuffix(cfg.Storage.BaseURL, "/")}
+}
+
+func (s *DiskStorage) Save(key string, body io.Reader, contentType string) (string, error) {
+	dest := filepath.Join(s.Dir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return "", err
+	}
+
+	file, err := os.Create(dest)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(file, body); err != nil {
+		os.Remove(dest)
+		return "", err
+	}
+	return s.BaseURL + "/" + key, nil
+}
+
+func (s *DiskStorage) Delete(key string) error {
+	err := os.Remove(filepath.Join(s.Dir, filepath.FromSlash(key)))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// NewStorageKey builds a collision-free key like 2026/01/3f9a1c2b-photo.jpg. The extension follows contentType,
+// the type sniffed from the file, so a renamed file can't be served as something else; the client's own
+// extension is kept when it is one of that type's.
+func NewStorageKey(filename, contentType string) string {
+	random := make([]byte, 4)
+	rand.Read(random)
+
+	ext := storageExt(strings.ToLower(path.Ext(filename)), contentType)
+	base := strings.TrimSuffix(path.Base(filepath.ToSlash(filename)), path.Ext(filename))
+	base = strings.Trim(nonSlugChars.ReplaceAllString(strings.ToLower(base), "-"), "-")
+	if base == "" {
+		base = "file"
+	}
+	return fmt.Sprintf("%s/%s-%s%s", time.Now().Format("2006/01"), hex.EncodeToString(random), base, ext)
+}
+
+// preferredExts picks among the several extensions some types have
+var preferredExts = map[string]string{"image/jpeg": ".jpg"}
+
+// storageExt is ext if it belongs to contentType, or else the type's usual extension; none for unknown types
+func storageExt(ext, contentType string) string {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	exts, _ := mime.ExtensionsByType(mediaType)
+	switch {
+	case slices.Contains(exts, ext):
+		return ext
+	case preferredExts[mediaType] != "":
+		return preferredExts[mediaType]
+	case len(exts) > 0:
+		return exts[0]
+	}
+	return ""
+}
@@ -0,0 +1,213 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"mini-blog/app/config"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	stripeAPI = "https://api.stripe.com/v1"
+	// stripeWebhookTolerance is how old a signed webhook may be before it is treated as a replay
+	stripeWebhookTolerance = 5 * time.Minute
+)
+
+// Stripe charges most currencies in hundredths, these in whole units and these in thousandths
+var (
+	zeroDecimalCurrencies  = map[string]bool{"bif": true, "clp": true, "djf": true, "gnf": true, "jpy": true, "kmf": true, "krw": true, "mga": true, "pyg": true, "rwf": true, "ugx": true, "vnd": true, "vuv": true, "xaf": true, "xof": true, "xpf": true}
+	threeDecimalCurrencies = map[string]bool{"bhd": true, "jod": true, "kwd": true, "omr": true, "tnd": true}
+)
+
+// CurrencyDecimals is how many decimal places an amount in currency has, as Stripe counts them
+func CurrencyDecimals(currency string) int {
+	switch currency = strings.ToLower(currency); {
+	case zeroDecimalCurrencies[currency]:
+		return 0
+	case threeDecimalCurrencies[currency]:
+		return 3
+	}
+	return 2
+}
+
+// ToMinorUnits converts whole units of currency to the smallest unit Stripe expects
+func ToMinorUnits(amount int, currency string) int {
+	for range CurrencyDecimals(currency) {
+		amount *= 10
+	}
+	return amount
+}
+
+// DecimalAmount writes an amount given in currency's smallest unit in whole units, such as "12.50" or "500"
+func DecimalAmount(amount int, currency string) string {
+	decimals := CurrencyDecimals(currency)
+	return strconv.FormatFloat(float64(amount)/math.Pow10(decimals), 'f', decimals, 64)
+}
+
+// FormatAmount is DecimalAmount with the currency code, such as "12.50 USD" or "500 JPY"
+func FormatAmount(amount int, currency string) string {
+	return DecimalAmount(amount, currency) + " " + strings.ToUpper(currency)
+}
+
+// StripeService takes one-time tips through Stripe Checkout
+type StripeService struct {
+	cfg    *config.Config
+	client *http.Client
+}
+
+// CheckoutSession is the part of a Stripe Checkout Session the app records
+type CheckoutSession struct {
+	ID                string `json:"id"`
+	URL               string `json:"url"`
+	PaymentStatus     string `json:"payment_status"` // "paid" once the money has moved
+	AmountTotal       int    `json:"amount_total"`   // in the currency's smallest unit
+	Currency          string `json:"currency"`
+	ClientReferenceID string `json:"client_reference_id"`
+	Metadata          struct {
+		Coupon string `json:"coupon"`
+	} `json:"metadata"`
+	CustomerDetails struct {
+		Email string `json:"email"`
+	} `json:"customer_details"`
+}
+
+// Paid reports whether the session's payment succeeded
+func (s CheckoutSession) Paid() bool {
+	return s.PaymentStatus == "paid"
+}
+
+func NewStripeService(cfg *config.Config) *StripeService {
+	return &StripeService{
+		cfg:    cfg,
+		client: &http.Client{Timeout: 15 * time.Second},
+	}
+}
+
+// Enabled reports whether a Stripe key is configured
+func (s *StripeService) Enabled() bool {
+	return s.cfg.Payments.StripeSecretKey != ""
+}
+
+// CreateCheckoutSession starts a one-time payment of amount (smallest currency unit).
+// successURL may contain {CHECKOUT_SESSION_ID}, which Stripe fills in; reference comes back as ClientReferenceID
+// and coupon, the code of a discount already taken off amount, as Metadata.Coupon.
+func (s *StripeService) CreateCheckoutSession(amount int, successURL, cancelURL, reference, email, coupon string) (*CheckoutSession, error) {
+	form := url.Values{
+		"mode":                                   {"payment"},
+		"submit_type":                            {"donate"},
+		"success_url":                            {successURL},
+		"cancel_url":                             {cancelURL},
+		"line_items[0][quantity]":                {"1"},
+		"line_items[0][price_data][currency]":    {s.cfg.Payments.Currency},
+		"line_items[0][price_data][unit_amount]": {strconv.Itoa(amount)},
+		"line_items[0][price_data][product_data][name]":        {"Tip"},
+		"line_items[0][price_data][product_data][description]": {"A one-time thank you"},
+	}
+	if reference != "" {
+		form.Set("client_reference_id", reference)
+	}
+	if email != "" {
+		form.Set("customer_email", email)
+	}
+	if coupon != "" {
+		form.Set("metadata[coupon]", coupon)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, stripeAPI+"/checkout/sessions", strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return s.do(req)
+}
+
+// GetCheckoutSession fetches a session by ID, e.g. when the payer lands on the thank-you page
+func (s *StripeService) GetCheckoutSession(id string) (*CheckoutSession, error) {
+	req, err := http.NewRequest(http.MethodGet, stripeAPI+"/checkout/sessions/"+url.PathEscape(id), nil)
+	if err != nil {
+		return nil, err
+	}
+	return s.do(req)
+}
+
+func (s *StripeService) do(req *http.Request) (*CheckoutSession, error) {
+	if !s.Enabled() {
+		return nil, fmt.Errorf("payments are not configured")
+	}
+	req.Header.Set("Authorization", "Bearer "+s.cfg.Payments.StripeSecretKey)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Stripe request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Stripe error: %d", resp.StatusCode)
+	}
+	var session CheckoutSession
+	if err := json.NewDecoder(resp.Body).Decode(&session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// VerifyWebhook checks a Stripe-Signature header against the raw request body
+func (s *StripeService) VerifyWebhook(payload []byte, header string) error {
+	secret := s.cfg.Payments.StripeWebhookSecret
+	if secret == "" {
+		return fmt.Errorf("webhook secret is not configured")
+	}
+
+	var timestamp string
+	var signatures []string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(part, "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("missing webhook timestamp")
+	}
+	if time.Since(time.Unix(seconds, 0)) > stripeWebhookTolerance {
+		return fmt.Errorf("webhook is too old")
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(payload)
+	expected := hex.EncodeToString(mac.Sum(nil))
+	for _, signature := range signatures {
+		if hmac.Equal([]byte(expected), []byte(signature)) {
+			return nil
+		}
+	}
+	return fmt.Errorf("webhook signature mismatch")
+}
+
+type tipJarContextKey struct{}
+
+// WithTipJar tells Layout that tips are configured, so it links the tip page
+func WithTipJar(ctx context.Context) context.Context {
+	return context.WithValue(ctx, tipJarContextKey{}, true)
+}
+
+// TipJarFromContext reports whether the tip page is available
+func TipJarFromContext(ctx context.Context) bool {
+	enabled, _ := ctx.Value(tipJarContextKey{}).(bool)
+	return enabled
+}
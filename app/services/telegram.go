@@ -0,0 +1,71 @@
+package services
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"mini-blog/app/config"
+	"net/http"
+	"time"
+)
+
+const telegramAPI = "https://api.telegram.org"
+
+// TelegramService talks to the admin through a Telegram bot
+type TelegramService struct {
+	cfg    *config.Config
+	client *http.Client
+}
+
+// TelegramUpdate is the part of an incoming bot update the app reads
+type TelegramUpdate struct {
+	Message *struct {
+		Text string `json:"text"`
+		Chat struct {
+			ID int64 `json:"id"`
+		} `json:"chat"`
+	} `json:"message"`
+}
+
+func NewTelegramService(cfg *config.Config) *TelegramService {
+	return &TelegramService{cfg: cfg, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// Enabled reports whether the bot is configured with a token, a webhook secret and the admin's chat
+func (s *TelegramService) Enabled() bool {
+	return s.cfg.Telegram.BotToken != "" && s.cfg.Telegram.WebhookSecret != "" && s.cfg.Telegram.ChatID != 0
+}
+
+// VerifyWebhook checks the secret_token Telegram echoes on every webhook call
+func (s *TelegramService) VerifyWebhook(header http.Header) error {
+	token := header.Get("X-Telegram-Bot-Api-Secret-Token")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(s.cfg.Telegram.WebhookSecret)) != 1 {
+		return fmt.Errorf("telegram secret token mismatch")
+	}
+	return nil
+}
+
+// IsAdminChat reports whether a message came from the configured chat
+func (s *TelegramService) IsAdminChat(chatID int64) bool {
+	return chatID == s.cfg.Telegram.ChatID
+}
+
+// SendMessage posts plain text to the admin's chat
+func (s *TelegramService) SendMessage(text string) error {
+	body, err := json.Marshal(map[string]interface{}{"chat_id": s.cfg.Telegram.ChatID, "text": text})
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Post(telegramAPI+"/bot"+s.cfg.Telegram.BotToken+"/sendMessage", "application/json", bytes.NewReader(body))
+	if err != nil {
+		// The URL carries the token; don't let it reach the logs
+		return fmt.Errorf("telegram request failed")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("telegram error: %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,22 @@
+package services
+
+import (
+	"context"
+
+	"mini-blog/app/models"
+)
+
+type themeContextKey struct{}
+
+// WithTheme stores the viewer's theme so Layout can render it without a client-side flash
+func WithTheme(ctx context.Context, theme string) context.Context {
+	return context.WithValue(ctx, themeContextKey{}, theme)
+}
+
+// ThemeFromContext returns the viewer's theme, defaulting to light
+func ThemeFromContext(ctx context.Context) string {
+	if theme, ok := ctx.Value(themeContextKey{}).(string); ok && models.IsValidTheme(theme) {
+		return theme
+	}
+	return models.ThemeLight
+}
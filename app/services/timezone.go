@@ -0,0 +1,76 @@
+package services
+
+import (
+	"context"
+	"time"
+	_ "time/tzdata" // Embed the zone database so user timezones resolve on minimal hosts
+
+	"mini-blog/app/models"
+)
+
+type timezoneContextKey struct{}
+type dateFormatContextKey struct{}
+
+// dateLayouts maps a date format preference to its long and short layouts
+var dateLayouts = map[string][2]string{
+	models.DateFormatUS:  {"January 2, 2006", "Jan 2, 2006"},
+	models.DateFormatEU:  {"2 January 2006", "2 Jan 2006"},
+	models.DateFormatISO: {"2006-01-02", "2006-01-02"},
+}
+
+// LoadTimezone resolves an IANA zone name, falling back to UTC for empty or unknown names
+func LoadTimezone(name string) *time.Location {
+	if name == "" {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// WithTimezone stores the viewer's timezone and date format preference on the render context
+func WithTimezone(ctx context.Context, loc *time.Location, dateFormat string) context.Context {
+	ctx = context.WithValue(ctx, timezoneContextKey{}, loc)
+	return context.WithValue(ctx, dateFormatContextKey{}, dateFormat)
+}
+
+// TimezoneFromContext returns the viewer's timezone, defaulting to UTC
+func TimezoneFromContext(ctx context.Context) *time.Location {
+	if loc, ok := ctx.Value(timezoneContextKey{}).(*time.Location); ok && loc != nil {
+		return loc
+	}
+	return time.UTC
+}
+
+// FormatDate renders t in the viewer's timezone using their preferred "long" or "short" layout
+func FormatDate(ctx context.Context, t time.Time, style string) string {
+	layouts, ok := dateLayouts[dateFormatFromContext(ctx)]
+	if !ok {
+		layouts = dateLayouts[models.DateFormatUS]
+	}
+
+	layout := layouts[0]
+	if style == "short" {
+		layout = layouts[1]
+	}
+	return t.In(TimezoneFromContext(ctx)).Format(layout)
+}
+
+// FormatCalendarDate formats a date-only value (like an air date) without shifting it across zones
+func FormatCalendarDate(ctx context.Context, t time.Time, style string) string {
+	return FormatDate(WithTimezone(ctx, time.UTC, dateFormatFromContext(ctx)), t, style)
+}
+
+// Today returns the viewer's current calendar date as a UTC midnight, matching how air dates are stored,
+// so "air_date <= Today(loc)" is the aired check for that viewer
+func Today(loc *time.Location) time.Time {
+	now := time.Now().In(loc)
+	return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+func dateFormatFromContext(ctx context.Context) string {
+	format, _ := ctx.Value(dateFormatContextKey{}).(string)
+	return format
+}
@@ -1,8 +1,12 @@
 package services
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
 	"net/http"
 	"net/url"
 	"sync/atomic"
@@ -13,10 +17,15 @@ import (
 
 var tmdbCallCounter int64
 
+// certificationRegion is the country whose rating board certifications are stored
+const certificationRegion = "US"
+
 type TMDBService struct {
 	BearerToken string
 	BaseURL     string
 	client      *http.Client
+	ctx         context.Context
+	usage       *CallMeter // shared by WithContext copies
 }
 
 func NewTMDBService(bearerToken string) *TMDBService {
@@ -24,16 +33,40 @@ func NewTMDBService(bearerToken string) *TMDBService {
 		BearerToken: bearerToken,
 		BaseURL:     "https://api.themoviedb.org/3",
 		client:      &http.Client{Timeout: 10 * time.Second},
+		usage:       NewCallMeter(),
 	}
 }
 
+// DrainUsage returns the calls made since it was last called, by UTC hour
+func (s *TMDBService) DrainUsage() map[time.Time]int64 {
+	return s.usage.Drain()
+}
+
+// WithContext returns a copy whose requests are cancelled with ctx, e.g. at a request deadline
+func (s *TMDBService) WithContext(ctx context.Context) *TMDBService {
+	scoped := *s
+	scoped.ctx = ctx
+	return &scoped
+}
+
+// Scoped is WithContext for callers holding TMDB as a MetadataProvider
+func (s *TMDBService) Scoped(ctx context.Context) MetadataProvider {
+	return s.WithContext(ctx)
+}
+
 // Consolidated HTTP request method to eliminate duplication
 func (s *TMDBService) doRequest(url string, target interface{}) error {
 	// Simple TMDB API call counter and logging
 	count := atomic.AddInt64(&tmdbCallCounter, 1)
 	fmt.Printf("🌐 TMDB API CALL #%d: %s\n", count, url)
+	s.usage.Add()
+
+	ctx := s.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
 
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return err
 	}
@@ -108,10 +141,13 @@ func (s *TMDBService) GetDetails(tmdbID int, mediaType string) (*models.Media, e
 		return nil, fmt.Errorf("invalid media type: %s", mediaType)
 	}
 
-	u := fmt.Sprintf("%s/%s/%d", s.BaseURL, endpoint, tmdbID)
+	// Certifications come from release_dates for movies and content_ratings for TV; keywords help spot anime;
+	// external_ids links a show to TVDB
+	u := fmt.Sprintf("%s/%s/%d?append_to_response=release_dates,content_ratings,keywords,external_ids", s.BaseURL, endpoint, tmdbID)
 
 	var details struct {
 		ID           int    `json:"id"`
+		Adult        bool   `json:"adult"`
 		Title        string `json:"title,omitempty"`
 		Name         string `json:"name,omitempty"`
 		Overview     string `json:"overview"`
@@ -123,9 +159,36 @@ func (s *TMDBService) GetDetails(tmdbID int, mediaType string) (*models.Media, e
 			ID   int    `json:"id"`
 			Name string `json:"name"`
 		} `json:"genres"`
-		Popularity  float64 `json:"popularity"`
-		VoteCount   int     `json:"vote_count"`
-		VoteAverage float64 `json:"vote_average"`
+		Popularity   float64 `json:"popularity"`
+		VoteCount    int     `json:"vote_count"`
+		VoteAverage  float64 `json:"vote_average"`
+		ReleaseDates struct {
+			Results []struct {
+				Country      string `json:"iso_3166_1"`
+				ReleaseDates []struct {
+					Certification string `json:"certification"`
+				} `json:"release_dates"`
+			} `json:"results"`
+		} `json:"release_dates"`
+		ContentRatings struct {
+			Results []struct {
+				Country string `json:"iso_3166_1"`
+				Rating  string `json:"rating"`
+			} `json:"results"`
+		} `json:"content_ratings"`
+		OriginalLanguage    string   `json:"original_language"`
+		OriginCountry       []string `json:"origin_country"`
+		ProductionCountries []struct {
+			Country string `json:"iso_3166_1"`
+		} `json:"production_countries"`
+		// Movies list keywords under "keywords", TV under "results"
+		Keywords struct {
+			Keywords []tmdbKeyword `json:"keywords"`
+			Results  []tmdbKeyword `json:"results"`
+		} `json:"keywords"`
+		ExternalIDs struct {
+			TVDBID int `json:"tvdb_id"`
+		} `json:"external_ids"`
 	}
 
 	if err := s.doRequest(u, &details); err != nil {
@@ -159,64 +222,196 @@ func (s *TMDBService) GetDetails(tmdbID int, mediaType string) (*models.Media, e
 		}
 	}
 
+	certification := ""
+	for _, country := range details.ReleaseDates.Results {
+		if country.Country != certificationRegion {
+			continue
+		}
+		for _, release := range country.ReleaseDates {
+			if release.Certification != "" {
+				certification = release.Certification
+				break
+			}
+		}
+	}
+	for _, rating := range details.ContentRatings.Results {
+		if rating.Country == certificationRegion && rating.Rating != "" {
+			certification = rating.Rating
+		}
+	}
+
+	var genreIDs []int
+	for _, genre := range details.Genres {
+		genreIDs = append(genreIDs, genre.ID)
+	}
+	countries := details.OriginCountry
+	for _, country := range details.ProductionCountries {
+		countries = append(countries, country.Country)
+	}
+	anime := detectAnime(genreIDs, details.OriginalLanguage, countries, append(details.Keywords.Keywords, details.Keywords.Results...))
+
 	return &models.Media{
-		TMDBID:       details.ID,
-		Type:         mediaType,
-		Title:        title,
-		Overview:     details.Overview,
-		PosterPath:   details.PosterPath,
-		ReleaseDate:  releaseDate,
-		Genres:       string(genresJSON),
-		Popularity:   details.Popularity,
-		VoteCount:    details.VoteCount,
-		VoteAverage:  details.VoteAverage,
-		InProduction: inProduction,
+		TMDBID:        details.ID,
+		IsAnime:       anime,
+		AnimeDetected: anime,
+		Certification: certification,
+		Adult:         details.Adult,
+		Type:          mediaType,
+		Title:         title,
+		Overview:      details.Overview,
+		PosterPath:    details.PosterPath,
+		ReleaseDate:   releaseDate,
+		Genres:        string(genresJSON),
+		Popularity:    details.Popularity,
+		VoteCount:     details.VoteCount,
+		VoteAverage:   details.VoteAverage,
+		InProduction:  inProduction,
+		TVDBID:        details.ExternalIDs.TVDBID,
 	}, nil
 }
 
-// Season represents a TV show season
-type Season struct {
-	SeasonNumber int    `json:"season_number"`
-	Name         string `json:"name"`
-	EpisodeCount int    `json:"episode_count"`
-	AirDate      string `json:"air_date"`
-	PosterPath   string `json:"poster_path"`
+const (
+	tmdbGenreAnimation = 16
+	tmdbKeywordAnime   = 210024
+)
+
+type tmdbKeyword struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
 }
 
-// Episode represents a TV show episode
-type Episode struct {
-	EpisodeNumber int    `json:"episode_number"`
-	Name          string `json:"name"`
-	Overview      string `json:"overview"`
-	AirDate       string `json:"air_date"`
-	Runtime       int    `json:"runtime"`
-	StillPath     string `json:"still_path"`
+// detectAnime treats a title as anime when TMDB tags it with the anime keyword, or when it is
+// animated and Japanese by language or country of origin
+func detectAnime(genreIDs []int, language string, countries []string, keywords []tmdbKeyword) bool {
+	for _, keyword := range keywords {
+		if keyword.ID == tmdbKeywordAnime {
+			return true
+		}
+	}
+
+	animated := false
+	for _, id := range genreIDs {
+		animated = animated || id == tmdbGenreAnimation
+	}
+	if !animated {
+		return false
+	}
+	if language == "ja" {
+		return true
+	}
+	for _, country := range countries {
+		if country == "JP" {
+			return true
+		}
+	}
+	return false
 }
 
-// GetSeasons fetches all seasons for a TV show
-func (s *TMDBService) GetSeasons(tmdbID int) ([]Season, error) {
-	u := fmt.Sprintf("%s/tv/%d", s.BaseURL, tmdbID)
+// Image is one poster or backdrop TMDB has for a title
+type Image struct {
+	FilePath    string  `json:"file_path"`
+	Language    string  `json:"iso_639_1"`
+	Width       int     `json:"width"`
+	Height      int     `json:"height"`
+	VoteAverage float64 `json:"vote_average"`
+}
 
-	var details struct {
-		Seasons []Season `json:"seasons"`
+// Images lists a title's alternate artwork, best voted first
+type Images struct {
+	Posters   []Image `json:"posters"`
+	Backdrops []Image `json:"backdrops"`
+}
+
+// GetImages fetches every poster and backdrop TMDB has for a title
+func (s *TMDBService) GetImages(tmdbID int, mediaType string) (*Images, error) {
+	if !models.IsValidMediaType(mediaType) {
+		return nil, fmt.Errorf("invalid media type: %s", mediaType)
 	}
-	if err := s.doRequest(u, &details); err != nil {
+
+	var images Images
+	if err := s.doRequest(fmt.Sprintf("%s/%s/%d/images", s.BaseURL, mediaType, tmdbID), &images); err != nil {
 		return nil, err
 	}
-	return details.Seasons, nil
+	return &images, nil
 }
 
-// GetEpisodes fetches all episodes for a specific season
-func (s *TMDBService) GetEpisodes(tmdbID int, seasonNumber int) ([]Episode, error) {
-	u := fmt.Sprintf("%s/tv/%d/season/%d", s.BaseURL, tmdbID, seasonNumber)
+// Placeholder hashes are taken from TMDB's smallest useful rendition
+const blurhashImageBase = "https://image.tmdb.org/t/p/w92"
 
-	var seasonData struct {
-		Episodes []Episode `json:"episodes"`
+// ImageBlurhash downloads a TMDB image (a poster or still path) and encodes it as a blurhash
+// with xComponents by yComponents components, for a placeholder while the full image loads
+func (s *TMDBService) ImageBlurhash(path string, xComponents, yComponents int) (string, error) {
+	ctx := s.ctx
+	if ctx == nil {
+		ctx = context.Background()
 	}
-	if err := s.doRequest(u, &seasonData); err != nil {
+	req, err := http.NewRequestWithContext(ctx, "GET", blurhashImageBase+path, nil)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("TMDB image request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("TMDB image error: %d", resp.StatusCode)
+	}
+
+	img, _, err := image.Decode(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode image: %w", err)
+	}
+	return EncodeBlurhash(img, xComponents, yComponents)
+}
+
+// WatchProvider is a streaming, rental or purchase service offering a title
+type WatchProvider struct {
+	ID       int    `json:"provider_id"`
+	Name     string `json:"provider_name"`
+	LogoPath string `json:"logo_path"`
+}
+
+// WatchOffers are the providers for a title in one region; Link is the region's
+// JustWatch-powered TMDB page, which sends viewers on to each provider
+type WatchOffers struct {
+	Region   string          `json:"-"`
+	Link     string          `json:"link"`
+	Flatrate []WatchProvider `json:"flatrate"`
+	Free     []WatchProvider `json:"free"`
+	Ads      []WatchProvider `json:"ads"`
+	Rent     []WatchProvider `json:"rent"`
+	Buy      []WatchProvider `json:"buy"`
+}
+
+// Stream lists the providers that include the title without a separate rental or purchase
+func (o WatchOffers) Stream() []WatchProvider {
+	return append(append(append([]WatchProvider{}, o.Flatrate...), o.Free...), o.Ads...)
+}
+
+// GetWatchProviders fetches where a title can be watched in each of regions (ISO 3166-1 codes), in that
+// order; regions without offers are left out
+func (s *TMDBService) GetWatchProviders(tmdbID int, mediaType string, regions []string) ([]WatchOffers, error) {
+	if !models.IsValidMediaType(mediaType) {
+		return nil, fmt.Errorf("invalid media type: %s", mediaType)
+	}
+
+	var data struct {
+		Results map[string]WatchOffers `json:"results"`
+	}
+	if err := s.doRequest(fmt.Sprintf("%s/%s/%d/watch/providers", s.BaseURL, mediaType, tmdbID), &data); err != nil {
 		return nil, err
 	}
-	return seasonData.Episodes, nil
+
+	var offers []WatchOffers
+	for _, region := range regions {
+		if regionOffers, ok := data.Results[region]; ok && regionOffers.Link != "" {
+			regionOffers.Region = region
+			offers = append(offers, regionOffers)
+		}
+	}
+	return offers, nil
 }
 
 // GetDetailedSeasons fetches seasons and maps to our local Season model
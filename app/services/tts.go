@@ -0,0 +1,113 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mini-blog/app/config"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ttsChunkSize stays under the provider's per-request input limit
+const ttsChunkSize = 4000
+
+// TTSService turns post text into MP3 audio through the configured provider
+type TTSService struct {
+	cfg    *config.Config
+	client *http.Client
+}
+
+func NewTTSService(cfg *config.Config) *TTSService {
+	return &TTSService{
+		cfg:    cfg,
+		client: &http.Client{Timeout: 2 * time.Minute},
+	}
+}
+
+// Enabled reports whether a narration provider is configured
+func (s *TTSService) Enabled() bool {
+	return s.cfg.TTS.Provider != "" && s.cfg.TTS.APIKey != ""
+}
+
+// Synthesize returns MP3 audio for text, splitting long input into chunks.
+// MP3 frames concatenate cleanly, so chunk audio is simply appended.
+func (s *TTSService) Synthesize(text string) ([]byte, error) {
+	if !s.Enabled() {
+		return nil, fmt.Errorf("narration is not configured")
+	}
+	if s.cfg.TTS.Provider != "openai" {
+		return nil, fmt.Errorf("unsupported TTS provider: %s", s.cfg.TTS.Provider)
+	}
+
+	var audio bytes.Buffer
+	for _, chunk := range splitForSpeech(text, ttsChunkSize) {
+		part, err := s.openAISpeech(chunk)
+		if err != nil {
+			return nil, err
+		}
+		audio.Write(part)
+	}
+	return audio.Bytes(), nil
+}
+
+func (s *TTSService) openAISpeech(input string) ([]byte, error) {
+	body, _ := json.Marshal(map[string]string{
+		"model":           s.cfg.TTS.Model,
+		"voice":           s.cfg.TTS.Voice,
+		"input":           input,
+		"response_format": "mp3",
+	})
+
+	req, err := http.NewRequest(http.MethodPost, "https://api.openai.com/v1/audio/speech", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+s.cfg.TTS.APIKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("TTS request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("TTS error: %d", resp.StatusCode)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// splitForSpeech breaks text into chunks of at most size bytes at paragraph or sentence boundaries
+func splitForSpeech(text string, size int) []string {
+	var chunks []string
+	var current strings.Builder
+
+	flush := func() {
+		if current.Len() > 0 {
+			chunks = append(chunks, strings.TrimSpace(current.String()))
+			current.Reset()
+		}
+	}
+
+	for _, sentence := range strings.SplitAfter(text, ". ") {
+		for len(sentence) > size {
+			flush()
+			cut := size
+			for cut > 0 && !utf8.RuneStart(sentence[cut]) {
+				cut--
+			}
+			chunks = append(chunks, sentence[:cut])
+			sentence = sentence[cut:]
+		}
+		if current.Len()+len(sentence) > size {
+			flush()
+		}
+		current.WriteString(sentence)
+	}
+	flush()
+	return chunks
+}
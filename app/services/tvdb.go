@@ -0,0 +1,248 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mini-blog/app/config"
+	"mini-blog/app/models"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	tvdbAPI = "https://api4.thetvdb.com/v4"
+	// tvdbTokenLifetime is kept under the month TVDB tokens last
+	tvdbTokenLifetime = 25 * 24 * time.Hour
+	// tvdbMaxPages bounds paging through a series' episodes (500 per page)
+	tvdbMaxPages = 20
+)
+
+var errTVDBUnauthorized = errors.New("tvdb rejected the token")
+
+// TVDBService reads episode data from TheTVDB's v4 API
+type TVDBService struct {
+	cfg    *config.Config
+	client *http.Client
+
+	mu           sync.Mutex
+	token        string
+	tokenExpires time.Time
+}
+
+func NewTVDBService(cfg *config.Config) *TVDBService {
+	return &TVDBService{cfg: cfg, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// Enabled reports whether a TVDB API key is configured
+func (s *TVDBService) Enabled() bool {
+	return s.cfg.TVDB.APIKey != ""
+}
+
+// Series is the episode source for one TVDB series, in aired order. It fetches every episode
+// on first use, so one value serves a whole sync.
+func (s *TVDBService) Series(tvdbID int) EpisodeSource {
+	return &tvdbSeries{service: s, tvdbID: tvdbID}
+}
+
+type tvdbSeries struct {
+	service  *TVDBService
+	tvdbID   int
+	episodes []tvdbEpisode
+	loaded   bool
+}
+
+type tvdbEpisode struct {
+	SeasonNumber int    `json:"seasonNumber"`
+	Number       int    `json:"number"`
+	Name         string `json:"name"`
+	Overview     string `json:"overview"`
+	Aired        string `json:"aired"`
+	Runtime      int    `json:"runtime"`
+}
+
+// GetDetailedSeasons derives seasons from the episodes, since TVDB lists every season type separately
+func (t *tvdbSeries) GetDetailedSeasons(tmdbID int) ([]models.Season, error) {
+	if err := t.load(); err != nil {
+		return nil, err
+	}
+
+	bySeason := map[int]*models.Season{}
+	for _, episode := range t.episodes {
+		season, ok := bySeason[episode.SeasonNumber]
+		if !ok {
+			name := fmt.Sprintf("Season %d", episode.SeasonNumber)
+			if episode.SeasonNumber == 0 {
+				name = "Specials"
+			}
+			season = &models.Season{TMDBID: tmdbID, SeasonNumber: episode.SeasonNumber, Name: name}
+			bySeason[episode.SeasonNumber] = season
+		}
+		season.EpisodeCount++
+		if aired := parseTVDBDate(episode.Aired); aired != nil && (season.AirDate == nil || aired.Before(*season.AirDate)) {
+			season.AirDate = aired
+		}
+	}
+
+	seasons := make([]models.Season, 0, len(bySeason))
+	for _, season := range bySeason {
+		seasons = append(seasons, *season)
+	}
+	sort.Slice(seasons, func(i, j int) bool { return seasons[i].SeasonNumber < seasons[j].SeasonNumber })
+	return seasons, nil
+}
+
+// GetDetailedEpisodes returns one season's episodes; TVDB has no stills or ratings, so those stay empty
+func (t *tvdbSeries) GetDetailedEpisodes(tmdbID int, seasonNumber int) ([]models.Episode, error) {
+	if err := t.load(); err != nil {
+		return nil, err
+	}
+
+	var episodes []models.Episode
+	for _, episode := range t.episodes {
+		if episode.SeasonNumber != seasonNumber {
+			continue
+		}
+		episodes = append(episodes, models.Episode{
+			TMDBID:        tmdbID,
+			SeasonNumber:  seasonNumber,
+			EpisodeNumber: episode.Number,
+			Name:          episode.Name,
+			Overview:      episode.Overview,
+			AirDate:       parseTVDBDate(episode.Aired),
+			Runtime:       episode.Runtime,
+		})
+	}
+	return episodes, nil
+}
+
+func (t *tvdbSeries) load() error {
+	if t.loaded {
+		return nil
+	}
+	for page := 0; page < tvdbMaxPages; page++ {
+		var result struct {
+			Data struct {
+				Episodes []tvdbEpisode `json:"episodes"`
+			} `json:"data"`
+			Links struct {
+				Next *string `json:"next"`
+			} `json:"links"`
+		}
+		endpoint := fmt.Sprintf("%s/series/%d/episodes/default/%s?page=%d", tvdbAPI, t.tvdbID, url.PathEscape(t.service.cfg.TVDB.Language), page)
+		if err := t.service.get(endpoint, &result); err != nil {
+			return err
+		}
+		t.episodes = append(t.episodes, result.Data.Episodes...)
+		if result.Links.Next == nil || *result.Links.Next == "" {
+			break
+		}
+	}
+	t.loaded = true
+	return nil
+}
+
+// get calls the API with a bearer token, logging in again once if the token was rejected
+func (s *TVDBService) get(endpoint string, target interface{}) error {
+	err := s.getWithToken(endpoint, target)
+	if errors.Is(err, errTVDBUnauthorized) {
+		s.mu.Lock()
+		s.token = ""
+		s.mu.Unlock()
+		err = s.getWithToken(endpoint, target)
+	}
+	return err
+}
+
+func (s *TVDBService) getWithToken(endpoint string, target interface{}) error {
+	token, err := s.bearerToken()
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("tvdb request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		return errTVDBUnauthorized
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("tvdb answered %d", resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(target)
+}
+
+// bearerToken logs in with the API key (and PIN) when there is no current token
+func (s *TVDBService) bearerToken() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token != "" && time.Now().Before(s.tokenExpires) {
+		return s.token, nil
+	}
+
+	credentials := map[string]string{"apikey": s.cfg.TVDB.APIKey}
+	if s.cfg.TVDB.PIN != "" {
+		credentials["pin"] = s.cfg.TVDB.PIN
+	}
+	body, err := json.Marshal(credentials)
+	if err != nil {
+		return "", err
+	}
+	resp, err := s.client.Post(tvdbAPI+"/login", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return "", fmt.Errorf("tvdb login failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("tvdb login answered %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Data struct {
+			Token string `json:"token"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil || result.Data.Token == "" {
+		return "", fmt.Errorf("tvdb login returned no token")
+	}
+	s.token, s.tokenExpires = result.Data.Token, time.Now().Add(tvdbTokenLifetime)
+	return s.token, nil
+}
+
+func parseTVDBDate(value string) *time.Time {
+	if value == "" {
+		return nil
+	}
+	parsed, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return nil
+	}
+	return &parsed
+}
+
+type tvdbContextKey struct{}
+
+// WithTVDB tells templates that TVDB is configured, so shows offer it as their episode source
+func WithTVDB(ctx context.Context) context.Context {
+	return context.WithValue(ctx, tvdbContextKey{}, true)
+}
+
+// TVDBFromContext reports whether TVDB can be picked as a show's episode source
+func TVDBFromContext(ctx context.Context) bool {
+	enabled, _ := ctx.Value(tvdbContextKey{}).(bool)
+	return enabled
+}
@@ -0,0 +1,32 @@
+package services
+
+import (
+	"sync"
+	"time"
+)
+
+// CallMeter counts an API's calls per UTC hour in memory until they are drained into the database
+type CallMeter struct {
+	mu    sync.Mutex
+	hours map[time.Time]int64
+}
+
+func NewCallMeter() *CallMeter {
+	return &CallMeter{hours: map[time.Time]int64{}}
+}
+
+// Add counts one call in the current hour
+func (m *CallMeter) Add() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.hours[time.Now().UTC().Truncate(time.Hour)]++
+}
+
+// Drain returns the calls counted since the last drain, by hour, and starts over
+func (m *CallMeter) Drain() map[time.Time]int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	hours := m.hours
+	m.hours = map[time.Time]int64{}
+	return hours
+}
@@ -0,0 +1,199 @@
+package templates
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"html/template"
+	"mini-blog/app/models"
+	"mini-blog/app/services"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/a-h/templ"
+)
+
+// Golden files pin the markup of key components. After an intended markup change, rewrite them with
+//
+//	go test ./app/templates -update
+//
+// and review the diff like any other change.
+var update = flag.Bool("update", false, "rewrite the golden files in testdata/golden")
+
+// Fixture times are fixed and in the past, so relative labels ("aired", "in 3 days") don't drift
+var (
+	goldenCreated = time.Date(2024, time.March, 9, 18, 30, 0, 0, time.UTC)
+	goldenAired   = time.Date(2024, time.January, 5, 0, 0, 0, 0, time.UTC)
+)
+
+func goldenAdmin() *models.User {
+	return &models.User{BaseModel: models.BaseModel{ID: 1, CreatedAt: goldenCreated}, Email: "admin@example.com", Name: "Admin", Role: models.RoleAdmin, IsVerified: true}
+}
+
+func goldenReader() *models.User {
+	return &models.User{BaseModel: models.BaseModel{ID: 2, CreatedAt: goldenCreated}, Email: "reader@example.com", Name: "Reader", Role: models.RoleUser, IsVerified: true}
+}
+
+// goldenContext mirrors BaseHandler.renderContext for a viewer in UTC with default settings
+func goldenContext(user *models.User) context.Context {
+	ctx := services.WithLocale(context.Background(), models.LocaleEnglish)
+	ctx = services.WithTheme(ctx, models.ThemeLight)
+	ctx = services.WithPolicy(ctx, services.PolicyFor(user))
+	return services.WithTimezone(ctx, time.UTC, "")
+}
+
+func goldenShow() models.Media {
+	synced := goldenCreated
+	return models.Media{
+		BaseModel:     models.BaseModel{ID: 7, CreatedAt: goldenCreated},
+		TMDBID:        1396,
+		Type:          models.MediaTypeTV,
+		Title:         "Breaking Bad",
+		Overview:      "A chemistry teacher turns to crime.",
+		PosterPath:    "/poster.jpg",
+		ReleaseDate:   &goldenAired,
+		VoteCount:     1200,
+		VoteAverage:   8.9,
+		Status:        models.StatusWatching,
+		Progress:      2,
+		TotalEpisodes: 3,
+		LastSyncedAt:  &synced,
+		InProduction:  false,
+		SyncSchedule:  models.SyncScheduleAuto,
+	}
+}
+
+func goldenMovie() models.Media {
+	return models.Media{
+		BaseModel:   models.BaseModel{ID: 8, CreatedAt: goldenCreated},
+		TMDBID:      603,
+		Type:        models.MediaTypeMovie,
+		Title:       "The Matrix",
+		PosterPath:  "/matrix.jpg",
+		ReleaseDate: &goldenAired,
+		VoteAverage: 8.2,
+		Status:      models.StatusCompleted,
+		Rating:      9,
+	}
+}
+
+func goldenEpisodes() []models.Episode {
+	episodes := make([]models.Episode, 3)
+	for i := range episodes {
+		aired := goldenAired.AddDate(0, 0, 7*i)
+		episodes[i] = models.Episode{
+			BaseModel:     models.BaseModel{ID: uint(100 + i)},
+			TMDBID:        1396,
+			SeasonNumber:  1,
+			EpisodeNumber: i + 1,
+			Name:          []string{"Pilot", "Cat's in the Bag...", "...And the Bag's in the River"}[i],
+			AirDate:       &aired,
+			VoteAverage:   8 + float64(i)/10,
+			VoteCount:     50,
+			Watched:       i < 2,
+		}
+	}
+	return episodes
+}
+
+func goldenPost() models.Post {
+	return models.Post{
+		BaseModel:  models.BaseModel{ID: 3, CreatedAt: goldenCreated, UpdatedAt: goldenCreated},
+		Title:      "Rewatching Breaking Bad",
+		Content:    "## Season one\n\nStill **holds up**. [Read more](https://example.com).",
+		Slug:       "rewatching-breaking-bad",
+		Published:  true,
+		Visibility: models.VisibilityPublic,
+		Status:     models.PostStatusPublished,
+		Version:    1,
+		Category:   &models.Category{BaseModel: models.BaseModel{ID: 1}, Name: "Reviews", Slug: "reviews"},
+		Tags:       []models.Tag{{BaseModel: models.BaseModel{ID: 1}, Name: "drama", Slug: "drama"}},
+	}
+}
+
+func TestComponentsMatchGolden(t *testing.T) {
+	admin, reader := goldenAdmin(), goldenReader()
+	show, movie := goldenShow(), goldenMovie()
+	episodes := goldenEpisodes()
+	seasons := []models.Season{{BaseModel: models.BaseModel{ID: 20}, TMDBID: 1396, SeasonNumber: 1, Name: "Season 1", EpisodeCount: 3, WatchedCount: 2}}
+
+	cases := []struct {
+		name      string
+		user      *models.User
+		component templ.Component
+	}{
+		{"media_grid_admin", admin, MediaGrid([]models.Media{show, movie}, admin)},
+		{"media_grid_reader", reader, MediaGrid([]models.Media{show, movie}, reader)},
+		{"media_grid_empty", reader, MediaGrid([]models.Media{}, reader)},
+		{"media_modal_show", admin, MediaDetailModal(&show, seasons, episodes, episodes, admin)},
+		{"media_modal_movie", reader, MediaDetailModal(&movie, nil, nil, nil, reader)},
+		{"post_view", reader, PostView(goldenPost(), nil)},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := tc.component.Render(goldenContext(tc.user), &buf); err != nil {
+				t.Fatalf("render: %v", err)
+			}
+			assertGolden(t, tc.name, buf.String())
+		})
+	}
+}
+
+func TestEmailsMatchGolden(t *testing.T) {
+	airing := []models.AiringEpisode{{Episode: goldenEpisodes()[2], ShowTitle: "Breaking Bad"}}
+
+	cases := []struct {
+		name string
+		html string
+	}{
+		{"email_post_newsletter", services.PostNewsletterHTML("Rewatching <Breaking Bad>", template.HTML("<p>Still holds up.</p>"), "https://example.com/posts/rewatching")},
+		{"email_announcement", services.AnnouncementHTML("Scheduled maintenance", template.HTML("<p>Back in an hour.</p>"))},
+		{"email_up_next", services.UpNextHTML("Saturday, March 9", airing, nil, "https://example.com/tv/airing")},
+		{"email_subscribe_confirm", services.SubscribeConfirmHTML("https://example.com/subscribe/confirm/abc123")},
+		{"email_preferences_footer", services.PreferencesFooterHTML("https://example.com/email/preferences", "https://example.com/email/unsubscribe")},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			assertGolden(t, tc.name, tc.html)
+		})
+	}
+}
+
+// assertGolden compares got with testdata/golden/<name>.html, or rewrites the file under -update
+func assertGolden(t *testing.T, name, got string) {
+	t.Helper()
+	path := filepath.Join("testdata", "golden", name+".html")
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(got), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("missing golden file, run with -update to create it: %v", err)
+	}
+	if got == string(want) {
+		return
+	}
+	// templ renders most markup on one line, so point at the first differing byte rather than a line
+	at := 0
+	for at < len(got) && at < len(want) && got[at] == want[at] {
+		at++
+	}
+	t.Fatalf("%s differs from %s at byte %d (run with -update if the change is intended)\n got: %s\nwant: %s",
+		name, path, at, goldenExcerpt(got, at), goldenExcerpt(string(want), at))
+}
+
+// goldenExcerpt is the markup around offset at
+func goldenExcerpt(s string, at int) string {
+	from, to := max(at-80, 0), min(at+80, len(s))
+	return s[from:to]
+}
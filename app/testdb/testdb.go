@@ -0,0 +1,141 @@
+// Package testdb gives tests a throwaway in-memory SQLite database with the app's schema,
+// plus factories for the rows most tests start from. Queries that lean on Postgres-only SQL
+// (ILIKE, jsonb) still need a real database; everything else can be exercised here.
+package testdb
+
+import (
+	"fmt"
+	"mini-blog/app/models"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// seq keeps factory emails, slugs and TMDB IDs unique within a test binary
+var seq atomic.Int64
+
+func next() int64 { return seq.Add(1) }
+
+// Open creates an empty migrated database that lives until the test ends
+func Open(t testing.TB) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(fmt.Sprintf("file:testdb%d?mode=memory&cache=shared", next())), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("open test database: %v", err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("open test database: %v", err)
+	}
+	// One connection, so every query sees the same in-memory database and writes never contend
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+
+	models.RunMigrations(db)
+	return db
+}
+
+// User creates a verified reader; pass functions to adjust it before it's saved
+func User(t testing.TB, db *gorm.DB, opts ...func(*models.User)) *models.User {
+	t.Helper()
+	n := next()
+	user := &models.User{
+		Email:      fmt.Sprintf("user%d@example.com", n),
+		Password:   "not-a-real-hash",
+		Name:       fmt.Sprintf("User %d", n),
+		Role:       models.RoleUser,
+		IsVerified: true,
+	}
+	for _, opt := range opts {
+		opt(user)
+	}
+	create(t, db, user)
+	return user
+}
+
+// Admin creates a verified admin
+func Admin(t testing.TB, db *gorm.DB, opts ...func(*models.User)) *models.User {
+	t.Helper()
+	return User(t, db, append([]func(*models.User){func(u *models.User) { u.Role = models.RoleAdmin }}, opts...)...)
+}
+
+// Post creates a published public post
+func Post(t testing.TB, db *gorm.DB, opts ...func(*models.Post)) *models.Post {
+	t.Helper()
+	n := next()
+	post := &models.Post{
+		Title:      fmt.Sprintf("Post %d", n),
+		Content:    "Some **markdown** content.",
+		Slug:       fmt.Sprintf("post-%d", n),
+		Published:  true,
+		Visibility: models.VisibilityPublic,
+		Status:     models.PostStatusPublished,
+		Version:    1,
+	}
+	for _, opt := range opts {
+		opt(post)
+	}
+	create(t, db, post)
+	return post
+}
+
+// Movie creates a planned movie
+func Movie(t testing.TB, db *gorm.DB, opts ...func(*models.Media)) *models.Media {
+	t.Helper()
+	n := next()
+	media := &models.Media{
+		TMDBID: int(100000 + n),
+		Type:   models.MediaTypeMovie,
+		Title:  fmt.Sprintf("Movie %d", n),
+		Status: models.StatusPlanned,
+	}
+	for _, opt := range opts {
+		opt(media)
+	}
+	create(t, db, media)
+	return media
+}
+
+// Show creates a show being watched with seasons of episodesPerSeason aired, unwatched episodes each,
+// keeping TotalEpisodes and the season counts in step like a sync would
+func Show(t testing.TB, db *gorm.DB, seasons, episodesPerSeason int, opts ...func(*models.Media)) *models.Media {
+	t.Helper()
+	n := next()
+	synced := time.Now()
+	media := &models.Media{
+		TMDBID:        int(100000 + n),
+		Type:          models.MediaTypeTV,
+		Title:         fmt.Sprintf("Show %d", n),
+		Status:        models.StatusWatching,
+		TotalEpisodes: seasons * episodesPerSeason,
+		LastSyncedAt:  &synced,
+		InProduction:  true,
+	}
+	for _, opt := range opts {
+		opt(media)
+	}
+	create(t, db, media)
+
+	aired := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	for s := 1; s <= seasons; s++ {
+		create(t, db, &models.Season{TMDBID: media.TMDBID, SeasonNumber: s, Name: fmt.Sprintf("Season %d", s), EpisodeCount: episodesPerSeason})
+		for e := 1; e <= episodesPerSeason; e++ {
+			airDate := aired.AddDate(0, s-1, 7*(e-1))
+			create(t, db, &models.Episode{TMDBID: media.TMDBID, SeasonNumber: s, EpisodeNumber: e, Name: fmt.Sprintf("Episode %d", e), AirDate: &airDate})
+		}
+	}
+	return media
+}
+
+func create(t testing.TB, db *gorm.DB, value interface{}) {
+	t.Helper()
+	if err := db.Create(value).Error; err != nil {
+		t.Fatalf("create %T: %v", value, err)
+	}
+}
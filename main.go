@@ -2,10 +2,16 @@ package main
 
 import (
 	"log"
+	"mini-blog/app/client"
 	"mini-blog/app/config"
 	"mini-blog/app/handlers"
 	"mini-blog/app/models"
+	"mini-blog/app/seed"
+	"mini-blog/app/services"
+	"net"
 	"net/http"
+	"os"
+	"strings"
 	"time"
 
 	"github.com/labstack/echo/v4"
@@ -13,19 +19,110 @@ import (
 )
 
 func main() {
-	cfg := config.Load()
+	// `mini-blog client ...` talks to a running site's API instead of serving one
+	if len(os.Args) > 1 && os.Args[1] == "client" {
+		os.Exit(client.Run(os.Args[2:]))
+	}
+	// `mini-blog seed --demo` fills a fresh database with sample data and exits
+	if len(os.Args) > 1 && os.Args[1] == "seed" {
+		os.Exit(seed.Run(os.Args[2:]))
+	}
+
+	sites := config.LoadSites()
+	if err := services.SetCodeTheme(sites[0].Markdown.CodeTheme); err != nil {
+		log.Fatalf("Invalid CODE_THEME: %v", err)
+	}
+	services.SetDiagramServer(sites[0].Markdown.DiagramURL)
+
+	// Each site gets its own database, handler (sessions, storage, settings) and workers
+	servers := make(map[string]*echo.Echo, len(sites))
+	for i, site := range sites {
+		if !site.JWTEnabled() {
+			variable := "JWT_SECRET"
+			if site.Name != "" {
+				variable = strings.ToUpper(site.Name) + "_" + variable
+			}
+			log.Printf("WARNING: %s is unset or the old public default; API clients can't sign in with JWTs until it is set", variable)
+		}
+		db := models.ConnectDB(site.Config)
+		models.RunMigrations(db)
+		models.CreateInitialAdmin(db, site.Config)
+
+		h := handlers.NewBaseHandler(site.Config, db)
+		// {{tmdb}} embeds in posts are looked up with the first site's client, counted against its budget,
+		// and cached for all of them
+		if i == 0 && site.TMDB.BearerToken != "" {
+			h.ServeTMDBEmbeds()
+		}
+		servers[site.Host] = newServer(site.Config, h)
+		startWorkers(site.Config, h)
+	}
+
+	port := sites[0].Server.Port
+	log.Printf("Server starting on port %s", port)
+	if len(sites) == 1 && sites[0].Host == "" {
+		log.Fatal(servers[""].Start(":" + port))
+	}
+
+	root := echo.New()
+	root.HideBanner = true
+	root.Pre(resolveTenant(servers))
+	root.GET("/health", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, map[string]string{"status": "ok"})
+	})
+	log.Fatal(root.Start(":" + port))
+}
+
+// uploadRoutes take files, so they are held to MAX_UPLOAD_SIZE rather than MAX_BODY_SIZE
+var uploadRoutes = map[string]bool{
+	"/admin/uploads":         true,
+	"/admin/backup/import":   true,
+	"/admin/backup/markdown": true,
+	"/admin/import/netflix":  true,
+}
+
+// bodyLimits caps request bodies, answering 413 past the cap for the matched route
+func bodyLimits(cfg *config.Config) echo.MiddlewareFunc {
+	bodyLimit := middleware.BodyLimit(cfg.Limits.BodySize)
+	uploadLimit := middleware.BodyLimit(cfg.Limits.UploadSize)
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		body, upload := bodyLimit(next), uploadLimit(next)
+		return func(c echo.Context) error {
+			if uploadRoutes[c.Path()] {
+				return upload(c)
+			}
+			return body(c)
+		}
+	}
+}
 
-	// Initialize database
-	models.ConnectDB(cfg)
-	models.RunMigrations()
-	models.CreateInitialAdmin(cfg)
+// resolveTenant hands each request to the site registered for its hostname; unknown hosts fall through to the root server
+func resolveTenant(servers map[string]*echo.Echo) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			host := c.Request().Host
+			if h, _, err := net.SplitHostPort(host); err == nil {
+				host = h
+			}
+			if server, ok := servers[strings.ToLower(host)]; ok {
+				server.ServeHTTP(c.Response(), c.Request())
+				return nil
+			}
+			return next(c)
+		}
+	}
+}
 
+func newServer(cfg *config.Config, h *handlers.BaseHandler) *echo.Echo {
 	e := echo.New()
 	e.Use(middleware.Recover())
 	e.Use(middleware.CORS())
+	e.Use(bodyLimits(cfg))
+	e.Use(h.APITokenUsage)
+	e.Use(h.JWTAuth)
+	e.Use(h.CollectPageView)
 	e.Static("/static", "static")
-
-	h := handlers.NewBaseHandler(cfg)
+	e.Static(cfg.Storage.BaseURL, cfg.Storage.Dir)
 
 	// Health check route (no database dependency)
 	e.GET("/health", func(c echo.Context) error {
@@ -34,9 +131,64 @@ func main() {
 
 	// Public routes
 	public := e.Group("")
-	public.GET("/", h.Home)
-	public.GET("/posts", h.Posts)
-	public.GET("/posts/:slug", h.PostView)
+	public.GET("/lang/:locale", h.SetLocale)
+	public.POST("/theme", h.ToggleTheme)
+	public.GET("/api/palette", h.Palette)
+	public.GET("/api/offline", h.OfflineSync)
+	public.POST("/api/auth/token", h.APIAuthToken)
+	public.POST("/api/auth/refresh", h.APIAuthRefresh)
+	public.POST("/api/auth/revoke", h.APIAuthRevoke)
+	public.GET("/manifest.webmanifest", h.Manifest)
+	public.GET("/sw.js", h.ServiceWorker)
+	public.GET("/e/o/:token", h.TrackEmailOpen)
+	public.GET("/e/c/:token", h.TrackEmailClick)
+	public.GET("/email/preferences", h.EmailPreferences)
+	public.POST("/email/preferences", h.EmailPreferencesUpdate)
+	public.GET("/email/unsubscribe", h.EmailUnsubscribePage)
+	public.POST("/email/unsubscribe", h.EmailUnsubscribe)
+	public.POST("/analytics/consent", h.AnalyticsConsent)
+	public.GET("/icon.svg", h.AppIcon)
+	public.GET("/robots.txt", h.Robots)
+	public.GET("/support", h.SupportPage)
+	public.POST("/support/checkout", h.SupportCheckout)
+	public.GET("/support/thanks", h.SupportThanks)
+	public.POST("/webhooks/stripe", h.StripeWebhook)
+	public.POST("/webhooks/resend", h.ResendWebhook)
+	public.POST("/integrations/slack", h.SlackCommand)
+	public.POST("/integrations/discord", h.DiscordCommand)
+	public.POST("/integrations/telegram", h.TelegramWebhook)
+
+	// The blog and the tracker each go away in the other's SITE_MODE; / is whatever the admin chose
+	public.GET("/", h.Landing)
+	if cfg.BlogEnabled() {
+		public.GET("/posts", h.Posts)
+		public.GET("/tags", h.Tags)
+		public.GET("/tags/:slug/feed.xml", h.TagFeed)
+		public.GET("/category/:slug", h.CategoryPosts)
+		public.GET("/category/:slug/feed.xml", h.CategoryFeed)
+		public.GET("/posts/:slug", h.PostView)
+		public.GET("/preview/:token", h.PostView)
+		public.POST("/posts/:slug/progress", h.ReadingProgressBeacon)
+		public.POST("/posts/:slug/like", h.PostLikeToggle)
+		public.GET("/posts/:slug/comments", h.PostComments)
+		public.POST("/posts/:slug/comments", h.PostCommentCreate, h.RequireAuth)
+		public.GET("/posts/:slug/comments/:id/reply", h.PostCommentReplyForm, h.RequireAuth)
+		public.DELETE("/posts/:slug/comments/:id", h.PostCommentDelete, h.RequireAuth)
+		public.POST("/posts/:slug/report", h.PostReport, h.RequireAuth)
+		public.POST("/posts/:slug/comments/:id/report", h.CommentReport, h.RequireAuth)
+		public.POST("/api/posts", h.PostCreateAPI)
+		public.GET("/api/posts/:id/views", h.PostViewsAPI)
+		public.GET("/podcast.xml", h.PodcastFeed)
+		public.GET("/feed.xml", h.RSSFeed)
+		public.GET("/atom.xml", h.AtomFeed)
+		public.GET("/feed.json", h.JSONFeed)
+		public.POST("/subscribe", h.Subscribe)
+		public.GET("/subscribe/confirm/:token", h.SubscribeConfirm)
+	}
+	if cfg.TrackerEnabled() {
+		public.GET("/api/tv/library", h.LibraryAPI)
+		public.PUT("/api/tv/episodes/:tmdbId/:season/:episode", h.EpisodeWatchedAPI)
+	}
 
 	// Auth routes
 	auth := e.Group("")
@@ -46,56 +198,263 @@ func main() {
 	auth.POST("/login", h.Login)
 	auth.POST("/verify-otp", h.VerifyOTP)
 	auth.POST("/resend-otp", h.ResendOTP)
+	auth.GET("/reset-password/:token", h.ResetPasswordPage)
+	auth.POST("/reset-password/:token", h.ResetPassword)
 	auth.GET("/logout", h.Logout)
+	auth.GET("/auth/oidc/login", h.SSOLogin)
+	auth.GET("/auth/oidc/callback", h.SSOCallback)
+
+	// User settings
+	settings := e.Group("/settings", h.RequireAuth)
+	settings.GET("", h.SettingsPage)
+	settings.POST("", h.SettingsUpdate)
+	settings.POST("/coupon", h.RedeemCoupon)
+	settings.GET("/activity", h.SettingsActivity)
+	settings.POST("/tokens", h.APITokenCreate)
+	settings.DELETE("/tokens/:id", h.APITokenDelete)
 
 	// Admin routes
-	admin := e.Group("/admin", h.RequireAdmin)
+	admin := e.Group("/admin", h.RestrictAdminIPs, h.RequireAdmin)
 	{
 		admin.GET("/dashboard", h.AdminDashboard)
-		admin.POST("/users/:id/role", h.AdminUpdateUserRole)
+		admin.GET("/reauth", h.AdminReauthPage)
+		admin.POST("/reauth", h.AdminReauth)
+		admin.POST("/reauth/code", h.AdminReauthCode)
+		admin.POST("/users/:id/role", h.AdminUpdateUserRole, h.RequireReauth)
+		admin.POST("/users/:id/ban", h.AdminUserBan)
+		admin.POST("/users/:id/unban", h.AdminUserUnban)
+		admin.POST("/users/:id/reset-link", h.AdminUserResetLink, h.RequireReauth)
+		admin.POST("/users/:id/verify", h.AdminUserVerify, h.RequireReauth)
+		admin.POST("/users/:id/sign-out", h.AdminUserSignOut)
+		admin.GET("/users", h.AdminUsers)
+		admin.GET("/users/export", h.AdminUsersExport)
+		admin.POST("/segments", h.AdminSegmentCreate)
+		admin.DELETE("/segments/:id", h.AdminSegmentDelete)
+		admin.GET("/landing", h.AdminLanding)
+		admin.POST("/landing", h.AdminLandingUpdate)
+		admin.GET("/crawlers", h.AdminCrawlers)
+		admin.POST("/crawlers", h.AdminCrawlersUpdate)
+		admin.GET("/adult-media", h.AdminAdultMedia)
+		admin.POST("/adult-media", h.AdminAdultMediaUpdate)
+		admin.GET("/signup-roles", h.AdminSignupRoles)
+		admin.POST("/signup-roles", h.AdminSignupRolesUpdate, h.RequireReauth)
+		admin.GET("/backup", h.AdminBackup)
+		admin.GET("/backup/export", h.AdminBackupExport, h.RequireReauth)
+		admin.POST("/backup/import", h.AdminBackupImport, h.RequireReauth)
+		admin.GET("/suppressions", h.AdminSuppressions)
+		admin.POST("/suppressions/:id/delete", h.AdminSuppressionDelete)
+		admin.GET("/announcements", h.AdminAnnouncements)
+		admin.POST("/announcements", h.AdminAnnouncementSend)
+		admin.POST("/announcements/preview", h.AdminAnnouncementPreview)
+		admin.POST("/announcements/test", h.AdminAnnouncementTest)
+		admin.GET("/coupons", h.AdminCoupons)
+		admin.POST("/coupons", h.AdminCouponCreate)
+		admin.DELETE("/coupons/:id", h.AdminCouponDelete)
+		admin.GET("/notifications", h.AdminNotifications)
+		admin.GET("/revenue", h.AdminRevenue)
+		admin.GET("/analytics", h.AdminAnalytics)
+		admin.GET("/revenue/export", h.AdminRevenueExport)
+		admin.GET("/webhooks", h.AdminWebhooks)
+		admin.POST("/webhooks", h.AdminWebhookCreate)
+		admin.DELETE("/webhooks/:id", h.AdminWebhookDelete)
+	}
+	if cfg.BlogEnabled() {
+		admin.GET("/home", h.AdminHomeLayout)
+		admin.POST("/home", h.AdminHomeLayoutUpdate)
+		admin.GET("/milestones", h.AdminMilestones)
+		admin.POST("/milestones", h.AdminMilestonesUpdate)
+		admin.GET("/backup/markdown", h.AdminMarkdownExport, h.RequireReauth)
+		admin.POST("/backup/markdown", h.AdminMarkdownImport, h.RequireReauth)
 
 		// Posts management
 		admin.GET("/posts/new", h.AdminPostNew)
+		admin.GET("/posts/media-picker", h.AdminPostMediaPicker)
 		admin.GET("/posts/:id/edit", h.AdminPostEdit)
 		admin.POST("/posts", h.AdminPostCreate)
 		admin.PUT("/posts/:id", h.AdminPostUpdate)
-		admin.DELETE("/posts/:id", h.AdminPostDelete)
+		admin.DELETE("/posts/:id", h.AdminPostDelete, h.RequireReauth)
+		admin.GET("/posts/:id/translations", h.AdminPostTranslations)
+		admin.POST("/posts/:id/translations", h.AdminPostTranslationSave)
+		admin.DELETE("/posts/:id/translations/:locale", h.AdminPostTranslationDelete)
+		admin.GET("/calendar", h.AdminContentCalendar)
+		admin.GET("/uploads", h.AdminUploads)
+		admin.POST("/uploads", h.AdminUploadCreate)
+		admin.POST("/uploads/delete", h.AdminUploadsBulkDelete, h.RequireReauth)
+		admin.GET("/uploads/picker", h.AdminUploadPicker)
+		admin.DELETE("/uploads/:id", h.AdminUploadDelete)
+		admin.GET("/newsletters", h.AdminNewsletters)
+		admin.POST("/newsletters", h.AdminNewsletterSend)
+		admin.GET("/newsletters/:id", h.AdminNewsletterStats)
+		admin.GET("/templates", h.AdminPostTemplates)
+		admin.POST("/templates", h.AdminPostTemplateCreate)
+		admin.DELETE("/templates/:id", h.AdminPostTemplateDelete)
+		admin.POST("/categories", h.AdminCategoryCreate)
+		admin.DELETE("/categories/:id", h.AdminCategoryDelete)
+		admin.GET("/reports", h.AdminReports)
+		admin.POST("/reports/:id/resolve", h.AdminReportResolve)
+		admin.POST("/reports/:id/dismiss", h.AdminReportDismiss)
+		admin.POST("/posts/:id/reschedule", h.AdminPostReschedule)
+		admin.POST("/posts/:id/duplicate", h.AdminPostDuplicate)
+		admin.POST("/posts/:id/pin", h.AdminPostTogglePin)
+		admin.GET("/posts/:id/views", h.AdminPostViews)
+		admin.GET("/posts/:id/workflow", h.AdminPostWorkflow)
+		admin.GET("/posts/:id/narration", h.AdminPostNarration)
+		admin.POST("/posts/:id/narration", h.AdminPostNarrate)
+		admin.POST("/posts/:id/preview", h.AdminPostPreviewLink)
+		admin.POST("/posts/:id/transition", h.AdminPostTransition)
+		admin.POST("/posts/:id/comments", h.AdminPostCommentCreate)
+		admin.POST("/posts/:id/comments/:commentId/resolve", h.AdminPostCommentResolve)
+	}
+	if cfg.TrackerEnabled() {
+		admin.GET("/integrity", h.AdminIntegrity)
+		admin.POST("/integrity/repair", h.AdminIntegrityRepair, h.RequireReauth)
+		admin.GET("/import", h.AdminWatchImport)
+		admin.DELETE("/import", h.AdminWatchImportClear)
+		admin.GET("/import/items", h.AdminWatchImportItems)
+		admin.POST("/import/items/:id", h.AdminWatchImportResolve)
+		admin.POST("/import/apply", h.AdminWatchImportApply)
+		admin.POST("/import/netflix", h.AdminNetflixImport)
 	}
 
 	// Media Tracker routes
-	tv := e.Group("/tv")
-	{
+	if cfg.TrackerEnabled() {
+		tv := e.Group("/tv")
 		// Public routes
-		tv.GET("", h.MediaList)
-		tv.GET("/filter", h.MediaFilter)
-		tv.GET("/search", h.MediaSearch)
-		tv.GET("/modal/:id", h.MediaModal)
-		tv.GET("/:tmdbId/episodes/:season", h.MediaEpisodes)
+		tv.GET("/airing", h.MediaAiring)
+		tv.GET("/watching", h.WatchingWidget)
+		tv.GET("/related/:tmdbId", h.MediaRelatedPosts)
+		tv.GET("/review", h.YearReview)
+		tv.GET("/review/s/:token", h.YearReviewSnapshot)
+		// Routes that call TMDB inline get a deadline so a hung request can't hold the connection
+		tmdbTimeout := middleware.ContextTimeout(cfg.TMDB.RouteTimeout)
+		tv.GET("", h.MediaList, tmdbTimeout) // admins' TMDB search runs here too
+		tv.GET("/modal/:id", h.MediaModal, tmdbTimeout)
+		tv.GET("/modal/:id/refresh", h.MediaModalRefresh)
+		tv.GET("/watch/:type/:tmdbId", h.MediaWatchProviders, tmdbTimeout)
+		tv.GET("/:tmdbId/episodes/:season", h.MediaEpisodes, tmdbTimeout)
+		tv.GET("/:tmdbId/export.csv", h.MediaExportCSV)
+		tv.GET("/notes/:tmdbId", h.MediaNotes)
 
 		// Admin-only routes
-		admin := tv.Group("", h.RequireAdmin)
+		admin := tv.Group("", h.RestrictAdminIPs, h.RequireAdmin)
 		{
 			admin.POST("/add", h.MediaAdd)
 			admin.PUT("/:id", h.MediaUpdate)
 			admin.POST("/update/:tmdbId", h.MediaUpdateByTMDB)
-			admin.DELETE("/:id", h.MediaDelete)
+			admin.DELETE("/:id", h.MediaDelete, h.RequireReauth)
 			admin.POST("/episodes/toggle/:tmdbId/:season/:episode", h.MarkEpisodeWatched)
 			admin.POST("/mark-season/:tmdbId/:season", h.MarkSeasonWatched)
+			admin.POST("/mark-range/:tmdbId/:season/:episode", h.MarkEpisodeRange)
 			admin.POST("/mark-show/:tmdbId", h.MarkShowWatched)
+			admin.POST("/:tmdbId/seasons/:season/sync", h.MediaSeasonSync, tmdbTimeout)
 			admin.POST("/status/:tmdbId", h.MediaStatusUpdate)
 			admin.POST("/toggle-anime/:tmdbId", h.MediaToggleAnime)
-			admin.DELETE("/remove/:tmdbId", h.MediaRemove)
+			admin.POST("/metadata/:tmdbId", h.MediaMetadataProvider)
+			admin.POST("/sync-schedule/:tmdbId", h.MediaSyncSchedule)
+			admin.GET("/notes/:tmdbId/edit", h.MediaNotesEdit)
+			admin.POST("/review/:year/share", h.YearReviewShare)
+			admin.GET("/posters/:tmdbId", h.MediaPosters, tmdbTimeout)
+			admin.POST("/posters/:tmdbId", h.MediaPosterSelect, tmdbTimeout)
+			admin.PUT("/notes/:tmdbId", h.MediaNotesUpdate)
+			admin.POST("/write-review/:tmdbId", h.MediaWriteReview)
+			admin.DELETE("/remove/:tmdbId", h.MediaRemove, h.RequireReauth)
 		}
 	}
 
-	// Start background sync
+	return e
+}
+
+func startWorkers(cfg *config.Config, h *handlers.BaseHandler) {
+	if cfg.TrackerEnabled() {
+		startTrackerWorkers(h)
+	}
+
+	// Publish scheduled posts and take down expired ones
+	if cfg.BlogEnabled() {
+		go func() {
+			for {
+				h.PublishScheduledPosts()
+				h.UnpublishExpiredPosts()
+				time.Sleep(time.Minute)
+			}
+		}()
+	}
+
+	// Downgrade users whose premium trial ended
 	go func() {
 		for {
-			time.Sleep(24 * time.Hour)
+			h.EndExpiredTrials()
+			time.Sleep(time.Hour)
+		}
+	}()
+
+	// Forget activity older than the log shows
+	go func() {
+		for {
+			h.PruneActivity()
+			time.Sleep(time.Hour)
+		}
+	}()
+
+	// Deliver queued webhook events
+	go func() {
+		for {
+			h.ProcessWebhookQueue()
+			time.Sleep(30 * time.Second)
+		}
+	}()
+
+	// Deliver queued email
+	go func() {
+		for {
+			h.ProcessEmailQueue()
+			time.Sleep(handlers.EmailQueueInterval)
+		}
+	}()
+}
+
+// startTrackerWorkers runs the /tv tracker's TMDB syncs and episode reminders; blog-only sites skip them
+func startTrackerWorkers(h *handlers.BaseHandler) {
+	// Sync stale media opened in the UI
+	go h.RunMediaSyncWorker()
+
+	// Start background sync; hourly passes so daily schedules aren't held up a day
+	go func() {
+		for {
+			time.Sleep(time.Hour)
 			h.BackgroundSync()
 		}
 	}()
 
-	log.Printf("Server starting on port %s", cfg.Server.Port)
-	log.Fatal(e.Start(":" + cfg.Server.Port))
+	// Placeholder hashes for episode stills, which syncs leave empty
+	go func() {
+		for {
+			h.HashEpisodeStills()
+			time.Sleep(time.Minute)
+		}
+	}()
+
+	// Total TMDB calls and warn as budgets run low
+	go func() {
+		for {
+			time.Sleep(time.Minute)
+			h.RecordTMDBUsage()
+		}
+	}()
+
+	// Queue morning "up next" emails as each user's send hour comes round
+	go func() {
+		for {
+			h.SendUpNextEmails()
+			time.Sleep(10 * time.Minute)
+		}
+	}()
+
+	// Tell the admin's Telegram chat about today's episodes
+	go func() {
+		for {
+			h.SendTelegramEpisodeAlerts()
+			time.Sleep(time.Hour)
+		}
+	}()
 }